@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cretz/teleworker/workergrpc"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func auditCmd() *cobra.Command {
+	var req workergrpc.QueryAuditRequest
+	var since, until time.Duration
+	var limit int
+	var clientFlags clientFlags
+	cmd := &cobra.Command{
+		Use:          "audit",
+		Short:        "Search the audit log of the server, most recent first, optionally only in --namespace",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			now := time.Now()
+			if since > 0 {
+				req.Since = timestamppb.New(now.Add(-since))
+			}
+			if until > 0 {
+				req.Until = timestamppb.New(now.Add(-until))
+			}
+			req.Limit = int32(limit)
+			conn, client, err := clientFlags.dialClient()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			resp, err := client.QueryAudit(cmd.Context(), &req)
+			if err != nil {
+				return fmt.Errorf("querying audit log: %w", err)
+			}
+			for _, entry := range resp.Entries {
+				fmt.Println(prototext.Format(entry))
+			}
+			return nil
+		},
+	}
+	clientFlags.applyFlags(cmd.Flags())
+	cmd.Flags().DurationVar(&since, "since", 0, "Only entries from at most this long ago")
+	cmd.Flags().DurationVar(&until, "until", 0, "Only entries from at least this long ago")
+	cmd.Flags().StringVar(&req.Actor, "actor", "", "Only entries whose caller certificate subject or serial contains this")
+	cmd.Flags().StringVar(&req.JobId, "job", "", "Only entries targeting this job ID")
+	cmd.Flags().StringVar(&req.Method, "method", "", "Only entries whose method contains this, e.g. StopJob")
+	cmd.Flags().IntVar(&limit, "limit", 0, "Maximum entries, otherwise server default")
+	return cmd
+}
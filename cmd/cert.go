@@ -50,7 +50,12 @@ func genCertCmd() *cobra.Command {
 	cmd.Flags().StringVar(&signerCert, "signer-cert", "", "Certification file to sign with")
 	cmd.Flags().StringVar(&signerKey, "signer-key", "", "Key file to sign with")
 	cmd.Flags().BoolVar(&config.CA, "is-ca", false, "Make a signer/CA certificate")
-	cmd.Flags().StringVar(&config.OU, "ou", "", "Set the OU which is used as the job namespace")
+	cmd.Flags().StringVar(&config.OU, "ou", "", "Set the OU which is used as the default job namespace")
+	cmd.Flags().StringArrayVar(&config.Namespaces, "namespace", nil,
+		"Grant another namespace to a client certificate, can be repeated")
+	cmd.Flags().StringVar(&config.CommonName, "cn", "", "Set the common name of a client certificate")
+	cmd.Flags().StringArrayVar(&config.Roles, "role", nil,
+		"Embed a role (viewer, submitter, operator, or admin) in a client certificate for policies to match, can be repeated")
 	cmd.Flags().StringVar(&config.ServerHost, "server-host", "", "Make a server auth certificate with this IP or DNS name")
 	return cmd
 }
@@ -6,14 +6,21 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/cretz/teleworker/worker"
 	"github.com/cretz/teleworker/workergrpc"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"google.golang.org/grpc"
 	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 type clientFlags struct {
@@ -21,6 +28,7 @@ type clientFlags struct {
 	serverCACert string
 	clientCert   string
 	clientKey    string
+	namespace    string
 }
 
 func (c *clientFlags) applyFlags(flags *pflag.FlagSet) {
@@ -28,6 +36,7 @@ func (c *clientFlags) applyFlags(flags *pflag.FlagSet) {
 	flags.StringVar(&c.serverCACert, "server-ca-cert", "", "Required CA certificate file to verify server certificates")
 	flags.StringVar(&c.clientCert, "client-cert", "", "Required client certificate file to send for auth")
 	flags.StringVar(&c.clientKey, "client-key", "", "Required client key file to send for auth")
+	flags.StringVar(&c.namespace, "namespace", "", "Namespace to act in, otherwise the default one of the client certificate")
 }
 
 func (c *clientFlags) dialClient() (*grpc.ClientConn, workergrpc.JobServiceClient, error) {
@@ -57,13 +66,63 @@ func (c *clientFlags) dialClient() (*grpc.ClientConn, workergrpc.JobServiceClien
 	if err != nil {
 		return nil, nil, fmt.Errorf("loading credentials: %w", err)
 	}
-	conn, err := grpc.Dial(c.address, grpc.WithTransportCredentials(creds))
+	conn, err := grpc.Dial(c.address, grpc.WithTransportCredentials(creds),
+		grpc.WithUnaryInterceptor(func(
+			ctx context.Context,
+			method string,
+			req, reply interface{},
+			cc *grpc.ClientConn,
+			invoker grpc.UnaryInvoker,
+			opts ...grpc.CallOption,
+		) error {
+			c.setNamespace(req)
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}),
+		grpc.WithStreamInterceptor(func(
+			ctx context.Context,
+			desc *grpc.StreamDesc,
+			cc *grpc.ClientConn,
+			method string,
+			streamer grpc.Streamer,
+			opts ...grpc.CallOption,
+		) (grpc.ClientStream, error) {
+			stream, err := streamer(ctx, desc, cc, method, opts...)
+			if err != nil {
+				return nil, err
+			}
+			return &namespaceClientStream{ClientStream: stream, flags: c}, nil
+		}),
+	)
 	if err != nil {
 		return nil, nil, fmt.Errorf("dialing server: %w", err)
 	}
 	return conn, workergrpc.NewJobServiceClient(conn), nil
 }
 
+// setNamespace sets the namespace field of the request if it has one and the
+// namespace flag is set.
+func (c *clientFlags) setNamespace(req interface{}) {
+	msg, ok := req.(proto.Message)
+	if !ok || c.namespace == "" {
+		return
+	}
+	field := msg.ProtoReflect().Descriptor().Fields().ByName("namespace")
+	if field != nil && field.Kind() == protoreflect.StringKind {
+		msg.ProtoReflect().Set(field, protoreflect.ValueOfString(c.namespace))
+	}
+}
+
+// namespaceClientStream sets the namespace of requests it sends.
+type namespaceClientStream struct {
+	grpc.ClientStream
+	flags *clientFlags
+}
+
+func (n *namespaceClientStream) SendMsg(m interface{}) error {
+	n.flags.setNamespace(m)
+	return n.ClientStream.SendMsg(m)
+}
+
 func getCmd() *cobra.Command {
 	var req workergrpc.GetJobRequest
 	var clientFlags clientFlags
@@ -100,34 +159,212 @@ func getCmd() *cobra.Command {
 	clientFlags.applyFlags(cmd.Flags())
 	cmd.Flags().BoolVar(&req.IncludeStdout, "stdout", false, "Dump stdout as trimmed string")
 	cmd.Flags().BoolVar(&req.IncludeStderr, "stderr", false, "Dump stderr as trimmed string")
+	cmd.Flags().Int32Var(&req.Attempt, "attempt", 0, "Dump output of the given 1-based attempt instead of the latest")
+	return cmd
+}
+
+func listCmd() *cobra.Command {
+	var req workergrpc.ListJobsRequest
+	var clientFlags clientFlags
+	cmd := &cobra.Command{
+		Use:          "list",
+		Short:        "List jobs",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conn, client, err := clientFlags.dialClient()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			resp, err := client.ListJobs(cmd.Context(), &req)
+			if err != nil {
+				return fmt.Errorf("listing jobs: %w", err)
+			}
+			for _, job := range resp.Jobs {
+				fmt.Println(prototext.Format(job))
+			}
+			return nil
+		},
+	}
+	clientFlags.applyFlags(cmd.Flags())
+	cmd.Flags().StringVarP(&req.LabelSelector, "selector", "l", "",
+		"Label selector to filter by, e.g. 'pipeline=nightly,owner in (a,b)'")
+	return cmd
+}
+
+func webhooksCmd() *cobra.Command {
+	var req workergrpc.ListWebhookDeliveriesRequest
+	var clientFlags clientFlags
+	cmd := &cobra.Command{
+		Use:          "webhooks [JOB_ID]",
+		Short:        "List webhook deliveries, optionally only for a job",
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				req.JobId = args[0]
+			}
+			conn, client, err := clientFlags.dialClient()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			resp, err := client.ListWebhookDeliveries(cmd.Context(), &req)
+			if err != nil {
+				return fmt.Errorf("listing webhook deliveries: %w", err)
+			}
+			for _, delivery := range resp.Deliveries {
+				fmt.Println(prototext.Format(delivery))
+			}
+			return nil
+		},
+	}
+	clientFlags.applyFlags(cmd.Flags())
+	return cmd
+}
+
+func namespacesCmd() *cobra.Command {
+	var clientFlags clientFlags
+	cmd := &cobra.Command{
+		Use:          "namespaces",
+		Short:        "List every namespace with jobs and how many are in each state, admins only",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conn, client, err := clientFlags.dialClient()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			resp, err := client.ListNamespaces(cmd.Context(), &workergrpc.ListNamespacesRequest{})
+			if err != nil {
+				return fmt.Errorf("listing namespaces: %w", err)
+			}
+			for _, ns := range resp.Namespaces {
+				fmt.Println(prototext.Format(ns))
+			}
+			return nil
+		},
+	}
+	clientFlags.applyFlags(cmd.Flags())
+	return cmd
+}
+
+func quotaCmd() *cobra.Command {
+	var clientFlags clientFlags
+	cmd := &cobra.Command{
+		Use:          "quota",
+		Short:        "Show the quota and rate limits of the namespace and its usage",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conn, client, err := clientFlags.dialClient()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			resp, err := client.GetQuota(cmd.Context(), &workergrpc.GetQuotaRequest{})
+			if err != nil {
+				return fmt.Errorf("getting quota: %w", err)
+			}
+			fmt.Println(prototext.Format(resp))
+			return nil
+		},
+	}
+	clientFlags.applyFlags(cmd.Flags())
+	return cmd
+}
+
+func labelCmd() *cobra.Command {
+	var annotations bool
+	var clientFlags clientFlags
+	cmd := &cobra.Command{
+		Use:          "label JOB_ID KEY=VALUE|KEY-...",
+		Short:        "Set labels of a job, or remove them with a trailing '-'",
+		Args:         cobra.MinimumNArgs(2),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req := &workergrpc.UpdateJobLabelsRequest{JobId: args[0]}
+			set := map[string]string{}
+			var remove []string
+			for _, arg := range args[1:] {
+				if eqIndex := strings.Index(arg, "="); eqIndex >= 0 {
+					set[arg[:eqIndex]] = arg[eqIndex+1:]
+				} else if strings.HasSuffix(arg, "-") {
+					remove = append(remove, strings.TrimSuffix(arg, "-"))
+				} else {
+					return fmt.Errorf("%q must be KEY=VALUE or KEY-", arg)
+				}
+			}
+			if annotations {
+				req.SetAnnotations, req.RemoveAnnotations = set, remove
+			} else {
+				req.SetLabels, req.RemoveLabels = set, remove
+			}
+			conn, client, err := clientFlags.dialClient()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			resp, err := client.UpdateJobLabels(cmd.Context(), req)
+			if err != nil {
+				return fmt.Errorf("updating job labels: %w", err)
+			}
+			fmt.Println(prototext.Format(resp.Job))
+			return nil
+		},
+	}
+	clientFlags.applyFlags(cmd.Flags())
+	cmd.Flags().BoolVar(&annotations, "annotations", false, "Update annotations instead of labels")
 	return cmd
 }
 
 func stopCmd() *cobra.Command {
-	var req workergrpc.StopJobRequest
+	var req workergrpc.StopJobsRequest
+	var timeout time.Duration
 	var clientFlags clientFlags
 	cmd := &cobra.Command{
-		Use:          "stop JOB_ID",
-		Short:        "Stop job by its ID",
-		Args:         cobra.ExactArgs(1),
+		Use:          "stop JOB_ID...",
+		Short:        "Stop jobs by their IDs or a label selector",
 		SilenceUsage: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if (len(args) == 0) == (req.LabelSelector == "") {
+				return fmt.Errorf("exactly one of job IDs or label selector required")
+			}
 			conn, client, err := clientFlags.dialClient()
 			if err != nil {
 				return err
 			}
 			defer conn.Close()
-			req.JobId = args[0]
+			req.JobIds = args
+			if timeout > 0 {
+				req.Timeout = durationpb.New(timeout)
+			}
 			// Stop in background
 			errCh := make(chan error, 1)
-			jobCh := make(chan *workergrpc.Job, 1)
+			respCh := make(chan *workergrpc.StopJobsResponse, 1)
 			ctx, cancel := context.WithCancel(cmd.Context())
 			defer cancel()
 			go func() {
-				if resp, err := client.StopJob(ctx, &req); err != nil {
+				if len(req.JobIds) == 1 {
+					// Use the single-job call for its errors
+					resp, err := client.StopJob(ctx, &workergrpc.StopJobRequest{
+						JobId:   req.JobIds[0],
+						Force:   req.Force,
+						Timeout: req.Timeout,
+					})
+					if err != nil {
+						errCh <- err
+					} else {
+						respCh <- &workergrpc.StopJobsResponse{
+							Results: []*workergrpc.StopJobsResult{{JobId: req.JobIds[0], Job: resp.Job}},
+						}
+					}
+				} else if resp, err := client.StopJobs(ctx, &req); err != nil {
 					errCh <- err
 				} else {
-					jobCh <- resp.Job
+					respCh <- resp
 				}
 			}()
 			// Wait for complete or signal
@@ -136,8 +373,19 @@ func stopCmd() *cobra.Command {
 			select {
 			case err := <-errCh:
 				return fmt.Errorf("stopping job: %w", err)
-			case job := <-jobCh:
-				fmt.Println(prototext.Format(job))
+			case resp := <-respCh:
+				var failed int
+				for _, result := range resp.Results {
+					if result.Error != "" {
+						failed++
+						fmt.Fprintf(os.Stderr, "Failed stopping job %v: %v\n", result.JobId, result.Error)
+					} else {
+						fmt.Println(prototext.Format(result.Job))
+					}
+				}
+				if failed > 0 {
+					return fmt.Errorf("failed stopping %v of %v job(s)", failed, len(resp.Results))
+				}
 				return nil
 			case <-sigCh:
 				return fmt.Errorf("signal received, cancelling stop")
@@ -146,24 +394,71 @@ func stopCmd() *cobra.Command {
 	}
 	clientFlags.applyFlags(cmd.Flags())
 	cmd.Flags().BoolVar(&req.Force, "force", false, "Send SIGKILL instead of SIGTERM")
+	cmd.Flags().StringVarP(&req.LabelSelector, "selector", "l", "",
+		"Stop all incomplete jobs matching the label selector instead of by ID")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "How long to wait for jobs to stop, otherwise server default")
+	return cmd
+}
+
+func rmCmd() *cobra.Command {
+	var clientFlags clientFlags
+	cmd := &cobra.Command{
+		Use:          "rm JOB_ID...",
+		Short:        "Delete completed jobs and their output by ID",
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conn, client, err := clientFlags.dialClient()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			for _, id := range args {
+				if _, err := client.DeleteJob(cmd.Context(), &workergrpc.DeleteJobRequest{JobId: id}); err != nil {
+					return fmt.Errorf("deleting job %v: %w", id, err)
+				}
+				fmt.Println(id)
+			}
+			return nil
+		},
+	}
+	clientFlags.applyFlags(cmd.Flags())
 	return cmd
 }
 
 func submitCmd() *cobra.Command {
-	req := &workergrpc.SubmitJobRequest{Job: &workergrpc.Job{}}
+	var id, idempotencyKey, fromFile string
+	var template templateFlags
+	var jobFlags jobFlags
 	var clientFlags clientFlags
 	cmd := &cobra.Command{
 		Use:          "submit COMMAND [ARGS...]",
-		Short:        "Submit a command",
-		Args:         cobra.MinimumNArgs(1),
+		Short:        "Submit a command, or many jobs from a file",
 		SilenceUsage: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if fromFile != "" {
+				if len(args) > 0 {
+					return fmt.Errorf("command cannot be given with a jobs file")
+				}
+				return submitJobsFile(cmd.Context(), &clientFlags, fromFile)
+			} else if template.name != "" {
+				if len(args) > 0 {
+					return fmt.Errorf("command cannot be given with a template")
+				}
+				return template.submit(cmd.Context(), &clientFlags, id)
+			} else if len(args) == 0 {
+				return fmt.Errorf("command required")
+			}
 			conn, client, err := clientFlags.dialClient()
 			if err != nil {
 				return err
 			}
 			defer conn.Close()
-			req.Job.Command = args
+			req := &workergrpc.SubmitJobRequest{IdempotencyKey: idempotencyKey}
+			if req.Job, err = jobFlags.toJob(args); err != nil {
+				return err
+			}
+			req.Job.Id = id
 			// Submit and dump result
 			resp, err := client.SubmitJob(cmd.Context(), req)
 			if err != nil {
@@ -174,13 +469,287 @@ func submitCmd() *cobra.Command {
 		},
 	}
 	clientFlags.applyFlags(cmd.Flags())
-	cmd.Flags().StringVar(&req.Job.Id, "id", "", "Set the job ID, otherwise it is generated")
-	cmd.Flags().StringVar(&req.Job.RootFs, "root-fs", "", "Root filesystem to limit to")
+	cmd.Flags().StringVar(&id, "id", "", "Set the job ID, otherwise it is generated")
+	cmd.Flags().StringVar(&idempotencyKey, "idempotency-key", "",
+		"Key to safely retry the submission, returning the original job if already submitted")
+	cmd.Flags().StringVar(&fromFile, "from-file", "",
+		"YAML or JSON file with a list of jobs, in the workflow job format, to submit all or none of")
+	cmd.Flags().StringVar(&template.name, "template", "", "Name of a server template to render and submit")
+	cmd.Flags().Int32Var(&template.version, "template-version", 0, "Version of the template, otherwise the latest")
+	cmd.Flags().StringArrayVar(&template.params, "param", nil, "Template parameter as NAME=VALUE, can be repeated")
+	jobFlags.applyFlags(cmd.Flags())
+	return cmd
+}
+
+func submitJobsFile(ctx context.Context, clientFlags *clientFlags, file string) error {
+	jobs, err := loadJobsFile(file)
+	if err != nil {
+		return err
+	}
+	conn, client, err := clientFlags.dialClient()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := client.BatchSubmitJobs(ctx, &workergrpc.BatchSubmitJobsRequest{Jobs: jobs})
+	if err != nil {
+		return fmt.Errorf("submitting jobs: %w", err)
+	}
+	for _, job := range resp.Jobs {
+		fmt.Println(prototext.Format(job))
+	}
+	return nil
+}
+
+// templateFlags are flags for submitting from a template.
+type templateFlags struct {
+	name    string
+	version int32
+	params  []string
+}
+
+func (t *templateFlags) submit(ctx context.Context, clientFlags *clientFlags, id string) error {
+	req := &workergrpc.SubmitFromTemplateRequest{TemplateName: t.name, TemplateVersion: t.version, JobId: id}
+	var err error
+	if req.Params, err = parseKeyValues(t.params); err != nil {
+		return fmt.Errorf("invalid param: %w", err)
+	}
+	conn, client, err := clientFlags.dialClient()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := client.SubmitFromTemplate(ctx, req)
+	if err != nil {
+		return fmt.Errorf("submitting job from template: %w", err)
+	}
+	fmt.Println(prototext.Format(resp.Job))
+	return nil
+}
+
+// jobFlags are flags for the values of a job to submit.
+type jobFlags struct {
+	job         workergrpc.Job
+	rlimits     []string
+	resources   workergrpc.JobResources
+	env         []string
+	retry       retryFlags
+	ttl         time.Duration
+	labels      []string
+	annotations []string
+	webhooks    []string
+	webhookKey  string
+}
+
+type retryFlags struct {
+	maxAttempts    int32
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	jitter         float64
+	exitCodes      []int
+	signals        []string
+}
+
+func (j *jobFlags) applyFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&j.job.RootFs, "root-fs", "", "Root filesystem to limit to")
+	flags.StringVar(&j.job.User, "user", "", "Server-configured user to run as, otherwise namespace default")
+	flags.StringVar(&j.job.Hostname, "hostname", "", "Hostname of the job, otherwise the job ID")
+	flags.Int32Var(&j.job.Priority, "priority", 0, "Priority of the job relative to others in the namespace")
+	flags.StringVar(&j.resources.CpuSet, "cpu-set", "", "CPUs the job can run on in list format, e.g. 0-3,8")
+	flags.StringVar(&j.resources.MemoryNodes, "memory-nodes", "", "NUMA memory nodes the job can use in list format")
+	flags.Uint64Var(&j.resources.CpuWeight, "cpu-weight", 0, "Relative CPU weight of the job from 1 to 10000")
+	flags.Uint64Var(&j.resources.MemoryHigh, "memory-high", 0, "Bytes above which job memory use is throttled")
+	flags.StringSliceVar(&j.rlimits, "rlimit", nil,
+		"Resource limit as NAME=SOFT[:HARD] where NAME is nofile, core, fsize, or stack and values can be 'unlimited'")
+	flags.StringArrayVarP(&j.env, "env", "e", nil, "Environment variable as NAME=VALUE, can be repeated")
+	flags.StringArrayVarP(&j.labels, "label", "l", nil, "Label as KEY=VALUE, can be repeated")
+	flags.StringArrayVar(&j.annotations, "annotation", nil, "Annotation as KEY=VALUE, can be repeated")
+	flags.DurationVar(&j.ttl, "ttl", 0, "How long after completion the job is deleted, otherwise server retention")
+	flags.StringArrayVar(&j.webhooks, "webhook", nil, "URL to POST to when the job completes, can be repeated")
+	flags.StringVar(&j.webhookKey, "webhook-secret", "", "Secret to sign webhook payloads with, otherwise server default")
+	flags.Int32Var(&j.retry.maxAttempts, "max-attempts", 0, "Maximum attempts including the first to retry failures")
+	flags.DurationVar(&j.retry.initialBackoff, "retry-backoff", 0, "Backoff before the first retry, otherwise server default")
+	flags.DurationVar(&j.retry.maxBackoff, "retry-max-backoff", 0, "Maximum backoff between retries, otherwise server default")
+	flags.Float64Var(&j.retry.jitter, "retry-jitter", 0.2, "Fraction of each backoff to randomize")
+	flags.IntSliceVar(&j.retry.exitCodes, "retry-exit-codes", nil,
+		"Exit codes to retry, otherwise any failure is retried unless signals are set")
+	flags.StringSliceVar(&j.retry.signals, "retry-signals", nil,
+		"Terminating signals to retry (e.g. SIGKILL), otherwise any failure is retried unless exit codes are set")
+}
+
+// toJob returns the job for the flags with the given command.
+func (j *jobFlags) toJob(command []string) (*workergrpc.Job, error) {
+	job := &workergrpc.Job{
+		Command:  command,
+		RootFs:   j.job.RootFs,
+		User:     j.job.User,
+		Hostname: j.job.Hostname,
+		Priority: j.job.Priority,
+	}
+	if proto.Size(&j.resources) > 0 {
+		job.Resources = proto.Clone(&j.resources).(*workergrpc.JobResources)
+	}
+	var err error
+	if len(j.rlimits) > 0 {
+		if job.Rlimits, err = parseRLimits(j.rlimits); err != nil {
+			return nil, err
+		}
+	}
+	if j.ttl > 0 {
+		job.Ttl = durationpb.New(j.ttl)
+	}
+	if job.Env, err = parseKeyValues(j.env); err != nil {
+		return nil, fmt.Errorf("invalid env: %w", err)
+	} else if job.Labels, err = parseKeyValues(j.labels); err != nil {
+		return nil, fmt.Errorf("invalid label: %w", err)
+	} else if job.Annotations, err = parseKeyValues(j.annotations); err != nil {
+		return nil, fmt.Errorf("invalid annotation: %w", err)
+	}
+	for _, url := range j.webhooks {
+		job.Webhooks = append(job.Webhooks, &workergrpc.WebhookTarget{Url: url, Secret: j.webhookKey})
+	}
+	if j.retry.maxAttempts > 1 {
+		job.RetryPolicy = &workergrpc.RetryPolicy{
+			MaxAttempts:      j.retry.maxAttempts,
+			Jitter:           j.retry.jitter,
+			RetryableSignals: j.retry.signals,
+		}
+		if j.retry.initialBackoff > 0 {
+			job.RetryPolicy.InitialBackoff = durationpb.New(j.retry.initialBackoff)
+		}
+		if j.retry.maxBackoff > 0 {
+			job.RetryPolicy.MaxBackoff = durationpb.New(j.retry.maxBackoff)
+		}
+		for _, code := range j.retry.exitCodes {
+			job.RetryPolicy.RetryableExitCodes = append(job.RetryPolicy.RetryableExitCodes, int32(code))
+		}
+	}
+	return job, nil
+}
+
+// parseKeyValues parses KEY=VALUE strings into a map, or nil if there are none.
+func parseKeyValues(keyValues []string) (map[string]string, error) {
+	if len(keyValues) == 0 {
+		return nil, nil
+	}
+	ret := make(map[string]string, len(keyValues))
+	for _, keyValue := range keyValues {
+		eqIndex := strings.Index(keyValue, "=")
+		if eqIndex == -1 {
+			return nil, fmt.Errorf("%q missing '='", keyValue)
+		}
+		ret[keyValue[:eqIndex]] = keyValue[eqIndex+1:]
+	}
+	return ret, nil
+}
+
+func parseRLimits(rlimits []string) (*workergrpc.RLimits, error) {
+	var ret workergrpc.RLimits
+	for _, rlimit := range rlimits {
+		eqIndex := strings.Index(rlimit, "=")
+		if eqIndex == -1 {
+			return nil, fmt.Errorf("rlimit %q missing '='", rlimit)
+		}
+		// Hard defaults to soft if not present
+		var limit workergrpc.RLimit
+		values := strings.SplitN(rlimit[eqIndex+1:], ":", 2)
+		for i, value := range values {
+			v := worker.RLimitInfinity
+			if value != "unlimited" {
+				var err error
+				if v, err = strconv.ParseUint(value, 10, 64); err != nil {
+					return nil, fmt.Errorf("invalid rlimit value %q: %w", value, err)
+				}
+			}
+			if i == 0 {
+				limit.Soft, limit.Hard = v, v
+			} else {
+				limit.Hard = v
+			}
+		}
+		switch rlimit[:eqIndex] {
+		case "nofile":
+			ret.Nofile = &limit
+		case "core":
+			ret.Core = &limit
+		case "fsize":
+			ret.Fsize = &limit
+		case "stack":
+			ret.Stack = &limit
+		default:
+			return nil, fmt.Errorf("unknown rlimit %q", rlimit[:eqIndex])
+		}
+	}
+	return &ret, nil
+}
+
+func watchCmd() *cobra.Command {
+	var req workergrpc.WatchJobsRequest
+	var states []string
+	var resumeAfter uint64
+	var clientFlags clientFlags
+	cmd := &cobra.Command{
+		Use:          "watch",
+		Short:        "Watch job lifecycle events",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, state := range states {
+				value, ok := workergrpc.JobState_value["JOB_STATE_"+strings.ToUpper(state)]
+				if !ok {
+					return fmt.Errorf("unknown state %q", state)
+				}
+				req.States = append(req.States, workergrpc.JobState(value))
+			}
+			if cmd.Flags().Changed("resume-after") {
+				req.ResumeAfterSequence = wrapperspb.UInt64(resumeAfter)
+			}
+			conn, client, err := clientFlags.dialClient()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			ctx, cancel := context.WithCancel(cmd.Context())
+			defer cancel()
+			stream, err := client.WatchJobs(ctx, &req)
+			if err != nil {
+				return fmt.Errorf("starting watch: %w", err)
+			}
+			// Dump events in background
+			errCh := make(chan error, 1)
+			go func() {
+				for {
+					resp, err := stream.Recv()
+					if err != nil {
+						errCh <- err
+						return
+					}
+					fmt.Println(prototext.MarshalOptions{}.Format(resp.Event))
+				}
+			}()
+			// Wait for error or signal
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+			select {
+			case err := <-errCh:
+				return fmt.Errorf("reading stream: %w", err)
+			case <-sigCh:
+			}
+			return nil
+		},
+	}
+	clientFlags.applyFlags(cmd.Flags())
+	cmd.Flags().StringVarP(&req.LabelSelector, "selector", "l", "", "Label selector of jobs to watch")
+	cmd.Flags().StringSliceVar(&states, "state", nil,
+		"Only events where the job is in one of these states, e.g. running,completed")
+	cmd.Flags().Uint64Var(&resumeAfter, "resume-after", 0,
+		"Also send retained events after this sequence, otherwise only new events")
 	return cmd
 }
 
 func tailCmd() *cobra.Command {
 	var noPast, stderr, stdoutAndStderr bool
+	var attempt int32
 	var clientFlags clientFlags
 	cmd := &cobra.Command{
 		Use:          "tail JOB_ID",
@@ -197,6 +766,7 @@ func tailCmd() *cobra.Command {
 			req := &workergrpc.StreamJobOutputRequest{
 				JobId:         args[0],
 				FromBeginning: !noPast,
+				Attempt:       attempt,
 			}
 			if stderr {
 				if stdoutAndStderr {
@@ -216,12 +786,20 @@ func tailCmd() *cobra.Command {
 			// Dump output in background
 			errCh := make(chan error, 1)
 			go func() {
+				var lastAttempt int32
 				for {
 					resp, err := stream.Recv()
 					if err != nil {
 						errCh <- err
 						return
 					}
+					// Note when output moves on to a retry
+					if lastAttempt != 0 && resp.Attempt > lastAttempt {
+						log.Printf("Attempt %v started", resp.Attempt)
+					}
+					if resp.Attempt > lastAttempt {
+						lastAttempt = resp.Attempt
+					}
 					switch resp := resp.Response.(type) {
 					case *workergrpc.StreamJobOutputResponse_Stdout:
 						// TODO(cretz): We accept problems here with multibyte charsets
@@ -254,5 +832,7 @@ func tailCmd() *cobra.Command {
 	cmd.Flags().BoolVar(&stderr, "stderr", false, "Only stderr output instead of default stdout")
 	cmd.Flags().BoolVar(&stdoutAndStderr, "stdout-and-stderr", false,
 		"Both stdout and stderr output (in undefined order)")
+	cmd.Flags().Int32Var(&attempt, "attempt", 0,
+		"Only output of the given 1-based attempt, otherwise the latest attempt followed by any retries")
 	return cmd
 }
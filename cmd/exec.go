@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
@@ -20,6 +21,13 @@ func childExecCmd() *cobra.Command {
 	}
 }
 
+func rlimitExecCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rlimit-exec",
+		Short: "Internal command for applying resource limits to child executable",
+	}
+}
+
 func directExecCmd() *cobra.Command {
 	var withoutLimits bool
 	var root string
@@ -33,6 +41,7 @@ func directExecCmd() *cobra.Command {
 			if !withoutLimits {
 				config = worker.StandardConfig
 			}
+			config.Logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
 			var opts []worker.SubmitJobOption
 			if root != "" {
 				opts = append(opts, worker.WithRootFS(root))
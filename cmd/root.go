@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"syscall"
 
 	"github.com/cretz/teleworker/worker"
 	"github.com/spf13/cobra"
@@ -11,14 +12,23 @@ import (
 
 // Execute runs the command using program args and exits on failure.
 func Execute() {
-	// Take shortcut if second argument is child-exec
+	// Take shortcut if second argument is child-exec or rlimit-exec
 	if len(os.Args) > 1 && os.Args[1] == "child-exec" {
 		err := worker.ExecLimitedChild(os.Args[2:])
 		if exitErr, _ := err.(*exec.ExitError); exitErr != nil {
+			// Like shells, report a child terminated by a signal as 128 plus the
+			// signal number since we cannot re-raise it on ourselves as the init
+			// process of a PID namespace
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				os.Exit(128 + int(status.Signal()))
+			}
 			os.Exit(exitErr.ExitCode())
 		} else if err != nil {
 			log.Fatalf("Unexpected child-exec error: %v", err)
 		}
+	} else if len(os.Args) > 1 && os.Args[1] == "rlimit-exec" {
+		// This only returns on failure
+		log.Fatalf("Unexpected rlimit-exec error: %v", worker.ExecRLimitedChild(os.Args[2:]))
 	} else if err := rootCmd().Execute(); err != nil {
 		log.Fatal(err)
 	}
@@ -30,15 +40,27 @@ func rootCmd() *cobra.Command {
 		Short: "Worker for running jobs",
 	}
 	cmd.AddCommand(
+		auditCmd(),
 		childExecCmd(),
 		diagCmd(),
 		directExecCmd(),
 		genCertCmd(),
 		getCmd(),
+		labelCmd(),
+		listCmd(),
+		namespacesCmd(),
+		quotaCmd(),
+		rlimitExecCmd(),
+		rmCmd(),
+		scheduleCmd(),
 		serveCmd(),
 		stopCmd(),
 		submitCmd(),
 		tailCmd(),
+		templateCmd(),
+		watchCmd(),
+		webhooksCmd(),
+		workflowCmd(),
 	)
 	return cmd
 }
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cretz/teleworker/workergrpc"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/prototext"
+)
+
+func scheduleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schedule",
+		Short: "Manage schedules that submit jobs",
+	}
+	cmd.AddCommand(
+		scheduleCreateCmd(),
+		scheduleDeleteCmd(),
+		scheduleListCmd(),
+		schedulePauseCmd(true),
+		schedulePauseCmd(false),
+	)
+	return cmd
+}
+
+func scheduleCreateCmd() *cobra.Command {
+	schedule := &workergrpc.Schedule{}
+	var overlap string
+	var jobFlags jobFlags
+	var clientFlags clientFlags
+	cmd := &cobra.Command{
+		Use:          "create COMMAND [ARGS...]",
+		Short:        "Create a schedule that submits the command",
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch overlap {
+			case "skip":
+				schedule.Overlap = workergrpc.ScheduleOverlap_SCHEDULE_OVERLAP_SKIP
+			case "queue":
+				schedule.Overlap = workergrpc.ScheduleOverlap_SCHEDULE_OVERLAP_QUEUE
+			case "replace":
+				schedule.Overlap = workergrpc.ScheduleOverlap_SCHEDULE_OVERLAP_REPLACE
+			default:
+				return fmt.Errorf("unknown overlap %q", overlap)
+			}
+			conn, client, err := clientFlags.dialClient()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			if schedule.Job, err = jobFlags.toJob(args); err != nil {
+				return err
+			}
+			resp, err := client.CreateSchedule(cmd.Context(), &workergrpc.CreateScheduleRequest{Schedule: schedule})
+			if err != nil {
+				return fmt.Errorf("creating schedule: %w", err)
+			}
+			fmt.Println(prototext.Format(resp.Schedule))
+			return nil
+		},
+	}
+	clientFlags.applyFlags(cmd.Flags())
+	cmd.Flags().StringVar(&schedule.Id, "id", "", "Set the schedule ID, otherwise it is generated")
+	cmd.Flags().StringVar(&schedule.Cron, "cron", "", "Required cron expression of when to submit the job")
+	cmd.Flags().StringVar(&schedule.TimeZone, "time-zone", "", "IANA time zone of the cron expression, otherwise server local")
+	cmd.Flags().StringVar(&overlap, "overlap", "skip",
+		"What to do when the previous job is still active: skip, queue, or replace")
+	cmd.Flags().BoolVar(&schedule.Paused, "paused", false, "Create the schedule paused")
+	jobFlags.applyFlags(cmd.Flags())
+	return cmd
+}
+
+func scheduleListCmd() *cobra.Command {
+	var clientFlags clientFlags
+	cmd := &cobra.Command{
+		Use:          "list",
+		Short:        "List schedules",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conn, client, err := clientFlags.dialClient()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			resp, err := client.ListSchedules(cmd.Context(), &workergrpc.ListSchedulesRequest{})
+			if err != nil {
+				return fmt.Errorf("listing schedules: %w", err)
+			}
+			for _, schedule := range resp.Schedules {
+				fmt.Println(prototext.Format(schedule))
+			}
+			return nil
+		},
+	}
+	clientFlags.applyFlags(cmd.Flags())
+	return cmd
+}
+
+func scheduleDeleteCmd() *cobra.Command {
+	var clientFlags clientFlags
+	cmd := &cobra.Command{
+		Use:          "delete SCHEDULE_ID",
+		Short:        "Delete schedule by its ID",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conn, client, err := clientFlags.dialClient()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			_, err = client.DeleteSchedule(cmd.Context(), &workergrpc.DeleteScheduleRequest{ScheduleId: args[0]})
+			if err != nil {
+				return fmt.Errorf("deleting schedule: %w", err)
+			}
+			return nil
+		},
+	}
+	clientFlags.applyFlags(cmd.Flags())
+	return cmd
+}
+
+func schedulePauseCmd(paused bool) *cobra.Command {
+	use, short := "pause SCHEDULE_ID", "Pause schedule by its ID"
+	if !paused {
+		use, short = "resume SCHEDULE_ID", "Resume schedule by its ID"
+	}
+	var clientFlags clientFlags
+	cmd := &cobra.Command{
+		Use:          use,
+		Short:        short,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conn, client, err := clientFlags.dialClient()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			resp, err := client.PauseSchedule(cmd.Context(),
+				&workergrpc.PauseScheduleRequest{ScheduleId: args[0], Paused: paused})
+			if err != nil {
+				return fmt.Errorf("updating schedule: %w", err)
+			}
+			fmt.Println(prototext.Format(resp.Schedule))
+			return nil
+		},
+	}
+	clientFlags.applyFlags(cmd.Flags())
+	return cmd
+}
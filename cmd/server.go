@@ -2,17 +2,29 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/cretz/teleworker/worker"
 	"github.com/cretz/teleworker/workergrpc"
+	"github.com/cretz/teleworker/workerprom"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
 	"google.golang.org/grpc"
 )
 
@@ -20,12 +32,40 @@ func serveCmd() *cobra.Command {
 	var address string
 	var clientCACert, serverCert, serverKey string
 	var withoutLimits bool
+	var boundingCaps, effectiveCaps, ambientCaps []string
+	var resourceLimits worker.JobResourceLimits
+	var namespaceConfig string
+	var maxConcurrentJobs int
+	var preemption bool
+	var stateDir string
+	var idempotencyKeyTTL, completedJobRetention, stopTimeout time.Duration
+	var maxCompletedJobs, jobEventHistory int
+	var webhooks []string
+	var webhookSecretFile string
+	var webhookMaxAttempts int
+	var webhookJobHosts []string
+	var webhookAllowPrivate bool
+	var metricsAddress string
+	var traceExporter string
+	var traceJobEnv bool
+	var logFormat, logLevel string
+	var auditFile string
+	var auditMaxSize int64
+	var auditMaxFiles int
+	var auditFailClosed bool
+	var namespaceHierarchy bool
+	var policyFile, adminCACert string
+	var rateLimits workergrpc.RateLimitConfig
 	cmd := &cobra.Command{
 		Use:          "serve",
 		Short:        "Start gRPC server",
 		Args:         cobra.NoArgs,
 		SilenceUsage: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			logger, err := newLogger(logFormat, logLevel)
+			if err != nil {
+				return err
+			}
 			if clientCACert == "" {
 				return fmt.Errorf("client CA cert required")
 			} else if serverCert == "" {
@@ -46,17 +86,156 @@ func serveCmd() *cobra.Command {
 			if err != nil {
 				return fmt.Errorf("reading server key: %w", err)
 			}
+			// Client certificates from the admin CA are trusted too
+			var adminCACertBytes []byte
+			if adminCACert != "" {
+				if adminCACertBytes, err = os.ReadFile(adminCACert); err != nil {
+					return fmt.Errorf("reading admin CA cert: %w", err)
+				}
+				clientCACertBytes = append(append(clientCACertBytes, '\n'), adminCACertBytes...)
+			}
 			creds, err := workergrpc.MTLSServerCredentials(clientCACertBytes, serverCertBytes, serverKeyBytes)
 			if err != nil {
 				return fmt.Errorf("loading credentials: %w", err)
 			}
 			// Create worker
-			var w *worker.Worker
-			if withoutLimits {
-				w, err = worker.New(worker.Config{})
+			config := worker.StandardConfig
+			limitsChanged := false
+			for _, name := range []string{"bounding-cap", "effective-cap", "ambient-cap", "cpu-set", "memory-nodes",
+				"cpu-weight", "max-cpu-weight", "memory-high"} {
+				limitsChanged = limitsChanged || cmd.Flags().Changed(name)
+			}
+			if withoutLimits && limitsChanged {
+				return fmt.Errorf("capabilities and resources cannot be set without limits")
+			} else if withoutLimits {
+				config = worker.Config{}
 			} else {
-				w, err = worker.New(worker.StandardConfig)
+				// Copy the limits so the standard config is not mutated
+				limits := *config.Limits
+				config.Limits = &limits
+				if cmd.Flags().Changed("bounding-cap") {
+					config.Limits.Security.Capabilities.Bounding = boundingCaps
+				}
+				if cmd.Flags().Changed("effective-cap") {
+					config.Limits.Security.Capabilities.Effective = effectiveCaps
+				}
+				config.Limits.Security.Capabilities.Ambient = ambientCaps
+				config.Limits.ResourceLimits.CPUSet = resourceLimits.CPUSet
+				config.Limits.ResourceLimits.MemoryNodes = resourceLimits.MemoryNodes
+				config.Limits.ResourceLimits.CPUWeight = resourceLimits.CPUWeight
+				config.Limits.ResourceLimits.MaxCPUWeight = resourceLimits.MaxCPUWeight
+				config.Limits.ResourceLimits.MemoryHigh = resourceLimits.MemoryHigh
+			}
+			if namespaceConfig != "" {
+				if err := loadNamespaceConfig(namespaceConfig, &config); err != nil {
+					return err
+				}
+			}
+			config.MaxConcurrentJobs, config.Preemption = maxConcurrentJobs, preemption
+			config.StateDir, config.IdempotencyKeyTTL = stateDir, idempotencyKeyTTL
+			// Retention flags are for namespaces without their own config
+			if cmd.Flags().Changed("completed-job-retention") {
+				config.NamespaceDefaults.CompletedJobRetention = completedJobRetention
+			}
+			if cmd.Flags().Changed("max-completed-jobs") {
+				config.NamespaceDefaults.MaxCompletedJobs = maxCompletedJobs
+			}
+			config.JobEventHistory, config.Logger = jobEventHistory, logger
+			config.Webhooks.MaxAttempts = webhookMaxAttempts
+			config.Webhooks.JobTargetHosts = webhookJobHosts
+			config.Webhooks.AllowPrivateJobTargets = webhookAllowPrivate
+			if webhookSecretFile != "" {
+				b, err := os.ReadFile(webhookSecretFile)
+				if err != nil {
+					return fmt.Errorf("reading webhook secret: %w", err)
+				}
+				config.Webhooks.DefaultSecret = strings.TrimSpace(string(b))
+			}
+			for _, url := range webhooks {
+				config.Webhooks.Targets = append(config.Webhooks.Targets, worker.WebhookTarget{URL: url})
+			}
+			// Trace to the exporter if requested, flushing on return
+			serverOpts := []grpc.ServerOption{grpc.Creds(creds)}
+			serverOpts = append(serverOpts, workergrpc.LoggingServerOptions(logger)...)
+			if traceExporter != "" {
+				provider, err := newTracerProvider(cmd.Context(), traceExporter)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+					defer cancel()
+					if err := provider.Shutdown(ctx); err != nil {
+						logger.Error("Failed flushing traces", "error", err)
+					}
+				}()
+				config.Tracing.TracerProvider = provider
+				serverOpts = append(serverOpts, workergrpc.TracingServerOptions(provider, propagation.TraceContext{})...)
+			}
+			config.Tracing.InjectTraceParent = traceJobEnv
+			// Record every call to the audit log if requested
+			serviceOpts := []workergrpc.JobServiceOption{
+				workergrpc.WithLogger(logger),
+				workergrpc.WithStopTimeout(stopTimeout),
+			}
+			if namespaceHierarchy {
+				serviceOpts = append(serviceOpts, workergrpc.WithNamespaceHierarchy())
+			}
+			if rateLimits.SubmitRate < 0 || rateLimits.CallRate < 0 {
+				return fmt.Errorf("rate limits cannot be negative")
+			} else if rateLimits.SubmitRate > 0 || rateLimits.CallRate > 0 {
+				serviceOpts = append(serviceOpts, workergrpc.WithRateLimits(rateLimits))
+			}
+			if auditFile != "" {
+				audit, err := workergrpc.OpenAuditLog(workergrpc.AuditConfig{
+					Path:               auditFile,
+					MaxSize:            auditMaxSize,
+					MaxFiles:           auditMaxFiles,
+					FailClosed:         auditFailClosed,
+					NamespaceHierarchy: namespaceHierarchy,
+					Logger:             logger,
+				})
+				if err != nil {
+					return err
+				}
+				defer audit.Close()
+				serverOpts = append(serverOpts, workergrpc.AuditServerOptions(audit)...)
+				serviceOpts = append(serviceOpts, workergrpc.WithAuditLog(audit))
+			}
+			// Serve metrics in background if requested
+			if metricsAddress != "" {
+				reg := prometheus.NewRegistry()
+				reg.MustRegister(prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+				metrics, err := workerprom.New(reg)
+				if err != nil {
+					return fmt.Errorf("creating metrics: %w", err)
+				}
+				config.Metrics = metrics
+				serverOpts = append(serverOpts, workergrpc.MetricsServerOptions(metrics)...)
+				l, err := net.Listen("tcp", metricsAddress)
+				if err != nil {
+					return fmt.Errorf("listening to metrics address: %w", err)
+				}
+				mux := http.NewServeMux()
+				mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+				metricsSrv := &http.Server{Handler: mux}
+				defer metricsSrv.Close()
+				go func() {
+					if err := metricsSrv.Serve(l); err != http.ErrServerClosed {
+						logger.Error("Failed serving metrics", "error", err)
+					}
+				}()
+				logger.Info("Serving metrics", "url", "http://"+l.Addr().String()+"/metrics")
+			}
+			// Authorize after auditing and measuring so denied calls are recorded
+			if policyFile != "" || adminCACert != "" {
+				authorizer, err := loadPolicy(policyFile, adminCACertBytes)
+				if err != nil {
+					return err
+				}
+				serverOpts = append(serverOpts, workergrpc.AuthorizationServerOptions(authorizer)...)
 			}
+			w, err := worker.New(config)
 			if err != nil {
 				return fmt.Errorf("starting worker: %w", err)
 			}
@@ -67,9 +246,9 @@ func serveCmd() *cobra.Command {
 				w.Shutdown(ctx, true)
 			}()
 			// Serve in background
-			srv := grpc.NewServer(grpc.Creds(creds))
+			srv := grpc.NewServer(serverOpts...)
 			defer srv.Stop()
-			workergrpc.RegisterJobServiceServer(srv, workergrpc.NewJobServiceServer(w))
+			workergrpc.RegisterJobServiceServer(srv, workergrpc.NewJobServiceServer(w, serviceOpts...))
 			// This listener is closed before srv.Serve returns below
 			l, err := net.Listen("tcp", address)
 			if err != nil {
@@ -77,7 +256,7 @@ func serveCmd() *cobra.Command {
 			}
 			serveErrCh := make(chan error, 1)
 			go func() { serveErrCh <- srv.Serve(l) }()
-			log.Printf("Serving on %v", l.Addr().String())
+			logger.Info("Serving", "address", l.Addr().String())
 			// Wait for server error or signal
 			sigCh := make(chan os.Signal, 1)
 			signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
@@ -85,14 +264,14 @@ func serveCmd() *cobra.Command {
 			case err := <-serveErrCh:
 				return fmt.Errorf("serving service: %w", err)
 			case <-sigCh:
-				log.Printf("Termination signal received, attempting shutdown")
+				logger.Info("Termination signal received, attempting shutdown")
 				ctx, cancel := context.WithTimeout(cmd.Context(), 3*time.Second)
 				defer cancel()
 				err := w.Shutdown(ctx, false)
 				if err == nil {
 					return nil
 				}
-				log.Printf("Shutdown failed with %v, attempting forced shutdown", err)
+				logger.Warn("Shutdown failed, attempting forced shutdown", "error", err)
 				// Timeout, so we attempt a forced shutdown for a few seconds
 				ctx, cancel = context.WithTimeout(cmd.Context(), 3*time.Second)
 				defer cancel()
@@ -108,5 +287,153 @@ func serveCmd() *cobra.Command {
 	cmd.Flags().StringVar(&serverCert, "server-cert", "", "Required server certificate file to present to clients")
 	cmd.Flags().StringVar(&serverKey, "server-key", "", "Required server key file for server auth")
 	cmd.Flags().BoolVar(&withoutLimits, "without-limits", false, "Run without any resource limits")
+	cmd.Flags().StringSliceVar(&boundingCaps, "bounding-cap", nil,
+		"Capability kept in the bounding set of jobs, can be repeated, otherwise the defaults, set empty to drop all")
+	cmd.Flags().StringSliceVar(&effectiveCaps, "effective-cap", nil,
+		"Capability jobs have even as container root, can be repeated, otherwise the full bounding set for root")
+	cmd.Flags().StringSliceVar(&ambientCaps, "ambient-cap", nil,
+		"Capability raised in the ambient set of jobs so non-root jobs keep it, can be repeated")
+	cmd.Flags().StringVar(&resourceLimits.CPUSet, "cpu-set", "", "CPUs jobs can run on in list format, e.g. 0-3,8")
+	cmd.Flags().StringVar(&resourceLimits.MemoryNodes, "memory-nodes", "", "NUMA memory nodes jobs can use in list format")
+	cmd.Flags().Uint64Var(&resourceLimits.CPUWeight, "cpu-weight", 0, "Relative CPU weight of jobs from 1 to 10000")
+	cmd.Flags().Uint64Var(&resourceLimits.MaxCPUWeight, "max-cpu-weight", 0,
+		"Maximum CPU weight jobs can request, otherwise the CPU weight")
+	cmd.Flags().Uint64Var(&resourceLimits.MemoryHigh, "memory-high", 0, "Bytes above which job memory use is throttled")
+	cmd.Flags().StringVar(&namespaceConfig, "namespace-config", "", "JSON file of per-namespace configuration")
+	cmd.Flags().IntVar(&maxConcurrentJobs, "max-concurrent-jobs", 0, "Maximum jobs running at once before queuing, 0 for no maximum")
+	cmd.Flags().StringVar(&stateDir, "state-dir", "", "Directory to persist state such as schedules, otherwise not persisted")
+	cmd.Flags().BoolVar(&preemption, "preemption", false, "Gracefully stop lower priority jobs to make room for queued higher priority jobs")
+	cmd.Flags().DurationVar(&idempotencyKeyTTL, "idempotency-key-ttl", worker.DefaultIdempotencyKeyTTL,
+		"How long submission idempotency keys are retained")
+	cmd.Flags().DurationVar(&completedJobRetention, "completed-job-retention", 0,
+		"How long completed jobs without a TTL are retained in namespaces without namespace config, "+
+			"0 to retain until deleted or evicted")
+	cmd.Flags().IntVar(&maxCompletedJobs, "max-completed-jobs", 0,
+		"Maximum completed jobs retained per namespace without namespace config, oldest evicted first, "+
+			"0 for no maximum")
+	cmd.Flags().BoolVar(&namespaceHierarchy, "namespace-hierarchy", false,
+		"Granted namespaces also grant the namespaces beneath them, e.g. team1 grants team1/project1")
+	cmd.Flags().DurationVar(&stopTimeout, "stop-timeout", workergrpc.DefaultStopTimeout,
+		"How long stop calls wait for jobs to stop when the call has no timeout")
+	cmd.Flags().IntVar(&jobEventHistory, "job-event-history", worker.DefaultJobEventHistory,
+		"Job events retained per namespace for watchers to resume from")
+	cmd.Flags().StringArrayVar(&webhooks, "webhook", nil, "URL to POST to when any job completes, can be repeated")
+	cmd.Flags().StringVar(&webhookSecretFile, "webhook-secret-file", "",
+		"File of the secret to sign webhook payloads with when the webhook has none")
+	cmd.Flags().IntVar(&webhookMaxAttempts, "webhook-max-attempts", worker.DefaultWebhookMaxAttempts,
+		"Maximum attempts to deliver each webhook")
+	cmd.Flags().StringArrayVar(&webhookJobHosts, "webhook-job-host", nil,
+		"Host jobs may set their own webhooks to, '*' for any, can be repeated, otherwise jobs cannot have webhooks")
+	cmd.Flags().BoolVar(&webhookAllowPrivate, "webhook-allow-private", false,
+		"Allow job webhooks to connect to loopback, link-local, and private addresses")
+	cmd.Flags().StringVar(&metricsAddress, "metrics-address", "",
+		"Address to serve Prometheus metrics on at /metrics, otherwise not served")
+	cmd.Flags().StringVar(&traceExporter, "trace-exporter", "",
+		"OpenTelemetry trace exporter, 'otlp' (configured via OTEL_EXPORTER_OTLP_* env vars) or 'stdout', otherwise not traced")
+	cmd.Flags().BoolVar(&traceJobEnv, "trace-job-env", false,
+		"Set the trace context of each job attempt as the TRACEPARENT env var of the job")
+	cmd.Flags().StringVar(&logFormat, "log-format", "text", "Log format, 'text' or 'json'")
+	cmd.Flags().StringVar(&logLevel, "log-level", "info", "Minimum log level, 'debug', 'info', 'warn', or 'error'")
+	cmd.Flags().StringVar(&auditFile, "audit-file", "", "JSON lines file to record every call to, otherwise not recorded")
+	cmd.Flags().Int64Var(&auditMaxSize, "audit-max-size", workergrpc.DefaultAuditMaxSize,
+		"Size in bytes the audit file may reach before it is rotated")
+	cmd.Flags().IntVar(&auditMaxFiles, "audit-max-files", workergrpc.DefaultAuditMaxFiles,
+		"Number of rotated audit files kept")
+	cmd.Flags().BoolVar(&auditFailClosed, "audit-fail-closed", false,
+		"Record calls to the audit file before they run and reject them if that fails, otherwise failures are only logged")
+	cmd.Flags().StringVar(&policyFile, "policy-file", "",
+		"JSON file of the policy granting roles to client certificates, otherwise every caller is an operator")
+	cmd.Flags().StringVar(&adminCACert, "admin-ca-cert", "",
+		"CA certificate file to verify client certificates that are admins of every namespace")
+	cmd.Flags().Float64Var(&rateLimits.SubmitRate, "submit-rate", 0,
+		"Jobs each namespace can submit per second, 0 for no limit")
+	cmd.Flags().IntVar(&rateLimits.SubmitBurst, "submit-burst", 0,
+		"Jobs each namespace can submit at once, otherwise the submit rate")
+	cmd.Flags().Float64Var(&rateLimits.CallRate, "call-rate", 0,
+		"Calls each namespace can make per second, 0 for no limit")
+	cmd.Flags().IntVar(&rateLimits.CallBurst, "call-burst", 0,
+		"Calls each namespace can make at once, otherwise the call rate")
 	return cmd
 }
+
+// newLogger creates a logger to stderr in the named format at the named
+// minimum level.
+func newLogger(format, level string) (*slog.Logger, error) {
+	var opts slog.HandlerOptions
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	opts.Level = minLevel
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, &opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, &opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+// newTracerProvider creates a tracer provider that batches spans to the named
+// exporter.
+func newTracerProvider(ctx context.Context, exporter string) (*sdktrace.TracerProvider, error) {
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "otlp":
+		spanExporter, err = otlptracehttp.New(ctx)
+	case "stdout":
+		spanExporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating trace exporter: %w", err)
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("teleworker"))),
+	), nil
+}
+
+// namespaceConfigFile is the JSON structure of the namespace config file.
+type namespaceConfigFile struct {
+	// Used for namespaces not in Namespaces
+	Defaults   worker.NamespaceConfig            `json:"defaults"`
+	Namespaces map[string]worker.NamespaceConfig `json:"namespaces"`
+}
+
+func loadNamespaceConfig(file string, config *worker.Config) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("reading namespace config: %w", err)
+	}
+	var nsConfig namespaceConfigFile
+	if err := json.Unmarshal(b, &nsConfig); err != nil {
+		return fmt.Errorf("parsing namespace config: %w", err)
+	}
+	config.NamespaceDefaults, config.Namespaces = nsConfig.Defaults, nsConfig.Namespaces
+	return nil
+}
+
+// loadPolicy loads the policy from the file if present, otherwise every caller
+// is an operator.
+func loadPolicy(file string, adminCACert []byte) (*workergrpc.Authorizer, error) {
+	policy := workergrpc.Policy{DefaultRole: workergrpc.RoleOperator}
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading policy: %w", err)
+		}
+		policy = workergrpc.Policy{}
+		if err := json.Unmarshal(b, &policy); err != nil {
+			return nil, fmt.Errorf("parsing policy: %w", err)
+		}
+	}
+	policy.AdminCACert = adminCACert
+	authorizer, err := workergrpc.NewAuthorizer(policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	return authorizer, nil
+}
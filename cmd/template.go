@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cretz/teleworker/workergrpc"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/prototext"
+)
+
+func templateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "template",
+		Short: "Manage versioned job templates",
+	}
+	cmd.AddCommand(templateCreateCmd(), templateListCmd())
+	return cmd
+}
+
+func templateCreateCmd() *cobra.Command {
+	var params, requiredParams []string
+	var jobFlags jobFlags
+	var clientFlags clientFlags
+	cmd := &cobra.Command{
+		Use:   "create NAME COMMAND [ARGS...]",
+		Short: "Create the next version of a template for the command",
+		Long: "Create the next version of a template for the command. Args and env values can contain\n" +
+			"placeholders of the form {{name}} for parameters that are replaced on submission.",
+		Args:         cobra.MinimumNArgs(2),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			template := &workergrpc.JobTemplate{Name: args[0]}
+			var err error
+			if template.Job, err = jobFlags.toJob(args[1:]); err != nil {
+				return err
+			}
+			for _, name := range requiredParams {
+				template.Params = append(template.Params, &workergrpc.TemplateParam{Name: name, Required: true})
+			}
+			for _, param := range params {
+				pbParam := &workergrpc.TemplateParam{Name: param}
+				if eqIndex := strings.Index(param, "="); eqIndex >= 0 {
+					pbParam.Name, pbParam.Default = param[:eqIndex], param[eqIndex+1:]
+				}
+				template.Params = append(template.Params, pbParam)
+			}
+			conn, client, err := clientFlags.dialClient()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			resp, err := client.CreateTemplate(cmd.Context(), &workergrpc.CreateTemplateRequest{Template: template})
+			if err != nil {
+				return fmt.Errorf("creating template: %w", err)
+			}
+			fmt.Println(prototext.Format(resp.Template))
+			return nil
+		},
+	}
+	clientFlags.applyFlags(cmd.Flags())
+	cmd.Flags().StringArrayVar(&params, "param", nil, "Optional parameter as NAME[=DEFAULT], can be repeated")
+	cmd.Flags().StringArrayVar(&requiredParams, "required-param", nil, "Required parameter name, can be repeated")
+	jobFlags.applyFlags(cmd.Flags())
+	return cmd
+}
+
+func templateListCmd() *cobra.Command {
+	var clientFlags clientFlags
+	cmd := &cobra.Command{
+		Use:          "list [NAME]",
+		Short:        "List the latest version of every template, or every version of the named one",
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var req workergrpc.ListTemplatesRequest
+			if len(args) > 0 {
+				req.Name = args[0]
+			}
+			conn, client, err := clientFlags.dialClient()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			resp, err := client.ListTemplates(cmd.Context(), &req)
+			if err != nil {
+				return fmt.Errorf("listing templates: %w", err)
+			}
+			for _, template := range resp.Templates {
+				fmt.Println(prototext.Format(template))
+			}
+			return nil
+		},
+	}
+	clientFlags.applyFlags(cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cretz/teleworker/workergrpc"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/prototext"
+	"gopkg.in/yaml.v3"
+)
+
+func workflowCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "workflow",
+		Short: "Manage workflows of dependent jobs",
+	}
+	cmd.AddCommand(workflowGetCmd(), workflowSubmitCmd())
+	return cmd
+}
+
+// workflowFile is the YAML structure of a workflow file. For example:
+//
+//	id: nightly
+//	jobs:
+//	  - id: build
+//	    command: [make, build]
+//	  - id: test
+//	    command: [make, test]
+//	    depends_on: [build]
+//	  - id: report
+//	    command: [make, report]
+//	    depends_on:
+//	      - job: test
+//	        condition: always
+type workflowFile struct {
+	ID   string            `yaml:"id"`
+	Jobs []workflowFileJob `yaml:"jobs"`
+}
+
+type workflowFileJob struct {
+	ID       string            `yaml:"id"`
+	Command  []string          `yaml:"command"`
+	Env      map[string]string `yaml:"env"`
+	RootFS   string            `yaml:"root_fs"`
+	User     string            `yaml:"user"`
+	Hostname string            `yaml:"hostname"`
+	Priority int32             `yaml:"priority"`
+	// Same format as the submit rlimit flag
+	RLimits     []string                 `yaml:"rlimits"`
+	DependsOn   []workflowFileDependency `yaml:"depends_on"`
+	Labels      map[string]string        `yaml:"labels"`
+	Annotations map[string]string        `yaml:"annotations"`
+}
+
+// workflowFileDependency can be a job ID string to depend on its success.
+type workflowFileDependency struct {
+	Job string `yaml:"job"`
+	// success, failure, or always
+	Condition string `yaml:"condition"`
+}
+
+func (w *workflowFileDependency) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&w.Job)
+	}
+	type plain workflowFileDependency
+	return node.Decode((*plain)(w))
+}
+
+func loadWorkflowFile(file string) (*workergrpc.Workflow, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading workflow file: %w", err)
+	}
+	var wf workflowFile
+	if err := yaml.Unmarshal(b, &wf); err != nil {
+		return nil, fmt.Errorf("parsing workflow file: %w", err)
+	}
+	workflow := &workergrpc.Workflow{Id: wf.ID}
+	for _, fileJob := range wf.Jobs {
+		job, err := fileJob.toJob()
+		if err != nil {
+			return nil, err
+		}
+		workflow.Jobs = append(workflow.Jobs, job)
+	}
+	return workflow, nil
+}
+
+// loadJobsFile loads a YAML or JSON file with a list of jobs in the same
+// structure as workflow file jobs.
+func loadJobsFile(file string) ([]*workergrpc.Job, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading jobs file: %w", err)
+	}
+	// YAML is a superset of JSON
+	var fileJobs []workflowFileJob
+	if err := yaml.Unmarshal(b, &fileJobs); err != nil {
+		return nil, fmt.Errorf("parsing jobs file: %w", err)
+	}
+	jobs := make([]*workergrpc.Job, len(fileJobs))
+	for i, fileJob := range fileJobs {
+		if jobs[i], err = fileJob.toJob(); err != nil {
+			return nil, err
+		}
+	}
+	return jobs, nil
+}
+
+func (w *workflowFileJob) toJob() (*workergrpc.Job, error) {
+	job := &workergrpc.Job{
+		Id:          w.ID,
+		Command:     w.Command,
+		Env:         w.Env,
+		RootFs:      w.RootFS,
+		User:        w.User,
+		Hostname:    w.Hostname,
+		Priority:    w.Priority,
+		Labels:      w.Labels,
+		Annotations: w.Annotations,
+	}
+	if len(w.RLimits) > 0 {
+		var err error
+		if job.Rlimits, err = parseRLimits(w.RLimits); err != nil {
+			return nil, fmt.Errorf("job %v: %w", w.ID, err)
+		}
+	}
+	for _, dep := range w.DependsOn {
+		pbDep := &workergrpc.JobDependency{JobId: dep.Job}
+		switch dep.Condition {
+		case "", "success":
+			pbDep.Condition = workergrpc.DependencyCondition_DEPENDENCY_CONDITION_ON_SUCCESS
+		case "failure":
+			pbDep.Condition = workergrpc.DependencyCondition_DEPENDENCY_CONDITION_ON_FAILURE
+		case "always":
+			pbDep.Condition = workergrpc.DependencyCondition_DEPENDENCY_CONDITION_ALWAYS
+		default:
+			return nil, fmt.Errorf("job %v: unknown dependency condition %q", w.ID, dep.Condition)
+		}
+		job.Dependencies = append(job.Dependencies, pbDep)
+	}
+	return job, nil
+}
+
+func workflowSubmitCmd() *cobra.Command {
+	var id string
+	var clientFlags clientFlags
+	cmd := &cobra.Command{
+		Use:          "submit FILE",
+		Short:        "Submit a workflow from a YAML file",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			workflow, err := loadWorkflowFile(args[0])
+			if err != nil {
+				return err
+			}
+			if id != "" {
+				workflow.Id = id
+			}
+			conn, client, err := clientFlags.dialClient()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			resp, err := client.SubmitWorkflow(cmd.Context(), &workergrpc.SubmitWorkflowRequest{Workflow: workflow})
+			if err != nil {
+				return fmt.Errorf("submitting workflow: %w", err)
+			}
+			fmt.Println(prototext.Format(resp.Workflow))
+			return nil
+		},
+	}
+	clientFlags.applyFlags(cmd.Flags())
+	cmd.Flags().StringVar(&id, "id", "", "Set the workflow ID, otherwise the file ID or generated")
+	return cmd
+}
+
+func workflowGetCmd() *cobra.Command {
+	var clientFlags clientFlags
+	cmd := &cobra.Command{
+		Use:          "get WORKFLOW_ID",
+		Short:        "Get workflow and the state of its jobs by its ID",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			conn, client, err := clientFlags.dialClient()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			resp, err := client.GetWorkflow(cmd.Context(), &workergrpc.GetWorkflowRequest{WorkflowId: args[0]})
+			if err != nil {
+				return fmt.Errorf("getting workflow: %w", err)
+			}
+			fmt.Println(prototext.Format(resp.Workflow))
+			return nil
+		},
+	}
+	clientFlags.applyFlags(cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,150 @@
+//go:build linux
+// +build linux
+
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cretz/teleworker/worker"
+	"github.com/cretz/teleworker/workergrpc"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAudit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	// Start server with a tiny audit log so it rotates
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := workergrpc.OpenAuditLog(workergrpc.AuditConfig{
+		Path:    auditPath,
+		MaxSize: 2048,
+	})
+	require.NoError(t, err)
+	defer audit.Close()
+	authorizer, err := workergrpc.NewAuthorizer(workergrpc.Policy{
+		DefaultRole: workergrpc.RoleOperator,
+		Rules:       []workergrpc.PolicyRule{{OU: "admin", Role: workergrpc.RoleAdmin}},
+	})
+	require.NoError(t, err)
+	serverOpts := append(workergrpc.AuditServerOptions(audit), workergrpc.AuthorizationServerOptions(authorizer)...)
+	srv := startServerWithOptions(t, worker.Config{}, serverOpts, workergrpc.WithAuditLog(audit))
+	defer srv.Stop()
+	client := dialClient(t, srv, "client1")
+	defer client.Close()
+	admin := dialClient(t, srv, "admin")
+	defer admin.Close()
+	// Submit a job with a secret env var and stop it
+	submitResp, err := client.SubmitJob(ctx, &workergrpc.SubmitJobRequest{Job: &workergrpc.Job{
+		Command: []string{"sleep", "10"},
+		Env:     map[string]string{"TOKEN": "very-secret"},
+	}})
+	require.NoError(t, err)
+	jobID := submitResp.Job.Id
+	_, err = client.StopJob(ctx, &workergrpc.StopJobRequest{JobId: jobID, Force: true})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = client.GetJob(ctx, &workergrpc.GetJobRequest{JobId: "missing"})
+		require.Equal(t, codes.NotFound, status.Code(err))
+	}
+	// Only the admin can query
+	_, err = client.QueryAudit(ctx, &workergrpc.QueryAuditRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	// Who stopped the job, across rotation
+	resp, err := admin.QueryAudit(ctx, &workergrpc.QueryAuditRequest{JobId: jobID})
+	require.NoError(t, err)
+	require.Len(t, resp.Entries, 2)
+	stop, submit := resp.Entries[0], resp.Entries[1]
+	require.Equal(t, "/teleworker.worker.JobService/StopJob", stop.Method)
+	require.Equal(t, "client1", stop.Namespace)
+	require.Equal(t, []string{"client1"}, stop.Actor.OrganizationalUnits)
+	require.NotEmpty(t, stop.Actor.Serial)
+	require.Equal(t, codes.OK.String(), stop.Code)
+	require.Equal(t, "/teleworker.worker.JobService/SubmitJob", submit.Method)
+	require.Contains(t, submit.Request, "REDACTED")
+	require.NotContains(t, submit.Request, "very-secret")
+	_, err = os.Stat(auditPath + ".1")
+	require.NoError(t, err)
+	// Failures are recorded and filterable by namespace and method
+	resp, err = admin.QueryAudit(ctx, &workergrpc.QueryAuditRequest{
+		Namespace: "client1",
+		Method:    "GetJob",
+		Limit:     3,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Entries, 3)
+	require.Equal(t, codes.NotFound.String(), resp.Entries[0].Code)
+	require.Equal(t, []string{"missing"}, resp.Entries[0].JobIds)
+}
+
+func TestAuditStreams(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	audit, err := workergrpc.OpenAuditLog(workergrpc.AuditConfig{Path: filepath.Join(t.TempDir(), "audit.log")})
+	require.NoError(t, err)
+	defer audit.Close()
+	srv := startServerWithOptions(t, worker.Config{}, workergrpc.AuditServerOptions(audit))
+	defer srv.Stop()
+	client := dialClient(t, srv, "client1")
+	defer client.Close()
+	// Recorded as soon as the stream has its request
+	watchCtx, watchCancel := context.WithCancel(ctx)
+	defer watchCancel()
+	_, err = client.WatchJobs(watchCtx, &workergrpc.WatchJobsRequest{LabelSelector: "app=foo"})
+	require.NoError(t, err)
+	var entries []*workergrpc.AuditEntry
+	require.Eventually(t, func() bool {
+		entries, err = audit.Query(workergrpc.AuditQuery{Method: "WatchJobs"})
+		require.NoError(t, err)
+		return len(entries) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.True(t, entries[0].Started)
+	require.Empty(t, entries[0].Code)
+	require.Equal(t, "client1", entries[0].Namespace)
+	require.Contains(t, entries[0].Request, "app=foo")
+	// And again when it ends
+	watchCancel()
+	require.Eventually(t, func() bool {
+		entries, err = audit.Query(workergrpc.AuditQuery{Method: "WatchJobs"})
+		require.NoError(t, err)
+		return len(entries) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.False(t, entries[0].Started)
+	require.NotEmpty(t, entries[0].Code)
+}
+
+func TestAuditFailClosed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	for _, failClosed := range []bool{false, true} {
+		// Closed so recording always fails
+		audit, err := workergrpc.OpenAuditLog(workergrpc.AuditConfig{
+			Path:       filepath.Join(t.TempDir(), "audit.log"),
+			FailClosed: failClosed,
+		})
+		require.NoError(t, err)
+		require.NoError(t, audit.Close())
+		srv := startServerWithOptions(t, worker.Config{}, workergrpc.AuditServerOptions(audit))
+		defer srv.Stop()
+		client := dialClient(t, srv, "client1")
+		defer client.Close()
+		_, err = client.GetJob(ctx, &workergrpc.GetJobRequest{JobId: "missing"})
+		watch, watchErr := client.WatchJobs(ctx, &workergrpc.WatchJobsRequest{LabelSelector: "app in foo"})
+		require.NoError(t, watchErr)
+		_, watchErr = watch.Recv()
+		if failClosed {
+			require.Equal(t, codes.Unavailable, status.Code(err))
+			require.Equal(t, codes.Unavailable, status.Code(watchErr))
+		} else {
+			// Calls proceed
+			require.Equal(t, codes.NotFound, status.Code(err))
+			require.Equal(t, codes.InvalidArgument, status.Code(watchErr))
+		}
+	}
+}
@@ -0,0 +1,145 @@
+//go:build linux
+// +build linux
+
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cretz/teleworker/worker"
+	"github.com/cretz/teleworker/workergrpc"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestWatchJobs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	srv := startServer(t, worker.Config{JobEventHistory: 20})
+	defer srv.Stop()
+	client := dialClient(t, srv, "client1")
+	defer client.Close()
+
+	// One labeled job and one not
+	fooJob := client.submitLabeledAndWait(t, ctx, map[string]string{"app": "foo"}, "true")
+	client.submitAndWait(t, ctx, "true")
+
+	// Only completed events of the labeled job are sent
+	watch, err := client.WatchJobs(ctx, &workergrpc.WatchJobsRequest{
+		LabelSelector:       "app=foo",
+		States:              []workergrpc.JobState{workergrpc.JobState_JOB_STATE_COMPLETED},
+		ResumeAfterSequence: wrapperspb.UInt64(0),
+	})
+	require.NoError(t, err)
+	resp, err := watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, fooJob.Id, resp.Event.JobId)
+	require.Equal(t, workergrpc.JobEventType_JOB_EVENT_TYPE_COMPLETED, resp.Event.Type)
+	require.Equal(t, map[string]string{"app": "foo"}, resp.Event.Labels)
+	// New events are filtered the same way
+	client.submitAndWait(t, ctx, "true")
+	otherFooJob := client.submitLabeledAndWait(t, ctx, map[string]string{"app": "foo"}, "true")
+	resp, err = watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, otherFooJob.Id, resp.Event.JobId)
+	require.Equal(t, workergrpc.JobEventType_JOB_EVENT_TYPE_COMPLETED, resp.Event.Type)
+
+	// Resuming sends events after the sequence
+	watch, err = client.WatchJobs(ctx, &workergrpc.WatchJobsRequest{ResumeAfterSequence: wrapperspb.UInt64(0)})
+	require.NoError(t, err)
+	first, err := watch.Recv()
+	require.NoError(t, err)
+	second, err := watch.Recv()
+	require.NoError(t, err)
+	watch, err = client.WatchJobs(ctx, &workergrpc.WatchJobsRequest{
+		ResumeAfterSequence: wrapperspb.UInt64(first.Event.Sequence),
+	})
+	require.NoError(t, err)
+	resp, err = watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, second.Event.Sequence, resp.Event.Sequence)
+	require.Equal(t, second.Event.JobId, resp.Event.JobId)
+
+	// Enough jobs to push the first events out of history
+	for i := 0; i < 10; i++ {
+		client.submitAndWait(t, ctx, "true")
+	}
+	watch, err = client.WatchJobs(ctx, &workergrpc.WatchJobsRequest{
+		ResumeAfterSequence: wrapperspb.UInt64(first.Event.Sequence),
+	})
+	require.NoError(t, err)
+	_, err = watch.Recv()
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+	// Sequences not yet published are also out of range
+	watch, err = client.WatchJobs(ctx, &workergrpc.WatchJobsRequest{ResumeAfterSequence: wrapperspb.UInt64(1000000)})
+	require.NoError(t, err)
+	_, err = watch.Recv()
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+
+	// Invalid selector
+	watch, err = client.WatchJobs(ctx, &workergrpc.WatchJobsRequest{LabelSelector: "app in foo"})
+	require.NoError(t, err)
+	_, err = watch.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestWatchJobsOutputThrottled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	srv := startServer(t, worker.Config{})
+	defer srv.Stop()
+	client := dialClient(t, srv, "client1")
+	defer client.Close()
+
+	// Output every 50ms for about 1.5s
+	job := client.submitAndWait(t, ctx, "sh", "-c", "for i in $(seq 30); do echo a; sleep 0.05; done")
+	watch, err := client.WatchJobs(ctx, &workergrpc.WatchJobsRequest{ResumeAfterSequence: wrapperspb.UInt64(0)})
+	require.NoError(t, err)
+	var outputEvents []*workergrpc.JobEvent
+	for {
+		resp, err := watch.Recv()
+		require.NoError(t, err)
+		require.Equal(t, job.Id, resp.Event.JobId)
+		if resp.Event.Type == workergrpc.JobEventType_JOB_EVENT_TYPE_OUTPUT {
+			outputEvents = append(outputEvents, resp.Event)
+		} else if resp.Event.Type == workergrpc.JobEventType_JOB_EVENT_TYPE_COMPLETED {
+			// Completed event has the final size
+			require.EqualValues(t, 60, resp.Event.StdoutSize)
+			break
+		}
+	}
+	// Far fewer output events than writes, at least the interval apart
+	require.NotEmpty(t, outputEvents)
+	require.LessOrEqual(t, len(outputEvents), 5)
+	for i := 1; i < len(outputEvents); i++ {
+		between := outputEvents[i].Time.AsTime().Sub(outputEvents[i-1].Time.AsTime())
+		require.GreaterOrEqual(t, between, 450*time.Millisecond)
+		require.Greater(t, outputEvents[i].StdoutSize, outputEvents[i-1].StdoutSize)
+	}
+}
+
+func (c *client) submitLabeledAndWait(
+	t *testing.T,
+	ctx context.Context,
+	labels map[string]string,
+	command ...string,
+) *workergrpc.Job {
+	resp, err := c.SubmitJob(ctx, &workergrpc.SubmitJobRequest{Job: &workergrpc.Job{Command: command, Labels: labels}})
+	require.NoError(t, err)
+	for {
+		job, err := c.GetJob(ctx, &workergrpc.GetJobRequest{JobId: resp.Job.Id})
+		require.NoError(t, err)
+		if job.Job.ExitCode != nil {
+			return job.Job
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -26,7 +26,7 @@ func TestServer(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 	defer cancel()
 	// Start server
-	srv := startServer(t)
+	srv := startServer(t, worker.Config{})
 	defer srv.Stop()
 	// Dial 2 clients
 	client1 := dialClient(t, srv, "client1")
@@ -115,7 +115,16 @@ type server struct {
 	clientCAKey  []byte
 }
 
-func startServer(t *testing.T) *server {
+func startServer(t *testing.T, config worker.Config) *server {
+	return startServerWithOptions(t, config, nil)
+}
+
+func startServerWithOptions(
+	t *testing.T,
+	config worker.Config,
+	serverOpts []grpc.ServerOption,
+	serviceOpts ...workergrpc.JobServiceOption,
+) *server {
 	// Create server CA and server cert
 	serverCACert, serverCAKey, err := workergrpc.GenerateCertificate(workergrpc.GenerateCertificateConfig{
 		CA: true,
@@ -132,14 +141,14 @@ func startServer(t *testing.T) *server {
 		CA: true,
 	})
 	require.NoError(t, err)
-	// Create non-limited worker
-	w, err := worker.New(worker.Config{})
+	// Create worker, non-limited unless the config has limits
+	w, err := worker.New(config)
 	require.NoError(t, err)
 	// Create server
 	creds, err := workergrpc.MTLSServerCredentials(clientCACert, cert, key)
 	require.NoError(t, err)
-	srv := grpc.NewServer(grpc.Creds(creds))
-	workergrpc.RegisterJobServiceServer(srv, workergrpc.NewJobServiceServer(w))
+	srv := grpc.NewServer(append([]grpc.ServerOption{grpc.Creds(creds)}, serverOpts...)...)
+	workergrpc.RegisterJobServiceServer(srv, workergrpc.NewJobServiceServer(w, serviceOpts...))
 	l, err := net.Listen("tcp", "127.0.0.1:")
 	require.NoError(t, err)
 	go func() {
@@ -163,12 +172,13 @@ type client struct {
 }
 
 func dialClient(t *testing.T, s *server, ouNamespace string) *client {
+	return dialClientWithConfig(t, s, workergrpc.GenerateCertificateConfig{OU: ouNamespace})
+}
+
+func dialClientWithConfig(t *testing.T, s *server, config workergrpc.GenerateCertificateConfig) *client {
 	// Create client cert
-	cert, key, err := workergrpc.GenerateCertificate(workergrpc.GenerateCertificateConfig{
-		SignerCert: s.clientCACert,
-		SignerKey:  s.clientCAKey,
-		OU:         ouNamespace,
-	})
+	config.SignerCert, config.SignerKey = s.clientCACert, s.clientCAKey
+	cert, key, err := workergrpc.GenerateCertificate(config)
 	require.NoError(t, err)
 	// Dial
 	creds, err := workergrpc.MTLSClientCredentials(s.serverCACert, cert, key)
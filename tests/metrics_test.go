@@ -0,0 +1,79 @@
+//go:build linux
+// +build linux
+
+package tests
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cretz/teleworker/worker"
+	"github.com/cretz/teleworker/workergrpc"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCMetrics(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	// Measure before authorizing, as the server command does, so denied calls
+	// are measured
+	authorizer, err := workergrpc.NewAuthorizer(workergrpc.Policy{DefaultRole: workergrpc.RoleViewer})
+	require.NoError(t, err)
+	var metrics recordingMetrics
+	srv := startServerWithOptions(t, worker.Config{}, append(
+		workergrpc.MetricsServerOptions(&metrics),
+		workergrpc.AuthorizationServerOptions(authorizer)...,
+	))
+	defer srv.Stop()
+	client := dialClient(t, srv, "client1")
+	defer client.Close()
+
+	_, err = client.GetJob(ctx, &workergrpc.GetJobRequest{JobId: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.SubmitJob(ctx, &workergrpc.SubmitJobRequest{Job: &workergrpc.Job{Command: []string{"true"}}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	// Streams are measured when they end
+	watch, err := client.WatchJobs(ctx, &workergrpc.WatchJobsRequest{LabelSelector: "app in foo"})
+	require.NoError(t, err)
+	_, err = watch.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	require.Eventually(t, func() bool { return len(metrics.handled()) == 3 }, 5*time.Second, 10*time.Millisecond)
+	handled := metrics.handled()
+	require.Equal(t, "/teleworker.worker.JobService/GetJob", handled[0].method)
+	require.Equal(t, codes.NotFound, handled[0].code)
+	require.Equal(t, "/teleworker.worker.JobService/SubmitJob", handled[1].method)
+	require.Equal(t, codes.PermissionDenied, handled[1].code)
+	require.Equal(t, "/teleworker.worker.JobService/WatchJobs", handled[2].method)
+	require.Equal(t, codes.InvalidArgument, handled[2].code)
+	for _, h := range handled {
+		require.Positive(t, h.duration)
+	}
+}
+
+type handledMethod struct {
+	method   string
+	code     codes.Code
+	duration time.Duration
+}
+
+type recordingMetrics struct {
+	lock    sync.Mutex
+	methods []handledMethod
+}
+
+func (r *recordingMetrics) MethodHandled(method string, code codes.Code, duration time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.methods = append(r.methods, handledMethod{method, code, duration})
+}
+
+func (r *recordingMetrics) handled() []handledMethod {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]handledMethod(nil), r.methods...)
+}
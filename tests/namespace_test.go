@@ -0,0 +1,67 @@
+//go:build linux
+// +build linux
+
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cretz/teleworker/worker"
+	"github.com/cretz/teleworker/workergrpc"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNamespaceGrants(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	srv := startServerWithOptions(t, worker.Config{}, nil, workergrpc.WithNamespaceHierarchy())
+	defer srv.Stop()
+	// Defaults to the OU, also granted team1 and everything beneath it
+	client := dialClientWithConfig(t, srv, workergrpc.GenerateCertificateConfig{
+		OU:         "personal",
+		Namespaces: []string{"team1"},
+	})
+	defer client.Close()
+	project := dialClient(t, srv, "team1/project1")
+	defer project.Close()
+	submitResp, err := client.SubmitJob(ctx, &workergrpc.SubmitJobRequest{
+		Namespace: "team1/project1",
+		Job:       &workergrpc.Job{Command: []string{"echo", "hi"}},
+	})
+	require.NoError(t, err)
+	// Visible in the project namespace, but not the default one
+	_, err = project.GetJob(ctx, &workergrpc.GetJobRequest{JobId: submitResp.Job.Id})
+	require.NoError(t, err)
+	_, err = client.GetJob(ctx, &workergrpc.GetJobRequest{JobId: submitResp.Job.Id})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	// Children cannot act in parents or siblings
+	_, err = project.ListJobs(ctx, &workergrpc.ListJobsRequest{Namespace: "team1"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.ListJobs(ctx, &workergrpc.ListJobsRequest{Namespace: "team2"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.ListJobs(ctx, &workergrpc.ListJobsRequest{Namespace: "team1//project1"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestNamespaceGrantsWithoutHierarchy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	srv := startServer(t, worker.Config{})
+	defer srv.Stop()
+	client := dialClientWithConfig(t, srv, workergrpc.GenerateCertificateConfig{
+		OU:         "personal",
+		Namespaces: []string{"team1"},
+	})
+	defer client.Close()
+	// Every granted namespace, but nothing beneath them
+	_, err := client.ListJobs(ctx, &workergrpc.ListJobsRequest{Namespace: "team1"})
+	require.NoError(t, err)
+	_, err = client.ListJobs(ctx, &workergrpc.ListJobsRequest{Namespace: "personal"})
+	require.NoError(t, err)
+	_, err = client.ListJobs(ctx, &workergrpc.ListJobsRequest{Namespace: "team1/project1"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
@@ -0,0 +1,146 @@
+//go:build linux
+// +build linux
+
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cretz/teleworker/worker"
+	"github.com/cretz/teleworker/workergrpc"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPolicy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	// Everyone can view, CI certs can submit, and embedded roles are trusted
+	authorizer, err := workergrpc.NewAuthorizer(workergrpc.Policy{
+		DefaultRole: workergrpc.RoleViewer,
+		Rules: []workergrpc.PolicyRule{
+			{OU: "team1", CommonName: "ci-*", Role: workergrpc.RoleSubmitter},
+			{CertRole: "operator", Role: workergrpc.RoleOperator},
+		},
+	})
+	require.NoError(t, err)
+	srv := startServerWithOptions(t, worker.Config{}, workergrpc.AuthorizationServerOptions(authorizer))
+	defer srv.Stop()
+	viewer := dialClientWithConfig(t, srv, workergrpc.GenerateCertificateConfig{OU: "team1", CommonName: "alice"})
+	defer viewer.Close()
+	ci := dialClientWithConfig(t, srv, workergrpc.GenerateCertificateConfig{OU: "team1", CommonName: "ci-build"})
+	defer ci.Close()
+	operator := dialClientWithConfig(t, srv, workergrpc.GenerateCertificateConfig{
+		OU:    "team1",
+		Roles: []string{"operator"},
+	})
+	defer operator.Close()
+	// Viewer cannot submit, CI can
+	submitReq := &workergrpc.SubmitJobRequest{Job: &workergrpc.Job{Command: []string{"sleep", "10"}}}
+	_, err = viewer.SubmitJob(ctx, submitReq)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	submitResp, err := ci.SubmitJob(ctx, submitReq)
+	require.NoError(t, err)
+	// Both can view
+	_, err = viewer.GetJob(ctx, &workergrpc.GetJobRequest{JobId: submitResp.Job.Id})
+	require.NoError(t, err)
+	// Only the operator can stop
+	stopReq := &workergrpc.StopJobRequest{JobId: submitResp.Job.Id, Force: true}
+	_, err = ci.StopJob(ctx, stopReq)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = operator.StopJob(ctx, stopReq)
+	require.NoError(t, err)
+	// Admin-only calls are denied to everyone
+	_, err = operator.QueryAudit(ctx, &workergrpc.QueryAuditRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAdminCrossNamespace(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	authorizer, err := workergrpc.NewAuthorizer(workergrpc.Policy{
+		DefaultRole: workergrpc.RoleOperator,
+		Rules:       []workergrpc.PolicyRule{{CertRole: "admin", Role: workergrpc.RoleAdmin}},
+	})
+	require.NoError(t, err)
+	srv := startServerWithOptions(t, worker.Config{}, workergrpc.AuthorizationServerOptions(authorizer))
+	defer srv.Stop()
+	client := dialClient(t, srv, "team1")
+	defer client.Close()
+	admin := dialClientWithConfig(t, srv, workergrpc.GenerateCertificateConfig{OU: "ops", Roles: []string{"admin"}})
+	defer admin.Close()
+	submitResp, err := client.SubmitJob(ctx, &workergrpc.SubmitJobRequest{
+		Job: &workergrpc.Job{Command: []string{"sleep", "10"}},
+	})
+	require.NoError(t, err)
+	// Non-admins cannot act elsewhere or list namespaces
+	_, err = client.ListJobs(ctx, &workergrpc.ListJobsRequest{Namespace: "ops"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.ListNamespaces(ctx, &workergrpc.ListNamespacesRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	// Admin sees the namespace and stops the job in it
+	nsResp, err := admin.ListNamespaces(ctx, &workergrpc.ListNamespacesRequest{})
+	require.NoError(t, err)
+	require.Len(t, nsResp.Namespaces, 1)
+	require.Equal(t, "team1", nsResp.Namespaces[0].Namespace)
+	require.Equal(t, int32(1), nsResp.Namespaces[0].Running+nsResp.Namespaces[0].Queued)
+	_, err = admin.StopJob(ctx, &workergrpc.StopJobRequest{Namespace: "team1", JobId: submitResp.Job.Id, Force: true})
+	require.NoError(t, err)
+	_, err = admin.StopJob(ctx, &workergrpc.StopJobRequest{JobId: submitResp.Job.Id, Force: true})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestAdminRateLimits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	authorizer, err := workergrpc.NewAuthorizer(workergrpc.Policy{
+		DefaultRole: workergrpc.RoleOperator,
+		Rules:       []workergrpc.PolicyRule{{CertRole: "admin", Role: workergrpc.RoleAdmin}},
+	})
+	require.NoError(t, err)
+	srv := startServerWithOptions(t, worker.Config{}, workergrpc.AuthorizationServerOptions(authorizer),
+		workergrpc.WithRateLimits(workergrpc.RateLimitConfig{SubmitRate: 0.01, SubmitBurst: 1, CallRate: 0.01, CallBurst: 2}))
+	defer srv.Stop()
+	client := dialClient(t, srv, "team1")
+	defer client.Close()
+	admin := dialClientWithConfig(t, srv, workergrpc.GenerateCertificateConfig{OU: "ops", Roles: []string{"admin"}})
+	defer admin.Close()
+	submitReq := &workergrpc.SubmitJobRequest{Namespace: "team1", Job: &workergrpc.Job{Command: []string{"true"}}}
+
+	// Admin acting in the namespace is charged its own rate limits
+	_, err = admin.SubmitJob(ctx, submitReq)
+	require.NoError(t, err)
+	_, err = admin.SubmitJob(ctx, submitReq)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = admin.ListJobs(ctx, &workergrpc.ListJobsRequest{Namespace: "team1"})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	// So the namespace still has all of its own
+	_, err = client.SubmitJob(ctx, submitReq)
+	require.NoError(t, err)
+	quotaResp, err := client.GetQuota(ctx, &workergrpc.GetQuotaRequest{})
+	require.NoError(t, err)
+	require.Less(t, quotaResp.RateLimits.SubmitTokens, float64(1))
+	require.Less(t, quotaResp.RateLimits.CallTokens, float64(1))
+}
+
+func TestPolicyValidation(t *testing.T) {
+	for _, rule := range []workergrpc.PolicyRule{
+		{Role: workergrpc.RoleAdmin},
+		{OU: "team1"},
+		{CommonName: "[", Role: workergrpc.RoleViewer},
+		{Extension: &workergrpc.PolicyExtension{OID: "1.x"}, Role: workergrpc.RoleViewer},
+	} {
+		_, err := workergrpc.NewAuthorizer(workergrpc.Policy{Rules: []workergrpc.PolicyRule{rule}})
+		require.Error(t, err, "rule %+v", rule)
+	}
+	for _, rule := range []workergrpc.PolicyRule{
+		{CertRole: "admin", Role: workergrpc.RoleAdmin},
+		{Extension: &workergrpc.PolicyExtension{OID: "1.2.3.4"}, Role: workergrpc.RoleViewer},
+	} {
+		_, err := workergrpc.NewAuthorizer(workergrpc.Policy{Rules: []workergrpc.PolicyRule{rule}})
+		require.NoError(t, err, "rule %+v", rule)
+	}
+}
@@ -0,0 +1,93 @@
+//go:build linux
+// +build linux
+
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cretz/teleworker/worker"
+	"github.com/cretz/teleworker/workergrpc"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestQuota(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	// One job at a time and two submissions per namespace before waiting
+	srv := startServerWithOptions(t,
+		worker.Config{NamespaceDefaults: worker.NamespaceConfig{Quota: worker.NamespaceQuota{MaxActiveJobs: 1}}},
+		nil,
+		workergrpc.WithRateLimits(workergrpc.RateLimitConfig{SubmitRate: 0.1, SubmitBurst: 2}))
+	defer srv.Stop()
+	client := dialClient(t, srv, "client1")
+	defer client.Close()
+	submitReq := &workergrpc.SubmitJobRequest{Job: &workergrpc.Job{Command: []string{"sleep", "10"}}}
+	submitResp, err := client.SubmitJob(ctx, submitReq)
+	require.NoError(t, err)
+	defer client.StopJob(ctx, &workergrpc.StopJobRequest{JobId: submitResp.Job.Id, Force: true})
+	// Beyond the quota
+	_, err = client.SubmitJob(ctx, submitReq)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.IsType(t, &errdetails.QuotaFailure{}, status.Convert(err).Details()[0])
+	// Beyond the rate limit
+	_, err = client.SubmitJob(ctx, submitReq)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	retryInfo, ok := status.Convert(err).Details()[0].(*errdetails.RetryInfo)
+	require.True(t, ok)
+	require.Greater(t, retryInfo.RetryDelay.AsDuration(), time.Duration(0))
+	// Usage is reported
+	quotaResp, err := client.GetQuota(ctx, &workergrpc.GetQuotaRequest{})
+	require.NoError(t, err)
+	require.Equal(t, int32(1), quotaResp.Quota.MaxActiveJobs)
+	require.Equal(t, int32(1), quotaResp.Usage.ActiveJobs)
+	require.Equal(t, int32(2), quotaResp.RateLimits.SubmitBurst)
+	require.Less(t, quotaResp.RateLimits.SubmitTokens, float64(1))
+}
+
+func TestQuotaSiblingNamespaces(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	// Children of team1 share its quota and the rate limits of the grant
+	srv := startServerWithOptions(t,
+		worker.Config{Namespaces: map[string]worker.NamespaceConfig{
+			"team1": {Quota: worker.NamespaceQuota{MaxActiveJobs: 1}},
+		}},
+		nil,
+		workergrpc.WithNamespaceHierarchy(),
+		workergrpc.WithRateLimits(workergrpc.RateLimitConfig{SubmitRate: 0.1, SubmitBurst: 2}))
+	defer srv.Stop()
+	client := dialClient(t, srv, "team1")
+	defer client.Close()
+	submitResp, err := client.SubmitJob(ctx, &workergrpc.SubmitJobRequest{
+		Namespace: "team1/a",
+		Job:       &workergrpc.Job{Command: []string{"sleep", "10"}},
+	})
+	require.NoError(t, err)
+	defer client.StopJob(ctx, &workergrpc.StopJobRequest{Namespace: "team1/a", JobId: submitResp.Job.Id, Force: true})
+	// Sibling is beyond the shared quota
+	siblingReq := &workergrpc.SubmitJobRequest{
+		Namespace: "team1/b",
+		Job:       &workergrpc.Job{Command: []string{"sleep", "10"}},
+	}
+	_, err = client.SubmitJob(ctx, siblingReq)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	quotaFailure, ok := status.Convert(err).Details()[0].(*errdetails.QuotaFailure)
+	require.True(t, ok)
+	require.Equal(t, "namespace:team1", quotaFailure.Violations[0].Subject)
+	// Sibling is beyond the shared rate limit
+	_, err = client.SubmitJob(ctx, siblingReq)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.IsType(t, &errdetails.RetryInfo{}, status.Convert(err).Details()[0])
+	// Sibling reports the shared usage
+	quotaResp, err := client.GetQuota(ctx, &workergrpc.GetQuotaRequest{Namespace: "team1/b"})
+	require.NoError(t, err)
+	require.Equal(t, int32(1), quotaResp.Quota.MaxActiveJobs)
+	require.Equal(t, int32(1), quotaResp.Usage.ActiveJobs)
+	require.Less(t, quotaResp.RateLimits.SubmitTokens, float64(1))
+}
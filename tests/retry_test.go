@@ -0,0 +1,122 @@
+//go:build linux
+// +build linux
+
+package tests
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cretz/teleworker/worker"
+	"github.com/cretz/teleworker/workergrpc"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestRetryAttempts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	srv := startServer(t, worker.Config{})
+	defer srv.Stop()
+	client := dialClient(t, srv, "client1")
+	defer client.Close()
+	// Fails the first two attempts, each printing its number after a moment
+	submit := func() string {
+		counter := filepath.Join(t.TempDir(), "counter")
+		resp, err := client.SubmitJob(ctx, &workergrpc.SubmitJobRequest{Job: &workergrpc.Job{
+			Command: []string{"sh", "-c", "n=$(($(cat " + counter + " 2>/dev/null || echo 0) + 1)); echo $n > " +
+				counter + "; sleep 0.2; echo attempt-$n; [ $n -ge 3 ]"},
+			RetryPolicy: &workergrpc.RetryPolicy{MaxAttempts: 3, InitialBackoff: durationpb.New(50 * time.Millisecond)},
+		}})
+		require.NoError(t, err)
+		return resp.Job.Id
+	}
+
+	// Streaming without an attempt follows every attempt until completion
+	jobID := submit()
+	stream, err := client.StreamJobOutput(ctx, &workergrpc.StreamJobOutputRequest{
+		JobId:         jobID,
+		FromBeginning: true,
+		StreamLimit:   &workergrpc.StreamJobOutputRequest_OnlyStdout{OnlyStdout: true},
+	})
+	require.NoError(t, err)
+	var stdout string
+	var attempts []int32
+	var exitCode *int32
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		switch r := resp.Response.(type) {
+		case *workergrpc.StreamJobOutputResponse_Stdout:
+			stdout += string(r.Stdout)
+			attempts = append(attempts, resp.Attempt)
+		case *workergrpc.StreamJobOutputResponse_CompletedExitCode:
+			exitCode = &r.CompletedExitCode
+		}
+	}
+	require.Equal(t, "attempt-1\nattempt-2\nattempt-3\n", stdout)
+	require.Equal(t, []int32{1, 2, 3}, attempts)
+	require.NotNil(t, exitCode)
+	require.Equal(t, int32(0), *exitCode)
+
+	// Job has every attempt and the latest output
+	jobResp, err := client.GetJob(ctx, &workergrpc.GetJobRequest{JobId: jobID, IncludeStdout: true})
+	require.NoError(t, err)
+	require.Equal(t, "attempt-3\n", string(jobResp.Job.Stdout))
+	require.Len(t, jobResp.Job.Attempts, 3)
+	pids := map[int64]bool{}
+	for i, attempt := range jobResp.Job.Attempts {
+		require.Equal(t, int32(i+1), attempt.Number)
+		require.NotNil(t, attempt.ExitCode)
+		pids[attempt.Pid] = true
+	}
+	require.Len(t, pids, 3)
+	require.Equal(t, int32(1), jobResp.Job.Attempts[0].ExitCode.Value)
+	require.Equal(t, int32(0), jobResp.Job.Attempts[2].ExitCode.Value)
+	// Output of a specific attempt
+	jobResp, err = client.GetJob(ctx, &workergrpc.GetJobRequest{JobId: jobID, IncludeStdout: true, Attempt: 1})
+	require.NoError(t, err)
+	require.Equal(t, "attempt-1\n", string(jobResp.Job.Stdout))
+	_, err = client.GetJob(ctx, &workergrpc.GetJobRequest{JobId: jobID, Attempt: 4})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// Streaming an attempt only has its output and exit code
+	stream, err = client.StreamJobOutput(ctx, &workergrpc.StreamJobOutputRequest{
+		JobId:         jobID,
+		FromBeginning: true,
+		Attempt:       2,
+	})
+	require.NoError(t, err)
+	stdout, exitCode = "", nil
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		switch r := resp.Response.(type) {
+		case *workergrpc.StreamJobOutputResponse_Stdout:
+			require.Equal(t, int32(2), resp.Attempt)
+			stdout += string(r.Stdout)
+		case *workergrpc.StreamJobOutputResponse_Stderr:
+			require.Equal(t, int32(2), resp.Attempt)
+		case *workergrpc.StreamJobOutputResponse_CompletedExitCode:
+			exitCode = &r.CompletedExitCode
+		}
+	}
+	require.Equal(t, "attempt-2\n", stdout)
+	require.NotNil(t, exitCode)
+	require.Equal(t, int32(1), *exitCode)
+	stream, err = client.StreamJobOutput(ctx, &workergrpc.StreamJobOutputRequest{JobId: jobID, Attempt: 4})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,35 @@
+//go:build linux
+// +build linux
+
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cretz/teleworker/worker"
+	"github.com/cretz/teleworker/workergrpc"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRLimitsAboveMax(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	// Limits are validated on submission before the job is ever run
+	srv := startServer(t, worker.Config{Limits: &worker.JobLimitConfig{
+		RLimits: worker.JobRLimits{NoFile: &worker.JobRLimit{Soft: 1024, Hard: 4096}},
+	}})
+	defer srv.Stop()
+	client := dialClient(t, srv, "client1")
+	defer client.Close()
+	for _, limit := range []*workergrpc.RLimit{{Soft: 8192, Hard: 8192}, {Soft: 1024, Hard: 8192}, {Soft: 20, Hard: 10}} {
+		_, err := client.SubmitJob(ctx, &workergrpc.SubmitJobRequest{Job: &workergrpc.Job{
+			Command: []string{"true"},
+			Rlimits: &workergrpc.RLimits{Nofile: limit},
+		}})
+		require.Equal(t, codes.InvalidArgument, status.Code(err), "limit %v", limit)
+	}
+}
@@ -0,0 +1,64 @@
+//go:build linux
+// +build linux
+
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cretz/teleworker/worker"
+	"github.com/cretz/teleworker/workergrpc"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestStopTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	srv := startServerWithOptions(t, worker.Config{}, nil, workergrpc.WithStopTimeout(200*time.Millisecond))
+	defer srv.Stop()
+	client := dialClient(t, srv, "client1")
+	defer client.Close()
+
+	// Job that ignores SIGTERM
+	resp, err := client.SubmitJob(ctx, &workergrpc.SubmitJobRequest{Job: &workergrpc.Job{
+		Command: []string{"sh", "-c", "trap '' TERM; while true; do sleep 0.1; done"},
+	}})
+	require.NoError(t, err)
+	jobID := resp.Job.Id
+	// Give the shell time to set the trap
+	time.Sleep(300 * time.Millisecond)
+
+	// Server timeout applies without a request timeout
+	start := time.Now()
+	stopResp, err := client.StopJobs(ctx, &workergrpc.StopJobsRequest{JobIds: []string{jobID}})
+	require.NoError(t, err)
+	require.Len(t, stopResp.Results, 1)
+	require.Contains(t, stopResp.Results[0].Error, "within 200ms")
+	require.Less(t, time.Since(start), 2*time.Second)
+
+	// Request timeout takes precedence
+	_, err = client.StopJob(ctx, &workergrpc.StopJobRequest{JobId: jobID, Timeout: durationpb.New(100 * time.Millisecond)})
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), "within 100ms")
+
+	// Timeout must be positive
+	_, err = client.StopJobs(ctx, &workergrpc.StopJobsRequest{JobIds: []string{jobID}, Timeout: durationpb.New(0)})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.StopJob(ctx, &workergrpc.StopJobRequest{JobId: jobID, Timeout: durationpb.New(-time.Second)})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Force stops within the timeout
+	stopResp, err = client.StopJobs(ctx, &workergrpc.StopJobsRequest{
+		JobIds:  []string{jobID},
+		Force:   true,
+		Timeout: durationpb.New(10 * time.Second),
+	})
+	require.NoError(t, err)
+	require.Empty(t, stopResp.Results[0].Error)
+	require.NotNil(t, stopResp.Results[0].Job.ExitCode)
+}
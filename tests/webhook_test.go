@@ -0,0 +1,192 @@
+//go:build linux
+// +build linux
+
+package tests
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cretz/teleworker/worker"
+	"github.com/cretz/teleworker/workergrpc"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWebhooks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	// Start an HTTP stand-in that fails the first request to each path
+	type received struct {
+		header  http.Header
+		body    []byte
+		payload worker.WebhookPayload
+	}
+	var lock sync.Mutex
+	requests := map[string][]received{}
+	httpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		req := received{header: r.Header, body: body}
+		require.NoError(t, json.Unmarshal(body, &req.payload))
+		lock.Lock()
+		defer lock.Unlock()
+		requests[r.URL.Path] = append(requests[r.URL.Path], req)
+		if len(requests[r.URL.Path]) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer httpSrv.Close()
+	// Start server with a server-wide webhook
+	srv := startServer(t, worker.Config{Webhooks: worker.WebhookConfig{
+		Targets:                []worker.WebhookTarget{{URL: httpSrv.URL + "/server"}},
+		DefaultSecret:          "server-secret",
+		InitialBackoff:         10 * time.Millisecond,
+		JobTargetHosts:         []string{"127.0.0.1"},
+		AllowPrivateJobTargets: true,
+	}})
+	defer srv.Stop()
+	client := dialClient(t, srv, "client1")
+	defer client.Close()
+	// Invalid webhook URL fails submission
+	_, err := client.SubmitJob(ctx, &workergrpc.SubmitJobRequest{Job: &workergrpc.Job{
+		Command:  []string{"true"},
+		Webhooks: []*workergrpc.WebhookTarget{{Url: "ftp://example.com"}},
+	}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	// Host not allowed fails submission
+	_, err = client.SubmitJob(ctx, &workergrpc.SubmitJobRequest{Job: &workergrpc.Job{
+		Command:  []string{"true"},
+		Webhooks: []*workergrpc.WebhookTarget{{Url: "http://example.com"}},
+	}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	// Submit a failing job with its own webhook and confirm the secret is not
+	// returned
+	resp, err := client.SubmitJob(ctx, &workergrpc.SubmitJobRequest{Job: &workergrpc.Job{
+		Command:  []string{"sh", "-c", "exit 3"},
+		Labels:   map[string]string{"foo": "bar"},
+		Webhooks: []*workergrpc.WebhookTarget{{Url: httpSrv.URL + "/job", Secret: "job-secret"}},
+	}})
+	require.NoError(t, err)
+	require.Empty(t, resp.Job.Webhooks[0].Secret)
+	// Wait for both deliveries to succeed
+	var deliveries []*workergrpc.WebhookDelivery
+	require.Eventually(t, func() bool {
+		resp, err := client.ListWebhookDeliveries(ctx, &workergrpc.ListWebhookDeliveriesRequest{JobId: resp.Job.Id})
+		require.NoError(t, err)
+		deliveries = resp.Deliveries
+		if len(deliveries) != 2 {
+			return false
+		}
+		for _, delivery := range deliveries {
+			if delivery.Status != workergrpc.WebhookDeliveryStatus_WEBHOOK_DELIVERY_STATUS_SUCCEEDED {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 50*time.Millisecond)
+	for _, delivery := range deliveries {
+		require.Len(t, delivery.Attempts, 2)
+		require.EqualValues(t, 500, delivery.Attempts[0].StatusCode)
+		require.NotEmpty(t, delivery.Attempts[0].Error)
+		require.EqualValues(t, 200, delivery.Attempts[1].StatusCode)
+	}
+	// Check the payloads and signatures
+	lock.Lock()
+	defer lock.Unlock()
+	for path, secret := range map[string]string{"/server": "server-secret", "/job": "job-secret"} {
+		require.Len(t, requests[path], 2)
+		for _, req := range requests[path] {
+			require.Equal(t, "job.completed", req.payload.Event)
+			require.Equal(t, resp.Job.Id, req.payload.JobID)
+			require.Equal(t, "client1", req.payload.Namespace)
+			require.Equal(t, 3, req.payload.ExitCode)
+			require.Equal(t, map[string]string{"foo": "bar"}, req.payload.Labels)
+			require.Equal(t, req.payload.DeliveryID, req.header.Get(worker.WebhookDeliveryHeader))
+			timestamp, err := strconv.ParseInt(req.header.Get(worker.WebhookTimestampHeader), 10, 64)
+			require.NoError(t, err)
+			require.True(t, hmac.Equal(
+				[]byte(worker.WebhookSignature(secret, timestamp, req.body)),
+				[]byte(req.header.Get(worker.WebhookSignatureHeader)),
+			))
+		}
+	}
+}
+
+func TestWebhookRestrictions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	var lock sync.Mutex
+	var paths []string
+	httpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		paths = append(paths, r.URL.Path)
+		lock.Unlock()
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/other", http.StatusTemporaryRedirect)
+		}
+	}))
+	defer httpSrv.Close()
+	waitFailed := func(client *client, jobID string) *workergrpc.WebhookDelivery {
+		var delivery *workergrpc.WebhookDelivery
+		require.Eventually(t, func() bool {
+			resp, err := client.ListWebhookDeliveries(ctx, &workergrpc.ListWebhookDeliveriesRequest{JobId: jobID})
+			require.NoError(t, err)
+			if len(resp.Deliveries) != 1 {
+				return false
+			}
+			delivery = resp.Deliveries[0]
+			return delivery.Status == workergrpc.WebhookDeliveryStatus_WEBHOOK_DELIVERY_STATUS_FAILED
+		}, 10*time.Second, 50*time.Millisecond)
+		return delivery
+	}
+
+	// Jobs cannot have their own webhooks by default, and redirects are not
+	// followed
+	srv := startServer(t, worker.Config{Webhooks: worker.WebhookConfig{
+		Targets:     []worker.WebhookTarget{{URL: httpSrv.URL + "/redirect"}},
+		MaxAttempts: 1,
+	}})
+	defer srv.Stop()
+	client := dialClient(t, srv, "client1")
+	defer client.Close()
+	_, err := client.SubmitJob(ctx, &workergrpc.SubmitJobRequest{Job: &workergrpc.Job{
+		Command:  []string{"true"},
+		Webhooks: []*workergrpc.WebhookTarget{{Url: httpSrv.URL + "/job"}},
+	}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	job := client.submitAndWait(t, ctx, "true")
+	delivery := waitFailed(client, job.Id)
+	require.EqualValues(t, http.StatusTemporaryRedirect, delivery.Attempts[0].StatusCode)
+
+	// Any host allowed, but not private addresses
+	srv = startServer(t, worker.Config{Webhooks: worker.WebhookConfig{
+		JobTargetHosts: []string{"*"},
+		MaxAttempts:    1,
+	}})
+	defer srv.Stop()
+	client = dialClient(t, srv, "client1")
+	defer client.Close()
+	resp, err := client.SubmitJob(ctx, &workergrpc.SubmitJobRequest{Job: &workergrpc.Job{
+		Command:  []string{"true"},
+		Webhooks: []*workergrpc.WebhookTarget{{Url: httpSrv.URL + "/job"}},
+	}})
+	require.NoError(t, err)
+	delivery = waitFailed(client, resp.Job.Id)
+	require.Zero(t, delivery.Attempts[0].StatusCode)
+	require.Contains(t, delivery.Attempts[0].Error, "private address")
+
+	// Only the redirect was ever requested
+	lock.Lock()
+	defer lock.Unlock()
+	require.Equal(t, []string{"/redirect"}, paths)
+}
@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// BatchJob is a job to submit as part of Worker.SubmitJobs.
+type BatchJob struct {
+	// ID of the job, which must be unique in the namespace. If empty, one is
+	// generated.
+	ID string
+	// Specification of the job.
+	JobSpec
+	// Options applied after the specification. WithIdempotencyKey is not
+	// supported.
+	Options []SubmitJobOption
+}
+
+// SubmitJobs submits many jobs in the namespace at once. Either all jobs are
+// admitted or none are, so this returns an error without submitting any job
+// if any job is invalid or has an ID that already exists. Jobs can depend on
+// other jobs in the batch. Jobs that are admitted but fail to start have the
+// error set on the job instead of failing the submission, unless there is only
+// one job. The jobs are returned in the same order. This returns ErrShutdown
+// if the worker is shutdown.
+func (w *Worker) SubmitJobs(namespace string, batch []BatchJob) ([]*Job, error) {
+	w.shutdownLock.RLock()
+	defer w.shutdownLock.RUnlock()
+	if w.shutdown {
+		return nil, ErrShutdown
+	}
+	if len(batch) == 0 {
+		return nil, fmt.Errorf("no jobs")
+	}
+	jobs := make([]*Job, len(batch))
+	for i, batchJob := range batch {
+		id := batchJob.ID
+		if id == "" {
+			id = uuid.New().String()
+		}
+		jobs[i] = newJob(namespace, id, batchJob.JobSpec)
+		for _, opt := range batchJob.Options {
+			opt(jobs[i])
+		}
+		if jobs[i].IdempotencyKey != "" {
+			return nil, fmt.Errorf("idempotency keys not supported in batch")
+		}
+	}
+	if err := w.submitJobs(namespace, jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
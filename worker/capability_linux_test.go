@@ -0,0 +1,163 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	tests := []struct {
+		names    []string
+		expected []string
+		invalid  bool
+	}{
+		{names: nil, expected: []string{}},
+		{names: []string{"CAP_CHOWN"}, expected: []string{"CAP_CHOWN"}},
+		// Prefix is optional and case does not matter
+		{names: []string{"kill", "Cap_Chown", "NET_ADMIN"}, expected: []string{"CAP_CHOWN", "CAP_KILL", "CAP_NET_ADMIN"}},
+		{names: []string{"chown", "CAP_CHOWN"}, expected: []string{"CAP_CHOWN"}},
+		{names: []string{"CAP_CHOWN", "CAP_NOT_REAL"}, invalid: true},
+		{names: []string{"CAP_"}, invalid: true},
+		{names: []string{""}, invalid: true},
+	}
+	for _, test := range tests {
+		caps, err := parseCapabilities(test.names)
+		if test.invalid {
+			if err == nil {
+				t.Fatalf("expected %v to fail", test.names)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("unexpected error parsing %v: %v", test.names, err)
+		}
+		if names := caps.names(); !reflect.DeepEqual(test.expected, names) {
+			t.Fatalf("expected %v from %v, got %v", test.expected, test.names, names)
+		}
+	}
+}
+
+func TestCapabilitySetOps(t *testing.T) {
+	a, _ := parseCapabilities([]string{"CAP_KILL", "CAP_CHOWN"})
+	b, _ := parseCapabilities([]string{"CAP_CHOWN", "CAP_KILL", "CAP_SETUID"})
+	if !a.isSubsetOf(b) || b.isSubsetOf(a) {
+		t.Fatal("unexpected subset result")
+	}
+	expected := []uintptr{unix.CAP_CHOWN, unix.CAP_KILL, unix.CAP_SETUID}
+	if sorted := a.union(b).sorted(); !reflect.DeepEqual(expected, sorted) {
+		t.Fatalf("expected %v, got %v", expected, sorted)
+	}
+}
+
+func TestNewLimitedRunnerCapabilities(t *testing.T) {
+	tests := []struct {
+		name    string
+		caps    JobCapabilities
+		invalid bool
+	}{
+		{name: "default", caps: JobCapabilities{}},
+		{name: "unknown bounding", caps: JobCapabilities{Bounding: []string{"CAP_NOT_REAL"}}, invalid: true},
+		{name: "unknown ambient", caps: JobCapabilities{Ambient: []string{"CAP_NOT_REAL"}}, invalid: true},
+		{name: "unknown effective", caps: JobCapabilities{Effective: []string{"CAP_NOT_REAL"}}, invalid: true},
+		{
+			name:    "ambient outside bounding",
+			caps:    JobCapabilities{Bounding: []string{"CAP_CHOWN"}, Ambient: []string{"CAP_KILL"}},
+			invalid: true,
+		},
+		{
+			name:    "effective outside bounding",
+			caps:    JobCapabilities{Bounding: []string{"CAP_CHOWN"}, Effective: []string{"CAP_KILL"}},
+			invalid: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newLimitedRunner(&JobLimitConfig{Security: JobSecurity{Capabilities: test.caps}}, NopMetrics{}, nil)
+			if test.invalid && err == nil {
+				t.Fatal("expected error")
+			} else if !test.invalid && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestLimitedJobCapabilities(t *testing.T) {
+	const chown, kill, netBind = 1 << unix.CAP_CHOWN, 1 << unix.CAP_KILL, 1 << unix.CAP_NET_BIND_SERVICE
+	tests := []struct {
+		name       string
+		security   JobSecurity
+		expected   map[string]uint64
+		noNewPrivs string
+	}{
+		{
+			name: "root gets bounding set",
+			security: JobSecurity{
+				Capabilities: JobCapabilities{Bounding: []string{"CAP_CHOWN", "CAP_KILL", "CAP_NET_BIND_SERVICE"}},
+			},
+			expected: map[string]uint64{
+				"CapBnd": chown | kill | netBind,
+				"CapEff": chown | kill | netBind,
+				"CapAmb": 0,
+			},
+			noNewPrivs: "0",
+		},
+		{
+			name: "explicit effective",
+			security: JobSecurity{
+				Capabilities: JobCapabilities{
+					Bounding:  []string{"CAP_CHOWN", "CAP_KILL", "CAP_NET_BIND_SERVICE"},
+					Effective: []string{"CAP_CHOWN"},
+					Ambient:   []string{"CAP_KILL"},
+				},
+				NoNewPrivileges: true,
+			},
+			// Effective ones are raised as ambient along with the ambient ones
+			expected: map[string]uint64{
+				"CapBnd": chown | kill | netBind,
+				"CapEff": chown | kill,
+				"CapPrm": chown | kill,
+				"CapInh": chown | kill,
+				"CapAmb": chown | kill,
+			},
+			noNewPrivs: "1",
+		},
+		{
+			name:       "nothing bounding",
+			security:   JobSecurity{Capabilities: JobCapabilities{Bounding: []string{}}, NoNewPrivileges: true},
+			expected:   map[string]uint64{"CapBnd": 0, "CapEff": 0, "CapPrm": 0, "CapAmb": 0},
+			noNewPrivs: "1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, err := New(Config{Limits: &JobLimitConfig{Security: test.security}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Shutdown(context.Background(), true)
+			status := runJob(t, w, "", JobSpec{Command: "cat", Args: []string{"/proc/self/status"}})
+			fields := map[string]string{}
+			for _, line := range strings.Split(status, "\n") {
+				if name, value, ok := strings.Cut(line, ":"); ok {
+					fields[name] = strings.TrimSpace(value)
+				}
+			}
+			for name, expected := range test.expected {
+				var actual uint64
+				if _, err := fmt.Sscanf(fields[name], "%x", &actual); err != nil {
+					t.Fatalf("parsing %v %q: %v", name, fields[name], err)
+				} else if actual != expected {
+					t.Fatalf("expected %v %x, got %x", name, expected, actual)
+				}
+			}
+			if fields["NoNewPrivs"] != test.noNewPrivs {
+				t.Fatalf("expected NoNewPrivs %v, got %v", test.noNewPrivs, fields["NoNewPrivs"])
+			}
+		})
+	}
+}
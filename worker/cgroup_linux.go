@@ -0,0 +1,282 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Variable for tests
+var cgroupRoot = "/sys/fs/cgroup"
+
+// isCGroupV2 returns true if the unified cgroup v2 hierarchy is mounted at the
+// cgroup root.
+func isCGroupV2() bool {
+	_, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers"))
+	return err == nil
+}
+
+// applyCGroupLimits puts the current process in new cgroups with the given
+// limits. Regardless of error, caller should remove any returned dirs when
+// done.
+func applyCGroupLimits(containerID string, limits *JobResourceLimits) (dirs []string, err error) {
+	if isCGroupV2() {
+		return applyCGroupV2Limits(containerID, limits)
+	}
+	return applyCGroupV1Limits(containerID, limits)
+}
+
+func applyCGroupV1Limits(containerID string, limits *JobResourceLimits) (dirs []string, err error) {
+	// Collects settings per controller
+	var controllers []string
+	settings := map[string][][]string{}
+	addSetting := func(controller, file, value string) {
+		if _, ok := settings[controller]; !ok {
+			controllers = append(controllers, controller)
+		}
+		settings[controller] = append(settings[controller], []string{file, value})
+	}
+	// If the max period and max quota are present, limit CPU
+	if limits.CPUMaxPeriod > 0 && limits.CPUMaxQuota > 0 {
+		addSetting("cpu", "cpu.cfs_period_us", strconv.FormatUint(limits.CPUMaxPeriod, 10))
+		addSetting("cpu", "cpu.cfs_quota_us", strconv.FormatUint(limits.CPUMaxQuota, 10))
+	}
+	if limits.CPUWeight > 0 {
+		addSetting("cpu", "cpu.shares", strconv.FormatUint(cpuWeightToShares(limits.CPUWeight), 10))
+	}
+	// If memory max present, limit it
+	if limits.MemoryMax > 0 {
+		addSetting("memory", "memory.limit_in_bytes", strconv.FormatUint(limits.MemoryMax, 10))
+		addSetting("memory", "memory.memsw.limit_in_bytes", strconv.FormatUint(limits.MemoryMax, 10))
+	}
+	if limits.MemoryHigh > 0 {
+		addSetting("memory", "memory.soft_limit_in_bytes", strconv.FormatUint(limits.MemoryHigh, 10))
+	}
+	// If device maxes exist, apply them
+	if len(limits.DeviceIOMax) > 0 {
+		var readLimits, writeLimits strings.Builder
+		for dev, bps := range limits.DeviceIOMax {
+			if readLimits.Len() > 0 {
+				readLimits.WriteByte('\n')
+				writeLimits.WriteByte('\n')
+			}
+			str := dev + "  " + strconv.FormatUint(bps, 10)
+			readLimits.WriteString(str)
+			writeLimits.WriteString(str)
+		}
+		addSetting("blkio", "blkio.throttle.read_bps_device", readLimits.String())
+		addSetting("blkio", "blkio.throttle.write_bps_device", writeLimits.String())
+	}
+	// Pin CPUs and memory nodes. A v1 cpuset cannot have processes until both
+	// are set, so unset ones are inherited from the root.
+	if limits.CPUSet != "" || limits.MemoryNodes != "" {
+		if err := initCGroupV1CPUSetParent(); err != nil {
+			return nil, err
+		}
+		cpus, mems := limits.CPUSet, limits.MemoryNodes
+		if cpus == "" {
+			if cpus, err = readCGroupFile(filepath.Join(cgroupRoot, "cpuset", "cpuset.cpus")); err != nil {
+				return nil, err
+			}
+		}
+		if mems == "" {
+			if mems, err = readCGroupFile(filepath.Join(cgroupRoot, "cpuset", "cpuset.mems")); err != nil {
+				return nil, err
+			}
+		}
+		addSetting("cpuset", "cpuset.cpus", cpus)
+		addSetting("cpuset", "cpuset.mems", mems)
+	}
+	for _, controller := range controllers {
+		dir, err := writeCGroupSettings(filepath.Join(cgroupRoot, controller, "teleworker", containerID),
+			settings[controller]...)
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+		if err != nil {
+			return dirs, err
+		}
+	}
+	return dirs, nil
+}
+
+// initCGroupV1CPUSetParent makes sure the teleworker cpuset has CPUs and
+// memory nodes since v1 cpusets are created without either.
+func initCGroupV1CPUSetParent() error {
+	dir := filepath.Join(cgroupRoot, "cpuset", "teleworker")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating dir %v: %w", dir, err)
+	}
+	for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+		if value, err := readCGroupFile(filepath.Join(dir, file)); err != nil {
+			return err
+		} else if value != "" {
+			continue
+		}
+		value, err := readCGroupFile(filepath.Join(cgroupRoot, "cpuset", file))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+			return fmt.Errorf("writing file %v: %w", filepath.Join(dir, file), err)
+		}
+	}
+	return nil
+}
+
+func applyCGroupV2Limits(containerID string, limits *JobResourceLimits) (dirs []string, err error) {
+	var settings [][]string
+	controllers := map[string]bool{}
+	addSetting := func(controller, file, value string) {
+		controllers[controller] = true
+		settings = append(settings, []string{file, value})
+	}
+	if limits.CPUMaxPeriod > 0 && limits.CPUMaxQuota > 0 {
+		addSetting("cpu", "cpu.max", fmt.Sprintf("%v %v", limits.CPUMaxQuota, limits.CPUMaxPeriod))
+	}
+	if limits.CPUWeight > 0 {
+		addSetting("cpu", "cpu.weight", strconv.FormatUint(limits.CPUWeight, 10))
+	}
+	// Unlike v1, memory max does not include swap
+	if limits.MemoryMax > 0 {
+		addSetting("memory", "memory.max", strconv.FormatUint(limits.MemoryMax, 10))
+	}
+	if limits.MemoryHigh > 0 {
+		addSetting("memory", "memory.high", strconv.FormatUint(limits.MemoryHigh, 10))
+	}
+	// Unlike v1, each device must be written separately
+	for dev, bps := range limits.DeviceIOMax {
+		addSetting("io", "io.max", fmt.Sprintf("%v rbps=%v wbps=%v", dev, bps, bps))
+	}
+	if limits.CPUSet != "" {
+		addSetting("cpuset", "cpuset.cpus", limits.CPUSet)
+	}
+	if limits.MemoryNodes != "" {
+		addSetting("cpuset", "cpuset.mems", limits.MemoryNodes)
+	}
+	// Enable needed controllers for the teleworker cgroup and its children
+	if len(controllers) > 0 {
+		var enable strings.Builder
+		for controller := range controllers {
+			if enable.Len() > 0 {
+				enable.WriteByte(' ')
+			}
+			enable.WriteString("+" + controller)
+		}
+		parentDir := filepath.Join(cgroupRoot, "teleworker")
+		if err := os.MkdirAll(parentDir, 0755); err != nil {
+			return nil, fmt.Errorf("creating dir %v: %w", parentDir, err)
+		}
+		for _, dir := range []string{cgroupRoot, parentDir} {
+			file := filepath.Join(dir, "cgroup.subtree_control")
+			if err := os.WriteFile(file, []byte(enable.String()), 0644); err != nil {
+				return nil, fmt.Errorf("writing file %v: %w", file, err)
+			}
+		}
+	}
+	dir, err := writeCGroupSettings(filepath.Join(cgroupRoot, "teleworker", containerID), settings...)
+	if dir != "" {
+		dirs = append(dirs, dir)
+	}
+	return dirs, err
+}
+
+// If dir non-empty, regardless of error, caller should remove it when done.
+// Each setting is two-string tuple (and slice can be mutated internally).
+func writeCGroupSettings(dir string, settings ...[]string) (string, error) {
+	// Create dir if not there
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("creating dir %v: %w", dir, err)
+	}
+	// Add my PID to settings as procs
+	settings = append(settings, []string{"cgroup.procs", "0"})
+	// Write each setting as a file
+	for _, settingSet := range settings {
+		if err := os.WriteFile(filepath.Join(dir, settingSet[0]), []byte(settingSet[1]), 0644); err != nil {
+			return dir, fmt.Errorf("writing file %v: %w", filepath.Join(dir, settingSet[0]), err)
+		}
+	}
+	return dir, nil
+}
+
+func readCGroupFile(file string) (string, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("reading file %v: %w", file, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// cpuWeightToShares converts a cgroup v2 CPU weight to v1 CPU shares using the
+// same conversion as the container runtimes (inverse of the kernel's).
+func cpuWeightToShares(weight uint64) uint64 {
+	return 2 + ((weight-1)*262142)/9999
+}
+
+// validateCPUList confirms the cpuset list format value (e.g. "0-3,8") only
+// contains IDs present in the list format file (e.g. online CPUs).
+func validateCPUList(value, availableFile string) error {
+	ids, err := parseCPUList(value)
+	if err != nil {
+		return err
+	}
+	availableValue, err := readCGroupFile(availableFile)
+	if err != nil {
+		return err
+	}
+	available, err := parseCPUList(availableValue)
+	if err != nil {
+		return fmt.Errorf("parsing %v: %w", availableFile, err)
+	}
+	for id := range ids {
+		if !available[id] {
+			return fmt.Errorf("%v not available (available: %v)", id, availableValue)
+		}
+	}
+	return nil
+}
+
+// validateCPUSubset validates the list is within the allowed list, or within
+// the available list in the file if allowed is empty.
+func validateCPUSubset(value, allowed, availableFile string) error {
+	if allowed == "" {
+		return validateCPUList(value, availableFile)
+	}
+	ids, err := parseCPUList(value)
+	if err != nil {
+		return err
+	}
+	allowedIDs, err := parseCPUList(allowed)
+	if err != nil {
+		return err
+	}
+	for id := range ids {
+		if !allowedIDs[id] {
+			return fmt.Errorf("%v not allowed (allowed: %v)", id, allowed)
+		}
+	}
+	return nil
+}
+
+func parseCPUList(value string) (map[uint64]bool, error) {
+	ids := map[uint64]bool{}
+	for _, part := range strings.Split(value, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		start, err := strconv.ParseUint(bounds[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid list value %q", part)
+		}
+		end := start
+		if len(bounds) == 2 {
+			if end, err = strconv.ParseUint(bounds[1], 10, 32); err != nil || end < start {
+				return nil, fmt.Errorf("invalid list range %q", part)
+			}
+		}
+		for id := start; id <= end; id++ {
+			ids[id] = true
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,165 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyCGroupV2Limits(t *testing.T) {
+	origRoot := cgroupRoot
+	cgroupRoot = t.TempDir()
+	defer func() { cgroupRoot = origRoot }()
+
+	dirs, err := applyCGroupV2Limits("job1", &JobResourceLimits{
+		CPUMaxPeriod: 100000,
+		CPUMaxQuota:  50000,
+		CPUWeight:    200,
+		MemoryMax:    1 << 30,
+		MemoryHigh:   1 << 29,
+		CPUSet:       "0-1",
+		MemoryNodes:  "0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(cgroupRoot, "teleworker", "job1")
+	if len(dirs) != 1 || dirs[0] != dir {
+		t.Fatalf("unexpected dirs %v", dirs)
+	}
+	for file, expected := range map[string]string{
+		"cpu.max":      "50000 100000",
+		"cpu.weight":   "200",
+		"memory.max":   "1073741824",
+		"memory.high":  "536870912",
+		"cpuset.cpus":  "0-1",
+		"cpuset.mems":  "0",
+		"cgroup.procs": "0",
+	} {
+		if actual, err := readCGroupFile(filepath.Join(dir, file)); err != nil {
+			t.Fatal(err)
+		} else if actual != expected {
+			t.Fatalf("expected %v to be %q, got %q", file, expected, actual)
+		}
+	}
+	// Swap is left to the system
+	if _, err := os.Stat(filepath.Join(dir, "memory.swap.max")); !os.IsNotExist(err) {
+		t.Fatalf("expected no memory.swap.max, got %v", err)
+	}
+	// Controllers enabled on root and parent
+	for _, parent := range []string{cgroupRoot, filepath.Join(cgroupRoot, "teleworker")} {
+		enabled, err := readCGroupFile(filepath.Join(parent, "cgroup.subtree_control"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, controller := range []string{"+cpu", "+memory", "+cpuset"} {
+			if !strings.Contains(enabled, controller) {
+				t.Fatalf("expected %v enabled in %v, got %q", controller, parent, enabled)
+			}
+		}
+	}
+}
+
+func TestJobResourceLimits(t *testing.T) {
+	runner := &limitedRunner{JobLimitConfig: &JobLimitConfig{ResourceLimits: JobResourceLimits{
+		CPUSet:       "0-3",
+		MemoryNodes:  "0",
+		CPUWeight:    100,
+		MaxCPUWeight: 500,
+		MemoryMax:    1000,
+	}}}
+	tests := []struct {
+		name      string
+		resources JobResources
+		expected  JobResourceLimits
+		invalid   bool
+	}{
+		{
+			name:     "defaults",
+			expected: runner.ResourceLimits,
+		},
+		{
+			name:      "within server limits",
+			resources: JobResources{CPUSet: "1-2", MemoryNodes: "0", CPUWeight: 500, MemoryHigh: 800},
+			expected: JobResourceLimits{CPUSet: "1-2", MemoryNodes: "0", CPUWeight: 500, MaxCPUWeight: 500,
+				MemoryMax: 1000, MemoryHigh: 800},
+		},
+		{name: "CPU outside set", resources: JobResources{CPUSet: "3-4"}, invalid: true},
+		{name: "invalid CPU set", resources: JobResources{CPUSet: "a"}, invalid: true},
+		{name: "memory node outside set", resources: JobResources{MemoryNodes: "1"}, invalid: true},
+		{name: "CPU weight above max", resources: JobResources{CPUWeight: 501}, invalid: true},
+		{name: "memory high above memory max", resources: JobResources{MemoryHigh: 1001}, invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limits, err := runner.jobResourceLimits(&Job{JobSpec: JobSpec{Resources: test.resources}})
+			if test.invalid {
+				if !errors.Is(err, ErrInvalidResources) {
+					t.Fatalf("expected invalid resources, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if limits.CPUSet != test.expected.CPUSet || limits.MemoryNodes != test.expected.MemoryNodes ||
+				limits.CPUWeight != test.expected.CPUWeight || limits.MemoryHigh != test.expected.MemoryHigh ||
+				limits.MemoryMax != test.expected.MemoryMax {
+				t.Fatalf("expected %+v, got %+v", test.expected, limits)
+			}
+		})
+	}
+}
+
+func TestQuotaJobLimits(t *testing.T) {
+	w, err := New(Config{
+		Limits: &JobLimitConfig{ResourceLimits: JobResourceLimits{
+			CPUMaxPeriod: 100000,
+			CPUMaxQuota:  200000,
+			MemoryMax:    1 << 30,
+		}},
+		NamespaceDefaults: NamespaceConfig{Quota: NamespaceQuota{MaxCPU: 2.5, MaxMemoryBytes: 3 << 30}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Shutdown(context.Background(), true)
+	submit := func(cpuSet string) (*Job, error) {
+		return w.SubmitJobSpec("", "", JobSpec{Command: "sleep", Args: []string{"10"}, Resources: JobResources{CPUSet: cpuSet}})
+	}
+
+	// Jobs limited to one CPU count as one core instead of the worker's two
+	var jobs []*Job
+	for i := 0; i < 2; i++ {
+		job, err := submit("0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer job.Stop(context.Background(), true)
+		jobs = append(jobs, job)
+	}
+	if usage, err := w.Usage(""); err != nil {
+		t.Fatal(err)
+	} else if usage.CPU != 2 || usage.MemoryBytes != 2<<30 {
+		t.Fatalf("expected 2 cores and 2 GiB, got %+v", usage)
+	}
+	var quotaErr *QuotaExceededError
+	if _, err := submit(""); !errors.As(err, &quotaErr) || quotaErr.Quota != "max_cpu" || quotaErr.Usage != 4 {
+		t.Fatalf("expected max CPU exceeded, got %v", err)
+	}
+	// Released once completed
+	if _, err := jobs[0].Stop(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	if usage, err := w.Usage(""); err != nil {
+		t.Fatal(err)
+	} else if usage.CPU != 1 || usage.MemoryBytes != 1<<30 || usage.ActiveJobs != 1 {
+		t.Fatalf("expected stopped job released, got %+v", usage)
+	}
+	if _, err := submit("0"); err != nil {
+		t.Fatal(err)
+	}
+}
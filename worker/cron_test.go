@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr                        string
+		invalid                     bool
+		dayOfMonthAny, dayOfWeekAny bool
+	}{
+		{expr: "* * * * *", dayOfMonthAny: true, dayOfWeekAny: true},
+		{expr: "@daily", dayOfMonthAny: true, dayOfWeekAny: true},
+		{expr: "@MONTHLY", dayOfWeekAny: true},
+		{expr: "0 0 */1 * 1", dayOfMonthAny: true},
+		{expr: "0 0 */2 * 1", dayOfMonthAny: true},
+		{expr: "0 0 1-31 * 1", dayOfMonthAny: true},
+		{expr: "0 0 1 * 0-6", dayOfWeekAny: true},
+		{expr: "0 0 1 * 0-7", dayOfWeekAny: true},
+		{expr: "0 0 1 * 1-7", dayOfWeekAny: true},
+		{expr: "0 0 1 * mon-fri"},
+		{expr: "0 0 1-30 * 1"},
+		{expr: "0-30/10 9-17 * jan,JUL sun", dayOfMonthAny: true},
+		{expr: "", invalid: true},
+		{expr: "* * * *", invalid: true},
+		{expr: "* * * * * *", invalid: true},
+		{expr: "60 * * * *", invalid: true},
+		{expr: "* 24 * * *", invalid: true},
+		{expr: "* * 0 * *", invalid: true},
+		{expr: "* * * 13 *", invalid: true},
+		{expr: "* * * * 8", invalid: true},
+		{expr: "*/0 * * * *", invalid: true},
+		{expr: "*/a * * * *", invalid: true},
+		{expr: "5-1 * * * *", invalid: true},
+		{expr: "* * * foo *", invalid: true},
+		{expr: "@every", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			c, err := parseCron(test.expr)
+			if test.invalid {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.dayOfMonthAny != test.dayOfMonthAny || c.dayOfWeekAny != test.dayOfWeekAny {
+				t.Fatalf("expected day of month any %v and day of week any %v, got %v and %v",
+					test.dayOfMonthAny, test.dayOfWeekAny, c.dayOfMonthAny, c.dayOfWeekAny)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// A Wednesday
+	after := time.Date(2024, 1, 10, 10, 30, 15, 0, time.UTC)
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 10, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 10, 10, 45, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2024, 1, 10, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2024, 1, 11, 9, 0, 0, 0, time.UTC)},
+		// Leap day
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Both days restricted matches either
+		{"0 0 15 * fri", time.Date(2024, 1, 12, 0, 0, 0, 0, time.UTC)},
+		// Unrestricted day of month with a step or full range matches only the
+		// day of week
+		{"0 0 */1 * fri", time.Date(2024, 1, 12, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1-31 * mon", time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+		// Unrestricted day of week with a full range matches only the day of month
+		{"0 0 20 * 0-6", time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC)},
+		// Never
+		{"0 0 31 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			c, err := parseCron(test.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := c.next(after); !actual.Equal(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCronNextTimeZone(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone data unavailable")
+	}
+	c, err := parseCron("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := c.next(time.Date(2024, 1, 10, 15, 0, 0, 0, time.UTC).In(loc))
+	if expected := time.Date(2024, 1, 11, 14, 0, 0, 0, time.UTC); !actual.Equal(expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
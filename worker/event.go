@@ -0,0 +1,257 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrJobEventsExpired is returned from Worker.JobEvents if events after the
+// requested sequence are no longer retained, including when the sequence is
+// from before the worker started.
+var ErrJobEventsExpired = errors.New("job events expired")
+
+// DefaultJobEventHistory is how many job events are retained per namespace if
+// Config.JobEventHistory is 0.
+const DefaultJobEventHistory = 1000
+
+// outputEventInterval is the minimum time between output events of a job.
+const outputEventInterval = 500 * time.Millisecond
+
+// JobEventType is the type of a job lifecycle event.
+type JobEventType int
+
+const (
+	// JobEventQueued is emitted when a job is waiting for capacity to start.
+	JobEventQueued JobEventType = iota
+	// JobEventPending is emitted when a job is waiting for its dependencies.
+	JobEventPending
+	// JobEventStarted is emitted when an attempt of a job starts.
+	JobEventStarted
+	// JobEventOutput is emitted when the output size of the latest attempt
+	// changes. This is emitted at most twice a second per job.
+	JobEventOutput
+	// JobEventRetrying is emitted when a job is waiting out the backoff after a
+	// failed attempt.
+	JobEventRetrying
+	// JobEventStopping is emitted when a job that has not completed is asked to
+	// stop, including when it is preempted.
+	JobEventStopping
+	// JobEventCompleted is emitted when a job completes, fails to start, or is
+	// canceled.
+	JobEventCompleted
+	// JobEventDeleted is emitted when a completed job is deleted or evicted.
+	JobEventDeleted
+)
+
+func (j JobEventType) String() string {
+	switch j {
+	case JobEventQueued:
+		return "queued"
+	case JobEventPending:
+		return "pending"
+	case JobEventStarted:
+		return "started"
+	case JobEventOutput:
+		return "output"
+	case JobEventRetrying:
+		return "retrying"
+	case JobEventStopping:
+		return "stopping"
+	case JobEventCompleted:
+		return "completed"
+	case JobEventDeleted:
+		return "deleted"
+	}
+	return fmt.Sprintf("JobEventType(%d)", int(j))
+}
+
+// JobEvent is a lifecycle event of a job with the job's values at the time of
+// the event. Callers should never mutate any fields.
+type JobEvent struct {
+	// Sequence number of the event. This increases across all namespaces of
+	// the worker and starts over when the worker is created.
+	Sequence uint64
+	Type     JobEventType
+	// Namespace and ID of the job.
+	Namespace string
+	JobID     string
+	Time      time.Time
+	State     JobState
+	Labels    map[string]string
+	// 1-based number of the latest attempt, or 0 if the job never started.
+	Attempt int
+	// Output sizes of the latest attempt.
+	StdoutSize int
+	StderrSize int
+	// Exit code of the job if it has completed.
+	ExitCode *int
+}
+
+// eventBus retains recent job events per namespace and notifies listeners of
+// new ones.
+type eventBus struct {
+	history int
+	lock    sync.Mutex
+	seq     uint64
+	// Keyed by namespace
+	namespaces map[string]*namespaceEvents
+	// Value is the namespace listened to
+	listeners map[chan<- struct{}]string
+}
+
+type namespaceEvents struct {
+	events []JobEvent
+	// Sequence of the last event no longer retained
+	expiredSeq uint64
+}
+
+func newEventBus(history int) *eventBus {
+	if history == 0 {
+		history = DefaultJobEventHistory
+	}
+	return &eventBus{
+		history:    history,
+		namespaces: map[string]*namespaceEvents{},
+		listeners:  map[chan<- struct{}]string{},
+	}
+}
+
+// publish assigns the event a sequence, retains it, and notifies listeners of
+// its namespace via non-blocking send.
+func (e *eventBus) publish(event JobEvent) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.seq++
+	event.Sequence = e.seq
+	ns := e.namespaces[event.Namespace]
+	if ns == nil {
+		ns = &namespaceEvents{}
+		e.namespaces[event.Namespace] = ns
+	}
+	ns.events = append(ns.events, event)
+	if len(ns.events) > e.history {
+		ns.expiredSeq = ns.events[0].Sequence
+		ns.events[0] = JobEvent{}
+		ns.events = ns.events[1:]
+	}
+	for listener, namespace := range e.listeners {
+		if namespace == event.Namespace {
+			select {
+			case listener <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// JobEvents returns the retained events in the namespace with a sequence after
+// the given one in order. This returns ErrJobEventsExpired if any such event is
+// no longer retained.
+func (w *Worker) JobEvents(namespace string, afterSeq uint64) ([]JobEvent, error) {
+	w.events.lock.Lock()
+	defer w.events.lock.Unlock()
+	if afterSeq > w.events.seq {
+		return nil, ErrJobEventsExpired
+	}
+	ns := w.events.namespaces[namespace]
+	if ns == nil {
+		return nil, nil
+	} else if afterSeq < ns.expiredSeq {
+		return nil, ErrJobEventsExpired
+	}
+	// Events are in sequence order
+	for i, event := range ns.events {
+		if event.Sequence > afterSeq {
+			return append([]JobEvent(nil), ns.events[i:]...), nil
+		}
+	}
+	return nil, nil
+}
+
+// LastJobEventSequence returns the sequence of the last event in any
+// namespace, or 0 if there are none. Passing this to Worker.JobEvents returns
+// only events published after this call.
+func (w *Worker) LastJobEventSequence() uint64 {
+	w.events.lock.Lock()
+	defer w.events.lock.Unlock()
+	return w.events.seq
+}
+
+// AddJobEventListener sets the given channel to receive a value when an event
+// is published in the namespace, after which Worker.JobEvents returns it.
+// Values are sent via non-blocking sends, so the buffer should be at least 1
+// and callers should read all new events after each value. Caller should never
+// close the channel (or at least not until after RemoveJobEventListener is
+// called).
+func (w *Worker) AddJobEventListener(namespace string, ch chan<- struct{}) {
+	w.events.lock.Lock()
+	defer w.events.lock.Unlock()
+	w.events.listeners[ch] = namespace
+}
+
+// RemoveJobEventListener removes the given channel, that was previously passed
+// to AddJobEventListener, from getting notified.
+func (w *Worker) RemoveJobEventListener(ch chan<- struct{}) {
+	w.events.lock.Lock()
+	defer w.events.lock.Unlock()
+	delete(w.events.listeners, ch)
+}
+
+// publishEvent publishes an event of the type for the job.
+func (j *Job) publishEvent(typ JobEventType) {
+	j.updateLock.RLock()
+	defer j.updateLock.RUnlock()
+	j.publishEventLocked(typ)
+}
+
+// publishEventLocked publishes an event of the type for the job if it has
+// been submitted. Caller must hold the update lock.
+func (j *Job) publishEventLocked(typ JobEventType) {
+	if j.events == nil {
+		return
+	}
+	event := JobEvent{
+		Type:      typ,
+		Namespace: j.Namespace,
+		JobID:     j.ID,
+		Time:      time.Now(),
+		State:     j.state,
+		// Labels are replaced, never mutated, on update
+		Labels:   j.labels,
+		Attempt:  len(j.attempts),
+		ExitCode: j.exitCode,
+	}
+	if event.Attempt > 0 {
+		attempt := j.attempts[event.Attempt-1]
+		event.StdoutSize, event.StderrSize = len(attempt.stdout), len(attempt.stderr)
+	}
+	j.events.publish(event)
+}
+
+// publishOutputEventLocked publishes an output event now or, if one was
+// published within the interval, once the interval passes. Caller must hold
+// the update lock.
+func (j *Job) publishOutputEventLocked() {
+	if j.events == nil || j.outputEventPending {
+		return
+	}
+	wait := outputEventInterval - time.Since(j.lastOutputEventAt)
+	if wait <= 0 {
+		j.lastOutputEventAt = time.Now()
+		j.publishEventLocked(JobEventOutput)
+		return
+	}
+	j.outputEventPending = true
+	time.AfterFunc(wait, func() {
+		j.updateLock.Lock()
+		defer j.updateLock.Unlock()
+		j.outputEventPending = false
+		// The completed event has the final sizes
+		if j.exitCode == nil {
+			j.lastOutputEventAt = time.Now()
+			j.publishEventLocked(JobEventOutput)
+		}
+	})
+}
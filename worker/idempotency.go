@@ -0,0 +1,161 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrIdempotencyKeyMismatch is returned (wrapped) on submission if the
+// idempotency key was already used in the namespace for a job with a different
+// ID or specification.
+var ErrIdempotencyKeyMismatch = errors.New("idempotency key used with different job")
+
+// DefaultIdempotencyKeyTTL is how long idempotency keys are retained if
+// Config.IdempotencyKeyTTL is 0.
+const DefaultIdempotencyKeyTTL = 24 * time.Hour
+
+// WithIdempotencyKey is a submit job option to make the submission idempotent.
+// If the key was used in the namespace within the retention window for a job
+// with the same ID (or both generated), specification, dependencies, labels,
+// and annotations, that job is returned instead of submitting a new one. If any
+// of those differ, ErrIdempotencyKeyMismatch is returned. The key is released
+// early if the job is deleted.
+func WithIdempotencyKey(key string) SubmitJobOption {
+	return func(j *Job) { j.IdempotencyKey = key }
+}
+
+// idempotentRequest is the part of a submission that must match for a reused
+// idempotency key.
+type idempotentRequest struct {
+	// Empty if generated
+	ID           string
+	Spec         JobSpec
+	Dependencies []JobDependency
+	Labels       map[string]string
+	Annotations  map[string]string
+}
+
+// mismatch returns the name of the first field that differs, or empty if none.
+func (i *idempotentRequest) mismatch(other *idempotentRequest) string {
+	if i.ID != other.ID {
+		return "id"
+	} else if !reflect.DeepEqual(i.Dependencies, other.Dependencies) {
+		return "dependencies"
+	} else if !maps.Equal(i.Labels, other.Labels) {
+		return "labels"
+	} else if !maps.Equal(i.Annotations, other.Annotations) {
+		return "annotations"
+	}
+	v, otherV := reflect.ValueOf(i.Spec), reflect.ValueOf(other.Spec)
+	for n := 0; n < v.NumField(); n++ {
+		if !reflect.DeepEqual(v.Field(n).Interface(), otherV.Field(n).Interface()) {
+			return strings.Split(v.Type().Field(n).Tag.Get("json"), ",")[0]
+		}
+	}
+	return ""
+}
+
+type idempotencyEntry struct {
+	namespace string
+	key       string
+	request   idempotentRequest
+	expiresAt time.Time
+	// Closed when the submission completes, after which job is set or the
+	// entry is removed on failure
+	submitted chan struct{}
+	job       *Job
+}
+
+// idempotencyIndex holds idempotency keys until they expire.
+type idempotencyIndex struct {
+	ttl  time.Duration
+	lock sync.Mutex
+	// Keyed by namespace, then key
+	entries map[string]map[string]*idempotencyEntry
+	// In order of expiration since all have the same TTL
+	expirations []*idempotencyEntry
+}
+
+func newIdempotencyIndex(ttl time.Duration) *idempotencyIndex {
+	if ttl == 0 {
+		ttl = DefaultIdempotencyKeyTTL
+	}
+	return &idempotencyIndex{ttl: ttl, entries: map[string]map[string]*idempotencyEntry{}}
+}
+
+// reserve returns the existing job for the key if it matches the request or
+// reserves the key and returns a nil job. If reserved, caller must call
+// complete with the result of the submission.
+func (i *idempotencyIndex) reserve(namespace, key string, request idempotentRequest) (*Job, *idempotencyEntry, error) {
+	for {
+		i.lock.Lock()
+		i.expireLocked(time.Now())
+		entry := i.entries[namespace][key]
+		if entry == nil {
+			entry = &idempotencyEntry{
+				namespace: namespace,
+				key:       key,
+				request:   request,
+				submitted: make(chan struct{}),
+			}
+			if i.entries[namespace] == nil {
+				i.entries[namespace] = map[string]*idempotencyEntry{}
+			}
+			i.entries[namespace][key] = entry
+			i.lock.Unlock()
+			return nil, entry, nil
+		}
+		i.lock.Unlock()
+		if field := entry.request.mismatch(&request); field != "" {
+			return nil, nil, fmt.Errorf("%w: %v differs", ErrIdempotencyKeyMismatch, field)
+		}
+		// Wait for a concurrent submission, retrying the reservation if it failed
+		<-entry.submitted
+		if entry.job != nil {
+			return entry.job, nil, nil
+		}
+	}
+}
+
+// complete records the job submitted for the reserved entry or removes the
+// entry if the job is nil.
+func (i *idempotencyIndex) complete(entry *idempotencyEntry, job *Job) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if job == nil {
+		delete(i.entries[entry.namespace], entry.key)
+	} else {
+		entry.job, entry.expiresAt = job, time.Now().Add(i.ttl)
+		i.expirations = append(i.expirations, entry)
+	}
+	close(entry.submitted)
+}
+
+// remove removes the entry of the job's key if it is for the job, so the key
+// can be reused.
+func (i *idempotencyIndex) remove(job *Job) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if entry := i.entries[job.Namespace][job.IdempotencyKey]; entry != nil && entry.job == job {
+		delete(i.entries[job.Namespace], job.IdempotencyKey)
+	}
+}
+
+// expireLocked removes expired entries. Caller must hold the lock.
+func (i *idempotencyIndex) expireLocked(now time.Time) {
+	n := 0
+	for ; n < len(i.expirations) && !now.Before(i.expirations[n].expiresAt); n++ {
+		entry := i.expirations[n]
+		// The key may have been removed and reused since
+		if i.entries[entry.namespace][entry.key] == entry {
+			delete(i.entries[entry.namespace], entry.key)
+		}
+		i.expirations[n] = nil
+	}
+	i.expirations = i.expirations[n:]
+}
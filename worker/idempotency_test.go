@@ -0,0 +1,107 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIdempotentRequestMismatch(t *testing.T) {
+	base := idempotentRequest{
+		Spec:         JobSpec{Command: "echo", Args: []string{"foo"}},
+		Dependencies: []JobDependency{{JobID: "dep"}},
+		Labels:       map[string]string{"app": "foo"},
+		Annotations:  map[string]string{"note": "foo"},
+	}
+	tests := []struct {
+		name     string
+		update   func(*idempotentRequest)
+		expected string
+	}{
+		{name: "same", update: func(*idempotentRequest) {}},
+		{name: "id", update: func(r *idempotentRequest) { r.ID = "foo" }, expected: "id"},
+		{name: "args", update: func(r *idempotentRequest) { r.Spec.Args = []string{"bar"} }, expected: "args"},
+		{name: "priority", update: func(r *idempotentRequest) { r.Spec.Priority = 1 }, expected: "priority"},
+		{name: "dependencies", update: func(r *idempotentRequest) { r.Dependencies = nil }, expected: "dependencies"},
+		{name: "labels", update: func(r *idempotentRequest) { r.Labels = map[string]string{"app": "bar"} },
+			expected: "labels"},
+		{name: "annotations", update: func(r *idempotentRequest) { r.Annotations = nil }, expected: "annotations"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			other := base
+			test.update(&other)
+			if actual := base.mismatch(&other); actual != test.expected {
+				t.Fatalf("expected mismatch %q, got %q", test.expected, actual)
+			}
+		})
+	}
+	// Nil and empty labels are the same
+	if actual := (&idempotentRequest{}).mismatch(&idempotentRequest{Labels: map[string]string{}}); actual != "" {
+		t.Fatalf("expected no mismatch, got %q", actual)
+	}
+}
+
+func TestIdempotencyIndexRemove(t *testing.T) {
+	index := newIdempotencyIndex(time.Hour)
+	reserve := func(job *Job) {
+		t.Helper()
+		existing, entry, err := index.reserve("ns", "key", idempotentRequest{Spec: job.JobSpec})
+		if err != nil || existing != nil || entry == nil {
+			t.Fatalf("expected reservation, got %v, %v, %v", existing, entry, err)
+		}
+		index.complete(entry, job)
+	}
+	job1 := newJob("ns", "job1", JobSpec{Command: "true"})
+	reserve(job1)
+	firstExpiresAt := index.entries["ns"]["key"].expiresAt
+	// Removing a different job with the key does not remove it
+	index.remove(newJob("ns", "other", JobSpec{Command: "true", Priority: 1}))
+	_, _, err := index.reserve("ns", "key", idempotentRequest{Spec: JobSpec{Command: "false"}})
+	if !errors.Is(err, ErrIdempotencyKeyMismatch) {
+		t.Fatalf("expected mismatch, got %v", err)
+	}
+	// Removing the job allows reuse with a different request
+	job1.IdempotencyKey = "key"
+	index.remove(job1)
+	job2 := newJob("ns", "job2", JobSpec{Command: "false"})
+	reserve(job2)
+	// Expiring the first entry does not remove the reused key
+	index.lock.Lock()
+	index.expireLocked(firstExpiresAt)
+	index.lock.Unlock()
+	existing, _, err := index.reserve("ns", "key", idempotentRequest{Spec: job2.JobSpec})
+	if err != nil || existing != job2 {
+		t.Fatalf("expected existing job, got %v, %v", existing, err)
+	}
+}
+
+func TestIdempotencyKeyReleasedOnDelete(t *testing.T) {
+	w, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Shutdown(context.Background(), true)
+	submit := func(labels map[string]string) (*Job, error) {
+		return w.SubmitJobSpec("", "", JobSpec{Command: "true"}, WithIdempotencyKey("key"), WithLabels(labels))
+	}
+	job, err := submit(map[string]string{"app": "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := submit(map[string]string{"app": "foo"}); err != nil || again != job {
+		t.Fatalf("expected same job, got %v, %v", again, err)
+	}
+	if _, err := submit(map[string]string{"app": "bar"}); !errors.Is(err, ErrIdempotencyKeyMismatch) {
+		t.Fatalf("expected mismatch, got %v", err)
+	}
+	<-job.doneCtx.Done()
+	if err := w.DeleteJob("", job.ID); err != nil {
+		t.Fatal(err)
+	}
+	// The key is released with the job
+	if replacement, err := submit(map[string]string{"app": "bar"}); err != nil || replacement == job {
+		t.Fatalf("expected new job, got %v, %v", replacement, err)
+	}
+}
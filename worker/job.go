@@ -3,27 +3,53 @@ package worker
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 )
 
-// Job represents a running or completed job. Callers should never mutate any
-// fields. All visible fields are never changed.
+// Job represents a queued, running, or completed job. Callers should never
+// mutate any fields. All visible fields are never changed.
 type Job struct {
 	// Namespace for the job, can be empty string.
 	Namespace string
 	// ID of the job, never empty.
 	ID string
-	// Command of the job, never empty.
-	Command string
-	// Arguments for the command.
-	Args []string
-	// If set, the job is limited to this root directory.
-	RootFS string
+	// Specification the job was submitted with. Unset values that have worker
+	// defaults are set to the defaults.
+	JobSpec
+	// If set, the ID of the schedule that submitted this job.
+	ScheduleID string
+	// If set, the ID of the workflow this job was submitted in.
+	WorkflowID string
+	// If set, the name and version of the template this job was rendered from.
+	TemplateName    string
+	TemplateVersion int
+	// Jobs in the namespace that must complete before this job can start.
+	Dependencies []JobDependency
+	// If set, the key the job was submitted with to make submission idempotent.
+	IdempotencyKey string
 	// Time this job was created.
 	CreatedAt time.Time
-	// PID of the job while it was running.
-	PID int
+	// Effective Linux capabilities of the job process. This is only set for
+	// jobs on a worker configured with job limits.
+	Capabilities []string
+
+	hostUser *JobUser
+	// Resolved jobs of Dependencies in the same order
+	dependencyJobs []*Job
+	// CPU cores and memory bytes the job is limited to, or 0 if unlimited
+	cpuLimit    float64
+	memoryLimit uint64
+	// Usage of the namespace the job counts against, set once submitted
+	usage *namespaceUsage
+	// Set once submitted
+	events            *eventBus
+	metrics           Metrics
+	log               *slog.Logger
+	injectTraceParent bool
+	// Span context that spans of the job are children of
+	traceCtx context.Context
 
 	doneCtx         context.Context
 	doneCancel      context.CancelFunc
@@ -33,11 +59,93 @@ type Job struct {
 	forceStopCancel context.CancelFunc
 
 	// This mutex governs all fields below it
-	updateLock sync.RWMutex
-	stdout     []byte
-	stderr     []byte
-	exitCode   *int
-	listeners  map[chan<- JobUpdate]struct{}
+	updateLock  sync.RWMutex
+	state       JobState
+	startErr    error
+	preempted   bool
+	attempts    []*JobAttempt
+	exitCode    *int
+	completedAt time.Time
+	labels      map[string]string
+	annotations map[string]string
+	listeners   map[chan<- JobUpdate]struct{}
+	// Throttling of output events
+	lastOutputEventAt  time.Time
+	outputEventPending bool
+	// Time of the first stop request before completion
+	stopRequestedAt time.Time
+}
+
+// JobSpec is the specification of a job to submit.
+type JobSpec struct {
+	// Command of the job, never empty.
+	Command string `json:"command"`
+	// Arguments for the command.
+	Args []string `json:"args,omitempty"`
+	// Environment variables of the job. These are added to, or replace, the
+	// worker's environment.
+	Env map[string]string `json:"env,omitempty"`
+	// If set, the job is limited to this root directory.
+	RootFS string `json:"root_fs,omitempty"`
+	// If set, the name of the configured host user the job runs as. Otherwise
+	// the job runs as the worker's user.
+	User string `json:"user,omitempty"`
+	// POSIX resource limits of the job. Nil limits are inherited from the
+	// worker.
+	RLimits JobRLimits `json:"rlimits,omitempty"`
+	// Resource settings of the job within the worker's limits. This can only be
+	// set for jobs on a worker configured with job limits.
+	Resources JobResources `json:"resources,omitempty"`
+	// Hostname of the job. This is only set for jobs on a worker configured with
+	// job limits and defaults to the job ID.
+	Hostname string `json:"hostname,omitempty"`
+	// Priority of the job relative to other jobs in the namespace. Queued jobs
+	// with higher priority start first.
+	Priority int `json:"priority,omitempty"`
+	// If set, how the job is retried when an attempt fails.
+	RetryPolicy *RetryPolicy `json:"retry_policy,omitempty"`
+	// If set, how long after the job completes it is deleted.
+	TTL time.Duration `json:"ttl,omitempty"`
+	// Targets notified when the job completes in addition to the worker's.
+	Webhooks []WebhookTarget `json:"webhooks,omitempty"`
+}
+
+// JobState is the state of a job.
+type JobState int
+
+const (
+	// JobStateQueued is a job waiting for capacity to start.
+	JobStateQueued JobState = iota
+	// JobStateRunning is a job that has started and not completed.
+	JobStateRunning
+	// JobStateCompleted is a job that has completed or failed to start.
+	JobStateCompleted
+	// JobStateCanceled is a job that was stopped before it started.
+	JobStateCanceled
+	// JobStatePending is a job waiting for its dependencies to complete before
+	// it is queued.
+	JobStatePending
+	// JobStateRetrying is a job waiting out the backoff after a failed attempt
+	// before it is queued again.
+	JobStateRetrying
+)
+
+func (j JobState) String() string {
+	switch j {
+	case JobStateQueued:
+		return "queued"
+	case JobStateRunning:
+		return "running"
+	case JobStateCompleted:
+		return "completed"
+	case JobStateCanceled:
+		return "canceled"
+	case JobStatePending:
+		return "pending"
+	case JobStateRetrying:
+		return "retrying"
+	}
+	return fmt.Sprintf("JobState(%d)", int(j))
 }
 
 // JobUpdate represents a type of update that can be listened to.
@@ -46,19 +154,24 @@ type JobUpdate int
 const (
 	JobUpdateStdout JobUpdate = iota
 	JobUpdateStderr
+	// Sent when an attempt completes and when the job completes.
 	JobUpdateExitCode
+	// Sent when a new attempt starts.
+	JobUpdateAttempt
 )
 
 // newJob creates a new Job. This may not populate some fields that may be
 // populated by the caller.
-func newJob(namespace, id, command string, args ...string) *Job {
+func newJob(namespace, id string, spec JobSpec) *Job {
 	j := &Job{
 		Namespace: namespace,
 		ID:        id,
-		Command:   command,
-		Args:      args,
+		JobSpec:   spec,
 		CreatedAt: time.Now(),
 		listeners: map[chan<- JobUpdate]struct{}{},
+		metrics:   NopMetrics{},
+		log:       nopLogger,
+		traceCtx:  context.Background(),
 	}
 	// Since these contexts do not have timers, nothing leaks if they are not
 	// canceled
@@ -68,10 +181,61 @@ func newJob(namespace, id, command string, args ...string) *Job {
 	return j
 }
 
-// ReadStdout attempts a non-blocking read of the job output into b from the
-// given offset. An error occurs if the offset is beyond the length of the
-// output. The byte slice can be empty/nil to only check total output and exit
-// code.
+// State returns the current state of the job.
+func (j *Job) State() JobState {
+	j.updateLock.RLock()
+	defer j.updateLock.RUnlock()
+	return j.state
+}
+
+// PID returns the ID of the process of the latest attempt, or 0 if the job
+// never started. This was a field before jobs could be queued and retried, but
+// is now a method since it changes after submission. Callers that read the
+// field must call this instead.
+func (j *Job) PID() int {
+	j.updateLock.RLock()
+	defer j.updateLock.RUnlock()
+	if len(j.attempts) == 0 {
+		return 0
+	}
+	return j.attempts[len(j.attempts)-1].pid
+}
+
+// Attempts returns all attempts of the job in order. This is empty if the job
+// never started.
+func (j *Job) Attempts() []*JobAttempt {
+	j.updateLock.RLock()
+	defer j.updateLock.RUnlock()
+	return append([]*JobAttempt(nil), j.attempts...)
+}
+
+// Attempt returns the attempt with the given 1-based number, or nil if it has
+// not started.
+func (j *Job) Attempt(number int) *JobAttempt {
+	j.updateLock.RLock()
+	defer j.updateLock.RUnlock()
+	if number < 1 || number > len(j.attempts) {
+		return nil
+	}
+	return j.attempts[number-1]
+}
+
+// StartError returns the error starting a job that was queued, or the reason a
+// pending job was canceled because its dependencies were not satisfied. This is
+// nil if the job started or has not tried to start. Jobs that are not queued on
+// submission instead fail submission if they cannot start.
+func (j *Job) StartError() error {
+	j.updateLock.RLock()
+	defer j.updateLock.RUnlock()
+	return j.startErr
+}
+
+// ReadStdout attempts a non-blocking read of the job output of the latest
+// attempt into b from the given offset. Since a retried job's output starts
+// over with each attempt, callers following output across attempts should
+// read from each JobAttempt instead. An error occurs if the offset is beyond
+// the length of the output. The byte slice can be empty/nil to only check total
+// output and exit code.
 //
 // This returns the amount of data read (if any), total known amount of data,
 // and exit code if the job is complete (or nil if not completed). The exit code
@@ -93,12 +257,16 @@ func (j *Job) ReadStderr(b []byte, offset int) (read, total int, exitCode *int,
 func (j *Job) readOutput(stderr bool, b []byte, offset int) (read, total int, exitCode *int, err error) {
 	j.updateLock.RLock()
 	defer j.updateLock.RUnlock()
-	out := j.stdout
-	if stderr {
-		out = j.stderr
+	var out []byte
+	if len(j.attempts) > 0 {
+		out = j.attempts[len(j.attempts)-1].output(stderr)
 	}
+	read, total, err = readOutputAt(out, b, offset)
+	return read, total, j.exitCode, err
+}
+
+func readOutputAt(out []byte, b []byte, offset int) (read, total int, err error) {
 	total = len(out)
-	exitCode = j.exitCode
 	// Only copy to bytes if there are any
 	if len(b) > 0 {
 		if offset > total {
@@ -107,16 +275,16 @@ func (j *Job) readOutput(stderr bool, b []byte, offset int) (read, total int, ex
 			read = copy(b, out[offset:])
 		}
 	}
-	return read, total, exitCode, err
+	return read, total, err
 }
 
 // AddUpdateListener sets the given channel to receive update type on each
-// update. Updates to this channel occur via non-blocking sends, so callers
-// should make sure there is enough buffer room for any needed update type or
-// the update notification may be missed. Since there are three types of update
-// types, the buffer is usually best as >= 3, but can be larger to avoid races
-// depending on reader implementation. Caller should never close the channel
-// (or at least not until after RemoveUpdateListener is called).
+// update of any attempt. Updates to this channel occur via non-blocking sends,
+// so callers should make sure there is enough buffer room for any needed update
+// type or the update notification may be missed. Since there are four types of
+// update types, the buffer is usually best as >= 4, but can be larger to avoid
+// races depending on reader implementation. Caller should never close the
+// channel (or at least not until after RemoveUpdateListener is called).
 func (j *Job) AddUpdateListener(updates chan<- JobUpdate) {
 	j.updateLock.Lock()
 	defer j.updateLock.Unlock()
@@ -136,16 +304,12 @@ func (j *Job) RemoveUpdateListener(updates chan<- JobUpdate) {
 
 // Stop stops the job if not already stopped and waits for completion or context
 // close. This does not error if the job is already stopped. If force is set,
-// the job is killed via SIGKILL instead of SIGTERM. If the context closes
-// before the job is complete, an error is returned. Otherwise, the exit code is
-// returned equivalent to calling ExitCode.
+// the job is killed via SIGKILL instead of SIGTERM. If the job is queued or
+// pending, it is canceled instead. If the context closes before the job is
+// complete, an error is returned. Otherwise, the exit code is returned
+// equivalent to calling ExitCode.
 func (j *Job) Stop(ctx context.Context, force bool) (code int, err error) {
-	// Cancel the context
-	if force {
-		j.forceStopCancel()
-	} else {
-		j.stopCancel()
-	}
+	j.requestStop(force)
 	// Wait until done
 	select {
 	case <-ctx.Done():
@@ -157,51 +321,277 @@ func (j *Job) Stop(ctx context.Context, force bool) (code int, err error) {
 }
 
 // ExitCode returns a non-nil exit code if the job has completed, or nil if it
-// is still running. The result will be -1 if the job is completed but an exit
-// code could not be determined. If the result is non-nil, there is never more
-// output added to the job so the total will never change.
+// is still queued, running, or retrying. This is the exit code of the last
+// attempt. The result will be -1 if the job is completed but an exit code could
+// not be determined, it failed to start, or it was canceled. If the result is
+// non-nil, there is never more output added to the job so the total will never
+// change.
 func (j *Job) ExitCode() *int {
 	j.updateLock.RLock()
 	defer j.updateLock.RUnlock()
 	return j.exitCode
 }
 
-// updateOutput adds output to the job on the given stream. The byte slice is
-// not held by this call and can be reused by caller. This should never be
-// called after markDone is called.
-func (j *Job) updateOutput(stderr bool, output []byte) {
+// Labels returns a copy of the current labels of the job.
+func (j *Job) Labels() map[string]string {
+	j.updateLock.RLock()
+	defer j.updateLock.RUnlock()
+	return copyLabels(j.labels)
+}
+
+// Annotations returns a copy of the current annotations of the job.
+func (j *Job) Annotations() map[string]string {
+	j.updateLock.RLock()
+	defer j.updateLock.RUnlock()
+	return copyLabels(j.annotations)
+}
+
+// CompletedAt returns the time the job completed, or zero if it has not.
+func (j *Job) CompletedAt() time.Time {
+	j.updateLock.RLock()
+	defer j.updateLock.RUnlock()
+	return j.completedAt
+}
+
+// Preempted returns true if the job was stopped by the worker to make room for
+// a higher priority job.
+func (j *Job) Preempted() bool {
+	j.updateLock.RLock()
+	defer j.updateLock.RUnlock()
+	return j.preempted
+}
+
+// requestStop cancels the stop context without waiting and publishes a
+// stopping event if the job has not completed.
+func (j *Job) requestStop(force bool) {
+	if force {
+		j.forceStopCancel()
+	} else {
+		j.stopCancel()
+	}
+	j.updateLock.Lock()
+	defer j.updateLock.Unlock()
+	if j.exitCode == nil {
+		if j.stopRequestedAt.IsZero() {
+			j.stopRequestedAt = time.Now()
+		}
+		j.publishEventLocked(JobEventStopping)
+	}
+}
+
+// preempt marks the job preempted and gracefully stops it without waiting.
+func (j *Job) preempt() {
+	j.updateLock.Lock()
+	j.preempted = true
+	j.updateLock.Unlock()
+	j.requestStop(false)
+}
+
+// setState sets the state of a job that has not started. Pending and retrying
+// events are published for those states.
+func (j *Job) setState(state JobState) {
+	j.updateLock.Lock()
+	defer j.updateLock.Unlock()
+	j.state = state
+	switch state {
+	case JobStatePending:
+		j.publishEventLocked(JobEventPending)
+	case JobStateRetrying:
+		j.publishEventLocked(JobEventRetrying)
+	}
+}
+
+// markStarted sets the job as running with a new attempt for the given PID.
+func (j *Job) markStarted(pid int) *JobAttempt {
+	j.updateLock.Lock()
+	defer j.updateLock.Unlock()
+	j.state = JobStateRunning
+	attempt := &JobAttempt{
+		Number:    len(j.attempts) + 1,
+		StartedAt: time.Now(),
+		job:       j,
+		pid:       pid,
+		done:      make(chan struct{}),
+	}
+	j.attempts = append(j.attempts, attempt)
+	j.notifyLocked(JobUpdateAttempt)
+	j.publishEventLocked(JobEventStarted)
+	return attempt
+}
+
+// updateOutput adds output to the attempt on the given stream. The byte slice
+// is not held by this call and can be reused by caller. This should never be
+// called after markAttemptDone is called.
+func (j *Job) updateOutput(attempt *JobAttempt, stderr bool, output []byte) {
+	j.metrics.OutputCaptured(j.Namespace, stderr, len(output))
+	if j.usage != nil {
+		j.usage.outputBytes.Add(uint64(len(output)))
+	}
 	j.updateLock.Lock()
 	defer j.updateLock.Unlock()
 	// Append
-	var update JobUpdate
 	if stderr {
-		j.stderr = append(j.stderr, output...)
-		update = JobUpdateStderr
+		attempt.stderr = append(attempt.stderr, output...)
+		j.notifyLocked(JobUpdateStderr)
 	} else {
-		j.stdout = append(j.stdout, output...)
-		update = JobUpdateStdout
-	}
-	// Notify listeners via non-blocking send
-	for listener := range j.listeners {
-		select {
-		case listener <- update:
-		default:
-		}
+		attempt.stdout = append(attempt.stdout, output...)
+		j.notifyLocked(JobUpdateStdout)
 	}
+	j.publishOutputEventLocked()
+}
+
+// markAttemptDone puts the exit code and terminating signal, if any, on the
+// attempt. updateOutput should never be called for the attempt after this is
+// called.
+func (j *Job) markAttemptDone(attempt *JobAttempt, exitCode int, signal string) {
+	j.updateLock.Lock()
+	defer j.updateLock.Unlock()
+	attempt.exitCode, attempt.signal, attempt.endedAt = &exitCode, signal, time.Now()
+	close(attempt.done)
+	j.notifyLocked(JobUpdateExitCode)
 }
 
 // markDone puts the exit code on the job. updateOutput should never be called
 // after this is called.
 func (j *Job) markDone(exitCode int) {
+	j.markDoneWithState(JobStateCompleted, exitCode, nil)
+}
+
+// markDoneWithState is markDone with an explicit state and start error.
+func (j *Job) markDoneWithState(state JobState, exitCode int, startErr error) {
 	j.updateLock.Lock()
-	defer j.updateLock.Unlock()
+	// Release the job's limits from the usage before anyone sees it is done
+	if j.exitCode == nil && j.usage != nil {
+		j.usage.complete(j)
+	}
+	j.state = state
+	j.startErr = startErr
 	j.exitCode = &exitCode
+	j.completedAt = time.Now()
 	j.doneCancel()
-	// Notify listeners via non-blocking send
+	j.notifyLocked(JobUpdateExitCode)
+	j.publishEventLocked(JobEventCompleted)
+	// Record metrics outside of the lock
+	class, stopRequestedAt := j.exitClassLocked(), j.stopRequestedAt
+	var runDuration time.Duration
+	if len(j.attempts) > 0 {
+		runDuration = j.completedAt.Sub(j.attempts[0].StartedAt)
+	}
+	j.updateLock.Unlock()
+	j.metrics.JobCompleted(j.Namespace, class, runDuration)
+	if !stopRequestedAt.IsZero() {
+		j.metrics.JobStopped(j.Namespace, time.Since(stopRequestedAt))
+	}
+}
+
+// outputBytes returns the size of the stdout and stderr of all attempts.
+func (j *Job) outputBytes() uint64 {
+	j.updateLock.RLock()
+	defer j.updateLock.RUnlock()
+	var size uint64
+	for _, attempt := range j.attempts {
+		size += uint64(len(attempt.stdout) + len(attempt.stderr))
+	}
+	return size
+}
+
+// releaseOutput drops the output of all attempts of a completed job that was
+// deleted.
+func (j *Job) releaseOutput() {
+	j.updateLock.Lock()
+	defer j.updateLock.Unlock()
+	for _, attempt := range j.attempts {
+		attempt.stdout, attempt.stderr = nil, nil
+	}
+}
+
+// notifyLocked notifies listeners via non-blocking send. Caller must hold the
+// update lock.
+func (j *Job) notifyLocked(update JobUpdate) {
 	for listener := range j.listeners {
 		select {
-		case listener <- JobUpdateExitCode:
+		case listener <- update:
 		default:
 		}
 	}
 }
+
+// JobAttempt is a single run of a job. Jobs without a retry policy have at
+// most one attempt. Callers should never mutate any fields. All visible fields
+// are never changed.
+type JobAttempt struct {
+	// 1-based number of the attempt.
+	Number int
+	// Time the attempt started.
+	StartedAt time.Time
+
+	job  *Job
+	done chan struct{}
+
+	// These fields are governed by the job update lock
+	pid      int
+	stdout   []byte
+	stderr   []byte
+	exitCode *int
+	signal   string
+	endedAt  time.Time
+}
+
+// PID returns the ID of the attempt process.
+func (a *JobAttempt) PID() int {
+	a.job.updateLock.RLock()
+	defer a.job.updateLock.RUnlock()
+	return a.pid
+}
+
+// ExitCode returns a non-nil exit code if the attempt has completed, or nil if
+// it is still running. The result will be -1 if an exit code could not be
+// determined, including when the process was terminated by a signal.
+func (a *JobAttempt) ExitCode() *int {
+	a.job.updateLock.RLock()
+	defer a.job.updateLock.RUnlock()
+	return a.exitCode
+}
+
+// Signal returns the name of the signal that terminated the attempt process
+// (e.g. "SIGKILL"), or empty if it was not terminated by a signal or has not
+// completed. On a worker with job limits, the exit code 128+N of the job is
+// reported as signal N since the job runs under a child process.
+func (a *JobAttempt) Signal() string {
+	a.job.updateLock.RLock()
+	defer a.job.updateLock.RUnlock()
+	return a.signal
+}
+
+// EndedAt returns the time the attempt completed, or zero if it has not.
+func (a *JobAttempt) EndedAt() time.Time {
+	a.job.updateLock.RLock()
+	defer a.job.updateLock.RUnlock()
+	return a.endedAt
+}
+
+// ReadStdout is the equivalent of Job.ReadStdout for the output of this
+// attempt, except the exit code is of the attempt.
+func (a *JobAttempt) ReadStdout(b []byte, offset int) (read, total int, exitCode *int, err error) {
+	return a.readOutput(false, b, offset)
+}
+
+// ReadStderr is the equivalent of ReadStdout but for the stderr output.
+func (a *JobAttempt) ReadStderr(b []byte, offset int) (read, total int, exitCode *int, err error) {
+	return a.readOutput(true, b, offset)
+}
+
+func (a *JobAttempt) readOutput(stderr bool, b []byte, offset int) (read, total int, exitCode *int, err error) {
+	a.job.updateLock.RLock()
+	defer a.job.updateLock.RUnlock()
+	read, total, err = readOutputAt(a.output(stderr), b, offset)
+	return read, total, a.exitCode, err
+}
+
+// output returns the stdout or stderr. Caller must hold the job update lock.
+func (a *JobAttempt) output(stderr bool) []byte {
+	if stderr {
+		return a.stderr
+	}
+	return a.stdout
+}
@@ -0,0 +1,328 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidLabels is returned (wrapped) if job labels or annotations are
+// invalid.
+var ErrInvalidLabels = errors.New("invalid labels")
+
+// ErrInvalidLabelSelector is returned (wrapped) from ParseLabelSelector if the
+// selector is invalid.
+var ErrInvalidLabelSelector = errors.New("invalid label selector")
+
+var (
+	labelNameRegex   = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?)?$`)
+	labelPrefixRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// WithLabels is a submit job option to set identifying labels on the job that
+// can be matched by label selectors. Keys and values follow the Kubernetes
+// label syntax.
+func WithLabels(labels map[string]string) SubmitJobOption {
+	return func(j *Job) { j.labels = copyLabels(labels) }
+}
+
+// WithAnnotations is a submit job option to set non-identifying metadata on the
+// job. Keys follow the Kubernetes label key syntax and values can be any
+// string.
+func WithAnnotations(annotations map[string]string) SubmitJobOption {
+	return func(j *Job) { j.annotations = copyLabels(annotations) }
+}
+
+// validateLabelKey validates a key of the form [PREFIX/]NAME where the prefix
+// is a DNS subdomain of at most 253 characters and the name is at most 63
+// alphanumeric characters or '-', '_', or '.' beginning and ending with an
+// alphanumeric character.
+func validateLabelKey(key string) error {
+	name := key
+	if slash := strings.Index(key, "/"); slash >= 0 {
+		prefix := key[:slash]
+		if len(prefix) == 0 || len(prefix) > 253 || !labelPrefixRegex.MatchString(prefix) {
+			return fmt.Errorf("%w: key %q has invalid prefix", ErrInvalidLabels, key)
+		}
+		name = key[slash+1:]
+	}
+	if len(name) == 0 || len(name) > 63 || !labelNameRegex.MatchString(name) {
+		return fmt.Errorf("%w: invalid key %q", ErrInvalidLabels, key)
+	}
+	return nil
+}
+
+// validateLabelValue validates a value of at most 63 characters with the same
+// characters allowed as a key name. It can be empty.
+func validateLabelValue(value string) error {
+	if len(value) > 63 || !labelNameRegex.MatchString(value) {
+		return fmt.Errorf("%w: invalid value %q", ErrInvalidLabels, value)
+	}
+	return nil
+}
+
+func validateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if err := validateLabelKey(key); err != nil {
+			return err
+		} else if err := validateLabelValue(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateAnnotations(annotations map[string]string) error {
+	for key := range annotations {
+		if err := validateLabelKey(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	ret := make(map[string]string, len(labels))
+	for k, v := range labels {
+		ret[k] = v
+	}
+	return ret
+}
+
+// updateLabels returns a copy of the labels with the values set and keys
+// removed. Removals are applied after sets.
+func updateLabels(labels map[string]string, set map[string]string, remove []string) map[string]string {
+	ret := make(map[string]string, len(labels)+len(set))
+	for k, v := range labels {
+		ret[k] = v
+	}
+	for k, v := range set {
+		ret[k] = v
+	}
+	for _, k := range remove {
+		delete(ret, k)
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
+
+// JobLabelUpdate is a change to the labels and annotations of a job. Removals
+// are applied after sets.
+type JobLabelUpdate struct {
+	SetLabels         map[string]string
+	RemoveLabels      []string
+	SetAnnotations    map[string]string
+	RemoveAnnotations []string
+}
+
+// UpdateJobLabels applies the update to the labels and annotations of a job
+// and returns the job. This returns ErrJobNotFound if the job does not exist,
+// a wrapped ErrInvalidLabels if a set label or annotation is invalid, or
+// ErrShutdown if the worker is shutdown.
+func (w *Worker) UpdateJobLabels(namespace, id string, update JobLabelUpdate) (*Job, error) {
+	if err := validateLabels(update.SetLabels); err != nil {
+		return nil, err
+	} else if err := validateAnnotations(update.SetAnnotations); err != nil {
+		return nil, err
+	}
+	job, err := w.GetJob(namespace, id)
+	if err != nil {
+		return nil, err
+	} else if job == nil {
+		return nil, ErrJobNotFound
+	}
+	job.updateLock.Lock()
+	defer job.updateLock.Unlock()
+	job.labels = updateLabels(job.labels, update.SetLabels, update.RemoveLabels)
+	job.annotations = updateLabels(job.annotations, update.SetAnnotations, update.RemoveAnnotations)
+	return job, nil
+}
+
+// Jobs returns the jobs in the namespace matching the selector in the order
+// they were created. This returns ErrShutdown if the worker is shutdown.
+func (w *Worker) Jobs(namespace string, selector LabelSelector) ([]*Job, error) {
+	w.shutdownLock.RLock()
+	defer w.shutdownLock.RUnlock()
+	if w.shutdown {
+		return nil, ErrShutdown
+	}
+	w.jobsLock.RLock()
+	var jobs []*Job
+	for _, job := range w.jobs[namespace] {
+		// Reserved IDs have nil jobs
+		if job != nil && selector.Matches(job.Labels()) {
+			jobs = append(jobs, job)
+		}
+	}
+	w.jobsLock.RUnlock()
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].CreatedAt.Equal(jobs[j].CreatedAt) {
+			return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+	return jobs, nil
+}
+
+// LabelSelector matches labels. The zero value matches everything.
+type LabelSelector struct {
+	requirements []labelRequirement
+}
+
+type labelOperator int
+
+const (
+	labelOpEquals labelOperator = iota
+	labelOpNotEquals
+	labelOpIn
+	labelOpNotIn
+	labelOpExists
+	labelOpNotExists
+)
+
+type labelRequirement struct {
+	key      string
+	operator labelOperator
+	values   []string
+}
+
+// ParseLabelSelector parses a Kubernetes-style label selector. It is a
+// comma-separated set of requirements that must all match where each
+// requirement is one of:
+//
+//	key=value, key==value  the label is present with the value
+//	key!=value             the label is absent or has a different value
+//	key in (v1,v2)         the label is present with one of the values
+//	key notin (v1,v2)      the label is absent or has none of the values
+//	key                    the label is present
+//	!key                   the label is absent
+//
+// An empty selector matches everything.
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	var ret LabelSelector
+	rest := strings.TrimSpace(selector)
+	for rest != "" {
+		var req labelRequirement
+		var err error
+		if req, rest, err = parseLabelRequirement(rest); err != nil {
+			return LabelSelector{}, fmt.Errorf("%w: %v", ErrInvalidLabelSelector, err)
+		}
+		ret.requirements = append(ret.requirements, req)
+		rest = strings.TrimSpace(rest)
+		if rest == "" {
+			break
+		} else if rest[0] != ',' {
+			return LabelSelector{}, fmt.Errorf("%w: expected ',' at %q", ErrInvalidLabelSelector, rest)
+		}
+		if rest = strings.TrimSpace(rest[1:]); rest == "" {
+			return LabelSelector{}, fmt.Errorf("%w: trailing ','", ErrInvalidLabelSelector)
+		}
+	}
+	return ret, nil
+}
+
+// parseLabelRequirement parses a single requirement at the start of s and
+// returns the rest.
+func parseLabelRequirement(s string) (req labelRequirement, rest string, err error) {
+	if strings.HasPrefix(s, "!") {
+		req.operator = labelOpNotExists
+		s = strings.TrimSpace(s[1:])
+	} else {
+		req.operator = labelOpExists
+	}
+	// Key ends at whitespace, operator, or comma
+	end := strings.IndexAny(s, " \t=!,")
+	if end == -1 {
+		end = len(s)
+	}
+	req.key, rest = s[:end], strings.TrimSpace(s[end:])
+	if err := validateLabelKey(req.key); err != nil {
+		return req, "", err
+	}
+	if req.operator == labelOpNotExists || rest == "" || rest[0] == ',' {
+		return req, rest, nil
+	}
+	// Operator
+	switch {
+	case strings.HasPrefix(rest, "=="):
+		req.operator, rest = labelOpEquals, rest[2:]
+	case strings.HasPrefix(rest, "="):
+		req.operator, rest = labelOpEquals, rest[1:]
+	case strings.HasPrefix(rest, "!="):
+		req.operator, rest = labelOpNotEquals, rest[2:]
+	case strings.HasPrefix(rest, "in ") || strings.HasPrefix(rest, "in("):
+		req.operator, rest = labelOpIn, rest[2:]
+	case strings.HasPrefix(rest, "notin ") || strings.HasPrefix(rest, "notin("):
+		req.operator, rest = labelOpNotIn, rest[5:]
+	default:
+		return req, "", fmt.Errorf("unknown operator at %q", rest)
+	}
+	rest = strings.TrimSpace(rest)
+	// Set of values
+	if req.operator == labelOpIn || req.operator == labelOpNotIn {
+		end := strings.Index(rest, ")")
+		if !strings.HasPrefix(rest, "(") || end == -1 {
+			return req, "", fmt.Errorf("expected parenthesized values for %v", req.key)
+		}
+		for _, value := range strings.Split(rest[1:end], ",") {
+			value = strings.TrimSpace(value)
+			if err := validateLabelValue(value); err != nil {
+				return req, "", err
+			}
+			req.values = append(req.values, value)
+		}
+		return req, rest[end+1:], nil
+	}
+	// Single value
+	end = strings.IndexAny(rest, " \t,")
+	if end == -1 {
+		end = len(rest)
+	}
+	value := rest[:end]
+	if err := validateLabelValue(value); err != nil {
+		return req, "", err
+	}
+	req.values = []string{value}
+	return req, rest[end:], nil
+}
+
+// Empty returns true if the selector matches everything.
+func (l LabelSelector) Empty() bool { return len(l.requirements) == 0 }
+
+// Matches returns true if the labels meet every requirement of the selector.
+func (l LabelSelector) Matches(labels map[string]string) bool {
+	for _, req := range l.requirements {
+		value, ok := labels[req.key]
+		var matches bool
+		switch req.operator {
+		case labelOpEquals, labelOpIn:
+			matches = ok && req.hasValue(value)
+		case labelOpNotEquals, labelOpNotIn:
+			matches = !ok || !req.hasValue(value)
+		case labelOpExists:
+			matches = ok
+		case labelOpNotExists:
+			matches = !ok
+		}
+		if !matches {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *labelRequirement) hasValue(value string) bool {
+	for _, v := range l.values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package worker
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	tests := []struct {
+		selector   string
+		invalid    bool
+		matches    []map[string]string
+		nonMatches []map[string]string
+	}{
+		{
+			selector: "",
+			matches:  []map[string]string{nil, {"app": "foo"}},
+		},
+		{
+			selector:   "app=foo",
+			matches:    []map[string]string{{"app": "foo"}, {"app": "foo", "tier": "web"}},
+			nonMatches: []map[string]string{nil, {"app": "bar"}},
+		},
+		{
+			selector:   "app == foo",
+			matches:    []map[string]string{{"app": "foo"}},
+			nonMatches: []map[string]string{{"app": "bar"}},
+		},
+		{
+			selector:   "app!=foo",
+			matches:    []map[string]string{nil, {"app": "bar"}},
+			nonMatches: []map[string]string{{"app": "foo"}},
+		},
+		{
+			selector:   "app in (foo, bar)",
+			matches:    []map[string]string{{"app": "foo"}, {"app": "bar"}},
+			nonMatches: []map[string]string{nil, {"app": "baz"}},
+		},
+		{
+			selector:   "app notin(foo,bar)",
+			matches:    []map[string]string{nil, {"app": "baz"}},
+			nonMatches: []map[string]string{{"app": "foo"}, {"app": "bar"}},
+		},
+		{
+			selector:   "app",
+			matches:    []map[string]string{{"app": ""}, {"app": "foo"}},
+			nonMatches: []map[string]string{nil, {"tier": "web"}},
+		},
+		{
+			selector:   "!app",
+			matches:    []map[string]string{nil, {"tier": "web"}},
+			nonMatches: []map[string]string{{"app": ""}},
+		},
+		{
+			selector:   "example.com/app=foo",
+			matches:    []map[string]string{{"example.com/app": "foo"}},
+			nonMatches: []map[string]string{{"app": "foo"}},
+		},
+		{
+			selector: " app = foo , tier in (web) , !canary , env ",
+			matches:  []map[string]string{{"app": "foo", "tier": "web", "env": "prod"}},
+			nonMatches: []map[string]string{
+				{"app": "foo", "tier": "web"},
+				{"app": "foo", "tier": "db", "env": "prod"},
+				{"app": "foo", "tier": "web", "env": "prod", "canary": "true"},
+			},
+		},
+		{selector: ",", invalid: true},
+		{selector: "app=foo,", invalid: true},
+		{selector: "app=foo tier=web", invalid: true},
+		{selector: "=foo", invalid: true},
+		{selector: "app>foo", invalid: true},
+		{selector: "app in foo", invalid: true},
+		{selector: "app in (foo", invalid: true},
+		{selector: "app notin", invalid: true},
+		{selector: "app=-foo", invalid: true},
+		{selector: "app in (foo, b@r)", invalid: true},
+		{selector: "-app", invalid: true},
+		{selector: "Example.com/app", invalid: true},
+		{selector: "!app=foo", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.selector, func(t *testing.T) {
+			selector, err := ParseLabelSelector(test.selector)
+			if test.invalid {
+				if !errors.Is(err, ErrInvalidLabelSelector) {
+					t.Fatalf("expected invalid selector, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if selector.Empty() != (test.selector == "") {
+				t.Fatalf("unexpected empty %v", selector.Empty())
+			}
+			for _, labels := range test.matches {
+				if !selector.Matches(labels) {
+					t.Fatalf("expected match of %v", labels)
+				}
+			}
+			for _, labels := range test.nonMatches {
+				if selector.Matches(labels) {
+					t.Fatalf("expected no match of %v", labels)
+				}
+			}
+		})
+	}
+}
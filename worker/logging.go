@@ -0,0 +1,26 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+)
+
+// nopLogger is the logger used when none is configured.
+var nopLogger = slog.New(nopLogHandler{})
+
+type nopLogHandler struct{}
+
+func (nopLogHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (nopLogHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h nopLogHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h nopLogHandler) WithGroup(string) slog.Handler           { return h }
+
+// jobLogger returns the logger with the fields identifying the job.
+func jobLogger(logger *slog.Logger, j *Job) *slog.Logger {
+	return logger.With("namespace", j.Namespace, "job_id", j.ID)
+}
+
+// scheduleLogger returns the logger with the fields identifying the schedule.
+func scheduleLogger(logger *slog.Logger, s *Schedule) *slog.Logger {
+	return logger.With("namespace", s.Namespace, "schedule_id", s.ID)
+}
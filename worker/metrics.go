@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"fmt"
+	"time"
+)
+
+// Metrics receives measurements from a worker. Implementations must be safe for
+// concurrent use and must not block since they may be called while the worker
+// holds locks. Embed NopMetrics to only implement some methods.
+type Metrics interface {
+	// JobSubmitted is called when a job is accepted by the worker.
+	JobSubmitted(namespace string)
+	// JobCompleted is called when a job completes with how it completed and how
+	// long it ran from the start of its first attempt, which is 0 if it never
+	// started.
+	JobCompleted(namespace string, class JobExitClass, runDuration time.Duration)
+	// JobsRunning is called with the number of running jobs in the namespace
+	// when it changes. Jobs waiting to retry are not running.
+	JobsRunning(namespace string, count int)
+	// JobsQueued is called with the number of jobs in the namespace waiting for
+	// capacity when it changes.
+	JobsQueued(namespace string, count int)
+	// OutputCaptured is called with the number of bytes captured from stdout or
+	// stderr of a job.
+	OutputCaptured(namespace string, stderr bool, bytes int)
+	// JobStopped is called when a job completes after being requested to stop
+	// with the time since the first request.
+	JobStopped(namespace string, latency time.Duration)
+	// CGroupSetupFailed is called when cgroup limits could not be applied to a
+	// job attempt.
+	CGroupSetupFailed(namespace string)
+}
+
+// NopMetrics is a Metrics implementation that does nothing.
+type NopMetrics struct{}
+
+var _ Metrics = NopMetrics{}
+
+// JobSubmitted implements Metrics.JobSubmitted.
+func (NopMetrics) JobSubmitted(string) {}
+
+// JobCompleted implements Metrics.JobCompleted.
+func (NopMetrics) JobCompleted(string, JobExitClass, time.Duration) {}
+
+// JobsRunning implements Metrics.JobsRunning.
+func (NopMetrics) JobsRunning(string, int) {}
+
+// JobsQueued implements Metrics.JobsQueued.
+func (NopMetrics) JobsQueued(string, int) {}
+
+// OutputCaptured implements Metrics.OutputCaptured.
+func (NopMetrics) OutputCaptured(string, bool, int) {}
+
+// JobStopped implements Metrics.JobStopped.
+func (NopMetrics) JobStopped(string, time.Duration) {}
+
+// CGroupSetupFailed implements Metrics.CGroupSetupFailed.
+func (NopMetrics) CGroupSetupFailed(string) {}
+
+// JobExitClass is how a job completed.
+type JobExitClass int
+
+const (
+	// JobExitSuccess is a job whose last attempt exited with code 0.
+	JobExitSuccess JobExitClass = iota
+	// JobExitFailure is a job whose last attempt exited with a non-zero code.
+	JobExitFailure
+	// JobExitSignaled is a job whose last attempt was terminated by a signal.
+	JobExitSignaled
+	// JobExitStartError is a job that failed to start.
+	JobExitStartError
+	// JobExitCanceled is a job that was canceled before it started, including
+	// when its dependencies were not met.
+	JobExitCanceled
+)
+
+func (j JobExitClass) String() string {
+	switch j {
+	case JobExitSuccess:
+		return "success"
+	case JobExitFailure:
+		return "failure"
+	case JobExitSignaled:
+		return "signaled"
+	case JobExitStartError:
+		return "start_error"
+	case JobExitCanceled:
+		return "canceled"
+	}
+	return fmt.Sprintf("JobExitClass(%d)", int(j))
+}
+
+// exitClassLocked returns how the completed job completed. Caller must hold the
+// update lock.
+func (j *Job) exitClassLocked() JobExitClass {
+	switch {
+	case j.state == JobStateCanceled:
+		return JobExitCanceled
+	case j.startErr != nil || len(j.attempts) == 0:
+		return JobExitStartError
+	case j.attempts[len(j.attempts)-1].signal != "":
+		return JobExitSignaled
+	case *j.exitCode != 0:
+		return JobExitFailure
+	}
+	return JobExitSuccess
+}
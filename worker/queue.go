@@ -0,0 +1,276 @@
+package worker
+
+import (
+	"sync"
+)
+
+// jobQueue tracks running jobs and holds jobs that cannot start until others
+// complete. When a slot frees up, the namespace with the fewest running jobs
+// relative to its weight goes next, and within a namespace the highest priority
+// job that was queued first goes next. Namespaces at capacity are skipped so
+// they do not hold up other namespaces.
+type jobQueue struct {
+	// Maximum running jobs across all namespaces, or 0 for no maximum.
+	maxRunning int
+	// Namespace whose config, and with it the maximum running jobs and weight,
+	// the namespace shares.
+	configNamespace func(namespace string) string
+	// Config for the namespace.
+	namespaceConfig func(namespace string) *NamespaceConfig
+	// Whether running jobs can be preempted by higher priority queued jobs.
+	preemption bool
+	// Notified of running and queued counts when they change.
+	metrics Metrics
+
+	lock               sync.Mutex
+	closed             bool
+	running            map[*Job]struct{}
+	runningByNamespace map[string]int
+	// Keyed by config namespace
+	runningByConfig map[string]int
+	// Running jobs being stopped for preemption
+	preempting map[*Job]struct{}
+	// In submission order
+	queued []*Job
+}
+
+func newJobQueue(
+	maxRunning int,
+	configNamespace func(namespace string) string,
+	namespaceConfig func(namespace string) *NamespaceConfig,
+	preemption bool,
+	metrics Metrics,
+) *jobQueue {
+	return &jobQueue{
+		maxRunning:         maxRunning,
+		configNamespace:    configNamespace,
+		namespaceConfig:    namespaceConfig,
+		preemption:         preemption,
+		metrics:            metrics,
+		running:            map[*Job]struct{}{},
+		runningByNamespace: map[string]int{},
+		runningByConfig:    map[string]int{},
+		preempting:         map[*Job]struct{}{},
+	}
+}
+
+// reserveOrEnqueue reserves a running slot for the job and returns true if
+// there is capacity, otherwise it queues the job and returns false. If the job
+// is queued and preemption is enabled, a lower priority running job may be
+// gracefully stopped to make room for it.
+func (q *jobQueue) reserveOrEnqueue(j *Job) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	// Jobs already waiting in the namespace go first
+	if q.canRun(j.Namespace) && q.queuedInNamespace(j.Namespace) == 0 {
+		q.reserve(j)
+		return true
+	}
+	q.queued = append(q.queued, j)
+	q.metrics.JobsQueued(j.Namespace, q.queuedInNamespace(j.Namespace))
+	if q.preemption {
+		if victim := q.preemptionVictim(j); victim != nil {
+			victim.log.Info("Preempting job for queued job", "priority", victim.Priority,
+				"queued_job_namespace", j.Namespace, "queued_job_id", j.ID, "queued_job_priority", j.Priority)
+			q.preempting[victim] = struct{}{}
+			victim.preempt()
+		}
+	}
+	return false
+}
+
+// release frees the running slot of the job and returns queued jobs that now
+// have a reserved slot and should be started.
+func (q *jobQueue) release(j *Job) []*Job {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.running, j)
+	delete(q.preempting, j)
+	if q.runningByNamespace[j.Namespace]--; q.runningByNamespace[j.Namespace] <= 0 {
+		delete(q.runningByNamespace, j.Namespace)
+	}
+	configNamespace := q.configNamespace(j.Namespace)
+	if q.runningByConfig[configNamespace]--; q.runningByConfig[configNamespace] <= 0 {
+		delete(q.runningByConfig, configNamespace)
+	}
+	q.metrics.JobsRunning(j.Namespace, q.runningByNamespace[j.Namespace])
+	if q.closed {
+		return nil
+	}
+	var toStart []*Job
+	for {
+		next := q.next()
+		if next < 0 {
+			break
+		}
+		job := q.queued[next]
+		job.log.Debug("Starting queued job", "priority", job.Priority,
+			"namespace_running", q.runningByNamespace[job.Namespace], "namespace_weight", q.weight(job.Namespace))
+		q.queued = append(q.queued[:next], q.queued[next+1:]...)
+		q.metrics.JobsQueued(job.Namespace, q.queuedInNamespace(job.Namespace))
+		q.reserve(job)
+		toStart = append(toStart, job)
+	}
+	return toStart
+}
+
+// remove removes the job from the queue and returns true if it was queued.
+func (q *jobQueue) remove(j *Job) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for i, queued := range q.queued {
+		if queued == j {
+			q.queued = append(q.queued[:i], q.queued[i+1:]...)
+			q.metrics.JobsQueued(j.Namespace, q.queuedInNamespace(j.Namespace))
+			return true
+		}
+	}
+	return false
+}
+
+// close prevents any more queued jobs from starting and returns the jobs that
+// were queued.
+func (q *jobQueue) close() []*Job {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.closed = true
+	queued := q.queued
+	q.queued = nil
+	for _, j := range queued {
+		q.metrics.JobsQueued(j.Namespace, 0)
+	}
+	return queued
+}
+
+// queuePosition returns the 1-based position of the job among queued jobs in
+// its namespace in the order they will start, or 0 if it is not queued.
+func (q *jobQueue) queuePosition(j *Job) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	pos, found := 1, false
+	for _, queued := range q.queued {
+		if queued == j {
+			found = true
+		} else if queued.Namespace == j.Namespace && queued.Priority >= j.Priority &&
+			(queued.Priority > j.Priority || !found) {
+			pos++
+		}
+	}
+	if !found {
+		return 0
+	}
+	return pos
+}
+
+// next returns the index of the queued job that should start next, or -1 if
+// none can start. Caller must hold the lock.
+func (q *jobQueue) next() int {
+	best := -1
+	var bestShare float64
+	for i, queued := range q.queued {
+		if !q.canRun(queued.Namespace) {
+			continue
+		}
+		share := q.share(queued.Namespace)
+		switch {
+		case best < 0, share < bestShare:
+		case q.configNamespace(queued.Namespace) == q.configNamespace(q.queued[best].Namespace) &&
+			queued.Priority > q.queued[best].Priority:
+		default:
+			continue
+		}
+		best, bestShare = i, share
+	}
+	return best
+}
+
+// preemptionVictim returns the running job to preempt for the queued job, or
+// nil if none. Only jobs with lower priority that are not already being
+// preempted are considered. If the namespace is at capacity, the victim must be
+// in a namespace sharing its config. Otherwise the victim may also be in a
+// namespace with a larger share than the queued job's namespace, preferring the
+// lowest priority job in the namespace with the largest share. Caller must hold
+// the lock.
+func (q *jobQueue) preemptionVictim(j *Job) *Job {
+	// Only one preemption is outstanding per higher priority queued job
+	waiting := 0
+	for _, queued := range q.queued {
+		if queued.Priority >= j.Priority {
+			waiting++
+		}
+	}
+	if len(q.preempting) >= waiting {
+		return nil
+	}
+	namespaceFull := !q.canRunInNamespace(j.Namespace)
+	ownShare := q.share(j.Namespace)
+	var victim *Job
+	var victimShare float64
+	for running := range q.running {
+		if _, ok := q.preempting[running]; ok || running.Priority >= j.Priority {
+			continue
+		}
+		share := q.share(running.Namespace)
+		sameConfig := q.configNamespace(running.Namespace) == q.configNamespace(j.Namespace)
+		if !sameConfig && (namespaceFull || share <= ownShare) {
+			continue
+		}
+		switch {
+		case victim == nil, share > victimShare:
+		case share == victimShare && running.Priority < victim.Priority:
+		default:
+			continue
+		}
+		victim, victimShare = running, share
+	}
+	return victim
+}
+
+// queuedInNamespace returns the number of jobs queued in the namespace. Caller
+// must hold the lock.
+func (q *jobQueue) queuedInNamespace(namespace string) int {
+	count := 0
+	for _, queued := range q.queued {
+		if queued.Namespace == namespace {
+			count++
+		}
+	}
+	return count
+}
+
+// canRun returns whether there is capacity for a job in the namespace. Caller
+// must hold the lock.
+func (q *jobQueue) canRun(namespace string) bool {
+	if q.maxRunning > 0 && len(q.running) >= q.maxRunning {
+		return false
+	}
+	return q.canRunInNamespace(namespace)
+}
+
+// canRunInNamespace returns whether the namespaces sharing the namespace's
+// config are below its maximum. Caller must hold the lock.
+func (q *jobQueue) canRunInNamespace(namespace string) bool {
+	max := q.namespaceConfig(namespace).MaxConcurrentJobs
+	return max <= 0 || q.runningByConfig[q.configNamespace(namespace)] < max
+}
+
+// share returns the running jobs in the namespaces sharing the namespace's
+// config relative to its weight. Caller must hold the lock.
+func (q *jobQueue) share(namespace string) float64 {
+	return float64(q.runningByConfig[q.configNamespace(namespace)]) / float64(q.weight(namespace))
+}
+
+func (q *jobQueue) weight(namespace string) int {
+	if weight := q.namespaceConfig(namespace).Weight; weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// reserve takes a running slot for the job. Caller must hold the lock.
+func (q *jobQueue) reserve(j *Job) {
+	q.running[j] = struct{}{}
+	q.runningByNamespace[j.Namespace]++
+	q.runningByConfig[q.configNamespace(j.Namespace)]++
+	q.metrics.JobsRunning(j.Namespace, q.runningByNamespace[j.Namespace])
+}
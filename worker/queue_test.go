@@ -0,0 +1,221 @@
+package worker
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestJobQueue(maxRunning int, preemption bool, configs map[string]*NamespaceConfig) *jobQueue {
+	// Namespaces share the config of their top-level namespace
+	configNamespace := func(namespace string) string { return strings.SplitN(namespace, "/", 2)[0] }
+	return newJobQueue(maxRunning, configNamespace, func(namespace string) *NamespaceConfig {
+		if config := configs[configNamespace(namespace)]; config != nil {
+			return config
+		}
+		return &NamespaceConfig{}
+	}, preemption, NopMetrics{})
+}
+
+func newTestQueueJob(namespace, id string, priority int) *Job {
+	return newJob(namespace, id, JobSpec{Command: "true", Priority: priority})
+}
+
+func jobIDs(jobs []*Job) []string {
+	ids := make([]string, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.ID
+	}
+	return ids
+}
+
+func requireJobIDs(t *testing.T, expected []string, jobs []*Job) {
+	t.Helper()
+	actual := jobIDs(jobs)
+	if len(actual) != len(expected) {
+		t.Fatalf("expected jobs %v, got %v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("expected jobs %v, got %v", expected, actual)
+		}
+	}
+}
+
+func TestJobQueuePriorityOrder(t *testing.T) {
+	q := newTestJobQueue(1, false, nil)
+	running := newTestQueueJob("", "running", 0)
+	if !q.reserveOrEnqueue(running) {
+		t.Fatal("expected reservation")
+	}
+	low := newTestQueueJob("", "low", 0)
+	high1 := newTestQueueJob("", "high1", 5)
+	high2 := newTestQueueJob("", "high2", 5)
+	for _, job := range []*Job{low, high1, high2} {
+		if q.reserveOrEnqueue(job) {
+			t.Fatalf("expected %v queued", job.ID)
+		}
+	}
+	if pos := q.queuePosition(low); pos != 3 {
+		t.Fatalf("expected low at 3, got %v", pos)
+	}
+	if pos := q.queuePosition(high2); pos != 2 {
+		t.Fatalf("expected high2 at 2, got %v", pos)
+	}
+	// Highest priority first, then submission order
+	requireJobIDs(t, []string{"high1"}, q.release(running))
+	requireJobIDs(t, []string{"high2"}, q.release(high1))
+	requireJobIDs(t, []string{"low"}, q.release(high2))
+	requireJobIDs(t, nil, q.release(low))
+}
+
+func TestJobQueueFairShare(t *testing.T) {
+	q := newTestJobQueue(3, false, map[string]*NamespaceConfig{"heavy": {Weight: 2}})
+	var heavy, light []*Job
+	for i := 0; i < 4; i++ {
+		heavy = append(heavy, newTestQueueJob("heavy", "heavy"+string(rune('0'+i)), 0))
+		light = append(light, newTestQueueJob("light", "light"+string(rune('0'+i)), 0))
+	}
+	// Heavy fills the worker, then everything else queues
+	for i, job := range append(append([]*Job{}, heavy...), light...) {
+		if reserved := q.reserveOrEnqueue(job); reserved != (i < 3) {
+			t.Fatalf("unexpected reservation %v for %v", reserved, job.ID)
+		}
+	}
+	// Light has the lower share so it goes next even though heavy queued first
+	requireJobIDs(t, []string{"light0"}, q.release(heavy[0]))
+	// Heavy at 1/2 share and light at 1/1 share, so heavy goes next
+	requireJobIDs(t, []string{"heavy3"}, q.release(heavy[1]))
+	// Heavy at 1/2 share and light at 0/1 share, so light goes next
+	requireJobIDs(t, []string{"light1"}, q.release(light[0]))
+}
+
+func TestJobQueueNamespaceMaxDoesNotBlock(t *testing.T) {
+	q := newTestJobQueue(2, false, map[string]*NamespaceConfig{"capped": {MaxConcurrentJobs: 1}})
+	capped1 := newTestQueueJob("capped", "capped1", 0)
+	capped2 := newTestQueueJob("capped", "capped2", 0)
+	other := newTestQueueJob("other", "other", 0)
+	if !q.reserveOrEnqueue(capped1) {
+		t.Fatal("expected reservation")
+	}
+	if q.reserveOrEnqueue(capped2) {
+		t.Fatal("expected capped2 queued at namespace max")
+	}
+	if !q.reserveOrEnqueue(other) {
+		t.Fatal("expected other namespace to not wait on capped namespace")
+	}
+	requireJobIDs(t, nil, q.release(other))
+	requireJobIDs(t, []string{"capped2"}, q.release(capped1))
+}
+
+func TestJobQueueNamespaceMaxShared(t *testing.T) {
+	q := newTestJobQueue(3, false, map[string]*NamespaceConfig{"team1": {MaxConcurrentJobs: 1}})
+	child1 := newTestQueueJob("team1/a", "child1", 0)
+	child2 := newTestQueueJob("team1/b", "child2", 0)
+	if !q.reserveOrEnqueue(child1) {
+		t.Fatal("expected reservation")
+	}
+	if q.reserveOrEnqueue(child2) {
+		t.Fatal("expected sibling queued at shared max")
+	}
+	requireJobIDs(t, []string{"child2"}, q.release(child1))
+}
+
+func TestJobQueueRemoveAndClose(t *testing.T) {
+	q := newTestJobQueue(1, false, nil)
+	running := newTestQueueJob("", "running", 0)
+	queued1 := newTestQueueJob("", "queued1", 0)
+	queued2 := newTestQueueJob("", "queued2", 0)
+	q.reserveOrEnqueue(running)
+	q.reserveOrEnqueue(queued1)
+	q.reserveOrEnqueue(queued2)
+	if !q.remove(queued1) || q.remove(queued1) {
+		t.Fatal("expected removal once")
+	}
+	requireJobIDs(t, []string{"queued2"}, q.close())
+	requireJobIDs(t, nil, q.release(running))
+}
+
+func reserveAll(t *testing.T, q *jobQueue, jobs ...*Job) {
+	t.Helper()
+	for _, job := range jobs {
+		if !q.reserveOrEnqueue(job) {
+			t.Fatalf("expected %v reserved", job.ID)
+		}
+	}
+}
+
+func requirePreempting(t *testing.T, q *jobQueue, expected ...*Job) {
+	t.Helper()
+	if len(q.preempting) != len(expected) {
+		t.Fatalf("expected %v preempting, got %v", len(expected), len(q.preempting))
+	}
+	for _, job := range expected {
+		if _, ok := q.preempting[job]; !ok {
+			t.Fatalf("expected %v preempting", job.ID)
+		} else if job.stopCtx.Err() == nil {
+			t.Fatalf("expected %v stop requested", job.ID)
+		}
+	}
+}
+
+func TestJobQueuePreemptionInNamespace(t *testing.T) {
+	q := newTestJobQueue(2, true, nil)
+	low := newTestQueueJob("a", "low", 0)
+	lower := newTestQueueJob("a", "lower", -1)
+	reserveAll(t, q, low, lower)
+	// Only lower priority jobs are preempted
+	same := newTestQueueJob("a", "same", 0)
+	if q.reserveOrEnqueue(same) {
+		t.Fatal("expected queued")
+	}
+	requirePreempting(t, q, lower)
+	// No other lower priority job to preempt for another waiting job
+	another := newTestQueueJob("a", "another", 0)
+	if q.reserveOrEnqueue(another) {
+		t.Fatal("expected queued")
+	}
+	requirePreempting(t, q, lower)
+	requireJobIDs(t, []string{"same"}, q.release(lower))
+	requirePreempting(t, q)
+}
+
+func TestJobQueuePreemptionNamespaceFull(t *testing.T) {
+	q := newTestJobQueue(3, true, map[string]*NamespaceConfig{"capped": {MaxConcurrentJobs: 1}})
+	capped := newTestQueueJob("capped", "capped", 0)
+	other := newTestQueueJob("other", "other", -5)
+	reserveAll(t, q, capped, other)
+	// Namespace at its maximum only preempts within the namespace, even though
+	// the other job has lower priority
+	cappedHigh := newTestQueueJob("capped", "capped-high", 1)
+	if q.reserveOrEnqueue(cappedHigh) {
+		t.Fatal("expected queued")
+	}
+	requirePreempting(t, q, capped)
+	// Only one outstanding preemption per waiting job
+	if victim := q.preemptionVictim(cappedHigh); victim != nil {
+		t.Fatalf("expected no second victim, got %v", victim.ID)
+	}
+	requireJobIDs(t, []string{"capped-high"}, q.release(capped))
+}
+
+func TestJobQueuePreemptionLargestShare(t *testing.T) {
+	q := newTestJobQueue(3, true, nil)
+	a1 := newTestQueueJob("a", "a1", 0)
+	a2 := newTestQueueJob("a", "a2", -1)
+	b1 := newTestQueueJob("b", "b1", -2)
+	reserveAll(t, q, a1, a2, b1)
+	// Lowest priority job in the namespace with the largest share, not the
+	// lowest priority job overall
+	c1 := newTestQueueJob("c", "c1", 5)
+	if q.reserveOrEnqueue(c1) {
+		t.Fatal("expected queued")
+	}
+	requirePreempting(t, q, a2)
+	requireJobIDs(t, []string{"c1"}, q.release(a2))
+	// Namespaces with a share no larger than the queued job's are left alone
+	c2 := newTestQueueJob("c", "c2", 5)
+	if q.reserveOrEnqueue(c2) {
+		t.Fatal("expected queued")
+	}
+	requirePreempting(t, q)
+}
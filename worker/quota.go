@@ -0,0 +1,210 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrQuotaExceeded is wrapped by QuotaExceededError.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// NamespaceQuota limits what a namespace, with the namespaces sharing its
+// configuration, can use. Submissions that would go beyond it fail with a
+// QuotaExceededError. Zero values are unlimited.
+type NamespaceQuota struct {
+	// Maximum number of active jobs in the namespace, which are ones that have
+	// not completed including queued, pending, and retrying ones.
+	MaxActiveJobs int `json:"max_active_jobs,omitempty"`
+	// Maximum number of jobs retained in the namespace, including completed
+	// ones.
+	MaxRetainedJobs int `json:"max_retained_jobs,omitempty"`
+	// Maximum bytes of stdout and stderr retained for jobs in the namespace.
+	// Running jobs are not stopped when it is reached, but submissions fail until
+	// enough jobs are deleted or evicted.
+	MaxOutputBytes uint64 `json:"max_output_bytes,omitempty"`
+	// Maximum sum of the CPU limits, in cores, of jobs in the namespace that have
+	// not completed. The worker must have a job CPU limit to set this.
+	MaxCPU float64 `json:"max_cpu,omitempty"`
+	// Maximum sum of the memory limits of jobs in the namespace that have not
+	// completed. The worker must have a job memory limit to set this.
+	MaxMemoryBytes uint64 `json:"max_memory_bytes,omitempty"`
+}
+
+func (q *NamespaceQuota) validate(jobCPU float64, jobMemory uint64) error {
+	if q.MaxActiveJobs < 0 || q.MaxRetainedJobs < 0 || q.MaxCPU < 0 {
+		return fmt.Errorf("quota cannot be negative")
+	} else if q.MaxCPU > 0 && jobCPU == 0 {
+		return fmt.Errorf("max CPU quota requires a job CPU limit")
+	} else if q.MaxMemoryBytes > 0 && jobMemory == 0 {
+		return fmt.Errorf("max memory quota requires a job memory limit")
+	}
+	return nil
+}
+
+// NamespaceUsage is what a namespace is using of its quota.
+type NamespaceUsage struct {
+	// Jobs that have not completed, including queued, pending, and retrying
+	// ones.
+	ActiveJobs int
+	// All retained jobs.
+	RetainedJobs int
+	// Bytes of stdout and stderr of all retained jobs.
+	OutputBytes uint64
+	// Sum of the CPU limits, in cores, of active jobs.
+	CPU float64
+	// Sum of the memory limits of active jobs.
+	MemoryBytes uint64
+}
+
+// namespaceUsage is what the namespaces sharing a configuration are using of
+// its quota. It is updated as jobs are submitted, complete, and are removed so
+// checking the quota does not walk every job.
+type namespaceUsage struct {
+	lock         sync.Mutex
+	activeJobs   int
+	retainedJobs int
+	cpu          float64
+	memoryBytes  uint64
+	// Added to as output is captured, so not guarded by the lock
+	outputBytes atomic.Uint64
+}
+
+func (n *namespaceUsage) snapshot() *NamespaceUsage {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return &NamespaceUsage{
+		ActiveJobs:   n.activeJobs,
+		RetainedJobs: n.retainedJobs,
+		OutputBytes:  n.outputBytes.Load(),
+		CPU:          n.cpu,
+		MemoryBytes:  n.memoryBytes,
+	}
+}
+
+// complete releases the job's CPU and memory limits. This must only be called
+// once per job.
+func (n *namespaceUsage) complete(j *Job) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.activeJobs--
+	n.cpu -= j.cpuLimit
+	n.memoryBytes -= j.memoryLimit
+	// Avoid accumulating floating point error
+	if n.activeJobs == 0 {
+		n.cpu = 0
+	}
+}
+
+// remove releases the completed job and its output.
+func (n *namespaceUsage) remove(outputBytes uint64) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.retainedJobs--
+	if outputBytes > 0 {
+		n.outputBytes.Add(^(outputBytes - 1))
+	}
+}
+
+// QuotaExceededError is returned from submissions that would exceed the
+// namespace quota.
+type QuotaExceededError struct {
+	// Namespace whose configuration has the quota, which may be an ancestor of
+	// the submission's namespace.
+	Namespace string
+	// Quota field name exceeded, e.g. "max_active_jobs".
+	Quota string
+	// Value of the quota.
+	Limit float64
+	// Usage including the submission.
+	Usage float64
+}
+
+func (q *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%v: namespace %q would use %v of %v %v", ErrQuotaExceeded, q.Namespace, q.Usage, q.Limit, q.Quota)
+}
+
+func (q *QuotaExceededError) Unwrap() error { return ErrQuotaExceeded }
+
+// Quota returns the quota of the namespace.
+func (w *Worker) Quota(namespace string) NamespaceQuota {
+	return w.namespaceConfig(namespace).Quota
+}
+
+// Usage returns what the namespace, with the namespaces sharing its
+// configuration, is using of its quota. This returns ErrShutdown if the worker
+// is shutdown.
+func (w *Worker) Usage(namespace string) (*NamespaceUsage, error) {
+	w.shutdownLock.RLock()
+	defer w.shutdownLock.RUnlock()
+	if w.shutdown {
+		return nil, ErrShutdown
+	}
+	w.jobsLock.RLock()
+	defer w.jobsLock.RUnlock()
+	if usage := w.usage[w.configNamespace(namespace)]; usage != nil {
+		return usage.snapshot(), nil
+	}
+	return &NamespaceUsage{}, nil
+}
+
+// reserveUsageLocked adds the prepared jobs to the usage of the namespaces
+// sharing the namespace's configuration, or returns a QuotaExceededError if
+// that would exceed its quota. Caller must hold the jobs lock.
+func (w *Worker) reserveUsageLocked(namespace string, jobs []*Job) error {
+	namespace = w.configNamespace(namespace)
+	usage := w.usage[namespace]
+	if usage == nil {
+		usage = &namespaceUsage{}
+		w.usage[namespace] = usage
+	}
+	usage.lock.Lock()
+	defer usage.lock.Unlock()
+	// Check the usage with the jobs
+	activeJobs, retainedJobs := usage.activeJobs+len(jobs), usage.retainedJobs+len(jobs)
+	outputBytes, cpu, memoryBytes := usage.outputBytes.Load(), usage.cpu, usage.memoryBytes
+	for _, job := range jobs {
+		cpu += job.cpuLimit
+		memoryBytes += job.memoryLimit
+	}
+	quota := w.Quota(namespace)
+	exceeded := func(name string, limit, usage float64) error {
+		return &QuotaExceededError{Namespace: namespace, Quota: name, Limit: limit, Usage: usage}
+	}
+	switch {
+	case quota.MaxActiveJobs > 0 && activeJobs > quota.MaxActiveJobs:
+		return exceeded("max_active_jobs", float64(quota.MaxActiveJobs), float64(activeJobs))
+	case quota.MaxRetainedJobs > 0 && retainedJobs > quota.MaxRetainedJobs:
+		return exceeded("max_retained_jobs", float64(quota.MaxRetainedJobs), float64(retainedJobs))
+	case quota.MaxOutputBytes > 0 && outputBytes >= quota.MaxOutputBytes:
+		return exceeded("max_output_bytes", float64(quota.MaxOutputBytes), float64(outputBytes))
+	case quota.MaxCPU > 0 && cpu > quota.MaxCPU:
+		return exceeded("max_cpu", quota.MaxCPU, cpu)
+	case quota.MaxMemoryBytes > 0 && memoryBytes > quota.MaxMemoryBytes:
+		return exceeded("max_memory_bytes", float64(quota.MaxMemoryBytes), float64(memoryBytes))
+	}
+	usage.activeJobs, usage.retainedJobs, usage.cpu, usage.memoryBytes = activeJobs, retainedJobs, cpu, memoryBytes
+	for _, job := range jobs {
+		job.usage = usage
+	}
+	return nil
+}
+
+// releaseUsageLocked removes the job from the usage of its namespace, first
+// completing it if it never did. This is used when the job is removed or was
+// never submitted. Caller must hold the jobs lock.
+func (w *Worker) releaseUsageLocked(job *Job) {
+	if job.usage == nil {
+		return
+	}
+	if job.ExitCode() == nil {
+		job.usage.complete(job)
+	}
+	job.usage.remove(job.outputBytes())
+	// Drop the usage of namespaces without jobs
+	configNamespace := w.configNamespace(job.Namespace)
+	if usage := w.usage[configNamespace]; usage == job.usage && usage.snapshot().RetainedJobs == 0 {
+		delete(w.usage, configNamespace)
+	}
+}
@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestQuotaUsage(t *testing.T) {
+	w, err := New(Config{NamespaceDefaults: NamespaceConfig{Quota: NamespaceQuota{MaxActiveJobs: 2, MaxRetainedJobs: 3}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Shutdown(context.Background(), true)
+	expectUsage := func(namespace string, expected NamespaceUsage) {
+		t.Helper()
+		if usage, err := w.Usage(namespace); err != nil {
+			t.Fatal(err)
+		} else if *usage != expected {
+			t.Fatalf("expected usage %+v, got %+v", expected, *usage)
+		}
+	}
+	expectExceeded := func(err error, quota string) {
+		t.Helper()
+		var quotaErr *QuotaExceededError
+		if !errors.As(err, &quotaErr) || quotaErr.Namespace != "ns" || quotaErr.Quota != quota {
+			t.Fatalf("expected %v exceeded, got %v", quota, err)
+		}
+	}
+
+	// Children share the usage of their configuration's namespace
+	running, err := w.SubmitJob("ns", "running", "sleep", []string{"10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	completed, err := w.SubmitJob("ns/child", "completed", "echo", []string{"hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitDone(t, completed)
+	expectUsage("ns", NamespaceUsage{ActiveJobs: 1, RetainedJobs: 2, OutputBytes: 6})
+	expectUsage("ns/child", NamespaceUsage{ActiveJobs: 1, RetainedJobs: 2, OutputBytes: 6})
+	expectUsage("other", NamespaceUsage{})
+
+	// Active jobs are limited and failed submissions are not counted
+	queued, err := w.SubmitJob("ns", "queued", "sleep", []string{"10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.SubmitJob("ns", "", "true", nil)
+	expectExceeded(err, "max_active_jobs")
+	_, err = w.SubmitJob("ns", "running", "true", nil)
+	if err != ErrIDAlreadyExists {
+		t.Fatalf("expected ID already exists, got %v", err)
+	}
+	expectUsage("ns", NamespaceUsage{ActiveJobs: 2, RetainedJobs: 3, OutputBytes: 6})
+
+	// Completed jobs are still retained
+	for _, job := range []*Job{running, queued} {
+		if _, err := job.Stop(context.Background(), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectUsage("ns", NamespaceUsage{RetainedJobs: 3, OutputBytes: 6})
+	_, err = w.SubmitJob("ns", "", "true", nil)
+	expectExceeded(err, "max_retained_jobs")
+
+	// Until they are removed with their output
+	if err := w.DeleteJob("ns/child", "completed"); err != nil {
+		t.Fatal(err)
+	}
+	expectUsage("ns", NamespaceUsage{RetainedJobs: 2})
+	for _, id := range []string{"running", "queued"} {
+		if err := w.DeleteJob("ns", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectUsage("ns", NamespaceUsage{})
+	w.jobsLock.RLock()
+	defer w.jobsLock.RUnlock()
+	if len(w.usage) != 0 {
+		t.Fatalf("expected no usage, got %v", w.usage)
+	}
+}
@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrJobNotFound is returned from Worker.DeleteJob if the job does not exist.
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrJobNotCompleted is returned from Worker.DeleteJob if the job has not
+// completed.
+var ErrJobNotCompleted = errors.New("job not completed")
+
+// WithTTL is a submit job option to delete the job the given duration after it
+// completes. This takes precedence over
+// NamespaceConfig.CompletedJobRetention.
+func WithTTL(ttl time.Duration) SubmitJobOption {
+	return func(j *Job) { j.TTL = ttl }
+}
+
+// namespaceConfigJSON is NamespaceConfig with its durations as strings.
+type namespaceConfigJSON struct {
+	*namespaceConfigFields
+	CompletedJobRetention string `json:"completed_job_retention,omitempty"`
+}
+
+// namespaceConfigFields is NamespaceConfig without its JSON methods.
+type namespaceConfigFields NamespaceConfig
+
+// MarshalJSON implements json.Marshaler.
+func (n NamespaceConfig) MarshalJSON() ([]byte, error) {
+	fields := namespaceConfigFields(n)
+	raw := namespaceConfigJSON{namespaceConfigFields: &fields}
+	if n.CompletedJobRetention != 0 {
+		raw.CompletedJobRetention = n.CompletedJobRetention.String()
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Durations must be strings
+// accepted by time.ParseDuration.
+func (n *NamespaceConfig) UnmarshalJSON(b []byte) error {
+	raw := namespaceConfigJSON{namespaceConfigFields: (*namespaceConfigFields)(n)}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if raw.CompletedJobRetention != "" {
+		retention, err := time.ParseDuration(raw.CompletedJobRetention)
+		if err != nil {
+			return fmt.Errorf("invalid completed job retention: %w", err)
+		}
+		n.CompletedJobRetention = retention
+	}
+	return nil
+}
+
+// DeleteJob removes a completed job and its output. This returns
+// ErrJobNotFound if the job does not exist, ErrJobNotCompleted if it has not
+// completed, or ErrShutdown if the worker is shutdown.
+func (w *Worker) DeleteJob(namespace, id string) error {
+	w.shutdownLock.RLock()
+	defer w.shutdownLock.RUnlock()
+	if w.shutdown {
+		return ErrShutdown
+	}
+	w.jobsLock.Lock()
+	defer w.jobsLock.Unlock()
+	job := w.jobs[namespace][id]
+	if job == nil {
+		return ErrJobNotFound
+	} else if job.ExitCode() == nil {
+		return ErrJobNotCompleted
+	}
+	w.removeJobLocked(job)
+	return nil
+}
+
+// retainJob waits for the job to complete, then evicts the oldest completed
+// jobs in the namespace beyond the maximum and deletes the job when it expires.
+func (w *Worker) retainJob(job *Job) {
+	<-job.doneCtx.Done()
+	nsConfig := w.namespaceConfig(job.Namespace)
+	ttl := job.TTL
+	if ttl == 0 {
+		ttl = nsConfig.CompletedJobRetention
+	}
+	w.jobsLock.Lock()
+	defer w.jobsLock.Unlock()
+	// Do nothing if removed or shutdown in the meantime
+	if w.jobs[job.Namespace][job.ID] != job {
+		return
+	}
+	w.completedJobs[job.Namespace] = append(w.completedJobs[job.Namespace], job)
+	if nsConfig.MaxCompletedJobs > 0 {
+		for len(w.completedJobs[job.Namespace]) > nsConfig.MaxCompletedJobs {
+			w.removeJobLocked(w.completedJobs[job.Namespace][0])
+		}
+	}
+	if ttl > 0 {
+		time.AfterFunc(ttl, func() {
+			w.jobsLock.Lock()
+			defer w.jobsLock.Unlock()
+			if w.jobs[job.Namespace][job.ID] == job {
+				w.removeJobLocked(job)
+			}
+		})
+	}
+}
+
+// removeJobLocked removes the completed job. Caller must hold the jobs lock and
+// not the workflows lock.
+func (w *Worker) removeJobLocked(job *Job) {
+	delete(w.jobs[job.Namespace], job.ID)
+	completed := w.completedJobs[job.Namespace]
+	for i, completedJob := range completed {
+		if completedJob == job {
+			w.completedJobs[job.Namespace] = append(completed[:i], completed[i+1:]...)
+			break
+		}
+	}
+	if job.IdempotencyKey != "" {
+		w.idempotency.remove(job)
+	}
+	if job.WorkflowID != "" {
+		w.removeWorkflowJob(job)
+	}
+	// Release the output since others may still reference the job
+	w.releaseUsageLocked(job)
+	job.releaseOutput()
+	job.publishEvent(JobEventDeleted)
+}
@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRetentionPerNamespace(t *testing.T) {
+	w, err := New(Config{
+		Namespaces: map[string]NamespaceConfig{
+			"evicting": {MaxCompletedJobs: 2},
+			"expiring": {CompletedJobRetention: 50 * time.Millisecond},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Shutdown(context.Background(), true)
+	run := func(namespace string, opts ...SubmitJobOption) *Job {
+		t.Helper()
+		job, err := w.SubmitJob(namespace, "", "true", nil, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-job.doneCtx.Done()
+		return job
+	}
+	// Oldest completed jobs evicted beyond the maximum
+	var evicting []*Job
+	for i := 0; i < 3; i++ {
+		evicting = append(evicting, run("evicting"))
+	}
+	waitRemoved(t, w, evicting[0])
+	for _, job := range evicting[1:] {
+		if err := w.DeleteJob("evicting", job.ID); err != nil {
+			t.Fatalf("expected job retained, got %v", err)
+		}
+	}
+	// Completed jobs expire, unless their own TTL is longer
+	expiring := run("expiring")
+	withTTL := run("expiring", WithTTL(time.Hour))
+	waitRemoved(t, w, expiring)
+	if err := w.DeleteJob("expiring", withTTL.ID); err != nil {
+		t.Fatalf("expected job with TTL retained, got %v", err)
+	}
+	// Other namespaces use the defaults of retaining everything
+	var retained []*Job
+	for i := 0; i < 3; i++ {
+		retained = append(retained, run("other"))
+	}
+	time.Sleep(100 * time.Millisecond)
+	for _, job := range retained {
+		if err := w.DeleteJob("other", job.ID); err != nil {
+			t.Fatalf("expected job retained, got %v", err)
+		}
+	}
+}
+
+func TestRetentionNegative(t *testing.T) {
+	_, err := New(Config{Namespaces: map[string]NamespaceConfig{"ns": {MaxCompletedJobs: -1}}})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	_, err = New(Config{NamespaceDefaults: NamespaceConfig{CompletedJobRetention: -time.Second}})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestRetentionConfigJSON(t *testing.T) {
+	// Loaded the same as the server's namespace config file
+	var config struct {
+		Defaults   NamespaceConfig            `json:"defaults"`
+		Namespaces map[string]NamespaceConfig `json:"namespaces"`
+	}
+	err := json.Unmarshal([]byte(`{
+		"defaults": {"completed_job_retention": "1h30m", "max_completed_jobs": 10},
+		"namespaces": {"ns": {"max_concurrent_jobs": 2, "users": [{"name": "user1", "uid": 1000, "gid": 1000}]}}
+	}`), &config)
+	if err != nil {
+		t.Fatal(err)
+	} else if config.Defaults.CompletedJobRetention != 90*time.Minute || config.Defaults.MaxCompletedJobs != 10 {
+		t.Fatalf("unexpected defaults %+v", config.Defaults)
+	} else if ns := config.Namespaces["ns"]; ns.CompletedJobRetention != 0 || ns.MaxConcurrentJobs != 2 ||
+		len(ns.Users) != 1 || ns.Users[0].UID != 1000 {
+		t.Fatalf("unexpected namespace %+v", ns)
+	}
+	// Round trips as a string
+	b, err := json.Marshal(config.Defaults)
+	if err != nil {
+		t.Fatal(err)
+	} else if expected := `{"quota":{},"max_completed_jobs":10,"completed_job_retention":"1h30m0s"}`; string(b) != expected {
+		t.Fatalf("expected %s, got %s", expected, b)
+	}
+	// Numbers and invalid strings are rejected
+	for _, invalid := range []string{`{"completed_job_retention": 3600}`, `{"completed_job_retention": "1 hour"}`} {
+		var ns NamespaceConfig
+		if err := json.Unmarshal([]byte(invalid), &ns); err == nil {
+			t.Fatalf("expected %v to fail", invalid)
+		}
+	}
+}
+
+// waitRemoved waits for the job to be removed by retention.
+func waitRemoved(t *testing.T, w *Worker, job *Job) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if found, err := w.GetJob(job.Namespace, job.ID); err != nil {
+			t.Fatal(err)
+		} else if found == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %v not removed", job.ID)
+}
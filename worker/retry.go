@@ -0,0 +1,163 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ErrInvalidRetryPolicy is returned (wrapped) on submission if the retry policy
+// of the job is invalid.
+var ErrInvalidRetryPolicy = errors.New("invalid retry policy")
+
+// Defaults for unset retry policy backoff values.
+const (
+	DefaultRetryInitialBackoff    = time.Second
+	DefaultRetryMaxBackoff        = 5 * time.Minute
+	DefaultRetryBackoffMultiplier = 2.0
+)
+
+// RetryPolicy is how a job is retried when an attempt fails. Each attempt is
+// recorded on the same job with its own PID, output, and exit status. A job is
+// never retried after it is stopped or preempted.
+type RetryPolicy struct {
+	// Maximum number of attempts including the first. If 0 or 1, the job is not
+	// retried.
+	MaxAttempts int `json:"max_attempts"`
+	// Backoff before the second attempt. If 0, DefaultRetryInitialBackoff is
+	// used.
+	InitialBackoff time.Duration `json:"initial_backoff,omitempty"`
+	// Maximum backoff between attempts. If 0, DefaultRetryMaxBackoff is used.
+	MaxBackoff time.Duration `json:"max_backoff,omitempty"`
+	// Factor the backoff grows by each attempt, at least 1. If 0,
+	// DefaultRetryBackoffMultiplier is used.
+	BackoffMultiplier float64 `json:"backoff_multiplier,omitempty"`
+	// Fraction from 0 to 1 of each backoff that is randomly added or removed so
+	// retries of many jobs do not align. If 0, there is no jitter.
+	Jitter float64 `json:"jitter,omitempty"`
+	// Exit codes that are retried. If this and RetryableSignals are empty, any
+	// failed attempt is retried.
+	RetryableExitCodes []int `json:"retryable_exit_codes,omitempty"`
+	// Signals that terminate the job that are retried, e.g. "SIGKILL". Signal
+	// names are case insensitive, the "SIG" prefix is optional, and they can
+	// be numbers. They are normalized on submission.
+	RetryableSignals []string `json:"retryable_signals,omitempty"`
+}
+
+// WithRetryPolicy is a submit job option to retry the job when an attempt
+// fails.
+func WithRetryPolicy(policy RetryPolicy) SubmitJobOption {
+	return func(j *Job) { j.RetryPolicy = &policy }
+}
+
+// prepare validates the policy and applies defaults.
+func (r *RetryPolicy) prepare() error {
+	switch {
+	case r.MaxAttempts < 0:
+		return fmt.Errorf("%w: max attempts cannot be negative", ErrInvalidRetryPolicy)
+	case r.InitialBackoff < 0 || r.MaxBackoff < 0:
+		return fmt.Errorf("%w: backoff cannot be negative", ErrInvalidRetryPolicy)
+	case r.BackoffMultiplier != 0 && r.BackoffMultiplier < 1:
+		return fmt.Errorf("%w: backoff multiplier must be at least 1", ErrInvalidRetryPolicy)
+	case r.Jitter < 0 || r.Jitter > 1:
+		return fmt.Errorf("%w: jitter must be between 0 and 1", ErrInvalidRetryPolicy)
+	}
+	if r.InitialBackoff == 0 {
+		r.InitialBackoff = DefaultRetryInitialBackoff
+	}
+	if r.MaxBackoff == 0 {
+		r.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	if r.InitialBackoff > r.MaxBackoff {
+		return fmt.Errorf("%w: initial backoff above max backoff", ErrInvalidRetryPolicy)
+	}
+	if r.BackoffMultiplier == 0 {
+		r.BackoffMultiplier = DefaultRetryBackoffMultiplier
+	}
+	// Copy since we normalize the signals
+	signals := r.RetryableSignals
+	r.RetryableSignals = make([]string, len(signals))
+	for i, name := range signals {
+		var err error
+		if r.RetryableSignals[i], err = parseSignal(name); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRetryPolicy, err)
+		}
+	}
+	return nil
+}
+
+// retryable returns true if a completed attempt can be retried by the policy.
+func (r *RetryPolicy) retryable(attempt *JobAttempt) bool {
+	exitCode, signal := *attempt.ExitCode(), attempt.Signal()
+	if attempt.Number >= r.MaxAttempts || (exitCode == 0 && signal == "") {
+		return false
+	} else if len(r.RetryableExitCodes) == 0 && len(r.RetryableSignals) == 0 {
+		return true
+	}
+	for _, code := range r.RetryableExitCodes {
+		if code == exitCode && signal == "" {
+			return true
+		}
+	}
+	for _, retryableSignal := range r.RetryableSignals {
+		if retryableSignal == signal {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns how long to wait after the given attempt number before the
+// next attempt.
+func (r *RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := float64(r.InitialBackoff) * math.Pow(r.BackoffMultiplier, float64(attempt-1))
+	if backoff > float64(r.MaxBackoff) {
+		backoff = float64(r.MaxBackoff)
+	}
+	backoff += backoff * r.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(backoff)
+}
+
+// completeAttempt completes the job with the result of the attempt unless its
+// retry policy retries it.
+func (w *Worker) completeAttempt(job *Job, attempt *JobAttempt) {
+	stopped := job.stopCtx.Err() != nil || job.forceStopCtx.Err() != nil
+	if job.RetryPolicy == nil || stopped || !job.RetryPolicy.retryable(attempt) {
+		job.markDone(*attempt.ExitCode())
+		return
+	}
+	backoff := job.RetryPolicy.backoff(attempt.Number)
+	job.log.Info("Retrying job after failed attempt", "backoff", backoff, "attempt", attempt.Number,
+		"exit_code", *attempt.ExitCode())
+	job.setState(JobStateRetrying)
+	go w.retryJob(job, attempt, backoff)
+}
+
+// retryJob waits out the backoff then launches the next attempt of the job.
+func (w *Worker) retryJob(job *Job, last *JobAttempt, backoff time.Duration) {
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-job.stopCtx.Done():
+		job.markDone(*last.ExitCode())
+		return
+	case <-job.forceStopCtx.Done():
+		job.markDone(*last.ExitCode())
+		return
+	}
+	// Do not launch if shutdown in the meantime
+	w.shutdownLock.RLock()
+	defer w.shutdownLock.RUnlock()
+	if w.shutdown {
+		job.markDone(*last.ExitCode())
+		return
+	}
+	job.setState(JobStateQueued)
+	if err := w.launchJob(job); err != nil {
+		job.log.Error("Failed retrying job", "error", err)
+		job.markDoneWithState(JobStateCompleted, -1, err)
+	}
+}
@@ -0,0 +1,195 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyPrepare(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, RetryableSignals: []string{"kill", "15", "SIGINT"}}
+	if err := policy.prepare(); err != nil {
+		t.Fatal(err)
+	} else if policy.InitialBackoff != DefaultRetryInitialBackoff || policy.MaxBackoff != DefaultRetryMaxBackoff ||
+		policy.BackoffMultiplier != DefaultRetryBackoffMultiplier {
+		t.Fatalf("expected defaults, got %+v", policy)
+	} else if strings.Join(policy.RetryableSignals, ",") != "SIGKILL,SIGTERM,SIGINT" {
+		t.Fatalf("expected normalized signals, got %v", policy.RetryableSignals)
+	}
+	for _, invalid := range []RetryPolicy{
+		{MaxAttempts: -1},
+		{InitialBackoff: -time.Second},
+		{InitialBackoff: time.Minute, MaxBackoff: time.Second},
+		{BackoffMultiplier: 0.5},
+		{Jitter: 1.5},
+		{RetryableSignals: []string{"SIGNOTREAL"}},
+	} {
+		if err := invalid.prepare(); !errors.Is(err, ErrInvalidRetryPolicy) {
+			t.Fatalf("expected %+v invalid, got %v", invalid, err)
+		}
+	}
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	job := newJob("", "job1", JobSpec{Command: "true"})
+	attempt := func(number, exitCode int, signal string) *JobAttempt {
+		return &JobAttempt{Number: number, job: job, exitCode: &exitCode, signal: signal}
+	}
+	anyFailure := RetryPolicy{MaxAttempts: 3}
+	exitCodes := RetryPolicy{MaxAttempts: 3, RetryableExitCodes: []int{2, 3}}
+	signals := RetryPolicy{MaxAttempts: 3, RetryableSignals: []string{"SIGKILL"}}
+	tests := []struct {
+		name      string
+		policy    RetryPolicy
+		attempt   *JobAttempt
+		retryable bool
+	}{
+		{"success", anyFailure, attempt(1, 0, ""), false},
+		{"any failure", anyFailure, attempt(1, 1, ""), true},
+		{"any signal", anyFailure, attempt(2, -1, "SIGTERM"), true},
+		{"max attempts", anyFailure, attempt(3, 1, ""), false},
+		{"retryable exit code", exitCodes, attempt(1, 3, ""), true},
+		{"other exit code", exitCodes, attempt(1, 1, ""), false},
+		{"signal with exit code list", exitCodes, attempt(1, -1, "SIGKILL"), false},
+		{"retryable signal", signals, attempt(1, -1, "SIGKILL"), true},
+		{"other signal", signals, attempt(1, -1, "SIGTERM"), false},
+		{"exit code with signal list", signals, attempt(1, 1, ""), false},
+	}
+	for _, test := range tests {
+		if retryable := test.policy.retryable(test.attempt); retryable != test.retryable {
+			t.Fatalf("%v: expected retryable %v, got %v", test.name, test.retryable, retryable)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, BackoffMultiplier: 2}
+	for attempt, expected := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		if backoff := policy.backoff(attempt + 1); backoff != expected*time.Millisecond {
+			t.Fatalf("expected backoff %v after attempt %v, got %v", expected, attempt+1, backoff)
+		}
+	}
+	// Jitter stays within its fraction of the backoff, including at the max
+	policy.Jitter = 0.5
+	for attempt, base := range map[int]time.Duration{1: policy.InitialBackoff, 10: policy.MaxBackoff} {
+		distinct := map[time.Duration]bool{}
+		for i := 0; i < 1000; i++ {
+			backoff := policy.backoff(attempt)
+			if backoff < base/2 || backoff > base*3/2 {
+				t.Fatalf("backoff %v outside jitter of %v", backoff, base)
+			}
+			distinct[backoff] = true
+		}
+		if len(distinct) < 10 {
+			t.Fatalf("expected jittered backoffs, got %v", distinct)
+		}
+	}
+}
+
+func TestRetryJob(t *testing.T) {
+	w, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Shutdown(context.Background(), true)
+	run := func(script string, policy RetryPolicy) *Job {
+		t.Helper()
+		policy.InitialBackoff = 10 * time.Millisecond
+		job, err := w.SubmitJob("", "", "sh", []string{"-c", script}, WithRetryPolicy(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitDone(t, job)
+		return job
+	}
+
+	// Each attempt has its own process, output and status
+	job := run("echo pid $$; echo err >&2; exit 3", RetryPolicy{MaxAttempts: 3, RetryableExitCodes: []int{3}})
+	attempts := job.Attempts()
+	if len(attempts) != 3 || job.State() != JobStateCompleted || *job.ExitCode() != 3 {
+		t.Fatalf("expected 3 failed attempts, got %v in state %v", len(attempts), job.State())
+	}
+	pids := map[int]bool{}
+	for i, attempt := range attempts {
+		b := make([]byte, 100)
+		n, _, exitCode, err := attempt.ReadStdout(b, 0)
+		if err != nil {
+			t.Fatal(err)
+		} else if expected := "pid " + strconv.Itoa(attempt.PID()) + "\n"; string(b[:n]) != expected {
+			t.Fatalf("expected attempt %v output %q, got %q", i+1, expected, b[:n])
+		} else if _, total, _, _ := attempt.ReadStderr(nil, 0); total != 4 {
+			t.Fatalf("expected attempt stderr, got %v bytes", total)
+		} else if attempt.Number != i+1 || *exitCode != 3 || attempt.Signal() != "" || attempt.EndedAt().IsZero() {
+			t.Fatalf("unexpected attempt %v status", i+1)
+		} else if i > 0 && attempt.StartedAt.Before(attempts[i-1].EndedAt()) {
+			t.Fatalf("expected attempt %v to start after the last ended", i+1)
+		}
+		pids[attempt.PID()] = true
+	}
+	if len(pids) != 3 || job.PID() != attempts[2].PID() {
+		t.Fatalf("expected distinct PIDs with the job's the latest, got %v", pids)
+	}
+
+	// Only matching exit codes or signals are retried
+	if job := run("exit 1", RetryPolicy{MaxAttempts: 3, RetryableExitCodes: []int{3}}); len(job.Attempts()) != 1 {
+		t.Fatalf("expected no retry of other exit code, got %v attempts", len(job.Attempts()))
+	}
+	job = run("kill -KILL $$", RetryPolicy{MaxAttempts: 3, RetryableExitCodes: []int{137}})
+	if len(job.Attempts()) != 1 {
+		t.Fatalf("expected no retry of signal by exit code, got %v attempts", len(job.Attempts()))
+	}
+	job = run("kill -KILL $$", RetryPolicy{MaxAttempts: 2, RetryableSignals: []string{"KILL"}})
+	if attempts := job.Attempts(); len(attempts) != 2 || attempts[1].Signal() != "SIGKILL" {
+		t.Fatalf("expected retry of signal, got %v attempts", len(attempts))
+	}
+	// Success is never retried
+	if job := run("true", RetryPolicy{MaxAttempts: 3}); len(job.Attempts()) != 1 || *job.ExitCode() != 0 {
+		t.Fatalf("expected single successful attempt, got %v", len(job.Attempts()))
+	}
+}
+
+func TestRetryNotAfterStop(t *testing.T) {
+	w, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Shutdown(context.Background(), true)
+
+	// Stopping a running attempt does not retry it
+	job, err := w.SubmitJob("", "", "sleep", []string{"10"},
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := job.Stop(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if attempts := job.Attempts(); len(attempts) != 1 || attempts[0].Signal() != "SIGKILL" {
+		t.Fatalf("expected single killed attempt, got %v", len(attempts))
+	} else if job.State() != JobStateCompleted {
+		t.Fatalf("expected completed, got %v", job.State())
+	}
+
+	// Stopping during the backoff completes with the last attempt's exit code
+	job, err = w.SubmitJob("", "", "false", nil,
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); job.State() != JobStateRetrying; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for retrying, state %v", job.State())
+		} else if job.ExitCode() != nil {
+			t.Fatal("expected job not done while retrying")
+		}
+	}
+	if code, err := job.Stop(context.Background(), false); err != nil || code != 1 {
+		t.Fatalf("expected exit code 1, got %v, %v", code, err)
+	} else if len(job.Attempts()) != 1 {
+		t.Fatalf("expected single attempt, got %v", len(job.Attempts()))
+	}
+}
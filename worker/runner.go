@@ -1,10 +1,15 @@
 package worker
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"os"
 	"os/exec"
+	"sort"
+	"strings"
 	"syscall"
 )
 
@@ -14,6 +19,12 @@ type JobLimitConfig struct {
 	ResourceLimits JobResourceLimits
 	// Namespace isolation per job.
 	Isolation JobIsolation
+	// Privilege restrictions per job.
+	Security JobSecurity
+	// Maximum POSIX resource limits per job. These are also the defaults for
+	// jobs that do not set their own. Jobs cannot set a limit above the hard
+	// limit here.
+	RLimits JobRLimits
 }
 
 // JobResourceLimits represent per-job resource limits.
@@ -28,32 +39,295 @@ type JobResourceLimits struct {
 	// "major:minor" of the device, or empty string to default to the same device
 	// this worker executable is running on.
 	DeviceIOMax map[string]uint64 `json:"device_io_max,omitempty"`
+	// CPUs the job can run on in cpuset list format (e.g. "0-3,8"). If empty,
+	// the job can run on any CPU.
+	CPUSet string `json:"cpu_set,omitempty"`
+	// NUMA memory nodes the job can allocate memory on in cpuset list format. If
+	// empty, the job can use any memory node.
+	MemoryNodes string `json:"memory_nodes,omitempty"`
+	// Relative CPU weight from 1 to 10000 used to share contended CPU among
+	// jobs. The default for jobs and other processes is 100. This is converted
+	// to CPU shares on cgroup v1.
+	CPUWeight uint64 `json:"cpu_weight,omitempty"`
+	// Amount of bytes above which memory use is throttled and reclaimed before
+	// reaching the max. This is the soft limit on cgroup v1.
+	MemoryHigh uint64 `json:"memory_high,omitempty"`
+	// Maximum CPU weight jobs can request in their JobResources. If 0, jobs
+	// cannot request a weight above CPUWeight, or the default of 100 if that is
+	// also 0.
+	MaxCPUWeight uint64 `json:"max_cpu_weight,omitempty"`
 }
 
+// JobResources are per-job resource settings within the worker's
+// JobResourceLimits. Zero values use the worker's settings.
+type JobResources struct {
+	// CPUs the job can run on in cpuset list format (e.g. "0-3,8"). This must be
+	// within the worker's CPU set if it has one.
+	CPUSet string `json:"cpu_set,omitempty"`
+	// NUMA memory nodes the job can allocate memory on in cpuset list format.
+	// This must be within the worker's memory nodes if it has them.
+	MemoryNodes string `json:"memory_nodes,omitempty"`
+	// Relative CPU weight from 1 to 10000. This cannot be above the worker's
+	// MaxCPUWeight.
+	CPUWeight uint64 `json:"cpu_weight,omitempty"`
+	// Amount of bytes above which memory use is throttled. This cannot be above
+	// the worker's memory high, or its memory max if it has no memory high.
+	MemoryHigh uint64 `json:"memory_high,omitempty"`
+}
+
+// ErrInvalidResources is returned (wrapped) from Worker.SubmitJob if the
+// requested job resources are invalid or beyond the worker's limits.
+var ErrInvalidResources = errors.New("invalid resources")
+
 // JobIsolation represents namespaces that should be isolated per job.
 type JobIsolation struct {
 	PID bool
 	// TODO(cretz): Options for adding network interfaces?
 	Network bool
 	Mount   bool
+	// If true, jobs share the worker's IPC namespace instead of each having
+	// their own, so cooperating jobs can use shared memory.
+	SharedIPC bool
+}
+
+// JobSecurity represents privilege restrictions per job.
+type JobSecurity struct {
+	// Linux capabilities of the job.
+	Capabilities JobCapabilities
+	// If true, PR_SET_NO_NEW_PRIVS is set for the job so it can never gain
+	// privileges (e.g. via setuid binaries or file capabilities).
+	NoNewPrivileges bool
+	// If non-nil, the user ID inside the container to run the job as. It is
+	// mapped to the job's host user if any, or the same ID on the host
+	// otherwise. If nil, the job runs as its host user's ID inside the container
+	// if it has a host user, or container root otherwise.
+	UID *uint32
+	// If non-nil, the group ID inside the container to run the job as. This
+	// is mapped the same way as UID.
+	GID *uint32
+}
+
+// JobCapabilities represents the Linux capability sets of a job. Capability
+// names are as documented in capabilities(7), e.g. "CAP_CHOWN". The "CAP_"
+// prefix is optional and names are case insensitive.
+type JobCapabilities struct {
+	// Capabilities to keep in the bounding set, all others are dropped. If nil,
+	// DefaultCapabilities is used. If empty, all capabilities are dropped.
+	Bounding []string
+	// If non-nil, the job process has exactly these capabilities (plus ambient
+	// ones) even when running as container root. Otherwise, the job process
+	// has the full bounding set when running as container root or only the
+	// ambient set when not. This must be a subset of the bounding set.
+	Effective []string
+	// Capabilities to raise in the ambient set, which are retained across exec
+	// even for non-root users. This must be a subset of the bounding set.
+	Ambient []string
+}
+
+// DefaultCapabilities is the conservative set of capabilities kept in the
+// bounding set when JobCapabilities.Bounding is nil.
+var DefaultCapabilities = []string{
+	"CAP_AUDIT_WRITE",
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_KILL",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SETFCAP",
+	"CAP_SETGID",
+	"CAP_SETPCAP",
+	"CAP_SETUID",
+	"CAP_SYS_CHROOT",
+}
+
+// RLimitInfinity is the value for an unlimited resource limit.
+const RLimitInfinity = ^uint64(0)
+
+// JobRLimits represent POSIX resource limits per job. Nil limits are inherited
+// from the worker.
+type JobRLimits struct {
+	// Maximum number of open file descriptors (RLIMIT_NOFILE).
+	NoFile *JobRLimit `json:"nofile,omitempty"`
+	// Maximum core dump size in bytes (RLIMIT_CORE).
+	Core *JobRLimit `json:"core,omitempty"`
+	// Maximum size in bytes of files written (RLIMIT_FSIZE).
+	FileSize *JobRLimit `json:"fsize,omitempty"`
+	// Maximum stack size in bytes (RLIMIT_STACK).
+	Stack *JobRLimit `json:"stack,omitempty"`
+}
+
+// JobRLimit is a soft and hard value for a POSIX resource limit. Either can be
+// RLimitInfinity.
+type JobRLimit struct {
+	Soft uint64 `json:"soft"`
+	Hard uint64 `json:"hard"`
+}
+
+// ErrInvalidRLimits is returned (wrapped) from Worker.SubmitJob if the
+// requested resource limits are invalid or exceed the maximums.
+var ErrInvalidRLimits = errors.New("invalid rlimits")
+
+func (j *JobRLimits) isEmpty() bool {
+	return j.NoFile == nil && j.Core == nil && j.FileSize == nil && j.Stack == nil
+}
+
+// each calls fn with the name and pointer to each limit.
+func (j *JobRLimits) each(fn func(name string, limit **JobRLimit)) {
+	fn("nofile", &j.NoFile)
+	fn("core", &j.Core)
+	fn("fsize", &j.FileSize)
+	fn("stack", &j.Stack)
+}
+
+// validate checks that every soft limit is at most its hard limit.
+func (j *JobRLimits) validate() (err error) {
+	j.each(func(name string, limit **JobRLimit) {
+		if err == nil && *limit != nil && (*limit).Soft > (*limit).Hard {
+			err = fmt.Errorf("%w: %v soft limit %v above hard limit %v", ErrInvalidRLimits, name, (*limit).Soft, (*limit).Hard)
+		}
+	})
+	return
+}
+
+// applyMax validates these limits against the given maximums and sets unset
+// limits to the maximum. Limits are copied so the job never shares them with
+// the maximums or the caller.
+func (j *JobRLimits) applyMax(max *JobRLimits) (err error) {
+	if err := j.validate(); err != nil {
+		return err
+	}
+	maxes := map[string]*JobRLimit{}
+	max.each(func(name string, limit **JobRLimit) { maxes[name] = *limit })
+	j.each(func(name string, limit **JobRLimit) {
+		max := maxes[name]
+		switch {
+		case err != nil:
+		case *limit == nil && max != nil:
+			copied := *max
+			*limit = &copied
+		case *limit == nil:
+		case max != nil && (*limit).Soft > max.Hard:
+			err = fmt.Errorf("%w: %v soft limit %v above maximum %v", ErrInvalidRLimits, name, (*limit).Soft, max.Hard)
+		case max != nil && (*limit).Hard > max.Hard:
+			err = fmt.Errorf("%w: %v hard limit %v above maximum %v", ErrInvalidRLimits, name, (*limit).Hard, max.Hard)
+		default:
+			copied := **limit
+			*limit = &copied
+		}
+	})
+	return
 }
 
 type runner interface {
-	// Guaranteed to have PID set on complete. The job is not used by any other
-	// goroutines until this returns.
-	start(*Job) error
+	// Validates the job and sets any runner-specific job fields. The job is not
+	// used by any other goroutines until this returns.
+	prepare(*Job) error
+	// Guaranteed to have the job marked started on success. The job may be
+	// used by other goroutines while this runs. The context is only used for
+	// tracing. If the returned channel is not nil, spans are still being
+	// recorded under the context's span until it is closed.
+	start(context.Context, *Job) (setupDone <-chan struct{}, err error)
 }
 
-type execRunner struct{}
+type execRunner struct {
+	// If true, exit codes above 128 are reported as the signal of that number
+	// less 128 since the job is run under a child process that exits that way.
+	signalExitCodes bool
+}
 
 func newRunner() *execRunner { return &execRunner{} }
 
-func (e *execRunner) start(j *Job) error {
+func (e *execRunner) prepare(j *Job) error {
 	// Cannot have root when calling exec runner direct
 	if j.RootFS != "" {
 		return fmt.Errorf("cannot have job root in non-limited runner")
 	}
-	return e.startCmd(j, exec.Command(j.Command, j.Args...))
+	return nil
+}
+
+func (e *execRunner) start(ctx context.Context, j *Job) (<-chan struct{}, error) {
+	cmd := exec.Command(j.Command, j.Args...)
+	env := j.environ(ctx)
+	// Resource limits are applied by re-executing ourselves to set them right
+	// before exec'ing the command. The job's environment is only given to the
+	// command, not to us.
+	if !j.RLimits.isEmpty() {
+		envR, err := envPipe(env)
+		if err != nil {
+			return nil, err
+		}
+		defer envR.Close()
+		if cmd, err = rlimitCmd(j, envR); err != nil {
+			return nil, err
+		}
+	} else {
+		cmd.Env = applyEnviron(env)
+	}
+	if j.hostUser != nil {
+		if err := setCmdCredential(cmd, j.hostUser); err != nil {
+			return nil, err
+		}
+	}
+	return nil, e.startCmd(j, cmd)
+}
+
+// environ returns the environment variables of the job with the trace context
+// of the span in the context added if the job injects it.
+func (j *Job) environ(ctx context.Context) map[string]string {
+	if _, set := j.Env[TraceParentEnv]; j.injectTraceParent && !set {
+		if value := traceParent(ctx); value != "" {
+			env := make(map[string]string, len(j.Env)+1)
+			for name, value := range j.Env {
+				env[name] = value
+			}
+			env[TraceParentEnv] = value
+			return env
+		}
+	}
+	return j.Env
+}
+
+// envPipe returns the read side of a pipe the environment variables are written
+// to as JSON. This is used to give them to a child we re-execute without them
+// being in its args, which are readable by anyone. The write side is closed
+// once written or once the read side is closed by both us and the child.
+func envPipe(env map[string]string) (*os.File, error) {
+	b, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("creating environment pipe: %w", err)
+	}
+	// Written in the background since it may not fit in the pipe buffer
+	go func() {
+		defer w.Close()
+		w.Write(b)
+	}()
+	return r, nil
+}
+
+// applyEnviron returns our environment with the given variables added or
+// replaced.
+func applyEnviron(vars map[string]string) []string {
+	env := make([]string, 0, len(vars))
+	for _, kv := range os.Environ() {
+		// Omit the ones that are replaced
+		if _, replaced := vars[strings.SplitN(kv, "=", 2)[0]]; !replaced {
+			env = append(env, kv)
+		}
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, name+"="+vars[name])
+	}
+	return env
 }
 
 func (e *execRunner) startCmd(j *Job, cmd *exec.Cmd) error {
@@ -70,10 +344,10 @@ func (e *execRunner) startCmd(j *Job, cmd *exec.Cmd) error {
 	if err := cmd.Start(); err != nil {
 		return err
 	}
-	j.PID = cmd.Process.Pid
+	attempt := j.markStarted(cmd.Process.Pid)
 	// Start pipes
-	stdoutCh := startPipe(j, false /* stderr */, stdout)
-	stderrCh := startPipe(j, true /* stderr */, stderr)
+	stdoutCh := startPipe(attempt, false /* stderr */, stdout)
+	stderrCh := startPipe(attempt, true /* stderr */, stderr)
 	// Asynchronously wait for completion
 	go func() {
 		// Wait for pipes to be complete before waiting for command to be complete.
@@ -82,15 +356,21 @@ func (e *execRunner) startCmd(j *Job, cmd *exec.Cmd) error {
 		<-stderrCh
 		// Now wait on command completion
 		var exitCode int
+		var signal string
 		err := cmd.Wait()
 		if exitErr, _ := err.(*exec.ExitError); exitErr != nil {
 			exitCode = exitErr.ExitCode()
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				signal = signalName(status.Signal())
+			} else if e.signalExitCodes && exitCode > 128 {
+				signal = signalName(syscall.Signal(exitCode - 128))
+			}
 		} else if err != nil {
-			log.Printf("Child execution on job %v:%v failed without exit code: %v", j.Namespace, j.ID, err)
+			j.log.Error("Child execution failed without exit code", "pid", cmd.Process.Pid, "error", err)
 			exitCode = -1
 		}
 		// Mark done
-		j.markDone(exitCode)
+		j.markAttemptDone(attempt, exitCode, signal)
 	}()
 	// Asynchronously listen for stop requests
 	go func() {
@@ -100,7 +380,7 @@ func (e *execRunner) startCmd(j *Job, cmd *exec.Cmd) error {
 		// there's no use listening for done anymore
 		for stopCh != nil || forceStopCh != nil {
 			select {
-			case <-j.doneCtx.Done():
+			case <-attempt.done:
 				return
 			case <-stopCh:
 				stopCh = nil
@@ -115,7 +395,8 @@ func (e *execRunner) startCmd(j *Job, cmd *exec.Cmd) error {
 }
 
 // Returns channel that is completed when done
-func startPipe(j *Job, stderr bool, r io.Reader) <-chan struct{} {
+func startPipe(attempt *JobAttempt, stderr bool, r io.Reader) <-chan struct{} {
+	j := attempt.job
 	done := make(chan struct{})
 	// Read asynchronously until error
 	go func() {
@@ -126,12 +407,12 @@ func startPipe(j *Job, stderr bool, r io.Reader) <-chan struct{} {
 			n, err := r.Read(b)
 			// If any read, send it regardless of error
 			if n > 0 {
-				j.updateOutput(stderr, b[:n])
+				j.updateOutput(attempt, stderr, b[:n])
 			}
 			// If there's an error, we're done
 			if err != nil {
 				if err != io.EOF {
-					log.Printf("Got non-EOF error on job %v:%v output: %v", j.Namespace, j.ID, err)
+					j.log.Warn("Got non-EOF error on job output", "stderr", stderr, "error", err)
 				}
 				return
 			}
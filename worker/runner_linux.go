@@ -1,32 +1,122 @@
 package worker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sys/unix"
 )
 
 type jobLimitArgs struct {
 	JobResourceLimits
 	RootMount string `json:"root-mount,omitempty"`
+	// Capability numbers to keep in the bounding set
+	BoundingCaps []uintptr `json:"bounding-caps"`
+	AmbientCaps  []uintptr `json:"ambient-caps,omitempty"`
+	// If true, root in the container does not automatically get capabilities
+	NoRootCaps bool       `json:"no-root-caps,omitempty"`
+	NoNewPrivs bool       `json:"no-new-privs,omitempty"`
+	UID        *uint32    `json:"uid,omitempty"`
+	GID        *uint32    `json:"gid,omitempty"`
+	Groups     []uint32   `json:"groups,omitempty"`
+	RLimits    JobRLimits `json:"rlimits"`
+	Hostname   string     `json:"hostname,omitempty"`
+	// If non-zero, file descriptor the child writes its setup report to. It is
+	// closed once setup completes or fails.
+	SetupReportFD int `json:"setup-report-fd,omitempty"`
+	// If set, W3C trace context of the parent span. The setup report only has
+	// timed steps if this is set.
+	TraceParent string `json:"trace-parent,omitempty"`
+	// If non-zero, file descriptor the child reads the environment variables
+	// added to the command's environment from. These are not set on the child
+	// itself since it is privileged, and not in its args since those are
+	// readable by anyone.
+	EnvFD int `json:"env-fd,omitempty"`
 }
 
+const (
+	// setupReportFD is the file descriptor of the first extra file of the
+	// child.
+	setupReportFD = 3
+	// envFD is the file descriptor of the second extra file of the child.
+	envFD = 4
+	// rlimitEnvFD is the file descriptor of the only extra file of the
+	// rlimit-exec child.
+	rlimitEnvFD = 3
+)
+
 type limitedRunner struct {
 	*JobLimitConfig
 	*execRunner
+	boundingCaps capabilitySet
+	ambientCaps  capabilitySet
+	// Nil if root in the container gets the bounding set
+	effectiveCaps capabilitySet
+	metrics       Metrics
+	tracer        trace.Tracer
 }
 
-func newLimitedRunner(config *JobLimitConfig) (runner, error) {
+func newLimitedRunner(config *JobLimitConfig, metrics Metrics, tracer trace.Tracer) (runner, error) {
 	if (config.ResourceLimits.CPUMaxPeriod == 0) != (config.ResourceLimits.CPUMaxQuota == 0) {
 		return nil, fmt.Errorf("must set either both or neither CPU limit")
 	}
+	if config.ResourceLimits.CPUWeight > 10000 || config.ResourceLimits.MaxCPUWeight > 10000 {
+		return nil, fmt.Errorf("CPU weight must be between 1 and 10000")
+	}
+	if config.ResourceLimits.MemoryHigh > 0 && config.ResourceLimits.MemoryMax > 0 &&
+		config.ResourceLimits.MemoryHigh > config.ResourceLimits.MemoryMax {
+		return nil, fmt.Errorf("memory high cannot be above memory max")
+	}
+	// Confirm CPUs and memory nodes exist on this host
+	if config.ResourceLimits.CPUSet != "" {
+		if err := validateCPUList(config.ResourceLimits.CPUSet, "/sys/devices/system/cpu/online"); err != nil {
+			return nil, fmt.Errorf("invalid CPU set: %w", err)
+		}
+	}
+	if config.ResourceLimits.MemoryNodes != "" {
+		if err := validateCPUList(config.ResourceLimits.MemoryNodes, "/sys/devices/system/node/online"); err != nil {
+			return nil, fmt.Errorf("invalid memory nodes: %w", err)
+		}
+	}
+	l := &limitedRunner{
+		JobLimitConfig: config,
+		execRunner:     &execRunner{signalExitCodes: true},
+		metrics:        metrics,
+		tracer:         tracer,
+	}
+	// Parse and validate capabilities
+	var err error
+	bounding := config.Security.Capabilities.Bounding
+	if bounding == nil {
+		bounding = DefaultCapabilities
+	}
+	if l.boundingCaps, err = parseCapabilities(bounding); err != nil {
+		return nil, fmt.Errorf("invalid bounding capabilities: %w", err)
+	}
+	if l.ambientCaps, err = parseCapabilities(config.Security.Capabilities.Ambient); err != nil {
+		return nil, fmt.Errorf("invalid ambient capabilities: %w", err)
+	} else if !l.ambientCaps.isSubsetOf(l.boundingCaps) {
+		return nil, fmt.Errorf("ambient capabilities must be a subset of bounding capabilities")
+	}
+	if config.Security.Capabilities.Effective != nil {
+		if l.effectiveCaps, err = parseCapabilities(config.Security.Capabilities.Effective); err != nil {
+			return nil, fmt.Errorf("invalid effective capabilities: %w", err)
+		} else if !l.effectiveCaps.isSubsetOf(l.boundingCaps) {
+			return nil, fmt.Errorf("effective capabilities must be a subset of bounding capabilities")
+		}
+	}
 	// Set the default device number for empty-string device as the device of the
 	// executable
 	if limit := config.ResourceLimits.DeviceIOMax[""]; limit > 0 {
@@ -37,24 +127,195 @@ func newLimitedRunner(config *JobLimitConfig) (runner, error) {
 		}
 		config.ResourceLimits.DeviceIOMax[fmt.Sprintf("%v:%v", uint64(stat.Dev/256), uint64(stat.Dev%256))] = limit
 	}
-	return &limitedRunner{JobLimitConfig: config, execRunner: newRunner()}, nil
+	return l, nil
 }
 
-func (l *limitedRunner) start(j *Job) error {
-	// JSON marshal the args as the first parameter
-	jsonLimitArgs, err := json.Marshal(&jobLimitArgs{JobResourceLimits: l.ResourceLimits, RootMount: j.RootFS})
+func (l *limitedRunner) prepare(j *Job) error {
+	_, effectiveCaps, err := l.command(context.Background(), j)
 	if err != nil {
 		return err
 	}
+	j.Capabilities = effectiveCaps.names()
+	// Already validated by building the command
+	resourceLimits, _ := l.jobResourceLimits(j)
+	j.cpuLimit, j.memoryLimit = resourceLimits.cpuCores(), resourceLimits.MemoryMax
+	return nil
+}
+
+func (l *limitedRunner) start(ctx context.Context, j *Job) (<-chan struct{}, error) {
+	cmd, _, err := l.command(ctx, j)
+	if err != nil {
+		return nil, err
+	}
+	// Pass a pipe for the child to report setup on and one to read the
+	// environment from
+	envR, err := envPipe(j.environ(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer envR.Close()
+	reportR, reportW, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("creating setup report pipe: %w", err)
+	}
+	cmd.ExtraFiles = []*os.File{reportW, envR}
+	err = l.startCmd(j, cmd)
+	reportW.Close()
+	if err != nil {
+		reportR.Close()
+		return nil, err
+	}
+	// The report is read until the child sends it or exits, then its steps
+	// are recorded while the caller's span is still open
+	setupDone := make(chan struct{})
+	go func() {
+		defer close(setupDone)
+		defer reportR.Close()
+		var report childSetupReport
+		if b, _ := io.ReadAll(reportR); len(b) == 0 {
+			return
+		} else if err := json.Unmarshal(b, &report); err != nil {
+			j.log.Warn("Invalid setup report from job", "error", err)
+			return
+		}
+		if report.CGroupError != "" {
+			j.log.Warn("Failed applying cgroup limits to job", "error", report.CGroupError)
+			l.metrics.CGroupSetupFailed(j.Namespace)
+		}
+		recordChildSetupSpans(ctx, l.tracer, &report)
+	}()
+	return setupDone, nil
+}
+
+// jobResourceLimits returns the worker's resource limits with the job's
+// resources applied, validating them against the worker's.
+func (l *limitedRunner) jobResourceLimits(j *Job) (JobResourceLimits, error) {
+	limits := l.ResourceLimits
+	resources := j.Resources
+	if resources.CPUSet != "" {
+		if err := validateCPUSubset(resources.CPUSet, limits.CPUSet, "/sys/devices/system/cpu/online"); err != nil {
+			return limits, fmt.Errorf("%w: CPU set: %v", ErrInvalidResources, err)
+		}
+		limits.CPUSet = resources.CPUSet
+	}
+	if resources.MemoryNodes != "" {
+		if err := validateCPUSubset(resources.MemoryNodes, limits.MemoryNodes, "/sys/devices/system/node/online"); err != nil {
+			return limits, fmt.Errorf("%w: memory nodes: %v", ErrInvalidResources, err)
+		}
+		limits.MemoryNodes = resources.MemoryNodes
+	}
+	if resources.CPUWeight > 0 {
+		maxWeight := limits.MaxCPUWeight
+		if maxWeight == 0 {
+			maxWeight = limits.CPUWeight
+		}
+		if maxWeight == 0 {
+			maxWeight = 100
+		}
+		if resources.CPUWeight > maxWeight {
+			return limits, fmt.Errorf("%w: CPU weight %v above maximum %v", ErrInvalidResources, resources.CPUWeight, maxWeight)
+		}
+		limits.CPUWeight = resources.CPUWeight
+	}
+	if resources.MemoryHigh > 0 {
+		maxHigh := limits.MemoryHigh
+		if maxHigh == 0 {
+			maxHigh = limits.MemoryMax
+		}
+		if maxHigh > 0 && resources.MemoryHigh > maxHigh {
+			return limits, fmt.Errorf("%w: memory high %v above maximum %v", ErrInvalidResources, resources.MemoryHigh, maxHigh)
+		}
+		limits.MemoryHigh = resources.MemoryHigh
+	}
+	return limits, nil
+}
+
+// cpuCores returns the CPU cores the limits allow, which is the CPU max capped
+// at the number of CPUs in the CPU set, or 0 if there is no CPU max.
+func (r *JobResourceLimits) cpuCores() float64 {
+	if r.CPUMaxPeriod == 0 {
+		return 0
+	}
+	cores := float64(r.CPUMaxQuota) / float64(r.CPUMaxPeriod)
+	if r.CPUSet != "" {
+		if ids, err := parseCPUList(r.CPUSet); err == nil && float64(len(ids)) < cores {
+			cores = float64(len(ids))
+		}
+	}
+	return cores
+}
+
+// command builds the child-exec command for the job and returns it with the
+// capabilities the job will effectively have. The span in the context is
+// propagated to the child if it is recording.
+func (l *limitedRunner) command(ctx context.Context, j *Job) (*exec.Cmd, capabilitySet, error) {
+	resourceLimits, err := l.jobResourceLimits(j)
+	if err != nil {
+		return nil, nil, err
+	}
+	limitArgs := &jobLimitArgs{
+		JobResourceLimits: resourceLimits,
+		RootMount:         j.RootFS,
+		BoundingCaps:      l.boundingCaps.sorted(),
+		AmbientCaps:       l.ambientCaps.sorted(),
+		NoNewPrivs:        l.Security.NoNewPrivileges,
+		RLimits:           j.RLimits,
+		Hostname:          j.Hostname,
+		SetupReportFD:     setupReportFD,
+		EnvFD:             envFD,
+	}
+	if trace.SpanFromContext(ctx).IsRecording() {
+		limitArgs.TraceParent = traceParent(ctx)
+	}
+	// Map the user and groups for the job. The child itself always runs as
+	// container root mapped to our user.
+	var hostUID, hostGID *uint32
+	var hostGroups []uint32
+	if j.hostUser != nil {
+		hostUID, hostGID, hostGroups = &j.hostUser.UID, &j.hostUser.GID, j.hostUser.Groups
+	}
+	uidMappings, err := mapContainerID(os.Getuid(), l.Security.UID, hostUID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mapping user: %w", err)
+	}
+	limitArgs.UID = containerIDOf(uidMappings)
+	gidMappings, err := mapContainerID(os.Getgid(), l.Security.GID, hostGID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mapping group: %w", err)
+	}
+	limitArgs.GID = containerIDOf(gidMappings)
+	if gidMappings, limitArgs.Groups, err = mapContainerGroups(gidMappings, hostGroups); err != nil {
+		return nil, nil, fmt.Errorf("mapping groups: %w", err)
+	}
+	// Explicit effective capabilities are given to the job as ambient ones with
+	// root's implicit capabilities disabled
+	effectiveCaps := l.ambientCaps
+	if l.effectiveCaps != nil {
+		limitArgs.NoRootCaps = true
+		effectiveCaps = l.effectiveCaps.union(l.ambientCaps)
+		limitArgs.AmbientCaps = effectiveCaps.sorted()
+	} else if limitArgs.UID == nil {
+		effectiveCaps = l.boundingCaps
+	}
+	// JSON marshal the args as the first parameter
+	jsonLimitArgs, err := json.Marshal(limitArgs)
+	if err != nil {
+		return nil, nil, err
+	}
 	// Build command for child with the first param as the limit args, then the
 	// rest as the command and args
 	args := append([]string{"child-exec", string(jsonLimitArgs), j.Command}, j.Args...)
 	cmd := exec.Command("/proc/self/exe", args...)
 	// Add syscall args
 	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags:  syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUSER,
-		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
-		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		Cloneflags:  syscall.CLONE_NEWUTS | syscall.CLONE_NEWUSER,
+		UidMappings: uidMappings,
+		GidMappings: gidMappings,
+		// Supplementary groups can only be set by the child if allowed
+		GidMappingsEnableSetgroups: len(limitArgs.Groups) > 0,
+	}
+	if !l.Isolation.SharedIPC {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWIPC
 	}
 	if l.Isolation.PID {
 		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWPID
@@ -65,8 +326,7 @@ func (l *limitedRunner) start(j *Job) error {
 	if l.Isolation.Mount {
 		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNS
 	}
-	err = l.startCmd(j, cmd)
-	return err
+	return cmd, effectiveCaps, nil
 }
 
 // ExecLimitedChild is called via internal child-exec. Only returns a nil error
@@ -82,64 +342,90 @@ func ExecLimitedChild(args []string) error {
 	if err := json.Unmarshal([]byte(args[0]), &limitArgs); err != nil {
 		return fmt.Errorf("invalid child exec args: %w", err)
 	}
+	env, err := readEnv(limitArgs.EnvFD)
+	if err != nil {
+		return err
+	}
+	// Report setup to the parent when done even on failure
+	reporter := newChildSetupReporter(&limitArgs)
+	defer reporter.send()
 	// Create container ID (even if there are no limits)
 	containerID := uuid.New().String()
-	// If the max period and max quota are present, limit CPU
-	if limitArgs.CPUMaxPeriod > 0 && limitArgs.CPUMaxQuota > 0 {
-		dir, err := writeCGroupSettings(containerID, "cpu",
-			[]string{"cpu.cfs_period_us", strconv.FormatUint(limitArgs.CPUMaxPeriod, 10)},
-			[]string{"cpu.cfs_quota_us", strconv.FormatUint(limitArgs.CPUMaxQuota, 10)},
-		)
-		if dir != "" {
-			defer os.RemoveAll(dir)
-		}
+	// We are always in our own UTS namespace
+	if limitArgs.Hostname != "" {
+		err := reporter.step("set hostname", func() error {
+			if err := unix.Sethostname([]byte(limitArgs.Hostname)); err != nil {
+				return fmt.Errorf("setting hostname: %w", err)
+			}
+			return nil
+		})
 		if err != nil {
 			return err
 		}
 	}
-	// If memory max present, limit it
-	if limitArgs.MemoryMax > 0 {
-		dir, err := writeCGroupSettings(containerID, "memory",
-			[]string{"memory.limit_in_bytes", strconv.FormatUint(limitArgs.MemoryMax, 10)},
-			[]string{"memory.memsw.limit_in_bytes", strconv.FormatUint(limitArgs.MemoryMax, 10)},
-		)
-		if dir != "" {
-			defer os.RemoveAll(dir)
-		}
-		if err != nil {
+	// Apply cgroup limits, removing the cgroups when done
+	var dirs []string
+	err = reporter.step("apply cgroup limits", func() (err error) {
+		dirs, err = applyCGroupLimits(containerID, &limitArgs.JobResourceLimits)
+		return
+	})
+	for _, dir := range dirs {
+		defer os.RemoveAll(dir)
+	}
+	if err != nil {
+		reporter.report.CGroupError = err.Error()
+		return err
+	}
+	// Pivot root if there is a root mount
+	if limitArgs.RootMount != "" {
+		if err := reporter.step("pivot root", func() error { return pivotRoot(limitArgs.RootMount) }); err != nil {
 			return err
 		}
 	}
-	// If device maxes exist, apply them
-	if len(limitArgs.DeviceIOMax) > 0 {
-		var readLimits, writeLimits strings.Builder
-		for dev, bps := range limitArgs.DeviceIOMax {
-			if readLimits.Len() > 0 {
-				readLimits.WriteByte('\n')
-				writeLimits.WriteByte('\n')
-			}
-			str := dev + "  " + strconv.FormatUint(bps, 10)
-			readLimits.WriteString(str)
-			writeLimits.WriteString(str)
+	if err := reporter.step("set rlimits", func() error { return setRLimits(&limitArgs.RLimits) }); err != nil {
+		return err
+	}
+	// Capabilities, secure bits, and no-new-privs are per thread and inherited
+	// by forked children, so we apply them on a locked thread that we also start
+	// the command from
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	err = reporter.step("restrict privileges", func() error {
+		if err := dropBoundingCapabilities(limitArgs.BoundingCaps); err != nil {
+			return err
 		}
-		dir, err := writeCGroupSettings(containerID, "blkio",
-			[]string{"blkio.throttle.read_bps_device", readLimits.String()},
-			[]string{"blkio.throttle.write_bps_device", writeLimits.String()},
-		)
-		if dir != "" {
-			defer os.RemoveAll(dir)
+		if limitArgs.NoRootCaps {
+			if err := unix.Prctl(unix.PR_SET_SECUREBITS, secbitNoRoot|secbitNoRootLocked, 0, 0, 0); err != nil {
+				return fmt.Errorf("setting secure bits: %w", err)
+			}
 		}
-		if err != nil {
-			return err
+		if limitArgs.NoNewPrivs {
+			if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+				return fmt.Errorf("setting no new privs: %w", err)
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	// Pivot root if there is a root mount
-	if limitArgs.RootMount != "" {
-		if err := pivotRoot(limitArgs.RootMount); err != nil {
-			return err
+	// Setup is complete, which also closes the report file so the command does
+	// not inherit it
+	reporter.send()
+	cmd := exec.Command(args[1], args[2:]...)
+	cmd.Env = applyEnviron(env)
+	cmd.SysProcAttr = &syscall.SysProcAttr{AmbientCaps: limitArgs.AmbientCaps}
+	if limitArgs.UID != nil || limitArgs.GID != nil {
+		// Setting groups is denied in the user namespace unless there are
+		// supplementary groups
+		cmd.SysProcAttr.Credential = &syscall.Credential{Groups: limitArgs.Groups, NoSetGroups: len(limitArgs.Groups) == 0}
+		if limitArgs.UID != nil {
+			cmd.SysProcAttr.Credential.Uid = *limitArgs.UID
+		}
+		if limitArgs.GID != nil {
+			cmd.SysProcAttr.Credential.Gid = *limitArgs.GID
 		}
 	}
-	cmd := exec.Command(args[1], args[2:]...)
 	// While we don't need stdin, we can't use /dev/null because it may not be
 	// mounted after pivot root
 	cmd.Stdin = os.Stdin
@@ -148,6 +434,225 @@ func ExecLimitedChild(args []string) error {
 	return cmd.Run()
 }
 
+// childSetupReporter times the setup steps of the limited child and writes
+// the report to the parent once.
+type childSetupReporter struct {
+	file   *os.File
+	timed  bool
+	report childSetupReport
+}
+
+func newChildSetupReporter(limitArgs *jobLimitArgs) *childSetupReporter {
+	c := &childSetupReporter{timed: limitArgs.TraceParent != ""}
+	if limitArgs.SetupReportFD != 0 {
+		c.file = os.NewFile(uintptr(limitArgs.SetupReportFD), "setup-report")
+	}
+	return c
+}
+
+// step runs the setup step, timing it if the report is timed.
+func (c *childSetupReporter) step(name string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	if c.timed {
+		step := childSetupStep{Name: name, Start: start, End: time.Now()}
+		if err != nil {
+			step.Error = err.Error()
+		}
+		c.report.Steps = append(c.report.Steps, step)
+	}
+	return err
+}
+
+// send writes the report and closes the file unless already sent.
+func (c *childSetupReporter) send() {
+	if c.file == nil {
+		return
+	}
+	if b, err := json.Marshal(&c.report); err == nil {
+		c.file.Write(b)
+	}
+	c.file.Close()
+	c.file = nil
+}
+
+// signalName returns the name of the signal, e.g. "SIGKILL".
+func signalName(sig syscall.Signal) string {
+	if name := unix.SignalName(sig); name != "" {
+		return name
+	}
+	return fmt.Sprintf("SIG%d", int(sig))
+}
+
+// parseSignal returns the normalized name of a signal given as a name with or
+// without the "SIG" prefix, case insensitive, or as a number.
+func parseSignal(name string) (string, error) {
+	if num, err := strconv.Atoi(name); err == nil && num > 0 {
+		return signalName(syscall.Signal(num)), nil
+	}
+	upper := strings.ToUpper(name)
+	if !strings.HasPrefix(upper, "SIG") {
+		upper = "SIG" + upper
+	}
+	if unix.SignalNum(upper) == 0 {
+		return "", fmt.Errorf("unknown signal %q", name)
+	}
+	return upper, nil
+}
+
+type rlimitArgs struct {
+	RLimits JobRLimits `json:"rlimits"`
+	// If non-zero, file descriptor to read the environment variables added to
+	// the command's environment from
+	EnvFD int `json:"env-fd,omitempty"`
+}
+
+// rlimitCmd returns a command that re-executes ourselves via rlimit-exec to
+// apply the job resource limits before exec'ing the job command with the
+// environment variables read from the given file. The caller must close the
+// file once the command is started.
+func rlimitCmd(j *Job, env *os.File) (*exec.Cmd, error) {
+	jsonArgs, err := json.Marshal(&rlimitArgs{RLimits: j.RLimits, EnvFD: rlimitEnvFD})
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{"rlimit-exec", string(jsonArgs), j.Command}, j.Args...)
+	cmd := exec.Command("/proc/self/exe", args...)
+	cmd.ExtraFiles = []*os.File{env}
+	return cmd, nil
+}
+
+// ExecRLimitedChild is called via internal rlimit-exec. It applies the resource
+// limits and then replaces the current process with the command, so it only
+// returns on failure.
+func ExecRLimitedChild(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("invalid arg count")
+	}
+	var rlimitArgs rlimitArgs
+	if err := json.Unmarshal([]byte(args[0]), &rlimitArgs); err != nil {
+		return fmt.Errorf("invalid rlimit exec args: %w", err)
+	}
+	env, err := readEnv(rlimitArgs.EnvFD)
+	if err != nil {
+		return err
+	}
+	path, err := exec.LookPath(args[1])
+	if err != nil {
+		return err
+	}
+	if err := setRLimits(&rlimitArgs.RLimits); err != nil {
+		return err
+	}
+	return syscall.Exec(path, args[1:], applyEnviron(env))
+}
+
+// readEnv reads the environment variables from the file descriptor written by
+// envPipe and closes it so the command does not inherit it. Nothing is read if
+// the file descriptor is 0.
+func readEnv(fd int) (map[string]string, error) {
+	if fd == 0 {
+		return nil, nil
+	}
+	f := os.NewFile(uintptr(fd), "env")
+	defer f.Close()
+	var env map[string]string
+	if err := json.NewDecoder(f).Decode(&env); err != nil {
+		return nil, fmt.Errorf("reading environment: %w", err)
+	}
+	return env, nil
+}
+
+func setRLimits(rlimits *JobRLimits) (err error) {
+	resources := map[string]int{
+		"nofile": unix.RLIMIT_NOFILE,
+		"core":   unix.RLIMIT_CORE,
+		"fsize":  unix.RLIMIT_FSIZE,
+		"stack":  unix.RLIMIT_STACK,
+	}
+	rlimits.each(func(name string, limit **JobRLimit) {
+		if err == nil && *limit != nil {
+			if err = unix.Setrlimit(resources[name], &unix.Rlimit{Cur: (*limit).Soft, Max: (*limit).Hard}); err != nil {
+				err = fmt.Errorf("setting %v rlimit: %w", name, err)
+			}
+		}
+	})
+	return
+}
+
+// mapContainerID returns user namespace mappings for a user or group ID. The
+// first mapping is always container root to the worker ID. If either the
+// container ID or host ID is non-nil, the second mapping is for the job with
+// the container ID defaulting to the host ID and vice versa.
+func mapContainerID(workerID int, containerID, hostID *uint32) ([]syscall.SysProcIDMap, error) {
+	mappings := []syscall.SysProcIDMap{{ContainerID: 0, HostID: workerID, Size: 1}}
+	if containerID == nil && hostID == nil {
+		return mappings, nil
+	}
+	if containerID == nil {
+		containerID = hostID
+	} else if hostID == nil {
+		hostID = containerID
+	}
+	switch {
+	case *containerID == 0 && int(*hostID) == workerID:
+		// Same as root mapping
+	case *containerID == 0:
+		return nil, fmt.Errorf("container ID 0 cannot map to host ID %v", *hostID)
+	case int(*hostID) == workerID:
+		return nil, fmt.Errorf("host ID %v is already mapped to container ID 0", *hostID)
+	default:
+		mappings = append(mappings, syscall.SysProcIDMap{ContainerID: int(*containerID), HostID: int(*hostID), Size: 1})
+	}
+	return mappings, nil
+}
+
+// containerIDOf returns the job container ID from mappings created with
+// mapContainerID, or nil if the job runs as root.
+func containerIDOf(mappings []syscall.SysProcIDMap) *uint32 {
+	if len(mappings) < 2 {
+		return nil
+	}
+	id := uint32(mappings[1].ContainerID)
+	return &id
+}
+
+// mapContainerGroups adds mappings for the given host supplementary groups to
+// mappings created with mapContainerID and returns the groups as container
+// IDs. Groups not already mapped are mapped to the same ID in the container.
+func mapContainerGroups(
+	mappings []syscall.SysProcIDMap,
+	hostGroups []uint32,
+) ([]syscall.SysProcIDMap, []uint32, error) {
+	groups := make([]uint32, 0, len(hostGroups))
+	for _, hostGroup := range hostGroups {
+		containerGroup := -1
+		for _, mapping := range mappings {
+			if mapping.HostID == int(hostGroup) {
+				containerGroup = mapping.ContainerID
+				break
+			} else if mapping.ContainerID == int(hostGroup) {
+				return nil, nil, fmt.Errorf("group %v is already a different container ID", hostGroup)
+			}
+		}
+		if containerGroup < 0 {
+			containerGroup = int(hostGroup)
+			mappings = append(mappings, syscall.SysProcIDMap{ContainerID: containerGroup, HostID: containerGroup, Size: 1})
+		}
+		groups = append(groups, uint32(containerGroup))
+	}
+	return mappings, groups, nil
+}
+
+// setCmdCredential sets the command to run as the given host user.
+func setCmdCredential(cmd *exec.Cmd, user *JobUser) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: user.UID, Gid: user.GID, Groups: user.Groups}
+	return nil
+}
+
 func pivotRoot(target string) error {
 	// Create /proc inside of root mount and then mount it
 	procDir := filepath.Join(target, "proc")
@@ -181,22 +686,3 @@ func pivotRoot(target string) error {
 	}
 	return nil
 }
-
-// If dir non-empty, regardless of error, caller should remove it when done.
-// Each setting is two-string tuple (and slice can be mutated internally).
-func writeCGroupSettings(containerID, controller string, settings ...[]string) (dir string, err error) {
-	// Create dir if not there
-	dir = filepath.Join("/sys/fs/cgroup", controller, "teleworker", containerID)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return "", fmt.Errorf("creating dir %v: %w", dir, err)
-	}
-	// Add my PID to settings as procs
-	settings = append(settings, []string{"cgroup.procs", "0"})
-	// Write each setting as a file
-	for _, settingSet := range settings {
-		if err := os.WriteFile(filepath.Join(dir, settingSet[0]), []byte(settingSet[1]), 0644); err != nil {
-			return dir, fmt.Errorf("writing file %v: %w", filepath.Join(dir, settingSet[0]), err)
-		}
-	}
-	return
-}
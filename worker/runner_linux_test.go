@@ -0,0 +1,437 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+const (
+	testHelperEnv      = "TELEWORKER_TEST_JOB_VAR"
+	testHelperEnvValue = "job-secret"
+)
+
+func TestMain(m *testing.M) {
+	// Limited jobs and jobs with rlimits re-execute this test binary, which must
+	// not have the job environment in its args
+	if len(os.Args) > 1 && (os.Args[1] == "child-exec" || os.Args[1] == "rlimit-exec") {
+		if b, err := os.ReadFile("/proc/self/cmdline"); err != nil || strings.Contains(string(b), testHelperEnvValue) {
+			fmt.Fprintf(os.Stderr, "%v has job environment in args: %q, %v\n", os.Args[1], b, err)
+			os.Exit(1)
+		}
+	}
+	// Limited jobs re-execute this test binary as their child
+	if len(os.Args) > 1 && os.Args[1] == "child-exec" {
+		err := ExecLimitedChild(os.Args[2:])
+		if exitErr, _ := err.(*exec.ExitError); exitErr != nil {
+			os.Exit(exitErr.ExitCode())
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// Jobs with rlimits re-execute this test binary, which must not see the job
+	// environment
+	if len(os.Args) > 1 && os.Args[1] == "rlimit-exec" {
+		if _, ok := os.LookupEnv(testHelperEnv); ok {
+			fmt.Fprintln(os.Stderr, "rlimit-exec has job environment")
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, ExecRLimitedChild(os.Args[2:]))
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+func TestJobEnv(t *testing.T) {
+	t.Setenv("TELEWORKER_TEST_WORKER_VAR", "worker")
+	unlimited, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlimited.Shutdown(context.Background(), true)
+	limited, err := New(Config{Limits: &JobLimitConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer limited.Shutdown(context.Background(), true)
+	for _, test := range []struct {
+		name        string
+		w           *Worker
+		withRLimits bool
+	}{{"unlimited", unlimited, false}, {"rlimits", unlimited, true}, {"limited", limited, false}} {
+		w := test.w
+		t.Run(test.name, func(t *testing.T) {
+			spec := JobSpec{
+				Command: "sh",
+				Args:    []string{"-c", "echo -n $TELEWORKER_TEST_WORKER_VAR $" + testHelperEnv},
+				Env:     map[string]string{testHelperEnv: testHelperEnvValue},
+			}
+			if test.withRLimits {
+				spec.RLimits.NoFile = &JobRLimit{Soft: 512, Hard: 512}
+			}
+			job, err := w.SubmitJobSpec("", "", spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			<-job.doneCtx.Done()
+			b := make([]byte, 100)
+			n, _, exitCode, err := job.ReadStdout(b, 0)
+			if err != nil {
+				t.Fatal(err)
+			} else if *exitCode != 0 {
+				stderr := make([]byte, 1000)
+				stderrN, _, _, _ := job.ReadStderr(stderr, 0)
+				t.Fatalf("exit code %v, stderr: %s", *exitCode, stderr[:stderrN])
+			} else if string(b[:n]) != "worker "+testHelperEnvValue {
+				t.Fatalf("unexpected output %q", b[:n])
+			}
+		})
+	}
+}
+
+func TestLimitedCommandEnv(t *testing.T) {
+	runner := &limitedRunner{JobLimitConfig: &JobLimitConfig{}, execRunner: newRunner()}
+	job := newJob("", "job1", JobSpec{Command: "true", Env: map[string]string{"GODEBUG": "foo"}})
+	cmd, _, err := runner.command(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The privileged child keeps our environment and reads the job's from a
+	// file instead of its args
+	if cmd.Env != nil {
+		t.Fatalf("expected child to inherit environment, got %v", cmd.Env)
+	}
+	var limitArgs jobLimitArgs
+	if err := json.Unmarshal([]byte(cmd.Args[2]), &limitArgs); err != nil {
+		t.Fatal(err)
+	} else if limitArgs.EnvFD != envFD {
+		t.Fatalf("expected environment file descriptor, got %v", limitArgs.EnvFD)
+	} else if strings.Contains(strings.Join(cmd.Args, " "), "GODEBUG") {
+		t.Fatalf("expected no job environment in args, got %v", cmd.Args)
+	}
+}
+
+// runJob submits the job, waits for it to complete successfully, and returns
+// its stdout.
+func runJob(t *testing.T, w *Worker, namespace string, spec JobSpec) string {
+	t.Helper()
+	job, err := w.SubmitJobSpec(namespace, "", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-job.doneCtx.Done()
+	b := make([]byte, 10000)
+	n, _, exitCode, err := job.ReadStdout(b, 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if *exitCode != 0 {
+		stderr := make([]byte, 1000)
+		stderrN, _, _, _ := job.ReadStderr(stderr, 0)
+		t.Fatalf("exit code %v, start error %v, stderr: %s", *exitCode, job.StartError(), stderr[:stderrN])
+	}
+	return string(b[:n])
+}
+
+func TestMapContainerID(t *testing.T) {
+	id := func(v uint32) *uint32 { return &v }
+	tests := []struct {
+		name        string
+		containerID *uint32
+		hostID      *uint32
+		expected    []syscall.SysProcIDMap
+		invalid     bool
+	}{
+		{name: "root only", expected: []syscall.SysProcIDMap{{ContainerID: 0, HostID: 0, Size: 1}}},
+		{
+			name:   "host user",
+			hostID: id(1000),
+			expected: []syscall.SysProcIDMap{
+				{ContainerID: 0, HostID: 0, Size: 1},
+				{ContainerID: 1000, HostID: 1000, Size: 1},
+			},
+		},
+		{
+			name:        "container user",
+			containerID: id(5),
+			expected: []syscall.SysProcIDMap{
+				{ContainerID: 0, HostID: 0, Size: 1},
+				{ContainerID: 5, HostID: 5, Size: 1},
+			},
+		},
+		{
+			name:        "container user as host user",
+			containerID: id(5),
+			hostID:      id(1000),
+			expected: []syscall.SysProcIDMap{
+				{ContainerID: 0, HostID: 0, Size: 1},
+				{ContainerID: 5, HostID: 1000, Size: 1},
+			},
+		},
+		{
+			name:        "container root as worker",
+			containerID: id(0),
+			hostID:      id(0),
+			expected:    []syscall.SysProcIDMap{{ContainerID: 0, HostID: 0, Size: 1}},
+		},
+		{name: "container root as other host user", containerID: id(0), hostID: id(1000), invalid: true},
+		{name: "worker as other container user", containerID: id(5), hostID: id(0), invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mappings, err := mapContainerID(0, test.containerID, test.hostID)
+			if test.invalid {
+				if err == nil {
+					t.Fatalf("expected error, got %v", mappings)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(test.expected, mappings) {
+				t.Fatalf("expected %v, got %v", test.expected, mappings)
+			}
+			if test.containerID == nil && test.hostID == nil && containerIDOf(mappings) != nil {
+				t.Fatal("expected root to have no container ID")
+			}
+		})
+	}
+}
+
+func TestMapContainerGroups(t *testing.T) {
+	base := []syscall.SysProcIDMap{{ContainerID: 0, HostID: 0, Size: 1}, {ContainerID: 5, HostID: 1000, Size: 1}}
+	// Already mapped groups use their container ID, others map to themselves
+	mappings, groups, err := mapContainerGroups(append([]syscall.SysProcIDMap{}, base...), []uint32{1000, 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append(append([]syscall.SysProcIDMap{}, base...), syscall.SysProcIDMap{ContainerID: 2000, HostID: 2000, Size: 1})
+	if !reflect.DeepEqual(expected, mappings) {
+		t.Fatalf("expected %v, got %v", expected, mappings)
+	} else if !reflect.DeepEqual([]uint32{5, 2000}, groups) {
+		t.Fatalf("unexpected groups %v", groups)
+	}
+	// A group whose ID is already another host ID's container ID cannot map
+	if _, _, err := mapContainerGroups(append([]syscall.SysProcIDMap{}, base...), []uint32{5}); err == nil {
+		t.Fatal("expected conflict error")
+	}
+}
+
+func TestJobUser(t *testing.T) {
+	nobody := JobUser{Name: "nobody", UID: 65534, GID: 65534, Groups: []uint32{65534}}
+	for _, limited := range []bool{false, true} {
+		t.Run(fmt.Sprintf("limited=%v", limited), func(t *testing.T) {
+			config := Config{Namespaces: map[string]NamespaceConfig{
+				"ns1": {Users: []JobUser{nobody, {Name: "root"}}},
+			}}
+			if limited {
+				config.Limits = &JobLimitConfig{}
+			}
+			w, err := New(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Shutdown(context.Background(), true)
+			idSpec := JobSpec{Command: "sh", Args: []string{"-c", "echo -n $(id -u) $(id -g)"}}
+
+			// Defaults to the first user of the namespace
+			if out := runJob(t, w, "ns1", idSpec); out != "65534 65534" {
+				t.Fatalf("unexpected default user IDs %q", out)
+			}
+			rootSpec := idSpec
+			rootSpec.User = "root"
+			if out := runJob(t, w, "ns1", rootSpec); out != "0 0" {
+				t.Fatalf("unexpected root IDs %q", out)
+			}
+			// Unknown users and users of other namespaces are not allowed
+			unknownSpec := idSpec
+			unknownSpec.User = "unknown"
+			if _, err := w.SubmitJobSpec("ns1", "", unknownSpec); !errors.Is(err, ErrUserNotAllowed) {
+				t.Fatalf("expected user not allowed, got %v", err)
+			}
+			otherSpec := idSpec
+			otherSpec.User = "nobody"
+			if _, err := w.SubmitJobSpec("ns2", "", otherSpec); !errors.Is(err, ErrUserNotAllowed) {
+				t.Fatalf("expected user not allowed in other namespace, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRLimitsApplyMax(t *testing.T) {
+	max := JobRLimits{NoFile: &JobRLimit{Soft: 1024, Hard: 4096}}
+	tests := []struct {
+		name     string
+		rlimits  JobRLimits
+		expected JobRLimits
+		invalid  bool
+	}{
+		{name: "default", expected: max},
+		{
+			name:     "within max",
+			rlimits:  JobRLimits{NoFile: &JobRLimit{Soft: 4096, Hard: 4096}, Core: &JobRLimit{Soft: 0, Hard: 10}},
+			expected: JobRLimits{NoFile: &JobRLimit{Soft: 4096, Hard: 4096}, Core: &JobRLimit{Soft: 0, Hard: 10}},
+		},
+		{name: "soft above hard", rlimits: JobRLimits{NoFile: &JobRLimit{Soft: 20, Hard: 10}}, invalid: true},
+		{name: "soft above max", rlimits: JobRLimits{NoFile: &JobRLimit{Soft: 5000, Hard: 4096}}, invalid: true},
+		{name: "hard above max", rlimits: JobRLimits{NoFile: &JobRLimit{Soft: 10, Hard: 5000}}, invalid: true},
+		{
+			name:    "infinity above max",
+			rlimits: JobRLimits{NoFile: &JobRLimit{Soft: 10, Hard: RLimitInfinity}},
+			invalid: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rlimits := test.rlimits
+			err := rlimits.applyMax(&max)
+			if test.invalid {
+				if !errors.Is(err, ErrInvalidRLimits) {
+					t.Fatalf("expected invalid rlimits, got %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(test.expected, rlimits) {
+				t.Fatalf("expected %v, got %v", test.expected, rlimits)
+			}
+			// Never shared with the maximum or the caller
+			if rlimits.NoFile == max.NoFile || (test.rlimits.NoFile != nil && rlimits.NoFile == test.rlimits.NoFile) {
+				t.Fatal("expected limit to be copied")
+			}
+		})
+	}
+}
+
+func TestJobRLimits(t *testing.T) {
+	noFileSpec := func(rlimits JobRLimits) JobSpec {
+		return JobSpec{Command: "sh", Args: []string{"-c", "echo -n $(ulimit -Sn) $(ulimit -Hn)"}, RLimits: rlimits}
+	}
+	t.Run("limited", func(t *testing.T) {
+		w, err := New(Config{Limits: &JobLimitConfig{RLimits: JobRLimits{NoFile: &JobRLimit{Soft: 1024, Hard: 4096}}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Shutdown(context.Background(), true)
+		// Unset limits default to the maximum
+		if out := runJob(t, w, "", noFileSpec(JobRLimits{})); out != "1024 4096" {
+			t.Fatalf("unexpected default limits %q", out)
+		}
+		if out := runJob(t, w, "", noFileSpec(JobRLimits{NoFile: &JobRLimit{Soft: 512, Hard: 2048}})); out != "512 2048" {
+			t.Fatalf("unexpected limits %q", out)
+		}
+		_, err = w.SubmitJobSpec("", "", noFileSpec(JobRLimits{NoFile: &JobRLimit{Soft: 8192, Hard: 8192}}))
+		if !errors.Is(err, ErrInvalidRLimits) {
+			t.Fatalf("expected invalid rlimits, got %v", err)
+		}
+	})
+	t.Run("not limited", func(t *testing.T) {
+		w, err := New(Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Shutdown(context.Background(), true)
+		// Set by re-executing through rlimit-exec
+		if out := runJob(t, w, "", noFileSpec(JobRLimits{NoFile: &JobRLimit{Soft: 256, Hard: 512}})); out != "256 512" {
+			t.Fatalf("unexpected limits %q", out)
+		}
+		_, err = w.SubmitJobSpec("", "", noFileSpec(JobRLimits{NoFile: &JobRLimit{Soft: 512, Hard: 256}}))
+		if !errors.Is(err, ErrInvalidRLimits) {
+			t.Fatalf("expected invalid rlimits, got %v", err)
+		}
+	})
+}
+
+func TestValidateHostname(t *testing.T) {
+	for _, hostname := range []string{"a", "job1", "my-job.example", strings.Repeat("a", 64)} {
+		if err := validateHostname(hostname); err != nil {
+			t.Fatalf("expected %q valid, got %v", hostname, err)
+		}
+	}
+	for _, hostname := range []string{"", strings.Repeat("a", 65), "-job", "job-", "a..b", ".a", "my_job", "jöb", "a b"} {
+		if err := validateHostname(hostname); !errors.Is(err, ErrInvalidHostname) {
+			t.Fatalf("expected %q invalid, got %v", hostname, err)
+		}
+	}
+}
+
+func TestDefaultHostname(t *testing.T) {
+	tests := map[string]string{
+		"job1":                         "job1",
+		"my_job.1":                     "my-job-1",
+		"__job__":                      "job",
+		"___":                          "job",
+		strings.Repeat("a", 70):        strings.Repeat("a", 64),
+		strings.Repeat("a", 63) + "_b": strings.Repeat("a", 63),
+	}
+	for id, expected := range tests {
+		if actual := defaultHostname(id); actual != expected {
+			t.Fatalf("expected %q for %q, got %q", expected, id, actual)
+		} else if err := validateHostname(actual); err != nil {
+			t.Fatalf("default %q for %q is invalid: %v", actual, id, err)
+		}
+	}
+}
+
+func TestJobHostname(t *testing.T) {
+	w, err := New(Config{Limits: &JobLimitConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Shutdown(context.Background(), true)
+	hostnameSpec := JobSpec{Command: "cat", Args: []string{"/proc/sys/kernel/hostname"}}
+	run := func(id string, spec JobSpec) string {
+		job, err := w.SubmitJobSpec("", id, spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-job.doneCtx.Done()
+		b := make([]byte, 100)
+		n, _, exitCode, err := job.ReadStdout(b, 0)
+		if err != nil {
+			t.Fatal(err)
+		} else if *exitCode != 0 {
+			t.Fatalf("exit code %v, start error %v", *exitCode, job.StartError())
+		}
+		return strings.TrimSpace(string(b[:n]))
+	}
+
+	// Defaults to the job ID, made valid as needed
+	if hostname := run("job1", hostnameSpec); hostname != "job1" {
+		t.Fatalf("expected job ID hostname, got %q", hostname)
+	}
+	if hostname := run("my_job.1", hostnameSpec); hostname != "my-job-1" {
+		t.Fatalf("expected sanitized job ID hostname, got %q", hostname)
+	}
+	customSpec := hostnameSpec
+	customSpec.Hostname = "custom-host"
+	if hostname := run("", customSpec); hostname != "custom-host" {
+		t.Fatalf("expected custom hostname, got %q", hostname)
+	}
+	// The worker's hostname is unchanged
+	if hostname, _ := os.Hostname(); hostname == "custom-host" {
+		t.Fatal("worker hostname changed")
+	}
+	invalidSpec := hostnameSpec
+	invalidSpec.Hostname = "bad_host"
+	if _, err := w.SubmitJobSpec("", "", invalidSpec); !errors.Is(err, ErrInvalidHostname) {
+		t.Fatalf("expected invalid hostname, got %v", err)
+	}
+
+	// Cannot be set without limits
+	unlimited, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlimited.Shutdown(context.Background(), true)
+	if _, err := unlimited.SubmitJobSpec("", "", customSpec); err == nil {
+		t.Fatal("expected error setting hostname without limits")
+	}
+}
@@ -2,12 +2,39 @@
 
 package worker
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
 
-func newLimitedRunner(*JobLimitConfig) (runner, error) {
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newLimitedRunner(*JobLimitConfig, Metrics, trace.Tracer) (runner, error) {
 	return nil, fmt.Errorf("resource limited runner only supported on linux")
 }
 
+func setCmdCredential(*exec.Cmd, *JobUser) error {
+	return fmt.Errorf("running as user only supported on linux")
+}
+
 func ExecLimitedChild([]string) error {
 	return fmt.Errorf("limited child execution only supported on linux")
 }
+
+func rlimitCmd(*Job, *os.File) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("rlimits only supported on linux")
+}
+
+func ExecRLimitedChild([]string) error {
+	return fmt.Errorf("rlimited child execution only supported on linux")
+}
+
+func signalName(sig syscall.Signal) string {
+	return fmt.Sprintf("SIG%d", int(sig))
+}
+
+func parseSignal(name string) (string, error) {
+	return "", fmt.Errorf("retryable signals only supported on linux")
+}
@@ -0,0 +1,393 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrScheduleNotFound is returned when a schedule does not exist in the
+// namespace.
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// ErrInvalidSchedule is returned (wrapped) from Worker.CreateSchedule if the
+// cron expression, time zone, or overlap policy is invalid.
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// ScheduleOverlap is what to do when a schedule fires while the job from the
+// previous firing is still queued or running.
+type ScheduleOverlap string
+
+const (
+	// ScheduleOverlapSkip does not submit a job for the firing. This is the
+	// default.
+	ScheduleOverlapSkip ScheduleOverlap = "skip"
+	// ScheduleOverlapQueue submits the job once the previous job completes. At
+	// most one firing waits this way, others are skipped.
+	ScheduleOverlapQueue ScheduleOverlap = "queue"
+	// ScheduleOverlapReplace gracefully stops the previous job and submits the
+	// job once it completes.
+	ScheduleOverlapReplace ScheduleOverlap = "replace"
+)
+
+// ScheduleConfig is configuration for a schedule.
+type ScheduleConfig struct {
+	// Cron expression of when to submit the job. See Worker.CreateSchedule for
+	// the supported format.
+	Cron string `json:"cron"`
+	// IANA time zone the cron expression is in. If empty, the worker's local
+	// time zone is used.
+	TimeZone string `json:"time_zone,omitempty"`
+	// What to do if the previous job is still queued or running. If empty,
+	// ScheduleOverlapSkip is used.
+	Overlap ScheduleOverlap `json:"overlap,omitempty"`
+	// Job to submit each time the schedule fires. Each job gets a generated ID.
+	Job JobSpec `json:"job"`
+	// Labels and annotations of each submitted job.
+	JobLabels      map[string]string `json:"job_labels,omitempty"`
+	JobAnnotations map[string]string `json:"job_annotations,omitempty"`
+}
+
+// Schedule submits a job each time a cron expression fires. Callers should
+// never mutate any fields. All visible fields are never changed.
+type Schedule struct {
+	// Namespace for the schedule and its jobs, can be empty string.
+	Namespace string
+	// ID of the schedule, never empty.
+	ID string
+	// Configuration the schedule was created with. The overlap is never empty.
+	ScheduleConfig
+	// Time this schedule was created.
+	CreatedAt time.Time
+
+	cron   *cronSchedule
+	loc    *time.Location
+	cancel context.CancelFunc
+
+	// This mutex governs all fields below it
+	lock      sync.Mutex
+	paused    bool
+	nextRunAt time.Time
+	lastJob   *Job
+	// Whether a firing is waiting on the last job or submitting
+	pending bool
+}
+
+// Paused returns true if the schedule is not submitting jobs when it fires.
+func (s *Schedule) Paused() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.paused
+}
+
+// NextRunAt returns the next time the schedule fires, or a zero time if it
+// never will.
+func (s *Schedule) NextRunAt() time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.nextRunAt
+}
+
+// LastJobID returns the ID of the last job submitted by the schedule, or empty
+// if the schedule has not submitted a job since the worker started.
+func (s *Schedule) LastJobID() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.lastJob == nil {
+		return ""
+	}
+	return s.lastJob.ID
+}
+
+// persistedSchedule is the JSON form of a schedule in the state dir.
+type persistedSchedule struct {
+	Namespace string         `json:"namespace"`
+	ID        string         `json:"id"`
+	Config    ScheduleConfig `json:"config"`
+	CreatedAt time.Time      `json:"created_at"`
+	Paused    bool           `json:"paused,omitempty"`
+}
+
+const schedulesFile = "schedules.json"
+
+// CreateSchedule creates a schedule that submits a job each time the cron
+// expression fires. If the ID is empty one will be created, otherwise it must
+// be unique per namespace or ErrIDAlreadyExists is returned. The cron
+// expression is the standard 5 fields (minute, hour, day of month, month, day
+// of week) supporting "*", values, ranges, steps, lists, and names, or one of
+// @yearly, @monthly, @weekly, @daily, @midnight, or @hourly. The job is
+// validated the same as Worker.SubmitJobSpec. If the worker has a state dir,
+// the schedule is persisted there. This returns ErrShutdown if the worker is
+// shutdown.
+func (w *Worker) CreateSchedule(namespace, id string, config ScheduleConfig, paused bool) (*Schedule, error) {
+	w.shutdownLock.RLock()
+	defer w.shutdownLock.RUnlock()
+	if w.shutdown {
+		return nil, ErrShutdown
+	}
+	if id == "" {
+		id = uuid.New().String()
+	}
+	s, err := w.newSchedule(namespace, id, config, paused, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	// Validate the job with a throwaway copy
+	job := newJob(namespace, id, config.Job)
+	job.labels, job.annotations = config.JobLabels, config.JobAnnotations
+	if err := w.prepareJob(job); err != nil {
+		return nil, err
+	}
+	w.schedulesLock.Lock()
+	defer w.schedulesLock.Unlock()
+	if _, exists := w.schedules[namespace][id]; exists {
+		return nil, ErrIDAlreadyExists
+	}
+	if w.schedules[namespace] == nil {
+		w.schedules[namespace] = map[string]*Schedule{}
+	}
+	w.schedules[namespace][id] = s
+	if err := w.saveSchedules(); err != nil {
+		delete(w.schedules[namespace], id)
+		return nil, err
+	}
+	w.startSchedule(s)
+	return s, nil
+}
+
+// Schedules returns the schedules in the namespace sorted by creation time.
+func (w *Worker) Schedules(namespace string) []*Schedule {
+	w.schedulesLock.Lock()
+	defer w.schedulesLock.Unlock()
+	schedules := make([]*Schedule, 0, len(w.schedules[namespace]))
+	for _, s := range w.schedules[namespace] {
+		schedules = append(schedules, s)
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].CreatedAt.Before(schedules[j].CreatedAt) })
+	return schedules
+}
+
+// PauseSchedule pauses or resumes the schedule. A paused schedule does not
+// submit jobs when it fires, but jobs it already submitted are unaffected. This
+// returns ErrScheduleNotFound if the schedule does not exist.
+func (w *Worker) PauseSchedule(namespace, id string, paused bool) (*Schedule, error) {
+	w.schedulesLock.Lock()
+	defer w.schedulesLock.Unlock()
+	s := w.schedules[namespace][id]
+	if s == nil {
+		return nil, ErrScheduleNotFound
+	}
+	s.lock.Lock()
+	prevPaused := s.paused
+	s.paused = paused
+	s.lock.Unlock()
+	if err := w.saveSchedules(); err != nil {
+		s.lock.Lock()
+		s.paused = prevPaused
+		s.lock.Unlock()
+		return nil, err
+	}
+	return s, nil
+}
+
+// DeleteSchedule deletes the schedule. Jobs it already submitted are
+// unaffected. This returns ErrScheduleNotFound if the schedule does not exist.
+func (w *Worker) DeleteSchedule(namespace, id string) error {
+	w.schedulesLock.Lock()
+	defer w.schedulesLock.Unlock()
+	s := w.schedules[namespace][id]
+	if s == nil {
+		return ErrScheduleNotFound
+	}
+	delete(w.schedules[namespace], id)
+	if err := w.saveSchedules(); err != nil {
+		w.schedules[namespace][id] = s
+		return err
+	}
+	s.cancel()
+	return nil
+}
+
+func (w *Worker) newSchedule(namespace, id string, config ScheduleConfig, paused bool, createdAt time.Time) (*Schedule, error) {
+	s := &Schedule{Namespace: namespace, ID: id, ScheduleConfig: config, CreatedAt: createdAt, paused: paused}
+	var err error
+	if s.cron, err = parseCron(config.Cron); err != nil {
+		return nil, fmt.Errorf("%w: cron: %v", ErrInvalidSchedule, err)
+	}
+	s.loc = time.Local
+	if config.TimeZone != "" {
+		if s.loc, err = time.LoadLocation(config.TimeZone); err != nil {
+			return nil, fmt.Errorf("%w: time zone: %v", ErrInvalidSchedule, err)
+		}
+	}
+	switch s.Overlap {
+	case "":
+		s.Overlap = ScheduleOverlapSkip
+	case ScheduleOverlapSkip, ScheduleOverlapQueue, ScheduleOverlapReplace:
+	default:
+		return nil, fmt.Errorf("%w: unknown overlap %q", ErrInvalidSchedule, s.Overlap)
+	}
+	return s, nil
+}
+
+// startSchedule starts the goroutine that fires the schedule until it is
+// canceled.
+func (w *Worker) startSchedule(s *Schedule) {
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	// Set the next run before starting so it's visible right away
+	updateNextRunAt := func() time.Time {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.nextRunAt = s.cron.next(time.Now().In(s.loc))
+		return s.nextRunAt
+	}
+	next := updateNextRunAt()
+	go func() {
+		for ; !next.IsZero(); next = updateNextRunAt() {
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			w.fireSchedule(ctx, s)
+		}
+		scheduleLogger(w.log, s).Info("Schedule will never fire again")
+	}()
+}
+
+// fireSchedule submits the schedule's job, or waits for the last job to
+// complete first depending on the overlap policy. The job is submitted outside
+// of the schedule lock.
+func (w *Worker) fireSchedule(ctx context.Context, s *Schedule) {
+	s.lock.Lock()
+	last := s.lastJob
+	switch {
+	case s.paused:
+		s.lock.Unlock()
+		return
+	case s.pending:
+		s.lock.Unlock()
+		scheduleLogger(w.log, s).Info("Schedule skipping firing, previous firing still waiting")
+		return
+	case last == nil || last.ExitCode() != nil:
+		s.pending = true
+		s.lock.Unlock()
+		w.submitScheduledJob(s)
+		return
+	case s.Overlap == ScheduleOverlapSkip:
+		s.lock.Unlock()
+		scheduleLogger(w.log, s).Info("Schedule skipping firing, job still active", "job_id", last.ID)
+		return
+	}
+	// Submit once the last job is done
+	s.pending = true
+	s.lock.Unlock()
+	if s.Overlap == ScheduleOverlapReplace {
+		scheduleLogger(w.log, s).Info("Schedule stopping job to replace it", "job_id", last.ID)
+		last.requestStop(false)
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.lock.Lock()
+			s.pending = false
+			s.lock.Unlock()
+			return
+		case <-last.doneCtx.Done():
+		}
+		w.submitScheduledJob(s)
+	}()
+}
+
+// submitScheduledJob submits the schedule's job and clears the pending firing.
+// Caller must not hold the schedule lock.
+func (w *Worker) submitScheduledJob(s *Schedule) {
+	job, err := w.SubmitJobSpec(s.Namespace, "", s.Job, WithLabels(s.JobLabels), WithAnnotations(s.JobAnnotations),
+		func(j *Job) { j.ScheduleID = s.ID })
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pending = false
+	if err != nil {
+		scheduleLogger(w.log, s).Error("Schedule failed submitting job", "error", err)
+		return
+	}
+	s.lastJob = job
+}
+
+// loadSchedules loads and starts the schedules persisted in the state dir.
+func (w *Worker) loadSchedules() error {
+	b, err := os.ReadFile(filepath.Join(w.stateDir, schedulesFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("reading schedules: %w", err)
+	}
+	var persisted []persistedSchedule
+	if err := json.Unmarshal(b, &persisted); err != nil {
+		return fmt.Errorf("parsing schedules: %w", err)
+	}
+	for _, p := range persisted {
+		s, err := w.newSchedule(p.Namespace, p.ID, p.Config, p.Paused, p.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("loading schedule %v:%v: %w", p.Namespace, p.ID, err)
+		}
+		if w.schedules[p.Namespace] == nil {
+			w.schedules[p.Namespace] = map[string]*Schedule{}
+		}
+		w.schedules[p.Namespace][p.ID] = s
+	}
+	for _, byID := range w.schedules {
+		for _, s := range byID {
+			w.startSchedule(s)
+		}
+	}
+	return nil
+}
+
+// saveSchedules persists all schedules to the state dir if there is one.
+// Caller must hold the schedules lock.
+func (w *Worker) saveSchedules() error {
+	if w.stateDir == "" {
+		return nil
+	}
+	persisted := []persistedSchedule{}
+	for _, byID := range w.schedules {
+		for _, s := range byID {
+			persisted = append(persisted, persistedSchedule{
+				Namespace: s.Namespace,
+				ID:        s.ID,
+				Config:    s.ScheduleConfig,
+				CreatedAt: s.CreatedAt,
+				Paused:    s.Paused(),
+			})
+		}
+	}
+	sort.Slice(persisted, func(i, j int) bool { return persisted[i].CreatedAt.Before(persisted[j].CreatedAt) })
+	b, err := json.MarshalIndent(persisted, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeStateFile(filepath.Join(w.stateDir, schedulesFile), b)
+}
+
+// writeStateFile atomically replaces the file with the given bytes.
+func writeStateFile(file string, b []byte) error {
+	tmpFile := file + ".tmp"
+	if err := os.WriteFile(tmpFile, b, 0600); err != nil {
+		return fmt.Errorf("writing %v: %w", tmpFile, err)
+	} else if err := os.Rename(tmpFile, file); err != nil {
+		return fmt.Errorf("renaming %v: %w", tmpFile, err)
+	}
+	return nil
+}
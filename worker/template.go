@@ -0,0 +1,335 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// ErrTemplateNotFound is returned when a template or template version does not
+// exist in the namespace.
+var ErrTemplateNotFound = errors.New("template not found")
+
+// ErrInvalidTemplate is returned (wrapped) from Worker.CreateTemplate if the
+// template is invalid.
+var ErrInvalidTemplate = errors.New("invalid template")
+
+// ErrInvalidTemplateParams is returned (wrapped) from
+// Worker.SubmitFromTemplate if a parameter is unknown or a required one is
+// missing.
+var ErrInvalidTemplateParams = errors.New("invalid template parameters")
+
+var (
+	templateNameRegex        = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]{0,62}[A-Za-z0-9])?$`)
+	templateParamNameRegex   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	templatePlaceholderRegex = regexp.MustCompile(`\{\{\s*([^{}\s]*)\s*\}\}`)
+)
+
+// TemplateConfig is configuration for a job template.
+type TemplateConfig struct {
+	// Job rendered from the template. Args and env values can contain
+	// placeholders of the form "{{name}}" that are replaced with parameter
+	// values on submission.
+	Job JobSpec `json:"job"`
+	// Parameters that can be substituted into the job.
+	Params []TemplateParam `json:"params,omitempty"`
+	// Labels and annotations of each submitted job.
+	JobLabels      map[string]string `json:"job_labels,omitempty"`
+	JobAnnotations map[string]string `json:"job_annotations,omitempty"`
+}
+
+// TemplateParam is a parameter of a job template.
+type TemplateParam struct {
+	// Name of the parameter, which must be letters, digits, and underscores not
+	// starting with a digit.
+	Name string `json:"name"`
+	// If true, a value must be given on submission.
+	Required bool `json:"required,omitempty"`
+	// Value used if none is given on submission. This cannot be set on required
+	// parameters.
+	Default string `json:"default,omitempty"`
+}
+
+// JobTemplate is a version of a named job template. Callers should never mutate
+// any fields. All visible fields are never changed.
+type JobTemplate struct {
+	// Namespace for the template and its jobs, can be empty string.
+	Namespace string
+	// Name of the template, never empty.
+	Name string
+	// 1-based version of the template. Each template created with the same
+	// name gets the next version.
+	Version int
+	// Configuration the template was created with.
+	TemplateConfig
+	// Time this version was created.
+	CreatedAt time.Time
+}
+
+// persistedTemplate is the JSON form of a template version in the state dir.
+type persistedTemplate struct {
+	Namespace string         `json:"namespace"`
+	Name      string         `json:"name"`
+	Version   int            `json:"version"`
+	Config    TemplateConfig `json:"config"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+const templatesFile = "templates.json"
+
+// withTemplate is a submit job option to record the template and version the
+// job was rendered from.
+func withTemplate(t *JobTemplate) SubmitJobOption {
+	return func(j *Job) { j.TemplateName, j.TemplateVersion = t.Name, t.Version }
+}
+
+// CreateTemplate creates the next version of the named template in the
+// namespace, starting at 1. Every placeholder must be a declared parameter and
+// the rendered job is validated the same as Worker.SubmitJobSpec. If the worker
+// has a state dir, the template is persisted there. This returns ErrShutdown if
+// the worker is shutdown.
+func (w *Worker) CreateTemplate(namespace, name string, config TemplateConfig) (*JobTemplate, error) {
+	w.shutdownLock.RLock()
+	defer w.shutdownLock.RUnlock()
+	if w.shutdown {
+		return nil, ErrShutdown
+	}
+	t := &JobTemplate{Namespace: namespace, Name: name, TemplateConfig: config, CreatedAt: time.Now()}
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	// Validate the job with a throwaway copy, rendered with empty values for
+	// required parameters
+	params := map[string]string{}
+	for _, param := range t.Params {
+		if param.Required {
+			params[param.Name] = ""
+		}
+	}
+	spec, err := t.render(params)
+	if err != nil {
+		return nil, err
+	}
+	job := newJob(namespace, name, spec)
+	job.labels, job.annotations = config.JobLabels, config.JobAnnotations
+	if err := w.prepareJob(job); err != nil {
+		return nil, err
+	}
+	w.templatesLock.Lock()
+	defer w.templatesLock.Unlock()
+	if w.templates[namespace] == nil {
+		w.templates[namespace] = map[string][]*JobTemplate{}
+	}
+	versions := w.templates[namespace][name]
+	t.Version = len(versions) + 1
+	w.templates[namespace][name] = append(versions, t)
+	if err := w.saveTemplates(); err != nil {
+		w.templates[namespace][name] = versions
+		return nil, err
+	}
+	return t, nil
+}
+
+// Templates returns every version of the named template in the namespace in
+// version order, or the latest version of every template in the namespace
+// sorted by name if the name is empty.
+func (w *Worker) Templates(namespace, name string) []*JobTemplate {
+	w.templatesLock.RLock()
+	defer w.templatesLock.RUnlock()
+	if name != "" {
+		return append([]*JobTemplate(nil), w.templates[namespace][name]...)
+	}
+	templates := make([]*JobTemplate, 0, len(w.templates[namespace]))
+	for _, versions := range w.templates[namespace] {
+		templates = append(templates, versions[len(versions)-1])
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates
+}
+
+// GetTemplate returns the version of the named template in the namespace, or
+// the latest version if version is 0. This returns ErrTemplateNotFound if the
+// template or version does not exist.
+func (w *Worker) GetTemplate(namespace, name string, version int) (*JobTemplate, error) {
+	w.templatesLock.RLock()
+	defer w.templatesLock.RUnlock()
+	versions := w.templates[namespace][name]
+	if version == 0 {
+		version = len(versions)
+	}
+	if version < 1 || version > len(versions) {
+		return nil, ErrTemplateNotFound
+	}
+	return versions[version-1], nil
+}
+
+// SubmitFromTemplate renders the version of the named template, or the latest
+// if version is 0, with the parameters and submits it the same as
+// Worker.SubmitJobSpec. Options are applied after the template's. This returns
+// ErrTemplateNotFound if the template or version does not exist and a wrapped
+// ErrInvalidTemplateParams if a parameter is unknown or a required one is
+// missing.
+func (w *Worker) SubmitFromTemplate(
+	namespace, id, name string,
+	version int,
+	params map[string]string,
+	opts ...SubmitJobOption,
+) (*Job, error) {
+	t, err := w.GetTemplate(namespace, name, version)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := t.render(params)
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]SubmitJobOption{WithLabels(t.JobLabels), WithAnnotations(t.JobAnnotations), withTemplate(t)}, opts...)
+	return w.SubmitJobSpec(namespace, id, spec, opts...)
+}
+
+func (t *JobTemplate) validate() error {
+	if !templateNameRegex.MatchString(t.Name) {
+		return fmt.Errorf("%w: invalid name %q", ErrInvalidTemplate, t.Name)
+	}
+	declared := map[string]bool{}
+	for _, param := range t.Params {
+		switch {
+		case !templateParamNameRegex.MatchString(param.Name):
+			return fmt.Errorf("%w: invalid parameter name %q", ErrInvalidTemplate, param.Name)
+		case declared[param.Name]:
+			return fmt.Errorf("%w: duplicate parameter %v", ErrInvalidTemplate, param.Name)
+		case param.Required && param.Default != "":
+			return fmt.Errorf("%w: required parameter %v cannot have default", ErrInvalidTemplate, param.Name)
+		}
+		declared[param.Name] = true
+	}
+	check := func(s string) error {
+		for _, match := range templatePlaceholderRegex.FindAllStringSubmatch(s, -1) {
+			if !declared[match[1]] {
+				return fmt.Errorf("%w: undeclared parameter %q", ErrInvalidTemplate, match[1])
+			}
+		}
+		return nil
+	}
+	for _, arg := range t.Job.Args {
+		if err := check(arg); err != nil {
+			return err
+		}
+	}
+	for _, value := range t.Job.Env {
+		if err := check(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// render returns the job with the parameters substituted into the args and env
+// values. Unset parameters use their defaults.
+func (t *JobTemplate) render(params map[string]string) (JobSpec, error) {
+	values := make(map[string]string, len(t.Params))
+	for _, param := range t.Params {
+		value, ok := params[param.Name]
+		if !ok && param.Required {
+			return JobSpec{}, fmt.Errorf("%w: missing required parameter %v", ErrInvalidTemplateParams, param.Name)
+		} else if !ok {
+			value = param.Default
+		}
+		values[param.Name] = value
+	}
+	for name := range params {
+		if _, ok := values[name]; !ok {
+			return JobSpec{}, fmt.Errorf("%w: unknown parameter %v", ErrInvalidTemplateParams, name)
+		}
+	}
+	substitute := func(s string) string {
+		return templatePlaceholderRegex.ReplaceAllStringFunc(s, func(placeholder string) string {
+			return values[templatePlaceholderRegex.FindStringSubmatch(placeholder)[1]]
+		})
+	}
+	// Copy the args and env since we substitute into them
+	spec := t.Job
+	spec.Args = make([]string, len(t.Job.Args))
+	for i, arg := range t.Job.Args {
+		spec.Args[i] = substitute(arg)
+	}
+	if t.Job.Env != nil {
+		spec.Env = make(map[string]string, len(t.Job.Env))
+		for name, value := range t.Job.Env {
+			spec.Env[name] = substitute(value)
+		}
+	}
+	return spec, nil
+}
+
+// loadTemplates loads the templates persisted in the state dir.
+func (w *Worker) loadTemplates() error {
+	b, err := os.ReadFile(filepath.Join(w.stateDir, templatesFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("reading templates: %w", err)
+	}
+	var persisted []persistedTemplate
+	if err := json.Unmarshal(b, &persisted); err != nil {
+		return fmt.Errorf("parsing templates: %w", err)
+	}
+	// Persisted in version order
+	for _, p := range persisted {
+		if w.templates[p.Namespace] == nil {
+			w.templates[p.Namespace] = map[string][]*JobTemplate{}
+		}
+		versions := w.templates[p.Namespace][p.Name]
+		if p.Version != len(versions)+1 {
+			return fmt.Errorf("loading template %v:%v: unexpected version %v", p.Namespace, p.Name, p.Version)
+		}
+		w.templates[p.Namespace][p.Name] = append(versions, &JobTemplate{
+			Namespace:      p.Namespace,
+			Name:           p.Name,
+			Version:        p.Version,
+			TemplateConfig: p.Config,
+			CreatedAt:      p.CreatedAt,
+		})
+	}
+	return nil
+}
+
+// saveTemplates persists all templates to the state dir if there is one.
+// Caller must hold the templates lock.
+func (w *Worker) saveTemplates() error {
+	if w.stateDir == "" {
+		return nil
+	}
+	persisted := []persistedTemplate{}
+	for _, byName := range w.templates {
+		for _, versions := range byName {
+			for _, t := range versions {
+				persisted = append(persisted, persistedTemplate{
+					Namespace: t.Namespace,
+					Name:      t.Name,
+					Version:   t.Version,
+					Config:    t.TemplateConfig,
+					CreatedAt: t.CreatedAt,
+				})
+			}
+		}
+	}
+	sort.Slice(persisted, func(i, j int) bool {
+		if persisted[i].Namespace != persisted[j].Namespace {
+			return persisted[i].Namespace < persisted[j].Namespace
+		} else if persisted[i].Name != persisted[j].Name {
+			return persisted[i].Name < persisted[j].Name
+		}
+		return persisted[i].Version < persisted[j].Version
+	})
+	b, err := json.MarshalIndent(persisted, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeStateFile(filepath.Join(w.stateDir, templatesFile), b)
+}
@@ -0,0 +1,135 @@
+package worker
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTemplateValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template JobTemplate
+		invalid  bool
+	}{
+		{
+			name: "valid",
+			template: JobTemplate{Name: "tmpl", TemplateConfig: TemplateConfig{
+				Job: JobSpec{
+					Command: "echo",
+					Args:    []string{"{{greeting}}, {{ name }}"},
+					Env:     map[string]string{"NAME": "{{name}}"},
+				},
+				Params: []TemplateParam{{Name: "greeting", Default: "hello"}, {Name: "name", Required: true}},
+			}},
+		},
+		{name: "invalid name", template: JobTemplate{Name: "-tmpl"}, invalid: true},
+		{
+			name: "invalid param name",
+			template: JobTemplate{Name: "tmpl", TemplateConfig: TemplateConfig{
+				Params: []TemplateParam{{Name: "1name"}},
+			}},
+			invalid: true,
+		},
+		{
+			name: "duplicate param",
+			template: JobTemplate{Name: "tmpl", TemplateConfig: TemplateConfig{
+				Params: []TemplateParam{{Name: "name"}, {Name: "name"}},
+			}},
+			invalid: true,
+		},
+		{
+			name: "required with default",
+			template: JobTemplate{Name: "tmpl", TemplateConfig: TemplateConfig{
+				Params: []TemplateParam{{Name: "name", Required: true, Default: "foo"}},
+			}},
+			invalid: true,
+		},
+		{
+			name: "undeclared in args",
+			template: JobTemplate{Name: "tmpl", TemplateConfig: TemplateConfig{
+				Job: JobSpec{Command: "echo", Args: []string{"{{name}}"}},
+			}},
+			invalid: true,
+		},
+		{
+			name: "undeclared in env",
+			template: JobTemplate{Name: "tmpl", TemplateConfig: TemplateConfig{
+				Job: JobSpec{Command: "echo", Env: map[string]string{"NAME": "{{name}}"}},
+			}},
+			invalid: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.template.validate()
+			if test.invalid && !errors.Is(err, ErrInvalidTemplate) {
+				t.Fatalf("expected invalid template, got %v", err)
+			} else if !test.invalid && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestTemplateRender(t *testing.T) {
+	template := &JobTemplate{Name: "tmpl", TemplateConfig: TemplateConfig{
+		Job: JobSpec{
+			Command: "echo",
+			Args:    []string{"{{greeting}}, {{ name }}!", "{{name}}{{name}}"},
+			Env:     map[string]string{"NAME": "{{name}}", "FIXED": "fixed"},
+		},
+		Params: []TemplateParam{{Name: "greeting", Default: "hello"}, {Name: "name", Required: true}},
+	}}
+	tests := []struct {
+		name          string
+		params        map[string]string
+		expectedArgs  []string
+		expectedEnv   map[string]string
+		invalidParams bool
+	}{
+		{
+			name:         "defaults",
+			params:       map[string]string{"name": "world"},
+			expectedArgs: []string{"hello, world!", "worldworld"},
+			expectedEnv:  map[string]string{"NAME": "world", "FIXED": "fixed"},
+		},
+		{
+			name:         "all params",
+			params:       map[string]string{"greeting": "hi", "name": "there"},
+			expectedArgs: []string{"hi, there!", "therethere"},
+			expectedEnv:  map[string]string{"NAME": "there", "FIXED": "fixed"},
+		},
+		{
+			name:         "values not substituted again",
+			params:       map[string]string{"greeting": "{{name}}", "name": "x"},
+			expectedArgs: []string{"{{name}}, x!", "xx"},
+			expectedEnv:  map[string]string{"NAME": "x", "FIXED": "fixed"},
+		},
+		{name: "missing required", params: map[string]string{"greeting": "hi"}, invalidParams: true},
+		{name: "unknown", params: map[string]string{"name": "world", "other": "foo"}, invalidParams: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec, err := template.render(test.params)
+			if test.invalidParams {
+				if !errors.Is(err, ErrInvalidTemplateParams) {
+					t.Fatalf("expected invalid params, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(spec.Args, test.expectedArgs) {
+				t.Fatalf("expected args %v, got %v", test.expectedArgs, spec.Args)
+			} else if !reflect.DeepEqual(spec.Env, test.expectedEnv) {
+				t.Fatalf("expected env %v, got %v", test.expectedEnv, spec.Env)
+			}
+		})
+	}
+	// Template is not mutated
+	if template.Job.Args[0] != "{{greeting}}, {{ name }}!" || template.Job.Env["NAME"] != "{{name}}" {
+		t.Fatalf("template mutated: %+v", template.Job)
+	}
+}
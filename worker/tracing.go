@@ -0,0 +1,98 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the OpenTelemetry tracer of the worker.
+const TracerName = "github.com/cretz/teleworker/worker"
+
+// TraceParentEnv is the environment variable the W3C trace context of a job
+// attempt is set as if TracingConfig.InjectTraceParent is set.
+const TraceParentEnv = "TRACEPARENT"
+
+// TracingConfig is configuration for tracing the worker with OpenTelemetry.
+type TracingConfig struct {
+	// Provider of the worker's tracer. If nil, the global provider is used.
+	TracerProvider trace.TracerProvider
+	// If true, the trace context of each job attempt is set as the TRACEPARENT
+	// environment variable of the job unless the job sets it.
+	InjectTraceParent bool
+}
+
+// WithTraceContext is a submit job option to make the spans of the submission
+// and the job's attempts children of the span in the context, if any. Only the
+// span is used from the context, it is never waited on.
+func WithTraceContext(ctx context.Context) SubmitJobOption {
+	return func(j *Job) {
+		j.traceCtx = trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+	}
+}
+
+func newTracer(config TracingConfig) trace.Tracer {
+	provider := config.TracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(TracerName)
+}
+
+// jobAttributes returns the span attributes identifying the job.
+func jobAttributes(j *Job) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("teleworker.namespace", j.Namespace),
+		attribute.String("teleworker.job.id", j.ID),
+	}
+}
+
+// endSpan records the error, if any, on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceParent returns the W3C trace context of the span in the context, or
+// empty if there is no span.
+func traceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier["traceparent"]
+}
+
+// childSetupReport is written by the limited child to its parent once setup
+// completes or fails.
+type childSetupReport struct {
+	CGroupError string `json:"cgroup-error,omitempty"`
+	// Only present if the child was given a trace parent
+	Steps []childSetupStep `json:"steps,omitempty"`
+}
+
+// childSetupStep is a timed setup step of the limited child.
+type childSetupStep struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Error string    `json:"error,omitempty"`
+}
+
+// recordChildSetupSpans records the steps of the report as spans under the
+// span in the context.
+func recordChildSetupSpans(ctx context.Context, tracer trace.Tracer, report *childSetupReport) {
+	for _, step := range report.Steps {
+		_, span := tracer.Start(ctx, "child-exec "+step.Name, trace.WithTimestamp(step.Start))
+		if step.Error != "" {
+			span.SetStatus(codes.Error, step.Error)
+		}
+		span.End(trace.WithTimestamp(step.End))
+	}
+}
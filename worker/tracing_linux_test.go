@@ -0,0 +1,114 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestJobTraceParent(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	w, err := New(Config{Tracing: TracingConfig{
+		TracerProvider:    sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+		InjectTraceParent: true,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Shutdown(context.Background(), true)
+	run := func(env map[string]string) (*Job, string) {
+		job, err := w.SubmitJobSpec("", "", JobSpec{
+			Command: "sh",
+			Args:    []string{"-c", "echo -n $" + TraceParentEnv},
+			Env:     env,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-job.doneCtx.Done()
+		b := make([]byte, 100)
+		n, _, _, err := job.ReadStdout(b, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return job, string(b[:n])
+	}
+
+	// Set to the attempt's span
+	job, traceParent := run(nil)
+	span := waitSpan(t, recorder, "start job attempt", job.ID)
+	expected := fmt.Sprintf("00-%v-%v-01", span.SpanContext().TraceID(), span.SpanContext().SpanID())
+	if traceParent != expected {
+		t.Fatalf("expected %q, got %q", expected, traceParent)
+	}
+	// Not replaced if the job sets it
+	if _, traceParent = run(map[string]string{TraceParentEnv: "mine"}); traceParent != "mine" {
+		t.Fatalf("expected job's own value, got %q", traceParent)
+	}
+}
+
+func TestChildSetupSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	w, err := New(Config{
+		Limits:  &JobLimitConfig{},
+		Tracing: TracingConfig{TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Shutdown(context.Background(), true)
+	job, err := w.SubmitJobSpec("", "", JobSpec{Command: "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-job.doneCtx.Done()
+	if *job.ExitCode() != 0 {
+		t.Fatalf("unexpected exit code %v, start error %v", *job.ExitCode(), job.StartError())
+	}
+
+	// The attempt span only ends after its setup steps are recorded under it
+	parent := waitSpan(t, recorder, "start job attempt", job.ID)
+	var steps []string
+	for _, span := range recorder.Ended() {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			continue
+		}
+		steps = append(steps, span.Name())
+		if span.EndTime().After(parent.EndTime()) {
+			t.Fatalf("span %v ended after its parent", span.Name())
+		}
+	}
+	for _, step := range []string{"set hostname", "apply cgroup limits", "restrict privileges"} {
+		found := false
+		for _, name := range steps {
+			found = found || name == "child-exec "+step
+		}
+		if !found {
+			t.Fatalf("missing step %q in %v", step, steps)
+		}
+	}
+}
+
+// waitSpan waits for the span of the name for the job to end and returns it.
+func waitSpan(t *testing.T, recorder *tracetest.SpanRecorder, name, jobID string) sdktrace.ReadOnlySpan {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, span := range recorder.Ended() {
+			if span.Name() != name {
+				continue
+			}
+			for _, attr := range span.Attributes() {
+				if attr.Key == "teleworker.job.id" && attr.Value.AsString() == jobID {
+					return span
+				}
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for span %v", name)
+	return nil
+}
@@ -0,0 +1,475 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidWebhook is returned (wrapped) on submission if a webhook target of
+// the job is invalid.
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+// Defaults for unset webhook configuration values.
+const (
+	DefaultWebhookMaxAttempts    = 5
+	DefaultWebhookInitialBackoff = time.Second
+	DefaultWebhookTimeout        = 10 * time.Second
+	DefaultMaxWebhookDeliveries  = 1000
+)
+
+// Headers of webhook requests.
+const (
+	// Unique ID of the delivery, the same across its attempts.
+	WebhookDeliveryHeader = "X-Teleworker-Delivery"
+	// Unix seconds the attempt was sent at.
+	WebhookTimestampHeader = "X-Teleworker-Timestamp"
+	// Signature of the attempt as returned from WebhookSignature. This is only
+	// present if the target has a secret.
+	WebhookSignatureHeader = "X-Teleworker-Signature"
+)
+
+// WebhookConfig is configuration for notifying webhooks when jobs complete.
+type WebhookConfig struct {
+	// Targets notified when any job completes.
+	Targets []WebhookTarget
+	// Secret for targets that do not have their own. If empty, those payloads
+	// are not signed.
+	DefaultSecret string
+	// Maximum delivery attempts. If 0, DefaultWebhookMaxAttempts is used.
+	MaxAttempts int
+	// Backoff before the second attempt, doubling each attempt up to a minute.
+	// If 0, DefaultWebhookInitialBackoff is used.
+	InitialBackoff time.Duration
+	// Timeout of each attempt. If 0, DefaultWebhookTimeout is used.
+	Timeout time.Duration
+	// Number of deliveries retained per namespace in the delivery log. If 0,
+	// DefaultMaxWebhookDeliveries is used.
+	MaxDeliveries int
+	// Hosts the targets of individual jobs may use, or "*" for any host. If
+	// empty, jobs cannot have their own targets.
+	JobTargetHosts []string
+	// Whether the targets of individual jobs may connect to loopback,
+	// link-local, private, and unspecified addresses. This is off by default so
+	// submitters cannot reach services internal to the worker's network.
+	AllowPrivateJobTargets bool
+	// HTTP client to deliver with. If nil, clients with the timeout that do not
+	// follow redirects are used, and the one for the targets of individual jobs
+	// refuses private addresses unless AllowPrivateJobTargets is set. If set,
+	// the client is used for all targets as is.
+	Client *http.Client
+}
+
+// WebhookTarget is an HTTP endpoint that receives a POST of a JSON
+// WebhookPayload when a job completes. A 2xx response is a successful
+// delivery, otherwise it is retried.
+type WebhookTarget struct {
+	// Absolute http or https URL.
+	URL string `json:"url"`
+	// Key the payload is signed with. If empty, WebhookConfig.DefaultSecret is
+	// used.
+	Secret string `json:"secret,omitempty"`
+}
+
+func (w *WebhookTarget) validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: URL %q must be absolute http or https", ErrInvalidWebhook, w.URL)
+	}
+	return nil
+}
+
+// validateJobTarget validates a target of an individual job against the
+// allowed hosts.
+func (w *webhookNotifier) validateJobTarget(target *WebhookTarget) error {
+	if err := target.validate(); err != nil {
+		return err
+	}
+	// Already parsed successfully in validate
+	u, _ := url.Parse(target.URL)
+	for _, host := range w.jobTargetHosts {
+		if host == "*" || strings.EqualFold(host, u.Hostname()) {
+			return nil
+		}
+	}
+	if len(w.jobTargetHosts) == 0 {
+		return fmt.Errorf("%w: jobs cannot have their own webhooks", ErrInvalidWebhook)
+	}
+	return fmt.Errorf("%w: host of URL %q not allowed", ErrInvalidWebhook, target.URL)
+}
+
+// WithWebhooks is a submit job option to notify the targets, in addition to
+// the worker's, when the job completes.
+func WithWebhooks(targets ...WebhookTarget) SubmitJobOption {
+	return func(j *Job) { j.Webhooks = targets }
+}
+
+// WebhookPayload is the JSON body sent to webhook targets.
+type WebhookPayload struct {
+	// Always "job.completed".
+	Event       string            `json:"event"`
+	DeliveryID  string            `json:"delivery_id"`
+	Namespace   string            `json:"namespace"`
+	JobID       string            `json:"job_id"`
+	State       string            `json:"state"`
+	ExitCode    int               `json:"exit_code"`
+	Signal      string            `json:"signal,omitempty"`
+	StartError  string            `json:"start_error,omitempty"`
+	Preempted   bool              `json:"preempted,omitempty"`
+	Attempts    int               `json:"attempts"`
+	CreatedAt   time.Time         `json:"created_at"`
+	CompletedAt time.Time         `json:"completed_at"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// WebhookSignature returns the signature header value for a payload sent at
+// the Unix timestamp. It is "sha256=" followed by the hex HMAC-SHA256, keyed by
+// the secret, of the timestamp, a ".", and the body. Receivers should compare
+// it in constant time and reject old timestamps.
+func WebhookSignature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDeliveryStatus is the status of a webhook delivery.
+type WebhookDeliveryStatus int
+
+const (
+	// WebhookDeliveryPending is a delivery still being attempted.
+	WebhookDeliveryPending WebhookDeliveryStatus = iota
+	// WebhookDeliverySucceeded is a delivery that got a 2xx response.
+	WebhookDeliverySucceeded
+	// WebhookDeliveryFailed is a delivery that used all of its attempts.
+	WebhookDeliveryFailed
+)
+
+func (w WebhookDeliveryStatus) String() string {
+	switch w {
+	case WebhookDeliveryPending:
+		return "pending"
+	case WebhookDeliverySucceeded:
+		return "succeeded"
+	case WebhookDeliveryFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("WebhookDeliveryStatus(%d)", int(w))
+}
+
+// WebhookDelivery is the delivery of a job's completion to a webhook target.
+// Callers should never mutate any fields. All visible fields are never
+// changed.
+type WebhookDelivery struct {
+	// ID sent in the payload and header.
+	ID        string
+	Namespace string
+	JobID     string
+	URL       string
+	CreatedAt time.Time
+
+	// This mutex governs all fields below it
+	lock     sync.RWMutex
+	status   WebhookDeliveryStatus
+	attempts []WebhookDeliveryAttempt
+}
+
+// WebhookDeliveryAttempt is a single request of a webhook delivery.
+type WebhookDeliveryAttempt struct {
+	Time     time.Time
+	Duration time.Duration
+	// HTTP status code of the response, or 0 if there was none.
+	StatusCode int
+	// If set, why the attempt failed.
+	Error string
+}
+
+// Status returns the current status of the delivery.
+func (w *WebhookDelivery) Status() WebhookDeliveryStatus {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.status
+}
+
+// Attempts returns the attempts of the delivery so far in order.
+func (w *WebhookDelivery) Attempts() []WebhookDeliveryAttempt {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return append([]WebhookDeliveryAttempt(nil), w.attempts...)
+}
+
+// webhookNotifier delivers job completions to webhook targets and retains the
+// delivery log.
+type webhookNotifier struct {
+	targets        []WebhookTarget
+	jobTargetHosts []string
+	defaultSecret  string
+	retry          RetryPolicy
+	client         *http.Client
+	jobClient      *http.Client
+	maxDeliveries  int
+	// Canceled on worker shutdown to abandon deliveries
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock sync.RWMutex
+	// Keyed by namespace, in order of creation
+	deliveries map[string][]*WebhookDelivery
+}
+
+func newWebhookNotifier(config WebhookConfig) (*webhookNotifier, error) {
+	for _, target := range config.Targets {
+		if err := target.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if config.MaxAttempts < 0 || config.InitialBackoff < 0 || config.Timeout < 0 || config.MaxDeliveries < 0 {
+		return nil, fmt.Errorf("webhook values cannot be negative")
+	}
+	w := &webhookNotifier{
+		targets:        config.Targets,
+		jobTargetHosts: config.JobTargetHosts,
+		defaultSecret:  config.DefaultSecret,
+		retry: RetryPolicy{
+			MaxAttempts:    config.MaxAttempts,
+			InitialBackoff: config.InitialBackoff,
+			MaxBackoff:     time.Minute,
+			Jitter:         0.2,
+		},
+		client:        config.Client,
+		jobClient:     config.Client,
+		maxDeliveries: config.MaxDeliveries,
+		deliveries:    map[string][]*WebhookDelivery{},
+	}
+	if w.retry.MaxAttempts == 0 {
+		w.retry.MaxAttempts = DefaultWebhookMaxAttempts
+	}
+	if w.retry.InitialBackoff == 0 {
+		w.retry.InitialBackoff = DefaultWebhookInitialBackoff
+	}
+	if w.retry.InitialBackoff > w.retry.MaxBackoff {
+		w.retry.MaxBackoff = w.retry.InitialBackoff
+	}
+	if err := w.retry.prepare(); err != nil {
+		return nil, err
+	}
+	if w.client == nil {
+		timeout := config.Timeout
+		if timeout == 0 {
+			timeout = DefaultWebhookTimeout
+		}
+		w.client = newWebhookClient(timeout, true)
+		w.jobClient = newWebhookClient(timeout, config.AllowPrivateJobTargets)
+	}
+	if w.maxDeliveries == 0 {
+		w.maxDeliveries = DefaultMaxWebhookDeliveries
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	return w, nil
+}
+
+// newWebhookClient creates a client that does not follow redirects and, unless
+// allowing private addresses, only connects to public ones.
+func newWebhookClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	client := &http.Client{
+		Timeout: timeout,
+		// A redirect could point anywhere, so the response is the result
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	if !allowPrivate {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// A proxy would make the connected address meaningless
+		transport.Proxy = nil
+		// Check the address actually connected to, after resolution
+		transport.DialContext = (&net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				} else if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+					return fmt.Errorf("connecting to private address %v not allowed", host)
+				}
+				return nil
+			},
+		}).DialContext
+		client.Transport = transport
+	}
+	return client
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsPrivate() || ip.IsUnspecified()
+}
+
+// close abandons pending deliveries.
+func (w *webhookNotifier) close() {
+	w.cancel()
+}
+
+// notifyOnDone waits for the job to complete, then delivers to the worker's
+// and the job's targets.
+func (w *webhookNotifier) notifyOnDone(job *Job) {
+	select {
+	case <-w.ctx.Done():
+		return
+	case <-job.doneCtx.Done():
+	}
+	for _, target := range w.targets {
+		go w.deliver(job, target, w.client)
+	}
+	for _, target := range job.Webhooks {
+		go w.deliver(job, target, w.jobClient)
+	}
+}
+
+// deliver records a delivery of the completed job to the target and attempts
+// it with the client until it succeeds, runs out of attempts, or the worker is
+// shut down.
+func (w *webhookNotifier) deliver(job *Job, target WebhookTarget, client *http.Client) {
+	if target.Secret == "" {
+		target.Secret = w.defaultSecret
+	}
+	delivery := &WebhookDelivery{
+		ID:        uuid.New().String(),
+		Namespace: job.Namespace,
+		JobID:     job.ID,
+		URL:       target.URL,
+		CreatedAt: time.Now(),
+	}
+	w.lock.Lock()
+	w.deliveries[job.Namespace] = append(w.deliveries[job.Namespace], delivery)
+	if len(w.deliveries[job.Namespace]) > w.maxDeliveries {
+		w.deliveries[job.Namespace][0] = nil
+		w.deliveries[job.Namespace] = w.deliveries[job.Namespace][1:]
+	}
+	w.lock.Unlock()
+	body, err := json.Marshal(newWebhookPayload(job, delivery.ID))
+	if err != nil {
+		// Should never happen
+		job.log.Error("Failed marshaling webhook payload", "error", err)
+		return
+	}
+	for n := 1; ; n++ {
+		attempt := w.attempt(client, delivery.ID, target, body)
+		delivery.lock.Lock()
+		delivery.attempts = append(delivery.attempts, attempt)
+		done := attempt.Error == "" || n >= w.retry.MaxAttempts || w.ctx.Err() != nil
+		if attempt.Error == "" {
+			delivery.status = WebhookDeliverySucceeded
+		} else if done {
+			delivery.status = WebhookDeliveryFailed
+		}
+		delivery.lock.Unlock()
+		if done {
+			if attempt.Error != "" {
+				job.log.Warn("Failed delivering webhook", "url", target.URL, "attempts", n, "error", attempt.Error)
+			}
+			return
+		}
+		timer := time.NewTimer(w.retry.backoff(n))
+		select {
+		case <-w.ctx.Done():
+			timer.Stop()
+			delivery.lock.Lock()
+			delivery.status = WebhookDeliveryFailed
+			delivery.lock.Unlock()
+			job.log.Warn("Abandoned webhook delivery on shutdown", "url", target.URL, "attempts", n)
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt sends the body to the target once with the client.
+func (w *webhookNotifier) attempt(
+	client *http.Client,
+	deliveryID string,
+	target WebhookTarget,
+	body []byte,
+) (attempt WebhookDeliveryAttempt) {
+	attempt.Time = time.Now()
+	defer func() { attempt.Duration = time.Since(attempt.Time) }()
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := attempt.Time.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookDeliveryHeader, deliveryID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	if target.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, WebhookSignature(target.Secret, timestamp, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	// Drain some of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = "unexpected status " + resp.Status
+	}
+	return attempt
+}
+
+func newWebhookPayload(job *Job, deliveryID string) *WebhookPayload {
+	payload := &WebhookPayload{
+		Event:       "job.completed",
+		DeliveryID:  deliveryID,
+		Namespace:   job.Namespace,
+		JobID:       job.ID,
+		State:       job.State().String(),
+		ExitCode:    *job.ExitCode(),
+		Preempted:   job.Preempted(),
+		CreatedAt:   job.CreatedAt,
+		CompletedAt: job.CompletedAt(),
+		Labels:      job.Labels(),
+	}
+	if err := job.StartError(); err != nil {
+		payload.StartError = err.Error()
+	}
+	attempts := job.Attempts()
+	payload.Attempts = len(attempts)
+	if len(attempts) > 0 {
+		payload.Signal = attempts[len(attempts)-1].Signal()
+	}
+	return payload
+}
+
+// WebhookDeliveries returns the retained webhook deliveries in the namespace,
+// or only those of the job if the job ID is not empty, in order of creation.
+func (w *Worker) WebhookDeliveries(namespace, jobID string) []*WebhookDelivery {
+	w.webhooks.lock.RLock()
+	defer w.webhooks.lock.RUnlock()
+	var deliveries []*WebhookDelivery
+	for _, delivery := range w.webhooks.deliveries[namespace] {
+		if jobID == "" || delivery.JobID == jobID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries
+}
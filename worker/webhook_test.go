@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookValidateJobTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		hosts   []string
+		url     string
+		invalid bool
+	}{
+		{name: "no hosts", url: "https://example.com/hook", invalid: true},
+		{name: "any host", hosts: []string{"*"}, url: "https://example.com/hook"},
+		{name: "listed host", hosts: []string{"other.com", "Example.com"}, url: "https://example.com:8443/hook"},
+		{name: "unlisted host", hosts: []string{"other.com"}, url: "https://example.com/hook", invalid: true},
+		{name: "invalid URL", hosts: []string{"*"}, url: "ftp://example.com", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, err := newWebhookNotifier(WebhookConfig{JobTargetHosts: test.hosts})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = w.validateJobTarget(&WebhookTarget{URL: test.url})
+			if test.invalid && !errors.Is(err, ErrInvalidWebhook) {
+				t.Fatalf("expected invalid webhook, got %v", err)
+			} else if !test.invalid && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestWebhookPrivateIP(t *testing.T) {
+	for _, ip := range []string{"127.0.0.1", "::1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254",
+		"fe80::1", "fd00::1", "0.0.0.0", "::", "::ffff:127.0.0.1"} {
+		if !isPrivateIP(net.ParseIP(ip)) {
+			t.Fatalf("expected %v to be private", ip)
+		}
+	}
+	for _, ip := range []string{"8.8.8.8", "2001:4860:4860::8888"} {
+		if isPrivateIP(net.ParseIP(ip)) {
+			t.Fatalf("expected %v to be public", ip)
+		}
+	}
+}
+
+func TestWebhookAbandonedOnShutdown(t *testing.T) {
+	httpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer httpSrv.Close()
+	// Backoff long enough that only shutdown ends the delivery
+	w, err := New(Config{Webhooks: WebhookConfig{
+		Targets:        []WebhookTarget{{URL: httpSrv.URL}},
+		InitialBackoff: time.Hour,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := w.SubmitJobSpec("", "", JobSpec{Command: "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery := waitWebhookDelivery(t, w, job, func(d *WebhookDelivery) bool { return len(d.Attempts()) == 1 })
+	if err := w.Shutdown(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	waitWebhookDelivery(t, w, job, func(d *WebhookDelivery) bool { return d.Status() == WebhookDeliveryFailed })
+	if attempts := delivery.Attempts(); len(attempts) != 1 {
+		t.Fatalf("expected no attempts after shutdown, got %v", attempts)
+	}
+}
+
+func waitWebhookDelivery(t *testing.T, w *Worker, job *Job, cond func(*WebhookDelivery) bool) *WebhookDelivery {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if deliveries := w.WebhookDeliveries(job.Namespace, job.ID); len(deliveries) == 1 && cond(deliveries[0]) {
+			return deliveries[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for webhook delivery")
+	return nil
+}
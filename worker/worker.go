@@ -5,18 +5,53 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Worker represents a worker that can manage jobs.
 type Worker struct {
-	runner    runner
-	hasLimits bool
+	runner     runner
+	hasLimits  bool
+	namespaces map[string]NamespaceConfig
+	nsDefaults NamespaceConfig
+	maxRLimits JobRLimits
+	// CPU cores and memory bytes each job is limited to, or 0 if unlimited
+	jobCPU    float64
+	jobMemory uint64
+	queue     *jobQueue
 	// Keyed by namespace, then ID
-	jobs     map[string]map[string]*Job
+	jobs map[string]map[string]*Job
+	// Keyed by namespace, in order of completion
+	completedJobs map[string][]*Job
+	// Keyed by config namespace, guarded by the jobs lock
+	usage    map[string]*namespaceUsage
 	jobsLock sync.RWMutex
+	stateDir string
+	// Keyed by namespace, then ID
+	schedules     map[string]map[string]*Schedule
+	schedulesLock sync.Mutex
+	// Keyed by namespace, then ID
+	workflows     map[string]map[string]*Workflow
+	workflowsLock sync.RWMutex
+	// Keyed by namespace, then name, in version order
+	templates     map[string]map[string][]*JobTemplate
+	templatesLock sync.RWMutex
+	idempotency   *idempotencyIndex
+	events        *eventBus
+	webhooks      *webhookNotifier
+	metrics       Metrics
+	tracer        trace.Tracer
+	tracing       TracingConfig
+	log           *slog.Logger
 
 	shutdown     bool
 	shutdownLock sync.RWMutex
@@ -26,6 +61,96 @@ type Worker struct {
 type Config struct {
 	// If nil, jobs will not have any limits placed.
 	Limits *JobLimitConfig
+	// Configuration keyed by namespace. Namespaces not present here share the
+	// configuration of their nearest ancestor present here, e.g.
+	// "team1/project1" shares that of "team1", or if there is none, that of
+	// their top-level namespace which uses NamespaceDefaults. Namespaces sharing
+	// configuration also share its quota and maximum concurrent jobs.
+	Namespaces map[string]NamespaceConfig
+	// Configuration for namespaces not present in Namespaces.
+	NamespaceDefaults NamespaceConfig
+	// Maximum number of jobs running at once across all namespaces. Jobs
+	// submitted beyond this are queued. If 0, there is no maximum.
+	MaxConcurrentJobs int
+	// If true, a job that is queued may cause a running job with lower priority
+	// to be gracefully stopped to make room for it. The stopped job is not
+	// requeued.
+	Preemption bool
+	// Directory to persist state such as schedules and templates in. It is
+	// created if it does not exist. If empty, nothing is persisted.
+	StateDir string
+	// How long idempotency keys of submitted jobs are retained. If 0,
+	// DefaultIdempotencyKeyTTL is used.
+	IdempotencyKeyTTL time.Duration
+	// Number of job events retained per namespace for watchers to resume from.
+	// If 0, DefaultJobEventHistory is used.
+	JobEventHistory int
+	// Webhooks notified when jobs complete.
+	Webhooks WebhookConfig
+	// Receives measurements of jobs. If nil, nothing is measured.
+	Metrics Metrics
+	// OpenTelemetry tracing of submissions and job attempts.
+	Tracing TracingConfig
+	// Logger for the worker and its jobs. If nil, nothing is logged.
+	Logger *slog.Logger
+}
+
+// NamespaceConfig is configuration for jobs in a namespace.
+type NamespaceConfig struct {
+	// Host users that jobs in the namespace may run as. The first is used for
+	// jobs that do not request a user. If empty, jobs run as the user of the
+	// worker and cannot request a user.
+	Users []JobUser `json:"users,omitempty"`
+	// Maximum number of jobs in the namespace running at once. Jobs submitted
+	// beyond this are queued. If 0, there is no maximum other than
+	// Config.MaxConcurrentJobs.
+	MaxConcurrentJobs int `json:"max_concurrent_jobs,omitempty"`
+	// Weight of the namespace when sharing capacity with other namespaces. A
+	// namespace with twice the weight of another gets twice as many running
+	// jobs when both have jobs queued. If 0, the weight is 1.
+	Weight int `json:"weight,omitempty"`
+	// Limits on what the namespace can use.
+	Quota NamespaceQuota `json:"quota,omitempty"`
+	// How long completed jobs in the namespace are retained after they complete
+	// unless they have their own TTL. If 0, they are retained until deleted or
+	// evicted. In JSON, this is a duration string such as "1h30m".
+	CompletedJobRetention time.Duration `json:"-"`
+	// Maximum number of completed jobs retained in the namespace. The jobs that
+	// completed first are evicted beyond this. If 0, there is no maximum.
+	MaxCompletedJobs int `json:"max_completed_jobs,omitempty"`
+}
+
+// JobUser is a host user that jobs can run as.
+type JobUser struct {
+	// Name used to request this user on submission. This is unique per namespace
+	// but does not have to match the user name on the host.
+	Name string `json:"name"`
+	// Host user ID.
+	UID uint32 `json:"uid"`
+	// Host group ID.
+	GID uint32 `json:"gid"`
+	// Host supplementary group IDs.
+	Groups []uint32 `json:"groups,omitempty"`
+}
+
+func (n *NamespaceConfig) validate() error {
+	names := map[string]bool{}
+	for _, user := range n.Users {
+		if user.Name == "" {
+			return fmt.Errorf("user name required")
+		} else if names[user.Name] {
+			return fmt.Errorf("duplicate user %v", user.Name)
+		}
+		names[user.Name] = true
+	}
+	if n.MaxConcurrentJobs < 0 {
+		return fmt.Errorf("max concurrent jobs cannot be negative")
+	} else if n.Weight < 0 {
+		return fmt.Errorf("weight cannot be negative")
+	} else if n.CompletedJobRetention < 0 || n.MaxCompletedJobs < 0 {
+		return fmt.Errorf("completed job retention cannot be negative")
+	}
+	return nil
 }
 
 // StandardConfig is a commonly used configuration for limiting jobs.
@@ -45,22 +170,90 @@ var StandardConfig = Config{
 			Network: true,
 			Mount:   true,
 		},
+		Security: JobSecurity{
+			NoNewPrivileges: true,
+		},
+		RLimits: JobRLimits{
+			NoFile: &JobRLimit{Soft: 1024, Hard: 4096},
+		},
 	},
 }
 
 // New creates a new worker from the given configuration. Note, any config
 // pointers/references may be mutated internally (e.g. the device io max map).
 func New(config Config) (*Worker, error) {
-	w := &Worker{hasLimits: config.Limits != nil, jobs: map[string]map[string]*Job{}}
+	w := &Worker{
+		hasLimits:     config.Limits != nil,
+		namespaces:    config.Namespaces,
+		nsDefaults:    config.NamespaceDefaults,
+		jobs:          map[string]map[string]*Job{},
+		completedJobs: map[string][]*Job{},
+		usage:         map[string]*namespaceUsage{},
+		stateDir:      config.StateDir,
+		schedules:     map[string]map[string]*Schedule{},
+		workflows:     map[string]map[string]*Workflow{},
+		templates:     map[string]map[string][]*JobTemplate{},
+	}
+	if config.Limits != nil {
+		if err := config.Limits.RLimits.validate(); err != nil {
+			return nil, fmt.Errorf("invalid max rlimits: %w", err)
+		}
+		w.maxRLimits = config.Limits.RLimits
+		if limits := config.Limits.ResourceLimits; limits.CPUMaxPeriod > 0 {
+			w.jobCPU = float64(limits.CPUMaxQuota) / float64(limits.CPUMaxPeriod)
+		}
+		w.jobMemory = config.Limits.ResourceLimits.MemoryMax
+	}
+	for ns, nsConfig := range w.namespaces {
+		if err := nsConfig.validate(); err != nil {
+			return nil, fmt.Errorf("invalid config for namespace %q: %w", ns, err)
+		} else if err := nsConfig.Quota.validate(w.jobCPU, w.jobMemory); err != nil {
+			return nil, fmt.Errorf("invalid quota for namespace %q: %w", ns, err)
+		}
+	}
+	if err := w.nsDefaults.validate(); err != nil {
+		return nil, fmt.Errorf("invalid namespace defaults: %w", err)
+	} else if err := w.nsDefaults.Quota.validate(w.jobCPU, w.jobMemory); err != nil {
+		return nil, fmt.Errorf("invalid namespace default quota: %w", err)
+	}
+	if config.MaxConcurrentJobs < 0 {
+		return nil, fmt.Errorf("max concurrent jobs cannot be negative")
+	} else if config.IdempotencyKeyTTL < 0 {
+		return nil, fmt.Errorf("idempotency key TTL cannot be negative")
+	} else if config.JobEventHistory < 0 {
+		return nil, fmt.Errorf("job event history cannot be negative")
+	}
+	w.events = newEventBus(config.JobEventHistory)
+	if w.metrics = config.Metrics; w.metrics == nil {
+		w.metrics = NopMetrics{}
+	}
+	w.tracer, w.tracing = newTracer(config.Tracing), config.Tracing
+	if w.log = config.Logger; w.log == nil {
+		w.log = nopLogger
+	}
+	var err error
+	if w.webhooks, err = newWebhookNotifier(config.Webhooks); err != nil {
+		return nil, fmt.Errorf("invalid webhook config: %w", err)
+	}
+	w.idempotency = newIdempotencyIndex(config.IdempotencyKeyTTL)
+	w.queue = newJobQueue(config.MaxConcurrentJobs, w.configNamespace, w.namespaceConfig, config.Preemption, w.metrics)
 	// Only use limited runner when resource limits are set
 	if w.hasLimits {
-		var err error
-		if w.runner, err = newLimitedRunner(config.Limits); err != nil {
+		if w.runner, err = newLimitedRunner(config.Limits, w.metrics, w.tracer); err != nil {
 			return nil, fmt.Errorf("creating limited runner: %w", err)
 		}
 	} else {
 		w.runner = newRunner()
 	}
+	if w.stateDir != "" {
+		if err := os.MkdirAll(w.stateDir, 0700); err != nil {
+			return nil, fmt.Errorf("creating state dir: %w", err)
+		} else if err := w.loadSchedules(); err != nil {
+			return nil, err
+		} else if err := w.loadTemplates(); err != nil {
+			return nil, err
+		}
+	}
 	return w, nil
 }
 
@@ -71,12 +264,16 @@ var ErrShutdown = errors.New("worker shutdown")
 // exists.
 var ErrIDAlreadyExists = errors.New("ID already exists")
 
+// ErrUserNotAllowed is returned from Worker.SubmitJob if the requested user is
+// not allowed for the namespace.
+var ErrUserNotAllowed = errors.New("user not allowed")
+
 // GetJob returns a job for the given namespace and ID, or nil with no error if
 // not found. This returns ErrShutdown if the worker is shutdown. Callers should
 // not mutate any fields on the resulting job.
 func (w *Worker) GetJob(namespace, id string) (*Job, error) {
 	w.shutdownLock.RLock()
-	defer w.shutdownLock.RLock()
+	defer w.shutdownLock.RUnlock()
 	if w.shutdown {
 		return nil, ErrShutdown
 	}
@@ -85,6 +282,38 @@ func (w *Worker) GetJob(namespace, id string) (*Job, error) {
 	return w.jobs[namespace][id], nil
 }
 
+// NamespaceJobCounts is the number of retained jobs in a namespace by state.
+type NamespaceJobCounts struct {
+	Namespace string
+	// States without jobs are not present.
+	Counts map[JobState]int
+}
+
+// Namespaces returns the job counts of every namespace with retained jobs,
+// sorted by namespace.
+func (w *Worker) Namespaces() ([]*NamespaceJobCounts, error) {
+	w.shutdownLock.RLock()
+	defer w.shutdownLock.RUnlock()
+	if w.shutdown {
+		return nil, ErrShutdown
+	}
+	w.jobsLock.RLock()
+	defer w.jobsLock.RUnlock()
+	namespaces := make([]*NamespaceJobCounts, 0, len(w.jobs))
+	for namespace, jobs := range w.jobs {
+		if len(jobs) == 0 {
+			continue
+		}
+		counts := &NamespaceJobCounts{Namespace: namespace, Counts: map[JobState]int{}}
+		for _, job := range jobs {
+			counts.Counts[job.State()]++
+		}
+		namespaces = append(namespaces, counts)
+	}
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Namespace < namespaces[j].Namespace })
+	return namespaces, nil
+}
+
 // SubmitJobOption represents an option for Worker.SubmitJob
 type SubmitJobOption func(*Job)
 
@@ -94,12 +323,143 @@ func WithRootFS(root string) SubmitJobOption {
 	return func(j *Job) { j.RootFS = root }
 }
 
+// WithUser is a submit job option to run the job as the named host user. The
+// user must be configured for the namespace or ErrUserNotAllowed is returned.
+func WithUser(name string) SubmitJobOption {
+	return func(j *Job) { j.User = name }
+}
+
+// WithRLimits is a submit job option to set POSIX resource limits for the job.
+// On a worker with job limits, unset limits default to the configured maximums
+// and set ones cannot exceed them or an error wrapping ErrInvalidRLimits is
+// returned. On a worker without job limits, the executable must handle
+// rlimit-exec by calling ExecRLimitedChild the same way it must handle
+// child-exec for limited workers.
+func WithRLimits(rlimits JobRLimits) SubmitJobOption {
+	return func(j *Job) { j.RLimits = rlimits }
+}
+
+// WithHostname is a submit job option to set the hostname of the job. It must
+// be a valid hostname or an error wrapping ErrInvalidHostname is returned. This
+// cannot be set on a worker configured without job limits.
+func WithHostname(hostname string) SubmitJobOption {
+	return func(j *Job) { j.Hostname = hostname }
+}
+
+// WithPriority is a submit job option to set the priority of the job. Queued
+// jobs with higher priority start before others in the namespace and, if the
+// worker has preemption enabled, may preempt running jobs with lower priority.
+func WithPriority(priority int) SubmitJobOption {
+	return func(j *Job) { j.Priority = priority }
+}
+
+// WithEnv is a submit job option to set environment variables of the job.
+// Names must be non-empty and cannot contain '=' or NUL and values cannot
+// contain NUL or an error wrapping ErrInvalidEnv is returned.
+func WithEnv(env map[string]string) SubmitJobOption {
+	return func(j *Job) { j.Env = env }
+}
+
+// ErrInvalidHostname is returned (wrapped) from Worker.SubmitJob if the
+// hostname is invalid.
+var ErrInvalidHostname = errors.New("invalid hostname")
+
+// ErrInvalidEnv is returned (wrapped) from Worker.SubmitJob if an environment
+// variable is invalid.
+var ErrInvalidEnv = errors.New("invalid env")
+
+func validateEnv(env map[string]string) error {
+	for name, value := range env {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return fmt.Errorf("%w: invalid name %q", ErrInvalidEnv, name)
+		} else if strings.Contains(value, "\x00") {
+			return fmt.Errorf("%w: value of %v contains NUL", ErrInvalidEnv, name)
+		}
+	}
+	return nil
+}
+
+// validateHostname confirms the hostname is 1-64 characters of only letters,
+// digits, hyphens, and dots with each dot-separated label non-empty and not
+// starting or ending with a hyphen.
+func validateHostname(hostname string) error {
+	if len(hostname) == 0 || len(hostname) > 64 {
+		return fmt.Errorf("%w: must be 1 to 64 characters", ErrInvalidHostname)
+	}
+	for _, label := range strings.Split(hostname, ".") {
+		if label == "" || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("%w: labels must be non-empty and not start or end with hyphen", ErrInvalidHostname)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') && r != '-' {
+				return fmt.Errorf("%w: invalid character %q", ErrInvalidHostname, r)
+			}
+		}
+	}
+	return nil
+}
+
+// defaultHostname returns the ID if it is a valid hostname or the ID with
+// every non-alphanumeric character replaced by a hyphen and truncated as needed
+// otherwise.
+func defaultHostname(id string) string {
+	if validateHostname(id) == nil {
+		return id
+	}
+	hostname := strings.Trim(strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '-'
+	}, id), "-")
+	if len(hostname) > 64 {
+		hostname = strings.TrimRight(hostname[:64], "-")
+	}
+	if hostname == "" {
+		return "job"
+	}
+	return hostname
+}
+
+// configNamespace returns the namespace whose configuration the namespace
+// shares. This is the namespace itself or its nearest ancestor if either is
+// configured, otherwise its top-level namespace.
+func (w *Worker) configNamespace(namespace string) string {
+	for ancestor := namespace; ; {
+		if _, ok := w.namespaces[ancestor]; ok {
+			return ancestor
+		}
+		i := strings.LastIndex(ancestor, "/")
+		if i < 0 {
+			return ancestor
+		}
+		ancestor = ancestor[:i]
+	}
+}
+
+// namespaceConfig returns the configuration for the given namespace.
+func (w *Worker) namespaceConfig(namespace string) *NamespaceConfig {
+	if nsConfig, ok := w.namespaces[w.configNamespace(namespace)]; ok {
+		return &nsConfig
+	}
+	return &w.nsDefaults
+}
+
 // SubmitJob submits a job to run on the worker. If the ID is empty one will be
 // created, otherwise it must be unique per namespace or ErrIDAlreadyExists is
 // returned. Namespace can be empty. This returns ErrShutdown if the worker is
-// shutdown. If the job is successfully started, it is returned with PID.
-// Otherwise an error is returned.
+// shutdown. If there is capacity to run the job, it is started and returned
+// with PID or an error is returned if it cannot start. Otherwise the job is
+// returned queued and any failure to start it later is available via
+// Job.StartError. If the submission would exceed the namespace quota, a
+// QuotaExceededError is returned.
 func (w *Worker) SubmitJob(namespace, id, command string, args []string, opts ...SubmitJobOption) (*Job, error) {
+	return w.SubmitJobSpec(namespace, id, JobSpec{Command: command, Args: args}, opts...)
+}
+
+// SubmitJobSpec is SubmitJob with a job specification. Options are applied
+// after the specification.
+func (w *Worker) SubmitJobSpec(namespace, id string, spec JobSpec, opts ...SubmitJobOption) (_ *Job, err error) {
 	// Lock shutdown for life of the submission
 	w.shutdownLock.RLock()
 	defer w.shutdownLock.RUnlock()
@@ -107,49 +467,301 @@ func (w *Worker) SubmitJob(namespace, id, command string, args []string, opts ..
 		return nil, ErrShutdown
 	}
 	// Make unique ID if not there
+	requestedID := id
 	if id == "" {
 		id = uuid.New().String()
 	}
-	// Put nil in the map to confirm ID not in use and hold ID spot
+	// Create job with options
+	job := newJob(namespace, id, spec)
+	for _, opt := range opts {
+		opt(job)
+	}
+	// Return the existing job if the idempotency key was already used
+	if job.IdempotencyKey != "" {
+		var existing *Job
+		var entry *idempotencyEntry
+		existing, entry, err = w.idempotency.reserve(namespace, job.IdempotencyKey,
+			idempotentRequest{ID: requestedID, Spec: job.JobSpec, Dependencies: job.Dependencies,
+				Labels: job.labels, Annotations: job.annotations})
+		if err != nil {
+			return nil, err
+		} else if existing != nil {
+			return existing, nil
+		}
+		// Record the job, or release the key on failure, once submitted
+		defer func() {
+			if err != nil {
+				w.idempotency.complete(entry, nil)
+			} else {
+				w.idempotency.complete(entry, job)
+			}
+		}()
+	}
+	if err = w.submitJobs(namespace, []*Job{job}); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// submitJobs validates and launches all jobs in the namespace or none of them.
+// Dependencies must either be other jobs in the set or existing jobs. If there
+// is one job and it fails to start, an error is returned, otherwise start
+// failures are set on the jobs and validation errors are wrapped with the job
+// ID. The submission is traced as a child of the first job's trace context and
+// the jobs' spans become children of it. Caller must hold the shutdown read
+// lock.
+func (w *Worker) submitJobs(namespace string, jobs []*Job) error {
+	name := "Worker.SubmitJob"
+	attrs := []attribute.KeyValue{attribute.String("teleworker.namespace", namespace)}
+	if len(jobs) == 1 {
+		attrs = append(attrs, attribute.String("teleworker.job.id", jobs[0].ID))
+	} else {
+		name = "Worker.SubmitJobs"
+		attrs = append(attrs, attribute.Int("teleworker.job.count", len(jobs)))
+	}
+	ctx, span := w.tracer.Start(jobs[0].traceCtx, name, trace.WithAttributes(attrs...))
+	for _, job := range jobs {
+		job.traceCtx = ctx
+	}
+	err := w.addJobs(ctx, namespace, jobs)
+	endSpan(span, err)
+	return err
+}
+
+// addJobs is submitJobs within its span.
+func (w *Worker) addJobs(ctx context.Context, namespace string, jobs []*Job) error {
+	// Validate before reserving so the quota includes the jobs' limits
+	_, prepareSpan := w.tracer.Start(ctx, "prepare jobs")
+	var err error
+	for _, job := range jobs {
+		if err = w.prepareJob(job); err != nil && len(jobs) > 1 {
+			err = fmt.Errorf("job %v: %w", job.ID, err)
+			break
+		} else if err != nil {
+			break
+		}
+	}
+	endSpan(prepareSpan, err)
+	if err != nil {
+		return err
+	}
+	// Put nil in the map to confirm IDs not in use and hold ID spots
+	_, reserveSpan := w.tracer.Start(ctx, "reserve job IDs")
 	w.jobsLock.Lock()
-	_, exists := w.jobs[namespace][id]
-	if !exists {
-		if w.jobs[namespace] == nil {
-			w.jobs[namespace] = map[string]*Job{}
+	if w.jobs[namespace] == nil {
+		w.jobs[namespace] = map[string]*Job{}
+	}
+	var reserved []string
+	for _, job := range jobs {
+		if _, exists := w.jobs[namespace][job.ID]; exists {
+			err = ErrIDAlreadyExists
+			if len(jobs) > 1 {
+				err = fmt.Errorf("job %v: %w", job.ID, err)
+			}
+			break
 		}
-		w.jobs[namespace][id] = nil
+		w.jobs[namespace][job.ID] = nil
+		reserved = append(reserved, job.ID)
 	}
-	w.jobsLock.Unlock()
-	if exists {
-		return nil, ErrIDAlreadyExists
+	if err == nil {
+		err = w.reserveUsageLocked(namespace, jobs)
 	}
-	// Remove ID from job map on failure
+	if err == nil {
+		err = w.resolveDependencies(namespace, jobs)
+	}
+	w.jobsLock.Unlock()
+	endSpan(reserveSpan, err)
+	// Remove IDs from job map and jobs from usage on failure
 	success := false
 	defer func() {
 		if !success {
 			w.jobsLock.Lock()
 			defer w.jobsLock.Unlock()
-			delete(w.jobs[namespace], id)
+			for _, id := range reserved {
+				delete(w.jobs[namespace], id)
+			}
+			for _, job := range jobs {
+				w.releaseUsageLocked(job)
+			}
 		}
 	}()
-	// Create job with options
-	job := newJob(namespace, id, command, args...)
-	for _, opt := range opts {
-		opt(job)
+	if err != nil {
+		return err
 	}
-	if !w.hasLimits && job.RootFS != "" {
-		return nil, fmt.Errorf("cannot set root FS on non-limited worker")
+	// Launch the jobs, leaving ones with dependencies pending
+	for _, job := range jobs {
+		job.events, job.metrics, job.log = w.events, w.metrics, jobLogger(w.log, job)
+		job.injectTraceParent = w.tracing.InjectTraceParent
 	}
-	// Attempt to start job
-	if err := w.runner.start(job); err != nil {
-		return nil, fmt.Errorf("starting job: %w", err)
+	for _, job := range jobs {
+		if len(job.dependencyJobs) > 0 {
+			job.setState(JobStatePending)
+			go w.awaitDependencies(job)
+		} else if err := w.launchJob(job); err != nil && len(jobs) == 1 {
+			return err
+		} else if err != nil {
+			job.markDoneWithState(JobStateCompleted, -1, err)
+		}
 	}
-	// Add to map and return
+	// Add to map
 	w.jobsLock.Lock()
-	w.jobs[namespace][id] = job
+	for _, job := range jobs {
+		w.jobs[namespace][job.ID] = job
+		w.metrics.JobSubmitted(namespace)
+		go w.retainJob(job)
+		if len(w.webhooks.targets) > 0 || len(job.Webhooks) > 0 {
+			go w.webhooks.notifyOnDone(job)
+		}
+	}
 	w.jobsLock.Unlock()
 	success = true
-	return job, nil
+	return nil
+}
+
+// launchJob starts the job if there is capacity, otherwise leaves it queued.
+// An error is only returned if the job fails to start.
+func (w *Worker) launchJob(job *Job) error {
+	if w.queue.reserveOrEnqueue(job) {
+		if err := w.startJob(job); err != nil {
+			w.releaseJob(job)
+			return fmt.Errorf("starting job: %w", err)
+		}
+	} else {
+		job.publishEvent(JobEventQueued)
+		go w.cancelOnStop(job)
+	}
+	return nil
+}
+
+// prepareJob validates the job and applies worker defaults.
+func (w *Worker) prepareJob(job *Job) error {
+	if job.Command == "" {
+		return fmt.Errorf("command required")
+	}
+	if !w.hasLimits && job.RootFS != "" {
+		return fmt.Errorf("cannot set root FS on non-limited worker")
+	}
+	if !w.hasLimits && job.Resources != (JobResources{}) {
+		return fmt.Errorf("%w: cannot set resources on non-limited worker", ErrInvalidResources)
+	}
+	if !w.hasLimits && job.Hostname != "" {
+		return fmt.Errorf("cannot set hostname on non-limited worker")
+	} else if w.hasLimits && job.Hostname == "" {
+		job.Hostname = defaultHostname(job.ID)
+	} else if job.Hostname != "" {
+		if err := validateHostname(job.Hostname); err != nil {
+			return err
+		}
+	}
+	if job.TTL < 0 {
+		return fmt.Errorf("TTL cannot be negative")
+	} else if err := validateEnv(job.Env); err != nil {
+		return err
+	} else if err := validateLabels(job.labels); err != nil {
+		return err
+	} else if err := validateAnnotations(job.annotations); err != nil {
+		return err
+	}
+	for _, target := range job.Webhooks {
+		if err := w.webhooks.validateJobTarget(&target); err != nil {
+			return err
+		}
+	}
+	if err := job.RLimits.applyMax(&w.maxRLimits); err != nil {
+		return err
+	}
+	if job.RetryPolicy != nil {
+		// Copy since we apply defaults
+		policy := *job.RetryPolicy
+		if err := policy.prepare(); err != nil {
+			return err
+		}
+		job.RetryPolicy = &policy
+	}
+	// Resolve the host user, defaulting to the first one
+	if users := w.namespaceConfig(job.Namespace).Users; job.User == "" && len(users) > 0 {
+		job.User, job.hostUser = users[0].Name, &users[0]
+	} else if job.User != "" {
+		for _, user := range users {
+			if user.Name == job.User {
+				user := user
+				job.hostUser = &user
+				break
+			}
+		}
+		if job.hostUser == nil {
+			return ErrUserNotAllowed
+		}
+	}
+	if err := w.runner.prepare(job); err != nil {
+		return fmt.Errorf("preparing job: %w", err)
+	}
+	return nil
+}
+
+// QueuePosition returns the 1-based position of the job among queued jobs in
+// its namespace, or 0 if the job is not queued.
+func (w *Worker) QueuePosition(job *Job) int {
+	return w.queue.queuePosition(job)
+}
+
+// startJob starts an attempt of a job that has a reserved slot and releases
+// the slot when the attempt completes.
+func (w *Worker) startJob(job *Job) error {
+	ctx, span := w.tracer.Start(job.traceCtx, "start job attempt", trace.WithAttributes(jobAttributes(job)...))
+	setupDone, err := w.runner.start(ctx, job)
+	if err != nil {
+		endSpan(span, err)
+		return err
+	}
+	attempts := job.Attempts()
+	attempt := attempts[len(attempts)-1]
+	span.SetAttributes(attribute.Int("teleworker.job.attempt", attempt.Number),
+		attribute.Int("teleworker.job.pid", attempt.PID()))
+	// End the span once the runner is done recording setup under it
+	if setupDone == nil {
+		span.End()
+	} else {
+		go func() {
+			<-setupDone
+			span.End()
+		}()
+	}
+	job.log.Debug("Started job attempt", "attempt", attempt.Number, "pid", attempt.PID())
+	go func() {
+		<-attempt.done
+		job.log.Debug("Job attempt completed", "attempt", attempt.Number, "pid", attempt.PID(),
+			"exit_code", *attempt.ExitCode(), "signal", attempt.Signal())
+		w.releaseJob(job)
+		w.completeAttempt(job, attempt)
+	}()
+	return nil
+}
+
+// releaseJob releases the slot of a job and starts any queued jobs that can
+// now run.
+func (w *Worker) releaseJob(job *Job) {
+	for _, queued := range w.queue.release(job) {
+		if err := w.startJob(queued); err != nil {
+			queued.log.Error("Failed starting queued job", "error", err)
+			queued.markDoneWithState(JobStateCompleted, -1, fmt.Errorf("starting job: %w", err))
+			w.releaseJob(queued)
+		}
+	}
+}
+
+// cancelOnStop cancels a queued job if it is stopped before it starts.
+func (w *Worker) cancelOnStop(job *Job) {
+	select {
+	case <-job.stopCtx.Done():
+	case <-job.forceStopCtx.Done():
+	case <-job.doneCtx.Done():
+		return
+	}
+	// If it is no longer queued, the runner handles the stop
+	if w.queue.remove(job) {
+		job.markDoneWithState(JobStateCanceled, -1, nil)
+	}
 }
 
 // Shutdown stops all jobs via Job.Stop, waits for all jobs to finish or context
@@ -168,6 +780,20 @@ func (w *Worker) Shutdown(ctx context.Context, force bool) error {
 		// multiple times
 		return ErrShutdown
 	}
+	// Abandon webhook deliveries once jobs are stopped or we give up waiting
+	defer w.webhooks.close()
+	// Stop firing schedules
+	w.schedulesLock.Lock()
+	for _, byID := range w.schedules {
+		for _, s := range byID {
+			s.cancel()
+		}
+	}
+	w.schedulesLock.Unlock()
+	// Cancel all queued jobs so none start
+	for _, job := range w.queue.close() {
+		job.markDoneWithState(JobStateCanceled, -1, nil)
+	}
 	w.jobsLock.Lock()
 	jobs := w.jobs
 	w.jobs = nil
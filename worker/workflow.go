@@ -0,0 +1,307 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrDependencyNotFound is returned (wrapped) on submission if a job depends on
+// a job that does not exist in the namespace.
+var ErrDependencyNotFound = errors.New("dependency not found")
+
+// ErrInvalidWorkflow is returned (wrapped) from Worker.SubmitWorkflow if the
+// workflow has no jobs or duplicate job IDs, or on any submission with a
+// dependency cycle or unknown dependency condition.
+var ErrInvalidWorkflow = errors.New("invalid workflow")
+
+// DependencyCondition is the condition a dependency must meet for the
+// dependent job to start.
+type DependencyCondition string
+
+const (
+	// DependencyOnSuccess requires the dependency to complete with a 0 exit
+	// code. This is the default.
+	DependencyOnSuccess DependencyCondition = "success"
+	// DependencyOnFailure requires the dependency to complete with a non-zero
+	// exit code or fail to start.
+	DependencyOnFailure DependencyCondition = "failure"
+	// DependencyAlways only requires the dependency to be done, including if it
+	// was canceled.
+	DependencyAlways DependencyCondition = "always"
+)
+
+// JobDependency is a job that must complete before a dependent job can start.
+type JobDependency struct {
+	// ID of the job in the same namespace.
+	JobID string
+	// Condition the job must meet. If empty, DependencyOnSuccess is used.
+	Condition DependencyCondition
+}
+
+// satisfiedBy returns nil if the completed job meets the condition, otherwise
+// the reason it does not.
+func (d *JobDependency) satisfiedBy(job *Job) error {
+	state, exitCode := job.State(), *job.ExitCode()
+	switch d.Condition {
+	case DependencyAlways:
+		return nil
+	case DependencyOnFailure:
+		if state == JobStateCanceled {
+			return fmt.Errorf("dependency %v was canceled", job.ID)
+		} else if exitCode == 0 {
+			return fmt.Errorf("dependency %v succeeded", job.ID)
+		}
+		return nil
+	default:
+		if state == JobStateCanceled {
+			return fmt.Errorf("dependency %v was canceled", job.ID)
+		} else if exitCode != 0 {
+			return fmt.Errorf("dependency %v failed with exit code %v", job.ID, exitCode)
+		}
+		return nil
+	}
+}
+
+// WithDependencies is a submit job option to hold the job pending until the
+// given existing jobs in the namespace complete. If any dependency does not
+// meet its condition, the job is canceled, which in turn cancels jobs that
+// depend on its success.
+func WithDependencies(dependencies ...JobDependency) SubmitJobOption {
+	return func(j *Job) { j.Dependencies = dependencies }
+}
+
+// WorkflowJob is a job to submit as part of a workflow.
+type WorkflowJob struct {
+	// ID of the job, which must be unique in the namespace. If empty, one is
+	// generated but other jobs cannot depend on it.
+	ID string
+	// Specification of the job.
+	JobSpec
+	// Jobs in the workflow or existing jobs in the namespace that must complete
+	// before this job can start.
+	Dependencies []JobDependency
+	// Labels and annotations of the job.
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// Workflow is a set of jobs submitted together. Callers should never mutate
+// any fields. All visible fields are never changed.
+type Workflow struct {
+	// Namespace for the workflow and its jobs, can be empty string.
+	Namespace string
+	// ID of the workflow, never empty.
+	ID string
+	// Jobs of the workflow in submission order.
+	Jobs []*Job
+	// Time this workflow was created.
+	CreatedAt time.Time
+
+	// Guarded by the worker's workflows lock. The workflow is removed once it is
+	// submitted and none of its jobs remain.
+	submitted     bool
+	remainingJobs int
+}
+
+// SubmitWorkflow submits a set of jobs that can depend on each other. Either
+// all jobs are submitted or none are. If the ID is empty one will be created,
+// otherwise it must be unique per namespace or ErrIDAlreadyExists is returned.
+// Jobs with dependencies are pending until their dependencies complete. Jobs
+// that fail to start have the error set on the job instead of failing the
+// submission. The workflow is removed once all of its jobs are removed. This
+// returns ErrShutdown if the worker is shutdown.
+func (w *Worker) SubmitWorkflow(namespace, id string, jobs []WorkflowJob) (*Workflow, error) {
+	w.shutdownLock.RLock()
+	defer w.shutdownLock.RUnlock()
+	if w.shutdown {
+		return nil, ErrShutdown
+	}
+	if id == "" {
+		id = uuid.New().String()
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("%w: no jobs", ErrInvalidWorkflow)
+	}
+	workflow := &Workflow{Namespace: namespace, ID: id, CreatedAt: time.Now(), remainingJobs: len(jobs)}
+	byID := map[string]*Job{}
+	for _, workflowJob := range jobs {
+		jobID := workflowJob.ID
+		if jobID == "" {
+			jobID = uuid.New().String()
+		} else if byID[jobID] != nil {
+			return nil, fmt.Errorf("%w: duplicate job ID %v", ErrInvalidWorkflow, jobID)
+		}
+		job := newJob(namespace, jobID, workflowJob.JobSpec)
+		job.WorkflowID, job.Dependencies = id, workflowJob.Dependencies
+		job.labels, job.annotations = copyLabels(workflowJob.Labels), copyLabels(workflowJob.Annotations)
+		byID[jobID] = job
+		workflow.Jobs = append(workflow.Jobs, job)
+	}
+	// Reserve the workflow ID, then submit. Jobs may be removed before this
+	// returns, so they are counted from the start.
+	w.workflowsLock.Lock()
+	if _, exists := w.workflows[namespace][id]; exists {
+		w.workflowsLock.Unlock()
+		return nil, ErrIDAlreadyExists
+	}
+	if w.workflows[namespace] == nil {
+		w.workflows[namespace] = map[string]*Workflow{}
+	}
+	w.workflows[namespace][id] = workflow
+	w.workflowsLock.Unlock()
+	err := w.submitJobs(namespace, workflow.Jobs)
+	w.workflowsLock.Lock()
+	defer w.workflowsLock.Unlock()
+	if err != nil || workflow.remainingJobs <= 0 {
+		w.deleteWorkflowLocked(workflow)
+	}
+	if err != nil {
+		return nil, err
+	}
+	workflow.submitted = true
+	return workflow, nil
+}
+
+// GetWorkflow returns a workflow for the given namespace and ID, or nil with no
+// error if not found. This returns ErrShutdown if the worker is shutdown.
+func (w *Worker) GetWorkflow(namespace, id string) (*Workflow, error) {
+	w.shutdownLock.RLock()
+	defer w.shutdownLock.RUnlock()
+	if w.shutdown {
+		return nil, ErrShutdown
+	}
+	w.workflowsLock.RLock()
+	defer w.workflowsLock.RUnlock()
+	if workflow := w.workflows[namespace][id]; workflow != nil && workflow.submitted {
+		return workflow, nil
+	}
+	return nil, nil
+}
+
+// removeWorkflowJob removes the workflow of the removed job if none of its jobs
+// remain.
+func (w *Worker) removeWorkflowJob(job *Job) {
+	w.workflowsLock.Lock()
+	defer w.workflowsLock.Unlock()
+	workflow := w.workflows[job.Namespace][job.WorkflowID]
+	if workflow == nil {
+		return
+	}
+	if workflow.remainingJobs--; workflow.remainingJobs <= 0 && workflow.submitted {
+		w.deleteWorkflowLocked(workflow)
+	}
+}
+
+// deleteWorkflowLocked deletes the workflow. Caller must hold the workflows
+// lock.
+func (w *Worker) deleteWorkflowLocked(workflow *Workflow) {
+	delete(w.workflows[workflow.Namespace], workflow.ID)
+	if len(w.workflows[workflow.Namespace]) == 0 {
+		delete(w.workflows, workflow.Namespace)
+	}
+}
+
+// checkDependencyCycles returns an error if any jobs depend on each other in a
+// cycle. Dependencies not in byID are ignored since existing jobs cannot depend
+// on new ones.
+func checkDependencyCycles(jobs []*Job, byID map[string]*Job) error {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := map[*Job]int{}
+	var visit func(job *Job) error
+	visit = func(job *Job) error {
+		switch marks[job] {
+		case visiting:
+			return fmt.Errorf("%w: dependency cycle at job %v", ErrInvalidWorkflow, job.ID)
+		case visited:
+			return nil
+		}
+		marks[job] = visiting
+		for _, dep := range job.Dependencies {
+			if depJob := byID[dep.JobID]; depJob != nil {
+				if err := visit(depJob); err != nil {
+					return err
+				}
+			}
+		}
+		marks[job] = visited
+		return nil
+	}
+	for _, job := range jobs {
+		if err := visit(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveDependencies sets the dependency jobs of each job from the set or the
+// existing jobs and confirms there are no cycles. Caller must hold the jobs
+// lock and have reserved the IDs of the set.
+func (w *Worker) resolveDependencies(namespace string, jobs []*Job) error {
+	byID := make(map[string]*Job, len(jobs))
+	for _, job := range jobs {
+		byID[job.ID] = job
+	}
+	for _, job := range jobs {
+		// Copy since we default the conditions
+		job.Dependencies = append([]JobDependency(nil), job.Dependencies...)
+		job.dependencyJobs = make([]*Job, len(job.Dependencies))
+		for i, dep := range job.Dependencies {
+			switch dep.Condition {
+			case "":
+				job.Dependencies[i].Condition = DependencyOnSuccess
+			case DependencyOnSuccess, DependencyOnFailure, DependencyAlways:
+			default:
+				return fmt.Errorf("%w: unknown dependency condition %q", ErrInvalidWorkflow, dep.Condition)
+			}
+			if job.dependencyJobs[i] = byID[dep.JobID]; job.dependencyJobs[i] == nil {
+				job.dependencyJobs[i] = w.jobs[namespace][dep.JobID]
+			}
+			if job.dependencyJobs[i] == nil {
+				return fmt.Errorf("%w: %v", ErrDependencyNotFound, dep.JobID)
+			}
+		}
+	}
+	return checkDependencyCycles(jobs, byID)
+}
+
+// awaitDependencies waits for the dependencies of the pending job to complete,
+// then launches or cancels it.
+func (w *Worker) awaitDependencies(job *Job) {
+	for _, depJob := range job.dependencyJobs {
+		select {
+		case <-depJob.doneCtx.Done():
+		case <-job.stopCtx.Done():
+			job.markDoneWithState(JobStateCanceled, -1, nil)
+			return
+		case <-job.forceStopCtx.Done():
+			job.markDoneWithState(JobStateCanceled, -1, nil)
+			return
+		}
+	}
+	for i, dep := range job.Dependencies {
+		if err := dep.satisfiedBy(job.dependencyJobs[i]); err != nil {
+			job.log.Info("Canceling job with unmet dependency", "error", err)
+			job.markDoneWithState(JobStateCanceled, -1, err)
+			return
+		}
+	}
+	// Do not launch if shutdown in the meantime
+	w.shutdownLock.RLock()
+	defer w.shutdownLock.RUnlock()
+	if w.shutdown {
+		job.markDoneWithState(JobStateCanceled, -1, ErrShutdown)
+		return
+	}
+	job.setState(JobStateQueued)
+	if err := w.launchJob(job); err != nil {
+		job.log.Error("Failed starting job", "error", err)
+		job.markDoneWithState(JobStateCompleted, -1, err)
+	}
+}
@@ -0,0 +1,247 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWorkflowConditions(t *testing.T) {
+	w, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Shutdown(context.Background(), true)
+	dep := func(id string, condition DependencyCondition) []JobDependency {
+		return []JobDependency{{JobID: id, Condition: condition}}
+	}
+	workflow, err := w.SubmitWorkflow("", "", []WorkflowJob{
+		{ID: "succeed", JobSpec: JobSpec{Command: "true"}},
+		{ID: "fail", JobSpec: JobSpec{Command: "false"}},
+		// Default condition is success
+		{ID: "after-success", JobSpec: JobSpec{Command: "true"}, Dependencies: dep("succeed", "")},
+		{ID: "success-of-failed", JobSpec: JobSpec{Command: "true"}, Dependencies: dep("fail", DependencyOnSuccess)},
+		{ID: "after-failure", JobSpec: JobSpec{Command: "true"}, Dependencies: dep("fail", DependencyOnFailure)},
+		{ID: "failure-of-succeeded", JobSpec: JobSpec{Command: "true"}, Dependencies: dep("succeed", DependencyOnFailure)},
+		{ID: "always-after-failure", JobSpec: JobSpec{Command: "true"}, Dependencies: dep("fail", DependencyAlways)},
+		// Cancellation cascades to jobs depending on success or failure
+		{ID: "cascade-success", JobSpec: JobSpec{Command: "true"}, Dependencies: dep("success-of-failed", "")},
+		{
+			ID:           "cascade-failure",
+			JobSpec:      JobSpec{Command: "true"},
+			Dependencies: dep("cascade-success", DependencyOnFailure),
+		},
+		// But not to ones that always run
+		{
+			ID:           "always-after-canceled",
+			JobSpec:      JobSpec{Command: "true"},
+			Dependencies: dep("cascade-failure", DependencyAlways),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]JobState{
+		"succeed":               JobStateCompleted,
+		"fail":                  JobStateCompleted,
+		"after-success":         JobStateCompleted,
+		"success-of-failed":     JobStateCanceled,
+		"after-failure":         JobStateCompleted,
+		"failure-of-succeeded":  JobStateCanceled,
+		"always-after-failure":  JobStateCompleted,
+		"cascade-success":       JobStateCanceled,
+		"cascade-failure":       JobStateCanceled,
+		"always-after-canceled": JobStateCompleted,
+	}
+	for _, job := range workflow.Jobs {
+		waitDone(t, job)
+		if state := job.State(); state != expected[job.ID] {
+			t.Fatalf("expected %v to be %v, got %v", job.ID, expected[job.ID], state)
+		} else if state == JobStateCanceled && (job.StartError() == nil || *job.ExitCode() != -1) {
+			t.Fatalf("expected %v canceled with reason, got %v", job.ID, job.StartError())
+		}
+	}
+	// Dependents only start after their dependencies complete
+	byID := map[string]*Job{}
+	for _, job := range workflow.Jobs {
+		byID[job.ID] = job
+	}
+	if byID["after-success"].Attempts()[0].StartedAt.Before(byID["succeed"].CompletedAt()) {
+		t.Fatal("expected dependent to start after dependency completed")
+	}
+	if found, err := w.GetWorkflow("", workflow.ID); err != nil || found != workflow {
+		t.Fatalf("expected workflow, got %v, %v", found, err)
+	}
+}
+
+func TestWorkflowInvalid(t *testing.T) {
+	w, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Shutdown(context.Background(), true)
+	existing, err := w.SubmitJob("", "existing", "true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := func(id string, deps ...string) WorkflowJob {
+		workflowJob := WorkflowJob{ID: id, JobSpec: JobSpec{Command: "true"}}
+		for _, dep := range deps {
+			workflowJob.Dependencies = append(workflowJob.Dependencies, JobDependency{JobID: dep})
+		}
+		return workflowJob
+	}
+	tests := []struct {
+		name     string
+		jobs     []WorkflowJob
+		expected error
+	}{
+		{name: "no jobs", expected: ErrInvalidWorkflow},
+		{name: "duplicate ID", jobs: []WorkflowJob{job("a"), job("a")}, expected: ErrInvalidWorkflow},
+		{name: "self cycle", jobs: []WorkflowJob{job("a", "a")}, expected: ErrInvalidWorkflow},
+		{name: "cycle", jobs: []WorkflowJob{job("a", "c"), job("b", "a"), job("c", "b")}, expected: ErrInvalidWorkflow},
+		{name: "unknown dependency", jobs: []WorkflowJob{job("a"), job("b", "unknown")}, expected: ErrDependencyNotFound},
+		{
+			name: "unknown condition",
+			jobs: []WorkflowJob{job("a"), {
+				ID:           "b",
+				JobSpec:      JobSpec{Command: "true"},
+				Dependencies: []JobDependency{{JobID: "a", Condition: "sometimes"}},
+			}},
+			expected: ErrInvalidWorkflow,
+		},
+		{name: "existing job ID", jobs: []WorkflowJob{job("a"), job("existing")}, expected: ErrIDAlreadyExists},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := w.SubmitWorkflow("", "wf1", test.jobs); !errors.Is(err, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, err)
+			}
+			// Nothing is submitted and the ID can be reused
+			if job, _ := w.GetJob("", "a"); job != nil {
+				t.Fatal("expected no jobs submitted")
+			} else if workflow, _ := w.GetWorkflow("", "wf1"); workflow != nil {
+				t.Fatal("expected no workflow")
+			}
+		})
+	}
+
+	// Existing jobs can be depended on and a valid workflow takes the ID
+	workflow, err := w.SubmitWorkflow("", "wf1", []WorkflowJob{job("a", "existing")})
+	if err != nil {
+		t.Fatal(err)
+	} else if workflow.Jobs[0].dependencyJobs[0] != existing {
+		t.Fatal("expected existing job as dependency")
+	}
+	if _, err := w.SubmitWorkflow("", "wf1", []WorkflowJob{job("b")}); err != ErrIDAlreadyExists {
+		t.Fatalf("expected ID already exists, got %v", err)
+	}
+	// Single jobs are also checked
+	_, err = w.SubmitJob("", "", "true", nil, WithDependencies(JobDependency{JobID: "unknown"}))
+	if !errors.Is(err, ErrDependencyNotFound) {
+		t.Fatalf("expected dependency not found, got %v", err)
+	}
+}
+
+func TestWorkflowPending(t *testing.T) {
+	w, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Shutdown(context.Background(), true)
+	workflow, err := w.SubmitWorkflow("", "", []WorkflowJob{
+		{ID: "upstream", JobSpec: JobSpec{Command: "sleep", Args: []string{"10"}}},
+		{ID: "downstream", JobSpec: JobSpec{Command: "true"}, Dependencies: []JobDependency{{JobID: "upstream"}}},
+		{ID: "stopped", JobSpec: JobSpec{Command: "true"}, Dependencies: []JobDependency{{JobID: "upstream"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream, downstream, stopped := workflow.Jobs[0], workflow.Jobs[1], workflow.Jobs[2]
+	if downstream.State() != JobStatePending || downstream.ExitCode() != nil {
+		t.Fatalf("expected downstream pending, got %v", downstream.State())
+	}
+	// Stopping a pending job cancels it without waiting on the dependency
+	if code, err := stopped.Stop(context.Background(), false); err != nil || code != -1 {
+		t.Fatalf("expected canceled job, got %v, %v", code, err)
+	} else if stopped.State() != JobStateCanceled {
+		t.Fatalf("expected canceled, got %v", stopped.State())
+	}
+	if downstream.State() != JobStatePending || upstream.State() != JobStateRunning {
+		t.Fatalf("expected others unaffected, got %v and %v", downstream.State(), upstream.State())
+	}
+	// Failing upstream cancels downstream
+	if _, err := upstream.Stop(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	waitDone(t, downstream)
+	if downstream.State() != JobStateCanceled {
+		t.Fatalf("expected downstream canceled, got %v", downstream.State())
+	} else if len(downstream.Attempts()) != 0 {
+		t.Fatal("expected downstream never started")
+	}
+}
+
+func TestWorkflowRemoved(t *testing.T) {
+	w, err := New(Config{Namespaces: map[string]NamespaceConfig{"evicting": {MaxCompletedJobs: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Shutdown(context.Background(), true)
+	jobs := []WorkflowJob{{ID: "a", JobSpec: JobSpec{Command: "true"}}, {ID: "b", JobSpec: JobSpec{Command: "true"}}}
+
+	// Remains until its last job is deleted
+	workflow, err := w.SubmitWorkflow("", "wf1", jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range workflow.Jobs {
+		waitDone(t, job)
+	}
+	if err := w.DeleteJob("", "a"); err != nil {
+		t.Fatal(err)
+	} else if found, _ := w.GetWorkflow("", "wf1"); found != workflow {
+		t.Fatal("expected workflow with remaining job")
+	}
+	if err := w.DeleteJob("", "b"); err != nil {
+		t.Fatal(err)
+	} else if found, _ := w.GetWorkflow("", "wf1"); found != nil {
+		t.Fatal("expected workflow removed")
+	}
+
+	// Also removed when its jobs are evicted
+	workflow, err = w.SubmitWorkflow("evicting", "wf1", jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range workflow.Jobs {
+		waitDone(t, job)
+	}
+	other, err := w.SubmitJob("evicting", "", "true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitDone(t, other)
+	for _, job := range workflow.Jobs {
+		waitRemoved(t, w, job)
+	}
+	if found, _ := w.GetWorkflow("evicting", "wf1"); found != nil {
+		t.Fatal("expected evicted workflow removed")
+	}
+	w.workflowsLock.RLock()
+	defer w.workflowsLock.RUnlock()
+	if len(w.workflows) != 0 {
+		t.Fatalf("expected no workflows, got %v", w.workflows)
+	}
+}
+
+// waitDone waits for the job to complete.
+func waitDone(t *testing.T, job *Job) {
+	t.Helper()
+	select {
+	case <-job.doneCtx.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for job %v", job.ID)
+	}
+}
@@ -0,0 +1,520 @@
+package workergrpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// DefaultAuditMaxSize is the default for AuditConfig.MaxSize.
+	DefaultAuditMaxSize = 100 * 1024 * 1024
+	// DefaultAuditMaxFiles is the default for AuditConfig.MaxFiles.
+	DefaultAuditMaxFiles = 5
+	// DefaultAuditQueryLimit is the number of entries returned by QueryAudit
+	// when the request has no limit.
+	DefaultAuditQueryLimit = 100
+	// MaxAuditQueryLimit is the most entries returned by QueryAudit.
+	MaxAuditQueryLimit = 1000
+)
+
+// Request JSON beyond this size is truncated in audit entries.
+const maxAuditRequestSize = 4096
+
+const auditRedacted = "REDACTED"
+
+// ErrAuditLogClosed is returned when recording to a closed audit log.
+var ErrAuditLogClosed = errors.New("audit log closed")
+
+// AuditConfig is configuration for an audit log.
+type AuditConfig struct {
+	// Required path of the JSON lines file. When the file is rotated, it is
+	// renamed with a ".1" suffix and older rotated files have their suffix
+	// incremented.
+	Path string
+	// Size in bytes the file may reach before it is rotated. If 0,
+	// DefaultAuditMaxSize is used.
+	MaxSize int64
+	// Number of rotated files kept, the oldest are removed beyond this. If 0,
+	// DefaultAuditMaxFiles is used.
+	MaxFiles int
+	// Logger for failures to record entries. If nil, nothing is logged.
+	Logger *slog.Logger
+	// By default, a call whose entry cannot be recorded is only logged and
+	// proceeds. If true, every call is also recorded when it starts, and if
+	// that fails the call is rejected with Unavailable before it runs. A
+	// failure to record the outcome of a call that already ran is still only
+	// logged.
+	FailClosed bool
+	// Whether granted namespaces also grant their descendants when deciding
+	// if a call is across namespaces. This should match whether the service
+	// uses WithNamespaceHierarchy.
+	NamespaceHierarchy bool
+}
+
+// AuditLog is an append-only, rotating JSON lines file recording every call to
+// the server. Use AuditServerOptions to record calls and WithAuditLog to
+// support QueryAudit.
+type AuditLog struct {
+	config AuditConfig
+	file   *os.File
+	size   int64
+	closed bool
+	lock   sync.Mutex
+}
+
+// OpenAuditLog opens the audit log file, creating it if it does not exist.
+// Close must be called when done.
+func OpenAuditLog(config AuditConfig) (*AuditLog, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("audit log path required")
+	} else if config.MaxSize < 0 {
+		return nil, fmt.Errorf("audit log max size cannot be negative")
+	} else if config.MaxFiles < 0 {
+		return nil, fmt.Errorf("audit log max files cannot be negative")
+	}
+	if config.MaxSize == 0 {
+		config.MaxSize = DefaultAuditMaxSize
+	}
+	if config.MaxFiles == 0 {
+		config.MaxFiles = DefaultAuditMaxFiles
+	}
+	a := &AuditLog{config: config}
+	if err := a.openLocked(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Close closes the audit log file. Calls after this are not recorded.
+func (a *AuditLog) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.closed = true
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+func (a *AuditLog) openLocked() error {
+	f, err := os.OpenFile(a.config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("opening audit log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("opening audit log: %w", err)
+	}
+	a.file, a.size = f, info.Size()
+	return nil
+}
+
+// rotatedPath returns the path of the rotated file with the suffix, or the
+// current file if the suffix is 0.
+func (a *AuditLog) rotatedPath(suffix int) string {
+	if suffix == 0 {
+		return a.config.Path
+	}
+	return a.config.Path + "." + strconv.Itoa(suffix)
+}
+
+func (a *AuditLog) rotateLocked() error {
+	if err := a.file.Close(); err != nil {
+		return fmt.Errorf("closing audit log: %w", err)
+	}
+	a.file = nil
+	// Shift every file up a suffix, overwriting the oldest
+	for suffix := a.config.MaxFiles; suffix > 0; suffix-- {
+		err := os.Rename(a.rotatedPath(suffix-1), a.rotatedPath(suffix))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("rotating audit log: %w", err)
+		}
+	}
+	return a.openLocked()
+}
+
+// Record appends the entry to the audit log, rotating it first if needed.
+func (a *AuditLog) Record(entry *AuditEntry) error {
+	b, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshaling audit entry: %w", err)
+	}
+	b = append(b, '\n')
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	// A failed rotation leaves the file closed, so try to reopen it
+	if a.closed {
+		return ErrAuditLogClosed
+	} else if a.file == nil {
+		if err := a.openLocked(); err != nil {
+			return err
+		}
+	} else if a.size > 0 && a.size+int64(len(b)) > a.config.MaxSize {
+		if err := a.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	n, err := a.file.Write(b)
+	a.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("writing audit log: %w", err)
+	}
+	return nil
+}
+
+// AuditQuery filters audit entries. Empty fields do not filter.
+type AuditQuery struct {
+	// Only entries at or after this time.
+	Since time.Time
+	// Only entries before this time.
+	Until time.Time
+	// Only entries in this namespace.
+	Namespace string
+	// Only entries whose actor subject or serial contains this.
+	Actor string
+	// Only entries targeting this job.
+	JobID string
+	// Only entries whose method contains this.
+	Method string
+	// Maximum number of entries. If 0, DefaultAuditQueryLimit is used.
+	Limit int
+}
+
+func (q *AuditQuery) matches(entry *AuditEntry) bool {
+	t := entry.Time.AsTime()
+	switch {
+	case !q.Since.IsZero() && t.Before(q.Since), !q.Until.IsZero() && !t.Before(q.Until):
+		return false
+	case q.Namespace != "" && entry.Namespace != q.Namespace:
+		return false
+	case q.Actor != "" && !strings.Contains(entry.Actor.GetSubject(), q.Actor) &&
+		!strings.Contains(entry.Actor.GetSerial(), q.Actor):
+		return false
+	case q.Method != "" && !strings.Contains(entry.Method, q.Method):
+		return false
+	case q.JobID != "":
+		for _, id := range entry.JobIds {
+			if id == q.JobID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Query returns the most recent entries matching the query, most recent first,
+// across the current and rotated files.
+func (a *AuditLog) Query(query AuditQuery) ([]*AuditEntry, error) {
+	if query.Limit == 0 {
+		query.Limit = DefaultAuditQueryLimit
+	}
+	// Open every file under lock, oldest first, so rotation while reading does
+	// not skip or repeat entries. The current file is only read up to its size
+	// now since it may be appended to.
+	var readers []io.Reader
+	a.lock.Lock()
+	for suffix := a.config.MaxFiles; suffix >= 0; suffix-- {
+		f, err := os.Open(a.rotatedPath(suffix))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			a.lock.Unlock()
+			return nil, fmt.Errorf("opening audit log: %w", err)
+		}
+		defer f.Close()
+		if suffix == 0 {
+			readers = append(readers, io.LimitReader(f, a.size))
+		} else {
+			readers = append(readers, f)
+		}
+	}
+	a.lock.Unlock()
+	// Keep only the last matches
+	var entries []*AuditEntry
+	for _, r := range readers {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			var entry AuditEntry
+			if err := protojson.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				return nil, fmt.Errorf("parsing audit log: %w", err)
+			} else if query.matches(&entry) {
+				if entries = append(entries, &entry); len(entries) > query.Limit {
+					entries = entries[1:]
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("reading audit log: %w", err)
+		}
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// AuditServerOptions returns gRPC server options with interceptors that record
+// every call to the audit log. Streams are recorded both when they receive
+// their request and when they end. See AuditConfig.FailClosed for what happens
+// when an entry cannot be recorded.
+func AuditServerOptions(a *AuditLog) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(a.unaryInterceptor),
+		grpc.ChainStreamInterceptor(a.streamInterceptor),
+	}
+}
+
+func (a *AuditLog) unaryInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if a.config.FailClosed {
+		if err := a.recordCall(ctx, info.FullMethod, true, req, nil, nil); err != nil {
+			return nil, status.Error(codes.Unavailable, "failed recording call to audit log")
+		}
+	}
+	resp, err := handler(ctx, req)
+	a.recordCall(ctx, info.FullMethod, false, req, resp, err)
+	return resp, err
+}
+
+func (a *AuditLog) streamInterceptor(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	audited := &auditedServerStream{ServerStream: ss, audit: a, method: info.FullMethod}
+	err := handler(srv, audited)
+	a.recordCall(ss.Context(), info.FullMethod, false, audited.req, nil, err)
+	return err
+}
+
+// auditedServerStream is a server stream that keeps the first request
+// received and records the start of the stream when it is received.
+type auditedServerStream struct {
+	grpc.ServerStream
+	audit  *AuditLog
+	method string
+	req    interface{}
+}
+
+func (a *auditedServerStream) RecvMsg(m interface{}) error {
+	err := a.ServerStream.RecvMsg(m)
+	if err == nil && a.req == nil {
+		a.req = m
+		err := a.audit.recordCall(a.Context(), a.method, true, m, nil, nil)
+		if err != nil && a.audit.config.FailClosed {
+			return status.Error(codes.Unavailable, "failed recording call to audit log")
+		}
+	}
+	return err
+}
+
+// recordCall records the start or the outcome of the call, logging and
+// returning any failure.
+func (a *AuditLog) recordCall(
+	ctx context.Context,
+	method string,
+	started bool,
+	req, resp interface{},
+	err error,
+) error {
+	entry := &AuditEntry{
+		Time:    timestamppb.Now(),
+		Method:  method,
+		JobIds:  auditJobIDs(req, resp),
+		Started: started,
+	}
+	if !started {
+		entry.Code = status.Code(err).String()
+	}
+	var granted []string
+	if p, ok := peer.FromContext(ctx); ok {
+		entry.Peer = p.Addr.String()
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.PeerCertificates) > 0 {
+			entry.Actor = toAuditActor(tlsInfo.State.PeerCertificates[0])
+			granted = CertificateNamespaces(tlsInfo.State.PeerCertificates[0])
+			entry.Namespace = granted[0]
+		}
+	}
+	// Calls for admins only are across namespaces, and the namespace of other
+	// requests is the one acted in
+	if MethodRole(method) == RoleAdmin {
+		entry.CrossNamespace = true
+	} else if req, ok := req.(interface{ GetNamespace() string }); ok && req.GetNamespace() != "" {
+		entry.CrossNamespace = !grantsNamespace(granted, req.GetNamespace(), a.config.NamespaceHierarchy)
+		entry.Namespace = req.GetNamespace()
+	}
+	if reqMsg, ok := req.(proto.Message); ok {
+		entry.Request = auditRequestSummary(reqMsg)
+	}
+	if err != nil {
+		entry.Error = status.Convert(err).Message()
+	}
+	recordErr := a.Record(entry)
+	if recordErr != nil && a.config.Logger != nil {
+		a.config.Logger.Error("Failed recording audit entry", "method", method, "error", recordErr)
+	}
+	return recordErr
+}
+
+func toAuditActor(cert *x509.Certificate) *AuditActor {
+	return &AuditActor{
+		Subject:             cert.Subject.String(),
+		Serial:              cert.SerialNumber.Text(16),
+		OrganizationalUnits: cert.Subject.OrganizationalUnit,
+	}
+}
+
+// auditJobIDs returns the IDs of the jobs targeted by the request or, if none,
+// created or stopped according to the response.
+func auditJobIDs(req, resp interface{}) []string {
+	switch req := req.(type) {
+	case *QueryAuditRequest:
+		// The job ID is a filter, not a target
+		return nil
+	case interface{ GetJobIds() []string }:
+		if ids := req.GetJobIds(); len(ids) > 0 {
+			return ids
+		}
+	case interface{ GetJobId() string }:
+		if id := req.GetJobId(); id != "" {
+			return []string{id}
+		}
+	}
+	var ids []string
+	switch resp := resp.(type) {
+	case interface{ GetJob() *Job }:
+		if job := resp.GetJob(); job != nil {
+			ids = append(ids, job.Id)
+		}
+	case *BatchSubmitJobsResponse:
+		for _, job := range resp.Jobs {
+			ids = append(ids, job.Id)
+		}
+	case *StopJobsResponse:
+		for _, result := range resp.Results {
+			ids = append(ids, result.JobId)
+		}
+	case *SubmitWorkflowResponse:
+		for _, job := range resp.Workflow.GetJobs() {
+			ids = append(ids, job.Id)
+		}
+	}
+	return ids
+}
+
+// auditRequestSummary returns the JSON of the request with secrets and the
+// values of environment variables and template parameters redacted, truncated
+// if large.
+func auditRequestSummary(req proto.Message) string {
+	req = proto.Clone(req)
+	redactAuditMessage(req.ProtoReflect())
+	b, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(req)
+	if err != nil {
+		return ""
+	} else if len(b) > maxAuditRequestSize {
+		return string(b[:maxAuditRequestSize]) + "..."
+	}
+	return string(b)
+}
+
+func redactAuditMessage(m protoreflect.Message) {
+	// Collect first since the message cannot be mutated while ranging
+	var fields []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fields = append(fields, fd)
+		return true
+	})
+	for _, fd := range fields {
+		v := m.Get(fd)
+		switch {
+		case fd.Name() == "secret" && fd.Kind() == protoreflect.StringKind:
+			m.Set(fd, protoreflect.ValueOfString(auditRedacted))
+		case fd.IsMap() && (fd.Name() == "env" || fd.Name() == "params"):
+			var keys []protoreflect.MapKey
+			v.Map().Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+				keys = append(keys, k)
+				return true
+			})
+			for _, k := range keys {
+				v.Map().Set(k, protoreflect.ValueOfString(auditRedacted))
+			}
+		case fd.IsList() && fd.Kind() == protoreflect.MessageKind:
+			for i := 0; i < v.List().Len(); i++ {
+				redactAuditMessage(v.List().Get(i).Message())
+			}
+		case !fd.IsMap() && !fd.IsList() && fd.Kind() == protoreflect.MessageKind:
+			redactAuditMessage(v.Message())
+		}
+	}
+}
+
+// WithAuditLog is a job service option to support QueryAudit with the audit
+// log. The log is not recorded to unless AuditServerOptions is also used.
+func WithAuditLog(a *AuditLog) JobServiceOption {
+	return func(j *jobService) { j.audit = a }
+}
+
+func (j *jobService) QueryAudit(ctx context.Context, req *QueryAuditRequest) (*QueryAuditResponse, error) {
+	if j.audit == nil {
+		return nil, status.Error(codes.FailedPrecondition, "audit log not enabled")
+	}
+	if _, err := namespaceFromContext(ctx); err != nil {
+		return nil, err
+	} else if !isAdmin(ctx) {
+		return nil, status.Error(codes.PermissionDenied, "admin required")
+	} else if req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit cannot be negative")
+	}
+	query := AuditQuery{
+		Namespace: req.Namespace,
+		Actor:     req.Actor,
+		JobID:     req.JobId,
+		Method:    req.Method,
+		Limit:     int(req.Limit),
+	}
+	if query.Limit > MaxAuditQueryLimit {
+		query.Limit = MaxAuditQueryLimit
+	}
+	if req.Since != nil {
+		query.Since = req.Since.AsTime()
+	}
+	if req.Until != nil {
+		query.Until = req.Until.AsTime()
+	}
+	j.logCrossNamespace(ctx)
+	entries, err := j.audit.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	return &QueryAuditResponse{Entries: entries}, nil
+}
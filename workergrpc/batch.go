@@ -0,0 +1,136 @@
+package workergrpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/cretz/teleworker/worker"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (j *jobService) BatchSubmitJobs(ctx context.Context, req *BatchSubmitJobsRequest) (*BatchSubmitJobsResponse, error) {
+	if len(req.Jobs) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one job required")
+	}
+	batch := make([]worker.BatchJob, len(req.Jobs))
+	for i, job := range req.Jobs {
+		if err := validateJobSpec(job); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "job %v: %v", i, status.Convert(err).Message())
+		}
+		batch[i] = worker.BatchJob{
+			ID:      job.Id,
+			JobSpec: fromProtoJobSpec(job),
+			Options: []worker.SubmitJobOption{
+				worker.WithDependencies(fromProtoDependencies(job.Dependencies)...),
+				worker.WithLabels(job.Labels),
+				worker.WithAnnotations(job.Annotations),
+				worker.WithTraceContext(ctx),
+			},
+		}
+	}
+	ns, err := j.namespace(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	} else if err := j.allowSubmit(ctx, ns, len(batch)); err != nil {
+		return nil, err
+	}
+	jobs, err := j.worker.SubmitJobs(ns, batch)
+	if err != nil {
+		return nil, jobSpecError(err, nil)
+	}
+	var resp BatchSubmitJobsResponse
+	for _, job := range jobs {
+		pbJob, err := j.toProtoJob(job, false /* includeStdout */, false /* includeStderr */)
+		if err != nil {
+			return nil, err
+		}
+		resp.Jobs = append(resp.Jobs, pbJob)
+	}
+	return &resp, nil
+}
+
+func (j *jobService) StopJobs(ctx context.Context, req *StopJobsRequest) (*StopJobsResponse, error) {
+	if (len(req.JobIds) == 0) == (req.LabelSelector == "") {
+		return nil, status.Error(codes.InvalidArgument, "exactly one of job IDs or label selector required")
+	}
+	timeout, err := j.requestStopTimeout(req.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	ns, err := j.namespace(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	// Collect the jobs, leaving nil for IDs not found
+	var results []*StopJobsResult
+	var jobs []*worker.Job
+	if req.LabelSelector != "" {
+		selector, err := worker.ParseLabelSelector(req.LabelSelector)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		allJobs, err := j.worker.Jobs(ns, selector)
+		if err == worker.ErrShutdown {
+			return nil, status.Error(codes.FailedPrecondition, "worker shutdown")
+		} else if err != nil {
+			return nil, err
+		}
+		for _, job := range allJobs {
+			if job.ExitCode() == nil {
+				results = append(results, &StopJobsResult{JobId: job.ID})
+				jobs = append(jobs, job)
+			}
+		}
+	} else {
+		for _, id := range req.JobIds {
+			if id == "" {
+				return nil, status.Error(codes.InvalidArgument, "job ID required")
+			}
+			job, err := j.worker.GetJob(ns, id)
+			if err == worker.ErrShutdown {
+				return nil, status.Error(codes.FailedPrecondition, "worker shutdown")
+			} else if err != nil {
+				return nil, err
+			}
+			results = append(results, &StopJobsResult{JobId: id})
+			jobs = append(jobs, job)
+		}
+	}
+	// Attempt to stop all concurrently only until the timeout
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		if job == nil {
+			results[i].Error = "not found"
+			continue
+		} else if job.ExitCode() != nil {
+			results[i].Error = "job already stopped"
+			continue
+		}
+		wg.Add(1)
+		go func(result *StopJobsResult, job *worker.Job) {
+			defer wg.Done()
+			if _, err := job.Stop(ctx, req.Force); err == context.DeadlineExceeded {
+				result.Error = fmt.Sprintf("failed stopping job within %v", timeout)
+			} else if err != nil {
+				result.Error = err.Error()
+			}
+		}(results[i], job)
+	}
+	wg.Wait()
+	// Convert the stopped jobs
+	for i, job := range jobs {
+		if results[i].Error != "" {
+			continue
+		}
+		pbJob, err := j.toProtoJob(job, false /* includeStdout */, false /* includeStderr */)
+		if err != nil {
+			return nil, err
+		}
+		results[i].Job = pbJob
+	}
+	return &StopJobsResponse{Results: results}, nil
+}
@@ -6,15 +6,23 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
 	"fmt"
 	"math/big"
 	"net"
+	"net/url"
+	"strings"
 	"time"
 
 	"google.golang.org/grpc/credentials"
 )
 
+// NamespaceURIScheme is the scheme of client certificate URI SANs that grant
+// namespaces in addition to the OUs, e.g. "teleworker-namespace:team1/project1".
+const NamespaceURIScheme = "teleworker-namespace"
+
 // Force minimum TLS 1.2
 const tlsMinVersion = tls.VersionTLS12
 
@@ -74,6 +82,15 @@ type GenerateCertificateConfig struct {
 	SignerCert []byte
 	SignerKey  []byte
 	OU         string
+	// Namespaces granted to a client certificate in addition to the OU, embedded
+	// as NamespaceURIScheme URI SANs.
+	Namespaces []string
+	// Common name of a client certificate. Server certificates use the server
+	// host.
+	CommonName string
+	// Role names embedded in a client certificate's RoleExtensionOID extension
+	// for policies to match.
+	Roles []string
 	// If true, this key can sign others and is marked as a CA. CA certs are only
 	// used for signing and verification, not directly for server/client auth.
 	// This cannot be true if ServerHost is non-empty.
@@ -93,6 +110,11 @@ func GenerateCertificate(config GenerateCertificateConfig) (certPEM, keyPEM []by
 	// Validate
 	if config.CA && config.ServerHost != "" {
 		return nil, nil, fmt.Errorf("cannot have server host for CA")
+	} else if (config.CA || config.ServerHost != "") &&
+		(config.CommonName != "" || len(config.Roles) > 0 || len(config.Namespaces) > 0) {
+		return nil, nil, fmt.Errorf("common name, roles, and namespaces only allowed for client certificates")
+	} else if !ValidNamespace(config.OU) {
+		return nil, nil, fmt.Errorf("invalid OU namespace %q", config.OU)
 	} else if (len(config.SignerCert) == 0) != (len(config.SignerKey) == 0) {
 		return nil, nil, fmt.Errorf("only one of signer cert or key present, must have both or neither")
 	}
@@ -128,6 +150,25 @@ func GenerateCertificate(config GenerateCertificateConfig) (certPEM, keyPEM []by
 		}
 	} else {
 		cert.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		cert.Subject.CommonName = config.CommonName
+		for _, namespace := range config.Namespaces {
+			if !ValidNamespace(namespace) {
+				return nil, nil, fmt.Errorf("invalid namespace %q", namespace)
+			}
+			cert.URIs = append(cert.URIs, &url.URL{Scheme: NamespaceURIScheme, Opaque: namespace})
+		}
+		if len(config.Roles) > 0 {
+			for _, role := range config.Roles {
+				if _, err := ParseRole(role); err != nil {
+					return nil, nil, err
+				}
+			}
+			value, err := asn1.Marshal(config.Roles)
+			if err != nil {
+				return nil, nil, fmt.Errorf("marshaling roles: %w", err)
+			}
+			cert.ExtraExtensions = []pkix.Extension{{Id: RoleExtensionOID, Value: value}}
+		}
 	}
 	// Load signer pair or use self signed
 	parentCert, parentPriv := cert, priv
@@ -170,3 +211,40 @@ func GenerateCertificate(config GenerateCertificateConfig) (certPEM, keyPEM []by
 	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes})
 	return certPEM, keyPEM, nil
 }
+
+// CertificateNamespaces returns the namespaces granted to the client
+// certificate, which are its OUs followed by its NamespaceURIScheme URI SANs.
+// A certificate without any is granted only the empty namespace.
+func CertificateNamespaces(cert *x509.Certificate) []string {
+	namespaces := append([]string(nil), cert.Subject.OrganizationalUnit...)
+	for _, uri := range cert.URIs {
+		if uri.Scheme == NamespaceURIScheme {
+			namespaces = append(namespaces, uri.Opaque)
+		}
+	}
+	if len(namespaces) == 0 {
+		return []string{""}
+	}
+	return namespaces
+}
+
+// ValidNamespace returns true if the namespace is empty or is "/"-separated
+// non-empty parts.
+func ValidNamespace(namespace string) bool {
+	if namespace == "" {
+		return true
+	}
+	for _, part := range strings.Split(namespace, "/") {
+		if part == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// NamespaceGrants returns true if the granted namespace is the namespace or,
+// if hierarchical, one of its ancestors. The empty namespace only grants
+// itself.
+func NamespaceGrants(granted, namespace string, hierarchical bool) bool {
+	return granted == namespace || (hierarchical && granted != "" && strings.HasPrefix(namespace, granted+"/"))
+}
@@ -0,0 +1,92 @@
+package workergrpc
+
+import (
+	"errors"
+
+	"github.com/cretz/teleworker/worker"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func (j *jobService) WatchJobs(req *WatchJobsRequest, srv JobService_WatchJobsServer) error {
+	selector, err := worker.ParseLabelSelector(req.LabelSelector)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	states := map[JobState]bool{}
+	for _, state := range req.States {
+		states[state] = true
+	}
+	ns, err := j.namespace(srv.Context(), req.Namespace)
+	if err != nil {
+		return err
+	}
+	// Listen before getting the sequence so no event is missed
+	notify := make(chan struct{}, 1)
+	j.worker.AddJobEventListener(ns, notify)
+	defer j.worker.RemoveJobEventListener(notify)
+	afterSeq := j.worker.LastJobEventSequence()
+	if req.ResumeAfterSequence != nil {
+		afterSeq = req.ResumeAfterSequence.Value
+	}
+	for {
+		events, err := j.worker.JobEvents(ns, afterSeq)
+		if errors.Is(err, worker.ErrJobEventsExpired) {
+			return status.Errorf(codes.OutOfRange, "events after sequence %v no longer retained", afterSeq)
+		} else if err != nil {
+			return err
+		}
+		for _, event := range events {
+			afterSeq = event.Sequence
+			pbEvent := toProtoJobEvent(&event)
+			if (len(states) > 0 && !states[pbEvent.State]) || !selector.Matches(event.Labels) {
+				continue
+			}
+			if err := srv.Send(&WatchJobsResponse{Event: pbEvent}); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-srv.Context().Done():
+			return nil
+		case <-notify:
+		}
+	}
+}
+
+func toProtoJobEvent(event *worker.JobEvent) *JobEvent {
+	pbEvent := &JobEvent{
+		Sequence:   event.Sequence,
+		JobId:      event.JobID,
+		Time:       timestamppb.New(event.Time),
+		State:      toProtoJobState(event.State),
+		Labels:     event.Labels,
+		Attempt:    int32(event.Attempt),
+		StdoutSize: int64(event.StdoutSize),
+		StderrSize: int64(event.StderrSize),
+	}
+	switch event.Type {
+	case worker.JobEventQueued:
+		pbEvent.Type = JobEventType_JOB_EVENT_TYPE_QUEUED
+	case worker.JobEventPending:
+		pbEvent.Type = JobEventType_JOB_EVENT_TYPE_PENDING
+	case worker.JobEventStarted:
+		pbEvent.Type = JobEventType_JOB_EVENT_TYPE_STARTED
+	case worker.JobEventOutput:
+		pbEvent.Type = JobEventType_JOB_EVENT_TYPE_OUTPUT
+	case worker.JobEventRetrying:
+		pbEvent.Type = JobEventType_JOB_EVENT_TYPE_RETRYING
+	case worker.JobEventStopping:
+		pbEvent.Type = JobEventType_JOB_EVENT_TYPE_STOPPING
+	case worker.JobEventCompleted:
+		pbEvent.Type = JobEventType_JOB_EVENT_TYPE_COMPLETED
+	case worker.JobEventDeleted:
+		pbEvent.Type = JobEventType_JOB_EVENT_TYPE_DELETED
+	}
+	if event.ExitCode != nil {
+		pbEvent.ExitCode = wrapperspb.Int32(int32(*event.ExitCode))
+	}
+	return pbEvent
+}
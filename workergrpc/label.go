@@ -0,0 +1,66 @@
+package workergrpc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cretz/teleworker/worker"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (j *jobService) ListJobs(ctx context.Context, req *ListJobsRequest) (*ListJobsResponse, error) {
+	selector, err := worker.ParseLabelSelector(req.LabelSelector)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	ns, err := j.namespace(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := j.worker.Jobs(ns, selector)
+	if err == worker.ErrShutdown {
+		return nil, status.Error(codes.FailedPrecondition, "worker shutdown")
+	} else if err != nil {
+		return nil, err
+	}
+	var resp ListJobsResponse
+	for _, job := range jobs {
+		pbJob, err := j.toProtoJob(job, false /* includeStdout */, false /* includeStderr */)
+		if err != nil {
+			return nil, err
+		}
+		resp.Jobs = append(resp.Jobs, pbJob)
+	}
+	return &resp, nil
+}
+
+func (j *jobService) UpdateJobLabels(ctx context.Context, req *UpdateJobLabelsRequest) (*UpdateJobLabelsResponse, error) {
+	if req.JobId == "" {
+		return nil, status.Error(codes.InvalidArgument, "job ID required")
+	}
+	ns, err := j.namespace(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	job, err := j.worker.UpdateJobLabels(ns, req.JobId, worker.JobLabelUpdate{
+		SetLabels:         req.SetLabels,
+		RemoveLabels:      req.RemoveLabels,
+		SetAnnotations:    req.SetAnnotations,
+		RemoveAnnotations: req.RemoveAnnotations,
+	})
+	if err == worker.ErrJobNotFound {
+		return nil, status.Error(codes.NotFound, "not found")
+	} else if err == worker.ErrShutdown {
+		return nil, status.Error(codes.FailedPrecondition, "worker shutdown")
+	} else if errors.Is(err, worker.ErrInvalidLabels) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	} else if err != nil {
+		return nil, err
+	}
+	pbJob, err := j.toProtoJob(job, false /* includeStdout */, false /* includeStderr */)
+	if err != nil {
+		return nil, err
+	}
+	return &UpdateJobLabelsResponse{Job: pbJob}, nil
+}
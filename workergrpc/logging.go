@@ -0,0 +1,92 @@
+package workergrpc
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// LoggingServerOptions returns gRPC server options with interceptors that log
+// every call to the logger with the peer identity. Successful calls are logged
+// at debug level, calls failed by the client at info level, and calls failed
+// by the server at error level.
+func LoggingServerOptions(logger *slog.Logger) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(LoggingUnaryInterceptor(logger)),
+		grpc.ChainStreamInterceptor(LoggingStreamInterceptor(logger)),
+	}
+}
+
+// LoggingUnaryInterceptor returns a unary server interceptor that logs every
+// call to the logger.
+func LoggingUnaryInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logCall(ctx, logger, info.FullMethod, err, time.Since(start))
+		return resp, err
+	}
+}
+
+// LoggingStreamInterceptor returns a stream server interceptor that logs every
+// stream to the logger once it ends.
+func LoggingStreamInterceptor(logger *slog.Logger) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		logCall(ss.Context(), logger, info.FullMethod, err, time.Since(start))
+		return err
+	}
+}
+
+func logCall(ctx context.Context, logger *slog.Logger, method string, err error, duration time.Duration) {
+	code := status.Code(err)
+	level := slog.LevelDebug
+	switch code {
+	case codes.OK:
+	case codes.Unknown, codes.Internal, codes.DataLoss, codes.Unimplemented:
+		level = slog.LevelError
+	default:
+		level = slog.LevelInfo
+	}
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := append(peerAttrs(ctx), slog.String("method", method), slog.String("code", code.String()),
+		slog.Duration("duration", duration))
+	if err != nil {
+		attrs = append(attrs, slog.String("error", status.Convert(err).Message()))
+	}
+	logger.LogAttrs(ctx, level, "Handled call", attrs...)
+}
+
+// peerAttrs returns the log attributes identifying the peer of the call.
+func peerAttrs(ctx context.Context) []slog.Attr {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	attrs := []slog.Attr{slog.String("peer", p.Addr.String())}
+	if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.PeerCertificates) > 0 {
+		cert := tlsInfo.State.PeerCertificates[0]
+		attrs = append(attrs, slog.String("peer_common_name", cert.Subject.CommonName),
+			slog.String("namespace", CertificateNamespaces(cert)[0]))
+	}
+	return attrs
+}
@@ -0,0 +1,60 @@
+package workergrpc
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Metrics receives measurements of gRPC calls. Implementations must be safe for
+// concurrent use and must not block.
+type Metrics interface {
+	// MethodHandled is called when a call completes with its full method name
+	// (e.g. "/teleworker.worker.JobService/GetJob"), status code, and how long
+	// it took. For streams, this is the life of the stream.
+	MethodHandled(method string, code codes.Code, duration time.Duration)
+}
+
+// MetricsServerOptions returns gRPC server options with interceptors that
+// measure every call to the metrics.
+func MetricsServerOptions(metrics Metrics) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(MetricsUnaryInterceptor(metrics)),
+		grpc.ChainStreamInterceptor(MetricsStreamInterceptor(metrics)),
+	}
+}
+
+// MetricsUnaryInterceptor returns a unary server interceptor that measures
+// every call to the metrics.
+func MetricsUnaryInterceptor(metrics Metrics) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		metrics.MethodHandled(info.FullMethod, status.Code(err), time.Since(start))
+		return resp, err
+	}
+}
+
+// MetricsStreamInterceptor returns a stream server interceptor that measures
+// every stream to the metrics.
+func MetricsStreamInterceptor(metrics Metrics) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		metrics.MethodHandled(info.FullMethod, status.Code(err), time.Since(start))
+		return err
+	}
+}
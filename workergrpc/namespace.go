@@ -0,0 +1,37 @@
+package workergrpc
+
+import (
+	"context"
+
+	"github.com/cretz/teleworker/worker"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (j *jobService) ListNamespaces(ctx context.Context, req *ListNamespacesRequest) (*ListNamespacesResponse, error) {
+	if _, err := namespaceFromContext(ctx); err != nil {
+		return nil, err
+	} else if !isAdmin(ctx) {
+		return nil, status.Error(codes.PermissionDenied, "admin required")
+	}
+	j.logCrossNamespace(ctx)
+	namespaces, err := j.worker.Namespaces()
+	if err == worker.ErrShutdown {
+		return nil, status.Error(codes.FailedPrecondition, "worker shutdown")
+	} else if err != nil {
+		return nil, err
+	}
+	var resp ListNamespacesResponse
+	for _, ns := range namespaces {
+		resp.Namespaces = append(resp.Namespaces, &NamespaceJobCounts{
+			Namespace: ns.Namespace,
+			Queued:    int32(ns.Counts[worker.JobStateQueued]),
+			Running:   int32(ns.Counts[worker.JobStateRunning]),
+			Completed: int32(ns.Counts[worker.JobStateCompleted]),
+			Canceled:  int32(ns.Counts[worker.JobStateCanceled]),
+			Pending:   int32(ns.Counts[worker.JobStatePending]),
+			Retrying:  int32(ns.Counts[worker.JobStateRetrying]),
+		})
+	}
+	return &resp, nil
+}
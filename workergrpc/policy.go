@@ -0,0 +1,375 @@
+package workergrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RoleExtensionOID is the OID of the certificate extension GenerateCertificate
+// embeds roles in. The value is a DER sequence of role name strings. This is
+// an arbitrary OID under the private enterprise arc.
+var RoleExtensionOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 58476, 1, 1}
+
+// Role is what a caller is authorized to do in its namespace. Each role can do
+// everything the roles before it can.
+type Role int
+
+const (
+	// RoleNone cannot call anything.
+	RoleNone Role = iota
+	// RoleViewer can get, list, watch, and stream the output of jobs and read
+	// schedules, workflows, templates, and webhook deliveries.
+	RoleViewer
+	// RoleSubmitter can also submit jobs and workflows and update job labels.
+	RoleSubmitter
+	// RoleOperator can also stop and delete jobs and manage schedules and
+	// templates.
+	RoleOperator
+	// RoleAdmin can call everything in every namespace, including QueryAudit
+	// and ListNamespaces.
+	RoleAdmin
+)
+
+var roleNames = []string{"none", "viewer", "submitter", "operator", "admin"}
+
+func (r Role) String() string {
+	if r >= 0 && int(r) < len(roleNames) {
+		return roleNames[r]
+	}
+	return "Role(" + strconv.Itoa(int(r)) + ")"
+}
+
+// ParseRole parses a role from its name.
+func ParseRole(name string) (Role, error) {
+	for i, roleName := range roleNames {
+		if name == roleName {
+			return Role(i), nil
+		}
+	}
+	return RoleNone, fmt.Errorf("unknown role %q", name)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (r Role) MarshalText() ([]byte, error) { return []byte(r.String()), nil }
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (r *Role) UnmarshalText(text []byte) (err error) {
+	*r, err = ParseRole(string(text))
+	return
+}
+
+// Minimum role for each method. Methods not present require RoleAdmin.
+var methodRoles = map[string]Role{
+	"/teleworker.worker.JobService/GetJob":                RoleViewer,
+	"/teleworker.worker.JobService/StreamJobOutput":       RoleViewer,
+	"/teleworker.worker.JobService/WatchJobs":             RoleViewer,
+	"/teleworker.worker.JobService/ListJobs":              RoleViewer,
+	"/teleworker.worker.JobService/ListSchedules":         RoleViewer,
+	"/teleworker.worker.JobService/GetWorkflow":           RoleViewer,
+	"/teleworker.worker.JobService/ListTemplates":         RoleViewer,
+	"/teleworker.worker.JobService/ListWebhookDeliveries": RoleViewer,
+	"/teleworker.worker.JobService/GetQuota":              RoleViewer,
+	"/teleworker.worker.JobService/SubmitJob":             RoleSubmitter,
+	"/teleworker.worker.JobService/BatchSubmitJobs":       RoleSubmitter,
+	"/teleworker.worker.JobService/SubmitWorkflow":        RoleSubmitter,
+	"/teleworker.worker.JobService/SubmitFromTemplate":    RoleSubmitter,
+	"/teleworker.worker.JobService/UpdateJobLabels":       RoleSubmitter,
+	"/teleworker.worker.JobService/StopJob":               RoleOperator,
+	"/teleworker.worker.JobService/StopJobs":              RoleOperator,
+	"/teleworker.worker.JobService/DeleteJob":             RoleOperator,
+	"/teleworker.worker.JobService/CreateSchedule":        RoleOperator,
+	"/teleworker.worker.JobService/DeleteSchedule":        RoleOperator,
+	"/teleworker.worker.JobService/PauseSchedule":         RoleOperator,
+	"/teleworker.worker.JobService/CreateTemplate":        RoleOperator,
+	"/teleworker.worker.JobService/QueryAudit":            RoleAdmin,
+	"/teleworker.worker.JobService/ListNamespaces":        RoleAdmin,
+}
+
+// MethodRole returns the minimum role needed to call the full method name.
+func MethodRole(method string) Role {
+	if role, ok := methodRoles[method]; ok {
+		return role
+	}
+	return RoleAdmin
+}
+
+// Policy maps client certificate attributes to roles.
+type Policy struct {
+	// Role of callers matching no rule. If unset, they can call nothing.
+	DefaultRole Role `json:"default_role,omitempty"`
+	// A caller has the highest role of every rule it matches.
+	Rules []PolicyRule `json:"rules,omitempty"`
+	// PEM of CA certificates whose client certificates are admins regardless
+	// of rules. The server's TLS credentials must also trust these. This is
+	// never loaded from JSON.
+	AdminCACert []byte `json:"-"`
+}
+
+// PolicyRule grants a role to certificates matching every attribute present.
+// At least one attribute is required. Attribute values are path.Match
+// patterns, e.g. "ci-*".
+type PolicyRule struct {
+	// Matches any organizational unit of the subject.
+	OU string `json:"ou,omitempty"`
+	// Matches the common name of the subject.
+	CommonName string `json:"common_name,omitempty"`
+	// Matches any DNS name SAN.
+	DNSName string `json:"dns_name,omitempty"`
+	// Matches any URI SAN.
+	URI string `json:"uri,omitempty"`
+	// Matches any email address SAN.
+	Email string `json:"email,omitempty"`
+	// Matches any role in the RoleExtensionOID extension, as embedded by
+	// GenerateCertificate.
+	CertRole string `json:"cert_role,omitempty"`
+	// Matches a custom extension.
+	Extension *PolicyExtension `json:"extension,omitempty"`
+	// Required role granted.
+	Role Role `json:"role"`
+}
+
+// PolicyExtension matches a custom certificate extension.
+type PolicyExtension struct {
+	// Required dotted OID of the extension, e.g. "1.2.3.4".
+	OID string `json:"oid"`
+	// Matches the extension value if it is a DER string, or its raw bytes
+	// otherwise. If empty, any value matches.
+	Value string `json:"value,omitempty"`
+}
+
+// Authorizer checks calls against a policy.
+type Authorizer struct {
+	policy Policy
+	// Parsed OIDs of rule extensions, nil for rules without one
+	extensionOIDs []asn1.ObjectIdentifier
+	adminCAs      []*x509.Certificate
+}
+
+// NewAuthorizer validates the policy and creates an authorizer for it.
+func NewAuthorizer(policy Policy) (*Authorizer, error) {
+	a := &Authorizer{policy: policy, extensionOIDs: make([]asn1.ObjectIdentifier, len(policy.Rules))}
+	for i, rule := range policy.Rules {
+		if rule.Role == RoleNone {
+			return nil, fmt.Errorf("rule %v: role required", i)
+		}
+		// A rule without any matcher would match every certificate, which is
+		// what DefaultRole is for
+		hasMatcher := rule.Extension != nil
+		for _, pattern := range []string{rule.OU, rule.CommonName, rule.DNSName, rule.URI, rule.Email, rule.CertRole} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %v: invalid pattern %q", i, pattern)
+			}
+			hasMatcher = hasMatcher || pattern != ""
+		}
+		if !hasMatcher {
+			return nil, fmt.Errorf("rule %v: at least one matcher required", i)
+		}
+		if rule.Extension != nil {
+			oid, err := parseOID(rule.Extension.OID)
+			if err != nil {
+				return nil, fmt.Errorf("rule %v: %w", i, err)
+			} else if _, err := path.Match(rule.Extension.Value, ""); err != nil {
+				return nil, fmt.Errorf("rule %v: invalid pattern %q", i, rule.Extension.Value)
+			}
+			a.extensionOIDs[i] = oid
+		}
+	}
+	for rest := policy.AdminCACert; len(rest) > 0; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing admin CA cert: %w", err)
+		}
+		a.adminCAs = append(a.adminCAs, cert)
+	}
+	if len(policy.AdminCACert) > 0 && len(a.adminCAs) == 0 {
+		return nil, fmt.Errorf("no admin CA cert in PEM")
+	}
+	return a, nil
+}
+
+func parseOID(s string) (asn1.ObjectIdentifier, error) {
+	var oid asn1.ObjectIdentifier
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		oid = append(oid, n)
+	}
+	if len(oid) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	return oid, nil
+}
+
+// connectionRole returns the role of the client of the verified connection,
+// which is admin if it was verified by an admin CA.
+func (a *Authorizer) connectionRole(state *tls.ConnectionState) Role {
+	for _, chain := range state.VerifiedChains {
+		for _, ca := range a.adminCAs {
+			if chain[len(chain)-1].Equal(ca) {
+				return RoleAdmin
+			}
+		}
+	}
+	return a.Role(state.PeerCertificates[0])
+}
+
+// Role returns the highest role the policy grants the client certificate.
+func (a *Authorizer) Role(cert *x509.Certificate) Role {
+	role := a.policy.DefaultRole
+	for i, rule := range a.policy.Rules {
+		if rule.Role > role && a.ruleMatches(i, cert) {
+			role = rule.Role
+		}
+	}
+	return role
+}
+
+func (a *Authorizer) ruleMatches(index int, cert *x509.Certificate) bool {
+	rule := &a.policy.Rules[index]
+	var uris []string
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+	}
+	if !matchesAny(rule.OU, cert.Subject.OrganizationalUnit) ||
+		!matchesAny(rule.CommonName, []string{cert.Subject.CommonName}) ||
+		!matchesAny(rule.DNSName, cert.DNSNames) ||
+		!matchesAny(rule.URI, uris) ||
+		!matchesAny(rule.Email, cert.EmailAddresses) ||
+		!matchesAny(rule.CertRole, CertificateRoles(cert)) {
+		return false
+	}
+	if oid := a.extensionOIDs[index]; oid != nil {
+		for _, ext := range cert.Extensions {
+			if ext.Id.Equal(oid) && matchesAny(rule.Extension.Value, []string{extensionString(ext.Value)}) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// matchesAny returns true if the pattern is empty or matches any value.
+func matchesAny(pattern string, values []string) bool {
+	if pattern == "" {
+		return true
+	}
+	for _, value := range values {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// extensionString returns the extension value as a string if it is a DER
+// string, or the raw bytes otherwise.
+func extensionString(value []byte) string {
+	var s string
+	if rest, err := asn1.Unmarshal(value, &s); err == nil && len(rest) == 0 {
+		return s
+	}
+	return string(value)
+}
+
+// CertificateRoles returns the role names in the RoleExtensionOID extension of
+// the certificate, if any. These are not validated as known roles.
+func CertificateRoles(cert *x509.Certificate) []string {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(RoleExtensionOID) {
+			var roles []string
+			if rest, err := asn1.Unmarshal(ext.Value, &roles); err == nil && len(rest) == 0 {
+				return roles
+			}
+		}
+	}
+	return nil
+}
+
+// AuthorizationServerOptions returns gRPC server options with interceptors
+// that fail every call with PermissionDenied unless the caller's role can call
+// the method. Without these, no caller is an admin.
+func AuthorizationServerOptions(a *Authorizer) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(a.unaryInterceptor),
+		grpc.ChainStreamInterceptor(a.streamInterceptor),
+	}
+}
+
+func (a *Authorizer) unaryInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	ctx, err := a.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *Authorizer) streamInterceptor(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	ctx, err := a.authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextServerStream{ServerStream: ss, ctx: ctx})
+}
+
+// authorize returns the context with the caller's role if the role can call
+// the method.
+func (a *Authorizer) authorize(ctx context.Context, method string) (context.Context, error) {
+	state := peerTLSState(ctx)
+	if state == nil {
+		return nil, status.Error(codes.Unauthenticated, "missing client certificate")
+	}
+	role, needed := a.connectionRole(state), MethodRole(method)
+	if role < needed {
+		return nil, status.Errorf(codes.PermissionDenied, "role %v cannot call %v, requires %v", role, method, needed)
+	}
+	return context.WithValue(ctx, roleContextKey{}, role), nil
+}
+
+type roleContextKey struct{}
+
+// isAdmin returns true if the caller was authorized as an admin.
+func isAdmin(ctx context.Context) bool {
+	role, _ := ctx.Value(roleContextKey{}).(Role)
+	return role >= RoleAdmin
+}
+
+// peerTLSState returns the TLS state of the call, or nil if there is no client
+// certificate.
+func peerTLSState(ctx context.Context) *tls.ConnectionState {
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.PeerCertificates) > 0 {
+			return &tlsInfo.State
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package workergrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cretz/teleworker/worker"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// RateLimitConfig is configuration for WithRateLimits. Every granted namespace
+// has its own token buckets, which calls acting in the namespaces it grants
+// share. Zero rates are unlimited.
+type RateLimitConfig struct {
+	// Jobs that can be submitted per second, including each job of batches and
+	// workflows.
+	SubmitRate float64
+	// Jobs that can be submitted at once. If 0, this is the submit rate rounded
+	// up.
+	SubmitBurst int
+	// Calls of any method that can be made per second. Streaming calls count
+	// once when started.
+	CallRate float64
+	// Calls that can be made at once. If 0, this is the call rate rounded up.
+	CallBurst int
+}
+
+// WithRateLimits is a job service option to limit the rate of calls and job
+// submissions per granted namespace. Calls beyond the limits fail with
+// ResourceExhausted and RetryInfo details.
+func WithRateLimits(config RateLimitConfig) JobServiceOption {
+	return func(j *jobService) {
+		if config.SubmitRate > 0 && config.SubmitBurst <= 0 {
+			config.SubmitBurst = int(math.Ceil(config.SubmitRate))
+		}
+		if config.CallRate > 0 && config.CallBurst <= 0 {
+			config.CallBurst = int(math.Ceil(config.CallRate))
+		}
+		j.rateLimits = &rateLimiter{config: config, namespaces: map[string]*namespaceLimiters{}}
+	}
+}
+
+// How often limiters that have refilled are removed
+const rateLimiterSweepInterval = time.Minute
+
+type rateLimiter struct {
+	config     RateLimitConfig
+	namespaces map[string]*namespaceLimiters
+	lastSweep  time.Time
+	lock       sync.Mutex
+}
+
+// Limiters are nil if unlimited
+type namespaceLimiters struct {
+	submit *rate.Limiter
+	call   *rate.Limiter
+}
+
+// limiters returns the limiters of the namespace, creating them if needed. The
+// rate limiter may be nil.
+func (r *rateLimiter) limiters(namespace string) *namespaceLimiters {
+	if r == nil {
+		return &namespaceLimiters{}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.sweepLocked(time.Now())
+	limiters := r.namespaces[namespace]
+	if limiters == nil {
+		limiters = &namespaceLimiters{}
+		if r.config.SubmitRate > 0 {
+			limiters.submit = rate.NewLimiter(rate.Limit(r.config.SubmitRate), r.config.SubmitBurst)
+		}
+		if r.config.CallRate > 0 {
+			limiters.call = rate.NewLimiter(rate.Limit(r.config.CallRate), r.config.CallBurst)
+		}
+		r.namespaces[namespace] = limiters
+	}
+	return limiters
+}
+
+// sweepLocked removes limiters that have refilled, which are the same as new
+// ones, if not done within the sweep interval. Caller must hold the lock.
+func (r *rateLimiter) sweepLocked(now time.Time) {
+	if now.Sub(r.lastSweep) < rateLimiterSweepInterval {
+		return
+	}
+	r.lastSweep = now
+	for namespace, limiters := range r.namespaces {
+		if limiters.full(now) {
+			delete(r.namespaces, namespace)
+		}
+	}
+}
+
+// full returns whether every limiter has all of its tokens.
+func (n *namespaceLimiters) full(now time.Time) bool {
+	for _, limiter := range []*rate.Limiter{n.submit, n.call} {
+		if limiter != nil && limiter.TokensAt(now) < float64(limiter.Burst()) {
+			return false
+		}
+	}
+	return true
+}
+
+// allowCall takes a call token from the namespace.
+func (r *rateLimiter) allowCall(namespace string) error {
+	return takeTokens(r.limiters(namespace).call, 1, "call")
+}
+
+// allowSubmit takes a submission token from the namespace for each job.
+func (r *rateLimiter) allowSubmit(namespace string, jobs int) error {
+	return takeTokens(r.limiters(namespace).submit, jobs, "submission")
+}
+
+// takeTokens takes the tokens if available now or fails with ResourceExhausted
+// and when to retry.
+func takeTokens(limiter *rate.Limiter, n int, what string) error {
+	if limiter == nil {
+		return nil
+	}
+	now := time.Now()
+	reservation := limiter.ReserveN(now, n)
+	if !reservation.OK() {
+		return status.Errorf(codes.ResourceExhausted, "%v %v rate limit burst is %v", n, what, limiter.Burst())
+	}
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return nil
+	}
+	reservation.CancelAt(now)
+	st, err := status.New(codes.ResourceExhausted, fmt.Sprintf("%v rate limit exceeded", what)).
+		WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)})
+	if err != nil {
+		return status.Errorf(codes.ResourceExhausted, "%v rate limit exceeded", what)
+	}
+	return st.Err()
+}
+
+// quotaError converts the quota error to ResourceExhausted with QuotaFailure
+// details.
+func quotaError(err error) error {
+	var quotaErr *worker.QuotaExceededError
+	if !errors.As(err, &quotaErr) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	st, detailErr := status.New(codes.ResourceExhausted, err.Error()).WithDetails(&errdetails.QuotaFailure{
+		Violations: []*errdetails.QuotaFailure_Violation{{
+			Subject:     "namespace:" + quotaErr.Namespace,
+			Description: quotaErr.Quota,
+		}},
+	})
+	if detailErr != nil {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return st.Err()
+}
+
+func (j *jobService) GetQuota(ctx context.Context, req *GetQuotaRequest) (*GetQuotaResponse, error) {
+	ns, err := j.namespace(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := j.worker.Usage(ns)
+	if err == worker.ErrShutdown {
+		return nil, status.Error(codes.FailedPrecondition, "worker shutdown")
+	} else if err != nil {
+		return nil, err
+	}
+	quota := j.worker.Quota(ns)
+	resp := &GetQuotaResponse{
+		Quota: &NamespaceQuota{
+			MaxActiveJobs:   int32(quota.MaxActiveJobs),
+			MaxRetainedJobs: int32(quota.MaxRetainedJobs),
+			MaxOutputBytes:  quota.MaxOutputBytes,
+			MaxCpu:          quota.MaxCPU,
+			MaxMemoryBytes:  quota.MaxMemoryBytes,
+		},
+		Usage: &NamespaceUsage{
+			ActiveJobs:   int32(usage.ActiveJobs),
+			RetainedJobs: int32(usage.RetainedJobs),
+			OutputBytes:  usage.OutputBytes,
+			Cpu:          usage.CPU,
+			MemoryBytes:  usage.MemoryBytes,
+		},
+		RateLimits: &RateLimits{},
+	}
+	limiters := j.rateLimits.limiters(j.rateLimitNamespace(ctx, ns))
+	if limiters.submit != nil {
+		resp.RateLimits.SubmitRate = float64(limiters.submit.Limit())
+		resp.RateLimits.SubmitBurst = int32(limiters.submit.Burst())
+		resp.RateLimits.SubmitTokens = limiters.submit.Tokens()
+	}
+	if limiters.call != nil {
+		resp.RateLimits.CallRate = float64(limiters.call.Limit())
+		resp.RateLimits.CallBurst = int32(limiters.call.Burst())
+		resp.RateLimits.CallTokens = limiters.call.Tokens()
+	}
+	return resp, nil
+}
@@ -0,0 +1,135 @@
+package workergrpc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cretz/teleworker/worker"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (j *jobService) CreateSchedule(ctx context.Context, req *CreateScheduleRequest) (*CreateScheduleResponse, error) {
+	if err := validateCreateScheduleRequest(req); err != nil {
+		return nil, err
+	}
+	ns, err := j.namespace(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	config := worker.ScheduleConfig{
+		Cron:           req.Schedule.Cron,
+		TimeZone:       req.Schedule.TimeZone,
+		Job:            fromProtoJobSpec(req.Schedule.Job),
+		JobLabels:      req.Schedule.Job.Labels,
+		JobAnnotations: req.Schedule.Job.Annotations,
+	}
+	switch req.Schedule.Overlap {
+	case ScheduleOverlap_SCHEDULE_OVERLAP_QUEUE:
+		config.Overlap = worker.ScheduleOverlapQueue
+	case ScheduleOverlap_SCHEDULE_OVERLAP_REPLACE:
+		config.Overlap = worker.ScheduleOverlapReplace
+	default:
+		config.Overlap = worker.ScheduleOverlapSkip
+	}
+	schedule, err := j.worker.CreateSchedule(ns, req.Schedule.Id, config, req.Schedule.Paused)
+	if err == worker.ErrIDAlreadyExists {
+		return nil, status.Error(codes.AlreadyExists, "schedule with ID already exists")
+	} else if errors.Is(err, worker.ErrInvalidSchedule) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	} else if err != nil {
+		return nil, jobSpecError(err, req.Schedule.Job)
+	}
+	return &CreateScheduleResponse{Schedule: toProtoSchedule(schedule)}, nil
+}
+
+func validateCreateScheduleRequest(req *CreateScheduleRequest) error {
+	switch {
+	case req.Schedule == nil:
+		return status.Error(codes.InvalidArgument, "schedule required")
+	case req.Schedule.Cron == "":
+		return status.Error(codes.InvalidArgument, "cron expression required")
+	case req.Schedule.CreatedAt != nil:
+		return status.Error(codes.InvalidArgument, "created at cannot be present on create")
+	case req.Schedule.NextRunAt != nil:
+		return status.Error(codes.InvalidArgument, "next run at cannot be present on create")
+	case req.Schedule.LastJobId != "":
+		return status.Error(codes.InvalidArgument, "last job ID cannot be present on create")
+	case req.Schedule.Job != nil && req.Schedule.Job.Id != "":
+		return status.Error(codes.InvalidArgument, "job ID cannot be present on schedule")
+	case req.Schedule.Job != nil && len(req.Schedule.Job.Dependencies) > 0:
+		return status.Error(codes.InvalidArgument, "job dependencies cannot be present on schedule")
+	}
+	return validateJobSpec(req.Schedule.Job)
+}
+
+func (j *jobService) ListSchedules(ctx context.Context, req *ListSchedulesRequest) (*ListSchedulesResponse, error) {
+	ns, err := j.namespace(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	var resp ListSchedulesResponse
+	for _, schedule := range j.worker.Schedules(ns) {
+		resp.Schedules = append(resp.Schedules, toProtoSchedule(schedule))
+	}
+	return &resp, nil
+}
+
+func (j *jobService) DeleteSchedule(ctx context.Context, req *DeleteScheduleRequest) (*DeleteScheduleResponse, error) {
+	if req.ScheduleId == "" {
+		return nil, status.Error(codes.InvalidArgument, "schedule ID required")
+	}
+	ns, err := j.namespace(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if err := j.worker.DeleteSchedule(ns, req.ScheduleId); err == worker.ErrScheduleNotFound {
+		return nil, status.Error(codes.NotFound, "not found")
+	} else if err != nil {
+		return nil, err
+	}
+	return &DeleteScheduleResponse{}, nil
+}
+
+func (j *jobService) PauseSchedule(ctx context.Context, req *PauseScheduleRequest) (*PauseScheduleResponse, error) {
+	if req.ScheduleId == "" {
+		return nil, status.Error(codes.InvalidArgument, "schedule ID required")
+	}
+	ns, err := j.namespace(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := j.worker.PauseSchedule(ns, req.ScheduleId, req.Paused)
+	if err == worker.ErrScheduleNotFound {
+		return nil, status.Error(codes.NotFound, "not found")
+	} else if err != nil {
+		return nil, err
+	}
+	return &PauseScheduleResponse{Schedule: toProtoSchedule(schedule)}, nil
+}
+
+func toProtoSchedule(schedule *worker.Schedule) *Schedule {
+	pbSchedule := &Schedule{
+		Id:        schedule.ID,
+		Cron:      schedule.Cron,
+		TimeZone:  schedule.TimeZone,
+		Job:       toProtoJobSpec(&schedule.Job),
+		Paused:    schedule.Paused(),
+		CreatedAt: timestamppb.New(schedule.CreatedAt),
+		LastJobId: schedule.LastJobID(),
+	}
+	switch schedule.Overlap {
+	case worker.ScheduleOverlapSkip:
+		pbSchedule.Overlap = ScheduleOverlap_SCHEDULE_OVERLAP_SKIP
+	case worker.ScheduleOverlapQueue:
+		pbSchedule.Overlap = ScheduleOverlap_SCHEDULE_OVERLAP_QUEUE
+	case worker.ScheduleOverlapReplace:
+		pbSchedule.Overlap = ScheduleOverlap_SCHEDULE_OVERLAP_REPLACE
+	}
+	pbSchedule.Job.Labels, pbSchedule.Job.Annotations = schedule.JobLabels, schedule.JobAnnotations
+	if nextRunAt := schedule.NextRunAt(); !nextRunAt.IsZero() {
+		pbSchedule.NextRunAt = timestamppb.New(nextRunAt)
+	}
+	return pbSchedule
+}
@@ -2,49 +2,129 @@ package workergrpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/cretz/teleworker/worker"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 type jobService struct {
 	UnimplementedJobServiceServer
-	worker *worker.Worker
+	worker      *worker.Worker
+	audit       *AuditLog
+	log         *slog.Logger
+	rateLimits  *rateLimiter
+	stopTimeout time.Duration
+	hierarchy   bool
 }
 
+// DefaultStopTimeout is how long stop calls wait for jobs to stop if the
+// request has no timeout and WithStopTimeout is not used.
+const DefaultStopTimeout = 3 * time.Second
+
+// JobServiceOption is an option for NewJobServiceServer.
+type JobServiceOption func(*jobService)
+
 // NewJobServiceServer returns an implementation of JobServiceServer backed by
 // the given worker. Note, no RPC calls will work on this unless the gRPC
 // credentials contain a client certificate (whose OU is used as the job
 // namespace).
-func NewJobServiceServer(w *worker.Worker) JobServiceServer { return &jobService{worker: w} }
+func NewJobServiceServer(w *worker.Worker, opts ...JobServiceOption) JobServiceServer {
+	j := &jobService{worker: w, stopTimeout: DefaultStopTimeout}
+	for _, opt := range opts {
+		opt(j)
+	}
+	return j
+}
+
+// WithLogger is a job service option to log admins acting across namespaces.
+func WithLogger(logger *slog.Logger) JobServiceOption {
+	return func(j *jobService) { j.log = logger }
+}
+
+// WithStopTimeout is a job service option to set how long stop calls wait for
+// jobs to stop if the request has no timeout. If not positive,
+// DefaultStopTimeout is used.
+func WithStopTimeout(timeout time.Duration) JobServiceOption {
+	return func(j *jobService) {
+		if timeout > 0 {
+			j.stopTimeout = timeout
+		}
+	}
+}
+
+// WithNamespaceHierarchy is a job service option to make granted namespaces
+// also grant their descendants, e.g. "team1" grants "team1/project1". Without
+// it, only the exact namespaces granted can be acted in. Calls in descendants
+// share the rate limits of the granted namespace, and like any namespace they
+// share the worker's configuration of their nearest configured ancestor.
+func WithNamespaceHierarchy() JobServiceOption {
+	return func(j *jobService) { j.hierarchy = true }
+}
+
+// requestStopTimeout returns the request's stop timeout or the default if
+// absent.
+func (j *jobService) requestStopTimeout(timeout *durationpb.Duration) (time.Duration, error) {
+	if timeout == nil {
+		return j.stopTimeout, nil
+	} else if err := timeout.CheckValid(); err != nil || timeout.AsDuration() <= 0 {
+		return 0, status.Error(codes.InvalidArgument, "timeout must be positive")
+	}
+	return timeout.AsDuration(), nil
+}
 
 func (j *jobService) GetJob(ctx context.Context, req *GetJobRequest) (*GetJobResponse, error) {
 	// Get job
-	job, err := j.getJob(ctx, req.JobId)
+	job, err := j.getJob(ctx, req.Namespace, req.JobId)
 	if err != nil {
 		return nil, err
 	}
-	// Convert and return
-	pbJob, err := toProtoJob(job, req.IncludeStdout, req.IncludeStderr)
+	if req.Attempt == 0 {
+		// Convert and return
+		pbJob, err := j.toProtoJob(job, req.IncludeStdout, req.IncludeStderr)
+		if err != nil {
+			return nil, err
+		}
+		return &GetJobResponse{Job: pbJob}, nil
+	}
+	// Use output of the requested attempt
+	attempt := job.Attempt(int(req.Attempt))
+	if attempt == nil {
+		return nil, status.Errorf(codes.NotFound, "attempt %v not found", req.Attempt)
+	}
+	pbJob, err := j.toProtoJob(job, false /* includeStdout */, false /* includeStderr */)
 	if err != nil {
 		return nil, err
 	}
+	if req.IncludeStdout {
+		if pbJob.Stdout, err = allOutput(attempt.ReadStdout); err != nil {
+			return nil, fmt.Errorf("reading stdout: %w", err)
+		}
+	}
+	if req.IncludeStderr {
+		if pbJob.Stderr, err = allOutput(attempt.ReadStderr); err != nil {
+			return nil, fmt.Errorf("reading stderr: %w", err)
+		}
+	}
 	return &GetJobResponse{Job: pbJob}, nil
 }
 
 // getJob fails if job not found.
-func (j *jobService) getJob(ctx context.Context, id string) (*worker.Job, error) {
+func (j *jobService) getJob(ctx context.Context, namespace, id string) (*worker.Job, error) {
 	if id == "" {
 		return nil, status.Errorf(codes.InvalidArgument, "job ID required")
 	}
-	ns, err := namespaceFromContext(ctx)
+	ns, err := j.namespace(ctx, namespace)
 	if err != nil {
 		return nil, err
 	}
@@ -59,29 +139,130 @@ func (j *jobService) getJob(ctx context.Context, id string) (*worker.Job, error)
 	return job, nil
 }
 
+// namespace returns the namespace the call acts in, which is the requested one
+// if present or the caller's otherwise, and takes a call token from it. Only
+// admins can act in other namespaces and when they do it is logged.
+func (j *jobService) namespace(ctx context.Context, requested string) (string, error) {
+	granted, err := namespacesFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	ns := requested
+	if ns == "" {
+		ns = granted[0]
+	} else if !ValidNamespace(ns) {
+		return "", status.Errorf(codes.InvalidArgument, "invalid namespace %q", ns)
+	} else if !grantsNamespace(granted, ns, j.hierarchy) {
+		if !isAdmin(ctx) {
+			return "", status.Errorf(codes.PermissionDenied, "cannot act in namespace %q", ns)
+		}
+		j.logCrossNamespace(ctx, slog.String("target_namespace", ns))
+	}
+	if err := j.rateLimits.allowCall(rateLimitNamespace(granted, ns, j.hierarchy)); err != nil {
+		return "", err
+	}
+	return ns, nil
+}
+
+// allowSubmit takes a submission token for each job from the rate limits of the
+// namespace the call acts in.
+func (j *jobService) allowSubmit(ctx context.Context, namespace string, jobs int) error {
+	return j.rateLimits.allowSubmit(j.rateLimitNamespace(ctx, namespace), jobs)
+}
+
+// rateLimitNamespace returns the namespace whose rate limits a call acting in
+// the namespace uses.
+func (j *jobService) rateLimitNamespace(ctx context.Context, namespace string) string {
+	granted, err := namespacesFromContext(ctx)
+	if err != nil {
+		return namespace
+	}
+	return rateLimitNamespace(granted, namespace, j.hierarchy)
+}
+
+// rateLimitNamespace returns the outermost granted namespace that grants the
+// namespace so calls in its descendants share its rate limits. If none grant it,
+// which only happens for admins acting in other namespaces, this is the one of
+// the caller's default namespace so the admin cannot drain the other's.
+func rateLimitNamespace(granted []string, namespace string, hierarchical bool) string {
+	if !grantsNamespace(granted, namespace, hierarchical) {
+		namespace = granted[0]
+	}
+	limitNamespace := namespace
+	for _, g := range granted {
+		if NamespaceGrants(g, namespace, hierarchical) && len(g) < len(limitNamespace) {
+			limitNamespace = g
+		}
+	}
+	return limitNamespace
+}
+
+// logCrossNamespace logs that an admin is acting in another namespace or
+// across namespaces.
+func (j *jobService) logCrossNamespace(ctx context.Context, attrs ...slog.Attr) {
+	if j.log == nil {
+		return
+	}
+	method, _ := grpc.Method(ctx)
+	attrs = append(append(peerAttrs(ctx), slog.String("method", method), slog.Bool("cross_namespace", true)), attrs...)
+	j.log.LogAttrs(ctx, slog.LevelInfo, "Admin acting across namespaces", attrs...)
+}
+
+// namespaceFromContext returns the caller's default namespace.
 func namespaceFromContext(ctx context.Context) (string, error) {
-	// Must have a peer context and a certificate, then we can use the leaf OU
-	// (even if empty)
+	granted, err := namespacesFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	return granted[0], nil
+}
+
+// namespacesFromContext returns the namespaces granted to the caller, of which
+// there is always at least one.
+func namespacesFromContext(ctx context.Context) ([]string, error) {
+	// Must have a peer context and a certificate, then we can use the leaf
 	if p, ok := peer.FromContext(ctx); ok {
 		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
-			ous := tlsInfo.State.PeerCertificates[0].Subject.OrganizationalUnit
-			if len(ous) == 0 {
-				return "", nil
-			}
-			return ous[0], nil
+			return CertificateNamespaces(tlsInfo.State.PeerCertificates[0]), nil
 		}
 	}
 	// Certificate required
-	return "", status.Error(codes.Unauthenticated, "missing client certificate")
+	return nil, status.Error(codes.Unauthenticated, "missing client certificate")
 }
 
-func toProtoJob(job *worker.Job, includeStdout, includeStderr bool) (*Job, error) {
-	pbJob := &Job{
-		Id:        job.ID,
-		Command:   append([]string{job.Command}, job.Args...),
-		RootFs:    job.RootFS,
-		CreatedAt: timestamppb.New(job.CreatedAt),
-		Pid:       int64(job.PID),
+// grantsNamespace returns true if any granted namespace grants the namespace.
+func grantsNamespace(granted []string, namespace string, hierarchical bool) bool {
+	for _, g := range granted {
+		if NamespaceGrants(g, namespace, hierarchical) {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *jobService) toProtoJob(job *worker.Job, includeStdout, includeStderr bool) (*Job, error) {
+	pbJob := toProtoJobSpec(&job.JobSpec)
+	pbJob.Id = job.ID
+	pbJob.CreatedAt = timestamppb.New(job.CreatedAt)
+	pbJob.Pid = int64(job.PID())
+	pbJob.Capabilities = job.Capabilities
+	pbJob.State = toProtoJobState(job.State())
+	pbJob.QueuePosition = int32(j.worker.QueuePosition(job))
+	pbJob.Preempted = job.Preempted()
+	pbJob.ScheduleId = job.ScheduleID
+	pbJob.Dependencies = toProtoDependencies(job.Dependencies)
+	pbJob.WorkflowId = job.WorkflowID
+	pbJob.TemplateName, pbJob.TemplateVersion = job.TemplateName, int32(job.TemplateVersion)
+	pbJob.IdempotencyKey = job.IdempotencyKey
+	pbJob.Labels, pbJob.Annotations = job.Labels(), job.Annotations()
+	if completedAt := job.CompletedAt(); !completedAt.IsZero() {
+		pbJob.CompletedAt = timestamppb.New(completedAt)
+	}
+	for _, attempt := range job.Attempts() {
+		pbJob.Attempts = append(pbJob.Attempts, toProtoJobAttempt(attempt))
+	}
+	if err := job.StartError(); err != nil {
+		pbJob.StartError = err.Error()
 	}
 	// We intentionally obtain the exit code before getting output since it's not
 	// atomic. If we get the exit code after, we could have a case where the exit
@@ -104,6 +285,141 @@ func toProtoJob(job *worker.Job, includeStdout, includeStderr bool) (*Job, error
 	return pbJob, nil
 }
 
+func toProtoJobSpec(spec *worker.JobSpec) *Job {
+	pbJob := &Job{
+		Command:     append([]string{spec.Command}, spec.Args...),
+		Env:         spec.Env,
+		RootFs:      spec.RootFS,
+		User:        spec.User,
+		Rlimits:     toProtoRLimits(&spec.RLimits),
+		Resources:   toProtoJobResources(&spec.Resources),
+		Hostname:    spec.Hostname,
+		Priority:    int32(spec.Priority),
+		RetryPolicy: toProtoRetryPolicy(spec.RetryPolicy),
+	}
+	if spec.TTL > 0 {
+		pbJob.Ttl = durationpb.New(spec.TTL)
+	}
+	// Secrets are write-only
+	for _, target := range spec.Webhooks {
+		pbJob.Webhooks = append(pbJob.Webhooks, &WebhookTarget{Url: target.URL})
+	}
+	return pbJob
+}
+
+func toProtoJobAttempt(attempt *worker.JobAttempt) *JobAttempt {
+	pbAttempt := &JobAttempt{
+		Number:    int32(attempt.Number),
+		Pid:       int64(attempt.PID()),
+		StartedAt: timestamppb.New(attempt.StartedAt),
+		Signal:    attempt.Signal(),
+	}
+	if exitCode := attempt.ExitCode(); exitCode != nil {
+		pbAttempt.ExitCode = wrapperspb.Int32(int32(*exitCode))
+		pbAttempt.EndedAt = timestamppb.New(attempt.EndedAt())
+	}
+	return pbAttempt
+}
+
+func toProtoRetryPolicy(policy *worker.RetryPolicy) *RetryPolicy {
+	if policy == nil {
+		return nil
+	}
+	pbPolicy := &RetryPolicy{
+		MaxAttempts:       int32(policy.MaxAttempts),
+		InitialBackoff:    durationpb.New(policy.InitialBackoff),
+		MaxBackoff:        durationpb.New(policy.MaxBackoff),
+		BackoffMultiplier: policy.BackoffMultiplier,
+		Jitter:            policy.Jitter,
+		RetryableSignals:  policy.RetryableSignals,
+	}
+	for _, code := range policy.RetryableExitCodes {
+		pbPolicy.RetryableExitCodes = append(pbPolicy.RetryableExitCodes, int32(code))
+	}
+	return pbPolicy
+}
+
+func fromProtoRetryPolicy(policy *RetryPolicy) *worker.RetryPolicy {
+	if policy == nil {
+		return nil
+	}
+	retryPolicy := &worker.RetryPolicy{
+		MaxAttempts:       int(policy.MaxAttempts),
+		InitialBackoff:    policy.InitialBackoff.AsDuration(),
+		MaxBackoff:        policy.MaxBackoff.AsDuration(),
+		BackoffMultiplier: policy.BackoffMultiplier,
+		Jitter:            policy.Jitter,
+		RetryableSignals:  policy.RetryableSignals,
+	}
+	for _, code := range policy.RetryableExitCodes {
+		retryPolicy.RetryableExitCodes = append(retryPolicy.RetryableExitCodes, int(code))
+	}
+	return retryPolicy
+}
+
+func toProtoJobState(state worker.JobState) JobState {
+	switch state {
+	case worker.JobStateQueued:
+		return JobState_JOB_STATE_QUEUED
+	case worker.JobStateRunning:
+		return JobState_JOB_STATE_RUNNING
+	case worker.JobStateCompleted:
+		return JobState_JOB_STATE_COMPLETED
+	case worker.JobStateCanceled:
+		return JobState_JOB_STATE_CANCELED
+	case worker.JobStatePending:
+		return JobState_JOB_STATE_PENDING
+	case worker.JobStateRetrying:
+		return JobState_JOB_STATE_RETRYING
+	}
+	return JobState_JOB_STATE_UNSPECIFIED
+}
+
+func toProtoRLimits(rlimits *worker.JobRLimits) *RLimits {
+	if rlimits.NoFile == nil && rlimits.Core == nil && rlimits.FileSize == nil && rlimits.Stack == nil {
+		return nil
+	}
+	toProto := func(limit *worker.JobRLimit) *RLimit {
+		if limit == nil {
+			return nil
+		}
+		return &RLimit{Soft: limit.Soft, Hard: limit.Hard}
+	}
+	return &RLimits{
+		Nofile: toProto(rlimits.NoFile),
+		Core:   toProto(rlimits.Core),
+		Fsize:  toProto(rlimits.FileSize),
+		Stack:  toProto(rlimits.Stack),
+	}
+}
+
+func toProtoJobResources(resources *worker.JobResources) *JobResources {
+	if *resources == (worker.JobResources{}) {
+		return nil
+	}
+	return &JobResources{
+		CpuSet:      resources.CPUSet,
+		MemoryNodes: resources.MemoryNodes,
+		CpuWeight:   resources.CPUWeight,
+		MemoryHigh:  resources.MemoryHigh,
+	}
+}
+
+func fromProtoRLimits(rlimits *RLimits) worker.JobRLimits {
+	fromProto := func(limit *RLimit) *worker.JobRLimit {
+		if limit == nil {
+			return nil
+		}
+		return &worker.JobRLimit{Soft: limit.Soft, Hard: limit.Hard}
+	}
+	return worker.JobRLimits{
+		NoFile:   fromProto(rlimits.GetNofile()),
+		Core:     fromProto(rlimits.GetCore()),
+		FileSize: fromProto(rlimits.GetFsize()),
+		Stack:    fromProto(rlimits.GetStack()),
+	}
+}
+
 func allOutput(fn func(b []byte, offset int) (read, total int, exitCode *int, err error)) ([]byte, error) {
 	// Continually ask until no more left
 	var offset int
@@ -123,53 +439,184 @@ func (j *jobService) SubmitJob(ctx context.Context, req *SubmitJobRequest) (*Sub
 	if err := validateSubmitJobRequest(req); err != nil {
 		return nil, err
 	}
-	ns, err := namespaceFromContext(ctx)
+	ns, err := j.namespace(ctx, req.Namespace)
 	if err != nil {
 		return nil, err
+	} else if err := j.allowSubmit(ctx, ns, 1); err != nil {
+		return nil, err
 	}
 	// Submit, convert, and return
 	var submitOpts []worker.SubmitJobOption
-	if req.Job.RootFs != "" {
-		submitOpts = []worker.SubmitJobOption{worker.WithRootFS(req.Job.RootFs)}
+	if len(req.Job.Dependencies) > 0 {
+		submitOpts = append(submitOpts, worker.WithDependencies(fromProtoDependencies(req.Job.Dependencies)...))
 	}
-	job, err := j.worker.SubmitJob(ns, req.Job.Id, req.Job.Command[0], req.Job.Command[1:], submitOpts...)
-	if err == worker.ErrShutdown {
-		return nil, status.Error(codes.FailedPrecondition, "worker shutdown")
-	} else if err == worker.ErrIDAlreadyExists {
-		return nil, status.Error(codes.AlreadyExists, "job with ID already exists")
-	} else if err != nil {
-		return nil, err
+	if req.IdempotencyKey != "" {
+		submitOpts = append(submitOpts, worker.WithIdempotencyKey(req.IdempotencyKey))
+	}
+	submitOpts = append(submitOpts, worker.WithLabels(req.Job.Labels), worker.WithAnnotations(req.Job.Annotations),
+		worker.WithTraceContext(ctx))
+	job, err := j.worker.SubmitJobSpec(ns, req.Job.Id, fromProtoJobSpec(req.Job), submitOpts...)
+	if err != nil {
+		return nil, jobSpecError(err, req.Job)
 	}
-	pbJob, err := toProtoJob(job, false /* includeStdout */, false /* includeStderr */)
+	pbJob, err := j.toProtoJob(job, false /* includeStdout */, false /* includeStderr */)
 	if err != nil {
 		return nil, err
 	}
 	return &SubmitJobResponse{Job: pbJob}, nil
 }
 
+// jobSpecError converts an error submitting or validating the job spec to a
+// status error.
+func jobSpecError(err error, job *Job) error {
+	if err == worker.ErrShutdown {
+		return status.Error(codes.FailedPrecondition, "worker shutdown")
+	} else if err == worker.ErrIDAlreadyExists {
+		return status.Error(codes.AlreadyExists, "job with ID already exists")
+	} else if errors.Is(err, worker.ErrIDAlreadyExists) {
+		return status.Error(codes.AlreadyExists, err.Error())
+	} else if err == worker.ErrUserNotAllowed && job != nil {
+		return status.Errorf(codes.PermissionDenied, "user %q not allowed", job.User)
+	} else if errors.Is(err, worker.ErrUserNotAllowed) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	} else if errors.Is(err, worker.ErrInvalidRLimits) || errors.Is(err, worker.ErrInvalidHostname) ||
+		errors.Is(err, worker.ErrInvalidWorkflow) || errors.Is(err, worker.ErrInvalidRetryPolicy) ||
+		errors.Is(err, worker.ErrInvalidEnv) || errors.Is(err, worker.ErrInvalidWebhook) ||
+		errors.Is(err, worker.ErrInvalidResources) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	} else if errors.Is(err, worker.ErrDependencyNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	} else if errors.Is(err, worker.ErrInvalidLabels) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	} else if errors.Is(err, worker.ErrIdempotencyKeyMismatch) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	} else if errors.Is(err, worker.ErrQuotaExceeded) {
+		return quotaError(err)
+	}
+	return err
+}
+
+func fromProtoJobSpec(job *Job) worker.JobSpec {
+	spec := worker.JobSpec{
+		Command:     job.Command[0],
+		Args:        job.Command[1:],
+		Env:         job.Env,
+		RootFS:      job.RootFs,
+		User:        job.User,
+		Hostname:    job.Hostname,
+		Priority:    int(job.Priority),
+		RetryPolicy: fromProtoRetryPolicy(job.RetryPolicy),
+		TTL:         job.Ttl.AsDuration(),
+	}
+	if job.Rlimits != nil {
+		spec.RLimits = fromProtoRLimits(job.Rlimits)
+	}
+	if job.Resources != nil {
+		spec.Resources = worker.JobResources{
+			CPUSet:      job.Resources.CpuSet,
+			MemoryNodes: job.Resources.MemoryNodes,
+			CPUWeight:   job.Resources.CpuWeight,
+			MemoryHigh:  job.Resources.MemoryHigh,
+		}
+	}
+	for _, target := range job.Webhooks {
+		spec.Webhooks = append(spec.Webhooks, worker.WebhookTarget{URL: target.Url, Secret: target.Secret})
+	}
+	return spec
+}
+
+func fromProtoDependencies(deps []*JobDependency) []worker.JobDependency {
+	ret := make([]worker.JobDependency, len(deps))
+	for i, dep := range deps {
+		ret[i].JobID = dep.JobId
+		switch dep.Condition {
+		case DependencyCondition_DEPENDENCY_CONDITION_ON_FAILURE:
+			ret[i].Condition = worker.DependencyOnFailure
+		case DependencyCondition_DEPENDENCY_CONDITION_ALWAYS:
+			ret[i].Condition = worker.DependencyAlways
+		default:
+			ret[i].Condition = worker.DependencyOnSuccess
+		}
+	}
+	return ret
+}
+
+func toProtoDependencies(deps []worker.JobDependency) []*JobDependency {
+	var ret []*JobDependency
+	for _, dep := range deps {
+		pbDep := &JobDependency{JobId: dep.JobID}
+		switch dep.Condition {
+		case worker.DependencyOnSuccess:
+			pbDep.Condition = DependencyCondition_DEPENDENCY_CONDITION_ON_SUCCESS
+		case worker.DependencyOnFailure:
+			pbDep.Condition = DependencyCondition_DEPENDENCY_CONDITION_ON_FAILURE
+		case worker.DependencyAlways:
+			pbDep.Condition = DependencyCondition_DEPENDENCY_CONDITION_ALWAYS
+		}
+		ret = append(ret, pbDep)
+	}
+	return ret
+}
+
 func validateSubmitJobRequest(req *SubmitJobRequest) error {
+	return validateJobSpec(req.Job)
+}
+
+// validateJobSpec confirms the job only has values that can be submitted.
+func validateJobSpec(job *Job) error {
 	// TODO(cretz): If doing properly, we'd send status with details of
 	// google.rpc.BadRequest with each field failure
 	switch {
-	case len(req.Job.Command) == 0:
+	case job == nil:
+		return status.Error(codes.InvalidArgument, "job required")
+	case len(job.Command) == 0:
 		return status.Error(codes.InvalidArgument, "at least one command value required")
-	case req.Job.CreatedAt != nil:
+	case job.CreatedAt != nil:
 		return status.Error(codes.InvalidArgument, "created at cannot be present on create")
-	case req.Job.Pid != 0:
+	case job.Pid != 0:
 		return status.Error(codes.InvalidArgument, "PID cannot be present on create")
-	case len(req.Job.Stdout) != 0:
+	case len(job.Stdout) != 0:
 		return status.Error(codes.InvalidArgument, "stdout cannot be present on create")
-	case len(req.Job.Stderr) != 0:
+	case len(job.Stderr) != 0:
 		return status.Error(codes.InvalidArgument, "stderr cannot be present on create")
-	case req.Job.ExitCode != nil:
+	case job.ExitCode != nil:
 		return status.Error(codes.InvalidArgument, "exit code cannot be present on create")
+	case len(job.Capabilities) != 0:
+		return status.Error(codes.InvalidArgument, "capabilities cannot be present on create")
+	case job.State != JobState_JOB_STATE_UNSPECIFIED:
+		return status.Error(codes.InvalidArgument, "state cannot be present on create")
+	case job.QueuePosition != 0:
+		return status.Error(codes.InvalidArgument, "queue position cannot be present on create")
+	case job.StartError != "":
+		return status.Error(codes.InvalidArgument, "start error cannot be present on create")
+	case job.Preempted:
+		return status.Error(codes.InvalidArgument, "preempted cannot be present on create")
+	case job.ScheduleId != "":
+		return status.Error(codes.InvalidArgument, "schedule ID cannot be present on create")
+	case job.WorkflowId != "":
+		return status.Error(codes.InvalidArgument, "workflow ID cannot be present on create")
+	case len(job.Attempts) != 0:
+		return status.Error(codes.InvalidArgument, "attempts cannot be present on create")
+	case job.IdempotencyKey != "":
+		return status.Error(codes.InvalidArgument, "idempotency key cannot be present on job")
+	case job.Ttl != nil && job.Ttl.AsDuration() < 0:
+		return status.Error(codes.InvalidArgument, "TTL cannot be negative")
+	case job.CompletedAt != nil:
+		return status.Error(codes.InvalidArgument, "completed at cannot be present on create")
+	case job.TemplateName != "" || job.TemplateVersion != 0:
+		return status.Error(codes.InvalidArgument, "template cannot be present on create")
+	}
+	for _, dep := range job.Dependencies {
+		if dep.JobId == "" {
+			return status.Error(codes.InvalidArgument, "dependency job ID required")
+		}
 	}
 	return nil
 }
 
 func (j *jobService) StopJob(ctx context.Context, req *StopJobRequest) (*StopJobResponse, error) {
 	// Get job
-	job, err := j.getJob(ctx, req.JobId)
+	job, err := j.getJob(ctx, req.Namespace, req.JobId)
 	if err != nil {
 		return nil, err
 	}
@@ -178,28 +625,58 @@ func (j *jobService) StopJob(ctx context.Context, req *StopJobRequest) (*StopJob
 	if job.ExitCode() != nil {
 		return nil, status.Error(codes.FailedPrecondition, "job already stopped")
 	}
-	// Attempt to stop only for 3 seconds
-	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	// Attempt to stop only until the timeout
+	timeout, err := j.requestStopTimeout(req.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	if _, err := job.Stop(ctx, req.Force); err == context.DeadlineExceeded {
-		return nil, status.Error(codes.DeadlineExceeded, "failed stopping job within 3 seconds")
+		return nil, status.Errorf(codes.DeadlineExceeded, "failed stopping job within %v", timeout)
 	} else if err != nil {
 		return nil, err
 	}
 	// Convert and return
-	pbJob, err := toProtoJob(job, false /* includeStdout */, false /* includeStderr */)
+	pbJob, err := j.toProtoJob(job, false /* includeStdout */, false /* includeStderr */)
 	if err != nil {
 		return nil, err
 	}
 	return &StopJobResponse{Job: pbJob}, nil
 }
 
+func (j *jobService) DeleteJob(ctx context.Context, req *DeleteJobRequest) (*DeleteJobResponse, error) {
+	if req.JobId == "" {
+		return nil, status.Error(codes.InvalidArgument, "job ID required")
+	}
+	ns, err := j.namespace(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if err := j.worker.DeleteJob(ns, req.JobId); err == worker.ErrJobNotFound {
+		return nil, status.Error(codes.NotFound, "not found")
+	} else if err == worker.ErrJobNotCompleted {
+		return nil, status.Error(codes.FailedPrecondition, "job not completed")
+	} else if err == worker.ErrShutdown {
+		return nil, status.Error(codes.FailedPrecondition, "worker shutdown")
+	} else if err != nil {
+		return nil, err
+	}
+	return &DeleteJobResponse{}, nil
+}
+
 func (j *jobService) StreamJobOutput(req *StreamJobOutputRequest, srv JobService_StreamJobOutputServer) error {
 	// Get job
-	job, err := j.getJob(srv.Context(), req.JobId)
+	job, err := j.getJob(srv.Context(), req.Namespace, req.JobId)
 	if err != nil {
 		return err
 	}
+	var attempt *worker.JobAttempt
+	if req.Attempt != 0 {
+		if attempt = job.Attempt(int(req.Attempt)); attempt == nil {
+			return status.Errorf(codes.NotFound, "attempt %v not found", req.Attempt)
+		}
+	}
 	// gRPC docs not only say send cannot occur concurrently, but can't even occur
 	// on separate goroutines so we use a channel to do all sends on the same
 	// goroutine (this one). We do not need to buffer this since we expect all
@@ -210,12 +687,16 @@ func (j *jobService) StreamJobOutput(req *StreamJobOutputRequest, srv JobService
 	var stdoutErrCh chan error
 	if !req.GetOnlyStderr() {
 		stdoutErrCh = make(chan error, 1)
-		go func() { stdoutErrCh <- j.streamOutput(srv, responseCh, job, req.FromBeginning, false /*stderr*/) }()
+		go func() {
+			stdoutErrCh <- j.streamOutput(srv, responseCh, job, attempt, req.FromBeginning, false /*stderr*/)
+		}()
 	}
 	var stderrErrCh chan error
 	if !req.GetOnlyStdout() {
 		stderrErrCh = make(chan error, 1)
-		go func() { stderrErrCh <- j.streamOutput(srv, responseCh, job, req.FromBeginning, true /*stderr*/) }()
+		go func() {
+			stderrErrCh <- j.streamOutput(srv, responseCh, job, attempt, req.FromBeginning, true /*stderr*/)
+		}()
 	}
 	// Continue reading until both channels are nil
 	for stdoutErrCh != nil || stderrErrCh != nil {
@@ -242,24 +723,73 @@ func (j *jobService) StreamJobOutput(req *StreamJobOutputRequest, srv JobService
 	}
 	// Both streams completed successfully (or were not started), send exit code
 	// and complete. We count on gRPC server stream to send before closing stream
-	// completely (assuming client doesn't abnormally terminate).
+	// completely (assuming client doesn't abnormally terminate). Exit code will
+	// always be present since that's the only way streamOutput closes without
+	// error.
+	exitCode := job.ExitCode()
+	if attempt != nil {
+		exitCode = attempt.ExitCode()
+	}
 	return srv.Send(&StreamJobOutputResponse{
-		// Exit code will always be present since that's the only way streamOutput
-		// closes without error
-		Response: &StreamJobOutputResponse_CompletedExitCode{CompletedExitCode: int32(*job.ExitCode())},
+		Response: &StreamJobOutputResponse_CompletedExitCode{CompletedExitCode: int32(*exitCode)},
 	})
 }
 
+// streamOutput streams the output of the given attempt, or if nil, the latest
+// attempt and every attempt after it until the job completes.
 func (j *jobService) streamOutput(
 	srv JobService_StreamJobOutputServer,
 	responseCh chan<- *StreamJobOutputResponse,
 	job *worker.Job,
+	attempt *worker.JobAttempt,
 	fromBeginning bool,
 	stderr bool,
 ) error {
-	readFn := job.ReadStdout
+	// Start a listener with a buffer of 2 just to make sure we don't miss an
+	// update at the same time we receive one (extra updates are harmless)
+	updateCh := make(chan worker.JobUpdate, 2)
+	job.AddUpdateListener(updateCh)
+	defer job.RemoveUpdateListener(updateCh)
+	if attempt != nil {
+		return j.streamAttemptOutput(srv, responseCh, updateCh, attempt, fromBeginning, stderr)
+	}
+	number := len(job.Attempts())
+	if number == 0 {
+		number = 1
+	}
+	for {
+		// Wait for the attempt to start unless the job completes first
+		if attempt = job.Attempt(number); attempt == nil {
+			if job.ExitCode() != nil {
+				return nil
+			}
+			select {
+			case <-srv.Context().Done():
+				return srv.Context().Err()
+			case <-updateCh:
+			}
+			continue
+		}
+		if err := j.streamAttemptOutput(srv, responseCh, updateCh, attempt, fromBeginning, stderr); err != nil {
+			return err
+		}
+		// Later attempts are always streamed from the beginning
+		number++
+		fromBeginning = true
+	}
+}
+
+func (j *jobService) streamAttemptOutput(
+	srv JobService_StreamJobOutputServer,
+	responseCh chan<- *StreamJobOutputResponse,
+	updateCh <-chan worker.JobUpdate,
+	attempt *worker.JobAttempt,
+	fromBeginning bool,
+	stderr bool,
+) error {
+	readFn := attempt.ReadStdout
 	if stderr {
-		readFn = job.ReadStderr
+		readFn = attempt.ReadStderr
 	}
 	// Make an eager read with a nil slice to get the initial total
 	_, pastTotal, _, err := readFn(nil, 0)
@@ -286,7 +816,7 @@ func (j *jobService) streamOutput(
 			}
 			offset += n
 			// Send
-			msg := &StreamJobOutputResponse{Past: true}
+			msg := &StreamJobOutputResponse{Past: true, Attempt: int32(attempt.Number)}
 			if stderr {
 				msg.Response = &StreamJobOutputResponse_Stderr{Stderr: b}
 			} else {
@@ -299,11 +829,6 @@ func (j *jobService) streamOutput(
 			}
 		}
 	}
-	// Start a listener with a buffer of 2 just to make sure we don't miss an
-	// update at the same time we receive one (extra updates are harmless)
-	updateCh := make(chan worker.JobUpdate, 2)
-	job.AddUpdateListener(updateCh)
-	defer job.RemoveUpdateListener(updateCh)
 	// Start from the past total
 	offset := pastTotal
 	buf := make([]byte, chunkSize)
@@ -321,7 +846,7 @@ func (j *jobService) streamOutput(
 				// the bytes
 				b := make([]byte, n)
 				copy(b, buf)
-				var msg StreamJobOutputResponse
+				msg := StreamJobOutputResponse{Attempt: int32(attempt.Number)}
 				if stderr {
 					msg.Response = &StreamJobOutputResponse_Stderr{Stderr: b}
 				} else {
@@ -0,0 +1,119 @@
+package workergrpc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cretz/teleworker/worker"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (j *jobService) CreateTemplate(ctx context.Context, req *CreateTemplateRequest) (*CreateTemplateResponse, error) {
+	if err := validateCreateTemplateRequest(req); err != nil {
+		return nil, err
+	}
+	ns, err := j.namespace(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	config := worker.TemplateConfig{
+		Job:            fromProtoJobSpec(req.Template.Job),
+		JobLabels:      req.Template.Job.Labels,
+		JobAnnotations: req.Template.Job.Annotations,
+	}
+	for _, param := range req.Template.Params {
+		config.Params = append(config.Params, worker.TemplateParam{
+			Name:     param.Name,
+			Required: param.Required,
+			Default:  param.Default,
+		})
+	}
+	template, err := j.worker.CreateTemplate(ns, req.Template.Name, config)
+	if errors.Is(err, worker.ErrInvalidTemplate) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	} else if err != nil {
+		return nil, jobSpecError(err, req.Template.Job)
+	}
+	return &CreateTemplateResponse{Template: toProtoTemplate(template)}, nil
+}
+
+func validateCreateTemplateRequest(req *CreateTemplateRequest) error {
+	switch {
+	case req.Template == nil:
+		return status.Error(codes.InvalidArgument, "template required")
+	case req.Template.Name == "":
+		return status.Error(codes.InvalidArgument, "template name required")
+	case req.Template.Version != 0:
+		return status.Error(codes.InvalidArgument, "version cannot be present on create")
+	case req.Template.CreatedAt != nil:
+		return status.Error(codes.InvalidArgument, "created at cannot be present on create")
+	case req.Template.Job != nil && req.Template.Job.Id != "":
+		return status.Error(codes.InvalidArgument, "job ID cannot be present on template")
+	case req.Template.Job != nil && len(req.Template.Job.Dependencies) > 0:
+		return status.Error(codes.InvalidArgument, "job dependencies cannot be present on template")
+	}
+	return validateJobSpec(req.Template.Job)
+}
+
+func (j *jobService) ListTemplates(ctx context.Context, req *ListTemplatesRequest) (*ListTemplatesResponse, error) {
+	ns, err := j.namespace(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	var resp ListTemplatesResponse
+	for _, template := range j.worker.Templates(ns, req.Name) {
+		resp.Templates = append(resp.Templates, toProtoTemplate(template))
+	}
+	return &resp, nil
+}
+
+func (j *jobService) SubmitFromTemplate(
+	ctx context.Context,
+	req *SubmitFromTemplateRequest,
+) (*SubmitFromTemplateResponse, error) {
+	if req.TemplateName == "" {
+		return nil, status.Error(codes.InvalidArgument, "template name required")
+	} else if req.TemplateVersion < 0 {
+		return nil, status.Error(codes.InvalidArgument, "template version cannot be negative")
+	}
+	ns, err := j.namespace(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	} else if err := j.allowSubmit(ctx, ns, 1); err != nil {
+		return nil, err
+	}
+	job, err := j.worker.SubmitFromTemplate(ns, req.JobId, req.TemplateName, int(req.TemplateVersion), req.Params,
+		worker.WithTraceContext(ctx))
+	if err == worker.ErrTemplateNotFound {
+		return nil, status.Error(codes.NotFound, "template not found")
+	} else if errors.Is(err, worker.ErrInvalidTemplateParams) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	} else if err != nil {
+		return nil, jobSpecError(err, nil)
+	}
+	pbJob, err := j.toProtoJob(job, false /* includeStdout */, false /* includeStderr */)
+	if err != nil {
+		return nil, err
+	}
+	return &SubmitFromTemplateResponse{Job: pbJob}, nil
+}
+
+func toProtoTemplate(template *worker.JobTemplate) *JobTemplate {
+	pbTemplate := &JobTemplate{
+		Name:      template.Name,
+		Version:   int32(template.Version),
+		Job:       toProtoJobSpec(&template.Job),
+		CreatedAt: timestamppb.New(template.CreatedAt),
+	}
+	pbTemplate.Job.Labels, pbTemplate.Job.Annotations = template.JobLabels, template.JobAnnotations
+	for _, param := range template.Params {
+		pbTemplate.Params = append(pbTemplate.Params, &TemplateParam{
+			Name:     param.Name,
+			Required: param.Required,
+			Default:  param.Default,
+		})
+	}
+	return pbTemplate
+}
@@ -0,0 +1,50 @@
+package workergrpc
+
+import (
+	"context"
+
+	"github.com/cretz/teleworker/worker"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (j *jobService) ListWebhookDeliveries(
+	ctx context.Context,
+	req *ListWebhookDeliveriesRequest,
+) (*ListWebhookDeliveriesResponse, error) {
+	ns, err := j.namespace(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	var resp ListWebhookDeliveriesResponse
+	for _, delivery := range j.worker.WebhookDeliveries(ns, req.JobId) {
+		resp.Deliveries = append(resp.Deliveries, toProtoWebhookDelivery(delivery))
+	}
+	return &resp, nil
+}
+
+func toProtoWebhookDelivery(delivery *worker.WebhookDelivery) *WebhookDelivery {
+	pbDelivery := &WebhookDelivery{
+		Id:        delivery.ID,
+		JobId:     delivery.JobID,
+		Url:       delivery.URL,
+		CreatedAt: timestamppb.New(delivery.CreatedAt),
+	}
+	switch delivery.Status() {
+	case worker.WebhookDeliveryPending:
+		pbDelivery.Status = WebhookDeliveryStatus_WEBHOOK_DELIVERY_STATUS_PENDING
+	case worker.WebhookDeliverySucceeded:
+		pbDelivery.Status = WebhookDeliveryStatus_WEBHOOK_DELIVERY_STATUS_SUCCEEDED
+	case worker.WebhookDeliveryFailed:
+		pbDelivery.Status = WebhookDeliveryStatus_WEBHOOK_DELIVERY_STATUS_FAILED
+	}
+	for _, attempt := range delivery.Attempts() {
+		pbDelivery.Attempts = append(pbDelivery.Attempts, &WebhookDeliveryAttempt{
+			Time:       timestamppb.New(attempt.Time),
+			Duration:   durationpb.New(attempt.Duration),
+			StatusCode: int32(attempt.StatusCode),
+			Error:      attempt.Error,
+		})
+	}
+	return pbDelivery
+}
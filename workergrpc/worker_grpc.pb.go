@@ -21,15 +21,93 @@ type JobServiceClient interface {
 	// Get a job by its ID. This will error with NotFound if the job is not found.
 	GetJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (*GetJobResponse, error)
 	// Submit a job. This will error with AlreadyExists if an ID is provided that
-	// already exists.
+	// already exists. If the server or namespace is at its concurrent job limit,
+	// the job is returned queued instead of running.
 	SubmitJob(ctx context.Context, in *SubmitJobRequest, opts ...grpc.CallOption) (*SubmitJobResponse, error)
 	// Stop a job by its ID. This will error with NotFound if the job is not
-	// found. This will error with FailedPrecondition if the job is not running.
+	// found. This will error with FailedPrecondition if the job has completed. If
+	// the job is queued, it is canceled.
 	// This will error with DeadlineExceeded if the attempted stop does not result
 	// in a completed process within a short time.
 	StopJob(ctx context.Context, in *StopJobRequest, opts ...grpc.CallOption) (*StopJobResponse, error)
 	// Stream output of a job by its ID.
 	StreamJobOutput(ctx context.Context, in *StreamJobOutputRequest, opts ...grpc.CallOption) (JobService_StreamJobOutputClient, error)
+	// Stream lifecycle events of jobs in the namespace as they happen until the
+	// call is canceled. This will error with InvalidArgument if the label
+	// selector is invalid and with OutOfRange if resuming after a sequence whose
+	// following events are no longer retained, in which case the watcher should
+	// list jobs and watch without resuming.
+	WatchJobs(ctx context.Context, in *WatchJobsRequest, opts ...grpc.CallOption) (JobService_WatchJobsClient, error)
+	// List jobs in the namespace in the order they were submitted. Output is not
+	// present. This will error with InvalidArgument if the label selector is
+	// invalid.
+	ListJobs(ctx context.Context, in *ListJobsRequest, opts ...grpc.CallOption) (*ListJobsResponse, error)
+	// Set or remove labels and annotations of a job by its ID. This will error
+	// with NotFound if the job is not found and with InvalidArgument if a set
+	// label or annotation is invalid.
+	UpdateJobLabels(ctx context.Context, in *UpdateJobLabelsRequest, opts ...grpc.CallOption) (*UpdateJobLabelsResponse, error)
+	// Delete a completed job and its output by its ID. This will error with
+	// NotFound if the job is not found and with FailedPrecondition if the job has
+	// not completed.
+	DeleteJob(ctx context.Context, in *DeleteJobRequest, opts ...grpc.CallOption) (*DeleteJobResponse, error)
+	// Submit many jobs at once. Either all jobs are submitted or none are. Jobs
+	// can depend on other jobs in the batch. This will error with AlreadyExists
+	// if any job ID already exists and with InvalidArgument if any job is
+	// invalid, naming the job. Jobs that fail to start have a start error
+	// instead of failing the submission.
+	BatchSubmitJobs(ctx context.Context, in *BatchSubmitJobsRequest, opts ...grpc.CallOption) (*BatchSubmitJobsResponse, error)
+	// Stop every job with one of the given IDs or matching a label selector.
+	// Each job is stopped concurrently and has its own result, so failing to
+	// stop one job does not fail the call. This will error with InvalidArgument
+	// unless exactly one of job IDs or label selector is present.
+	StopJobs(ctx context.Context, in *StopJobsRequest, opts ...grpc.CallOption) (*StopJobsResponse, error)
+	// Create a schedule. This will error with AlreadyExists if an ID is provided
+	// that already exists, and with InvalidArgument if the cron expression, time
+	// zone, or job is invalid. Schedules are persisted if the server has a state
+	// directory.
+	CreateSchedule(ctx context.Context, in *CreateScheduleRequest, opts ...grpc.CallOption) (*CreateScheduleResponse, error)
+	// List all schedules in the namespace.
+	ListSchedules(ctx context.Context, in *ListSchedulesRequest, opts ...grpc.CallOption) (*ListSchedulesResponse, error)
+	// Delete a schedule by its ID. Jobs already submitted by the schedule are
+	// unaffected. This will error with NotFound if the schedule is not found.
+	DeleteSchedule(ctx context.Context, in *DeleteScheduleRequest, opts ...grpc.CallOption) (*DeleteScheduleResponse, error)
+	// Pause or resume a schedule by its ID. This will error with NotFound if the
+	// schedule is not found.
+	PauseSchedule(ctx context.Context, in *PauseScheduleRequest, opts ...grpc.CallOption) (*PauseScheduleResponse, error)
+	// Submit a workflow. Either all jobs are submitted or none are. This will
+	// error with AlreadyExists if the workflow ID or any job ID already exists,
+	// and with InvalidArgument if there is a dependency cycle. Jobs that fail to
+	// start have a start error instead of failing the submission.
+	SubmitWorkflow(ctx context.Context, in *SubmitWorkflowRequest, opts ...grpc.CallOption) (*SubmitWorkflowResponse, error)
+	// Get a workflow by its ID. This will error with NotFound if the workflow is
+	// not found.
+	GetWorkflow(ctx context.Context, in *GetWorkflowRequest, opts ...grpc.CallOption) (*GetWorkflowResponse, error)
+	// Create the next version of a named template, starting at 1. This will
+	// error with InvalidArgument if the template is invalid, including any
+	// placeholder that is not a parameter. Templates are persisted if the server
+	// has a state directory.
+	CreateTemplate(ctx context.Context, in *CreateTemplateRequest, opts ...grpc.CallOption) (*CreateTemplateResponse, error)
+	// List the latest version of every template in the namespace, or every
+	// version of one template.
+	ListTemplates(ctx context.Context, in *ListTemplatesRequest, opts ...grpc.CallOption) (*ListTemplatesResponse, error)
+	// Submit a job rendered from a template with parameters. This will error
+	// with NotFound if the template or version is not found, with
+	// InvalidArgument if a parameter is unknown or a required one is missing,
+	// and otherwise the same as SubmitJob.
+	SubmitFromTemplate(ctx context.Context, in *SubmitFromTemplateRequest, opts ...grpc.CallOption) (*SubmitFromTemplateResponse, error)
+	// List the retained webhook deliveries in the namespace in order of
+	// creation. Older deliveries are dropped once the server's limit is reached.
+	ListWebhookDeliveries(ctx context.Context, in *ListWebhookDeliveriesRequest, opts ...grpc.CallOption) (*ListWebhookDeliveriesResponse, error)
+	// Search the server's audit log across namespaces, most recent entries
+	// first. This will error with FailedPrecondition if the server has no audit
+	// log and with PermissionDenied unless the caller is an admin.
+	QueryAudit(ctx context.Context, in *QueryAuditRequest, opts ...grpc.CallOption) (*QueryAuditResponse, error)
+	// List every namespace with retained jobs and how many are in each state.
+	// This will error with PermissionDenied unless the caller is an admin.
+	ListNamespaces(ctx context.Context, in *ListNamespacesRequest, opts ...grpc.CallOption) (*ListNamespacesResponse, error)
+	// Get the quota and rate limits of the namespace and what it is using of
+	// them.
+	GetQuota(ctx context.Context, in *GetQuotaRequest, opts ...grpc.CallOption) (*GetQuotaResponse, error)
 }
 
 type jobServiceClient struct {
@@ -99,6 +177,200 @@ func (x *jobServiceStreamJobOutputClient) Recv() (*StreamJobOutputResponse, erro
 	return m, nil
 }
 
+func (c *jobServiceClient) WatchJobs(ctx context.Context, in *WatchJobsRequest, opts ...grpc.CallOption) (JobService_WatchJobsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &JobService_ServiceDesc.Streams[1], "/teleworker.worker.JobService/WatchJobs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &jobServiceWatchJobsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type JobService_WatchJobsClient interface {
+	Recv() (*WatchJobsResponse, error)
+	grpc.ClientStream
+}
+
+type jobServiceWatchJobsClient struct {
+	grpc.ClientStream
+}
+
+func (x *jobServiceWatchJobsClient) Recv() (*WatchJobsResponse, error) {
+	m := new(WatchJobsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *jobServiceClient) ListJobs(ctx context.Context, in *ListJobsRequest, opts ...grpc.CallOption) (*ListJobsResponse, error) {
+	out := new(ListJobsResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/ListJobs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) UpdateJobLabels(ctx context.Context, in *UpdateJobLabelsRequest, opts ...grpc.CallOption) (*UpdateJobLabelsResponse, error) {
+	out := new(UpdateJobLabelsResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/UpdateJobLabels", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) DeleteJob(ctx context.Context, in *DeleteJobRequest, opts ...grpc.CallOption) (*DeleteJobResponse, error) {
+	out := new(DeleteJobResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/DeleteJob", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) BatchSubmitJobs(ctx context.Context, in *BatchSubmitJobsRequest, opts ...grpc.CallOption) (*BatchSubmitJobsResponse, error) {
+	out := new(BatchSubmitJobsResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/BatchSubmitJobs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) StopJobs(ctx context.Context, in *StopJobsRequest, opts ...grpc.CallOption) (*StopJobsResponse, error) {
+	out := new(StopJobsResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/StopJobs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) CreateSchedule(ctx context.Context, in *CreateScheduleRequest, opts ...grpc.CallOption) (*CreateScheduleResponse, error) {
+	out := new(CreateScheduleResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/CreateSchedule", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) ListSchedules(ctx context.Context, in *ListSchedulesRequest, opts ...grpc.CallOption) (*ListSchedulesResponse, error) {
+	out := new(ListSchedulesResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/ListSchedules", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) DeleteSchedule(ctx context.Context, in *DeleteScheduleRequest, opts ...grpc.CallOption) (*DeleteScheduleResponse, error) {
+	out := new(DeleteScheduleResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/DeleteSchedule", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) PauseSchedule(ctx context.Context, in *PauseScheduleRequest, opts ...grpc.CallOption) (*PauseScheduleResponse, error) {
+	out := new(PauseScheduleResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/PauseSchedule", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) SubmitWorkflow(ctx context.Context, in *SubmitWorkflowRequest, opts ...grpc.CallOption) (*SubmitWorkflowResponse, error) {
+	out := new(SubmitWorkflowResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/SubmitWorkflow", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) GetWorkflow(ctx context.Context, in *GetWorkflowRequest, opts ...grpc.CallOption) (*GetWorkflowResponse, error) {
+	out := new(GetWorkflowResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/GetWorkflow", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) CreateTemplate(ctx context.Context, in *CreateTemplateRequest, opts ...grpc.CallOption) (*CreateTemplateResponse, error) {
+	out := new(CreateTemplateResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/CreateTemplate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) ListTemplates(ctx context.Context, in *ListTemplatesRequest, opts ...grpc.CallOption) (*ListTemplatesResponse, error) {
+	out := new(ListTemplatesResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/ListTemplates", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) SubmitFromTemplate(ctx context.Context, in *SubmitFromTemplateRequest, opts ...grpc.CallOption) (*SubmitFromTemplateResponse, error) {
+	out := new(SubmitFromTemplateResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/SubmitFromTemplate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) ListWebhookDeliveries(ctx context.Context, in *ListWebhookDeliveriesRequest, opts ...grpc.CallOption) (*ListWebhookDeliveriesResponse, error) {
+	out := new(ListWebhookDeliveriesResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/ListWebhookDeliveries", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) QueryAudit(ctx context.Context, in *QueryAuditRequest, opts ...grpc.CallOption) (*QueryAuditResponse, error) {
+	out := new(QueryAuditResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/QueryAudit", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) ListNamespaces(ctx context.Context, in *ListNamespacesRequest, opts ...grpc.CallOption) (*ListNamespacesResponse, error) {
+	out := new(ListNamespacesResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/ListNamespaces", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobServiceClient) GetQuota(ctx context.Context, in *GetQuotaRequest, opts ...grpc.CallOption) (*GetQuotaResponse, error) {
+	out := new(GetQuotaResponse)
+	err := c.cc.Invoke(ctx, "/teleworker.worker.JobService/GetQuota", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // JobServiceServer is the server API for JobService service.
 // All implementations must embed UnimplementedJobServiceServer
 // for forward compatibility
@@ -106,15 +378,93 @@ type JobServiceServer interface {
 	// Get a job by its ID. This will error with NotFound if the job is not found.
 	GetJob(context.Context, *GetJobRequest) (*GetJobResponse, error)
 	// Submit a job. This will error with AlreadyExists if an ID is provided that
-	// already exists.
+	// already exists. If the server or namespace is at its concurrent job limit,
+	// the job is returned queued instead of running.
 	SubmitJob(context.Context, *SubmitJobRequest) (*SubmitJobResponse, error)
 	// Stop a job by its ID. This will error with NotFound if the job is not
-	// found. This will error with FailedPrecondition if the job is not running.
+	// found. This will error with FailedPrecondition if the job has completed. If
+	// the job is queued, it is canceled.
 	// This will error with DeadlineExceeded if the attempted stop does not result
 	// in a completed process within a short time.
 	StopJob(context.Context, *StopJobRequest) (*StopJobResponse, error)
 	// Stream output of a job by its ID.
 	StreamJobOutput(*StreamJobOutputRequest, JobService_StreamJobOutputServer) error
+	// Stream lifecycle events of jobs in the namespace as they happen until the
+	// call is canceled. This will error with InvalidArgument if the label
+	// selector is invalid and with OutOfRange if resuming after a sequence whose
+	// following events are no longer retained, in which case the watcher should
+	// list jobs and watch without resuming.
+	WatchJobs(*WatchJobsRequest, JobService_WatchJobsServer) error
+	// List jobs in the namespace in the order they were submitted. Output is not
+	// present. This will error with InvalidArgument if the label selector is
+	// invalid.
+	ListJobs(context.Context, *ListJobsRequest) (*ListJobsResponse, error)
+	// Set or remove labels and annotations of a job by its ID. This will error
+	// with NotFound if the job is not found and with InvalidArgument if a set
+	// label or annotation is invalid.
+	UpdateJobLabels(context.Context, *UpdateJobLabelsRequest) (*UpdateJobLabelsResponse, error)
+	// Delete a completed job and its output by its ID. This will error with
+	// NotFound if the job is not found and with FailedPrecondition if the job has
+	// not completed.
+	DeleteJob(context.Context, *DeleteJobRequest) (*DeleteJobResponse, error)
+	// Submit many jobs at once. Either all jobs are submitted or none are. Jobs
+	// can depend on other jobs in the batch. This will error with AlreadyExists
+	// if any job ID already exists and with InvalidArgument if any job is
+	// invalid, naming the job. Jobs that fail to start have a start error
+	// instead of failing the submission.
+	BatchSubmitJobs(context.Context, *BatchSubmitJobsRequest) (*BatchSubmitJobsResponse, error)
+	// Stop every job with one of the given IDs or matching a label selector.
+	// Each job is stopped concurrently and has its own result, so failing to
+	// stop one job does not fail the call. This will error with InvalidArgument
+	// unless exactly one of job IDs or label selector is present.
+	StopJobs(context.Context, *StopJobsRequest) (*StopJobsResponse, error)
+	// Create a schedule. This will error with AlreadyExists if an ID is provided
+	// that already exists, and with InvalidArgument if the cron expression, time
+	// zone, or job is invalid. Schedules are persisted if the server has a state
+	// directory.
+	CreateSchedule(context.Context, *CreateScheduleRequest) (*CreateScheduleResponse, error)
+	// List all schedules in the namespace.
+	ListSchedules(context.Context, *ListSchedulesRequest) (*ListSchedulesResponse, error)
+	// Delete a schedule by its ID. Jobs already submitted by the schedule are
+	// unaffected. This will error with NotFound if the schedule is not found.
+	DeleteSchedule(context.Context, *DeleteScheduleRequest) (*DeleteScheduleResponse, error)
+	// Pause or resume a schedule by its ID. This will error with NotFound if the
+	// schedule is not found.
+	PauseSchedule(context.Context, *PauseScheduleRequest) (*PauseScheduleResponse, error)
+	// Submit a workflow. Either all jobs are submitted or none are. This will
+	// error with AlreadyExists if the workflow ID or any job ID already exists,
+	// and with InvalidArgument if there is a dependency cycle. Jobs that fail to
+	// start have a start error instead of failing the submission.
+	SubmitWorkflow(context.Context, *SubmitWorkflowRequest) (*SubmitWorkflowResponse, error)
+	// Get a workflow by its ID. This will error with NotFound if the workflow is
+	// not found.
+	GetWorkflow(context.Context, *GetWorkflowRequest) (*GetWorkflowResponse, error)
+	// Create the next version of a named template, starting at 1. This will
+	// error with InvalidArgument if the template is invalid, including any
+	// placeholder that is not a parameter. Templates are persisted if the server
+	// has a state directory.
+	CreateTemplate(context.Context, *CreateTemplateRequest) (*CreateTemplateResponse, error)
+	// List the latest version of every template in the namespace, or every
+	// version of one template.
+	ListTemplates(context.Context, *ListTemplatesRequest) (*ListTemplatesResponse, error)
+	// Submit a job rendered from a template with parameters. This will error
+	// with NotFound if the template or version is not found, with
+	// InvalidArgument if a parameter is unknown or a required one is missing,
+	// and otherwise the same as SubmitJob.
+	SubmitFromTemplate(context.Context, *SubmitFromTemplateRequest) (*SubmitFromTemplateResponse, error)
+	// List the retained webhook deliveries in the namespace in order of
+	// creation. Older deliveries are dropped once the server's limit is reached.
+	ListWebhookDeliveries(context.Context, *ListWebhookDeliveriesRequest) (*ListWebhookDeliveriesResponse, error)
+	// Search the server's audit log across namespaces, most recent entries
+	// first. This will error with FailedPrecondition if the server has no audit
+	// log and with PermissionDenied unless the caller is an admin.
+	QueryAudit(context.Context, *QueryAuditRequest) (*QueryAuditResponse, error)
+	// List every namespace with retained jobs and how many are in each state.
+	// This will error with PermissionDenied unless the caller is an admin.
+	ListNamespaces(context.Context, *ListNamespacesRequest) (*ListNamespacesResponse, error)
+	// Get the quota and rate limits of the namespace and what it is using of
+	// them.
+	GetQuota(context.Context, *GetQuotaRequest) (*GetQuotaResponse, error)
 	mustEmbedUnimplementedJobServiceServer()
 }
 
@@ -134,6 +484,63 @@ func (UnimplementedJobServiceServer) StopJob(context.Context, *StopJobRequest) (
 func (UnimplementedJobServiceServer) StreamJobOutput(*StreamJobOutputRequest, JobService_StreamJobOutputServer) error {
 	return status.Errorf(codes.Unimplemented, "method StreamJobOutput not implemented")
 }
+func (UnimplementedJobServiceServer) WatchJobs(*WatchJobsRequest, JobService_WatchJobsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchJobs not implemented")
+}
+func (UnimplementedJobServiceServer) ListJobs(context.Context, *ListJobsRequest) (*ListJobsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListJobs not implemented")
+}
+func (UnimplementedJobServiceServer) UpdateJobLabels(context.Context, *UpdateJobLabelsRequest) (*UpdateJobLabelsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateJobLabels not implemented")
+}
+func (UnimplementedJobServiceServer) DeleteJob(context.Context, *DeleteJobRequest) (*DeleteJobResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteJob not implemented")
+}
+func (UnimplementedJobServiceServer) BatchSubmitJobs(context.Context, *BatchSubmitJobsRequest) (*BatchSubmitJobsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchSubmitJobs not implemented")
+}
+func (UnimplementedJobServiceServer) StopJobs(context.Context, *StopJobsRequest) (*StopJobsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StopJobs not implemented")
+}
+func (UnimplementedJobServiceServer) CreateSchedule(context.Context, *CreateScheduleRequest) (*CreateScheduleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateSchedule not implemented")
+}
+func (UnimplementedJobServiceServer) ListSchedules(context.Context, *ListSchedulesRequest) (*ListSchedulesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSchedules not implemented")
+}
+func (UnimplementedJobServiceServer) DeleteSchedule(context.Context, *DeleteScheduleRequest) (*DeleteScheduleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteSchedule not implemented")
+}
+func (UnimplementedJobServiceServer) PauseSchedule(context.Context, *PauseScheduleRequest) (*PauseScheduleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PauseSchedule not implemented")
+}
+func (UnimplementedJobServiceServer) SubmitWorkflow(context.Context, *SubmitWorkflowRequest) (*SubmitWorkflowResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitWorkflow not implemented")
+}
+func (UnimplementedJobServiceServer) GetWorkflow(context.Context, *GetWorkflowRequest) (*GetWorkflowResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetWorkflow not implemented")
+}
+func (UnimplementedJobServiceServer) CreateTemplate(context.Context, *CreateTemplateRequest) (*CreateTemplateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateTemplate not implemented")
+}
+func (UnimplementedJobServiceServer) ListTemplates(context.Context, *ListTemplatesRequest) (*ListTemplatesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTemplates not implemented")
+}
+func (UnimplementedJobServiceServer) SubmitFromTemplate(context.Context, *SubmitFromTemplateRequest) (*SubmitFromTemplateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitFromTemplate not implemented")
+}
+func (UnimplementedJobServiceServer) ListWebhookDeliveries(context.Context, *ListWebhookDeliveriesRequest) (*ListWebhookDeliveriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListWebhookDeliveries not implemented")
+}
+func (UnimplementedJobServiceServer) QueryAudit(context.Context, *QueryAuditRequest) (*QueryAuditResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryAudit not implemented")
+}
+func (UnimplementedJobServiceServer) ListNamespaces(context.Context, *ListNamespacesRequest) (*ListNamespacesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListNamespaces not implemented")
+}
+func (UnimplementedJobServiceServer) GetQuota(context.Context, *GetQuotaRequest) (*GetQuotaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetQuota not implemented")
+}
 func (UnimplementedJobServiceServer) mustEmbedUnimplementedJobServiceServer() {}
 
 // UnsafeJobServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -222,6 +629,351 @@ func (x *jobServiceStreamJobOutputServer) Send(m *StreamJobOutputResponse) error
 	return x.ServerStream.SendMsg(m)
 }
 
+func _JobService_WatchJobs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchJobsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(JobServiceServer).WatchJobs(m, &jobServiceWatchJobsServer{stream})
+}
+
+type JobService_WatchJobsServer interface {
+	Send(*WatchJobsResponse) error
+	grpc.ServerStream
+}
+
+type jobServiceWatchJobsServer struct {
+	grpc.ServerStream
+}
+
+func (x *jobServiceWatchJobsServer) Send(m *WatchJobsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _JobService_ListJobs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListJobsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).ListJobs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/ListJobs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).ListJobs(ctx, req.(*ListJobsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_UpdateJobLabels_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateJobLabelsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).UpdateJobLabels(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/UpdateJobLabels",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).UpdateJobLabels(ctx, req.(*UpdateJobLabelsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_DeleteJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).DeleteJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/DeleteJob",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).DeleteJob(ctx, req.(*DeleteJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_BatchSubmitJobs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchSubmitJobsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).BatchSubmitJobs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/BatchSubmitJobs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).BatchSubmitJobs(ctx, req.(*BatchSubmitJobsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_StopJobs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StopJobsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).StopJobs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/StopJobs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).StopJobs(ctx, req.(*StopJobsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_CreateSchedule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateScheduleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).CreateSchedule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/CreateSchedule",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).CreateSchedule(ctx, req.(*CreateScheduleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_ListSchedules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSchedulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).ListSchedules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/ListSchedules",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).ListSchedules(ctx, req.(*ListSchedulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_DeleteSchedule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteScheduleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).DeleteSchedule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/DeleteSchedule",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).DeleteSchedule(ctx, req.(*DeleteScheduleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_PauseSchedule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PauseScheduleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).PauseSchedule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/PauseSchedule",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).PauseSchedule(ctx, req.(*PauseScheduleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_SubmitWorkflow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitWorkflowRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).SubmitWorkflow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/SubmitWorkflow",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).SubmitWorkflow(ctx, req.(*SubmitWorkflowRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_GetWorkflow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetWorkflowRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).GetWorkflow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/GetWorkflow",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).GetWorkflow(ctx, req.(*GetWorkflowRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_CreateTemplate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateTemplateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).CreateTemplate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/CreateTemplate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).CreateTemplate(ctx, req.(*CreateTemplateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_ListTemplates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTemplatesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).ListTemplates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/ListTemplates",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).ListTemplates(ctx, req.(*ListTemplatesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_SubmitFromTemplate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitFromTemplateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).SubmitFromTemplate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/SubmitFromTemplate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).SubmitFromTemplate(ctx, req.(*SubmitFromTemplateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_ListWebhookDeliveries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListWebhookDeliveriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).ListWebhookDeliveries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/ListWebhookDeliveries",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).ListWebhookDeliveries(ctx, req.(*ListWebhookDeliveriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_QueryAudit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryAuditRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).QueryAudit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/QueryAudit",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).QueryAudit(ctx, req.(*QueryAuditRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_ListNamespaces_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListNamespacesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).ListNamespaces(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/ListNamespaces",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).ListNamespaces(ctx, req.(*ListNamespacesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobService_GetQuota_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetQuotaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobServiceServer).GetQuota(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/teleworker.worker.JobService/GetQuota",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobServiceServer).GetQuota(ctx, req.(*GetQuotaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // JobService_ServiceDesc is the grpc.ServiceDesc for JobService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -241,6 +993,78 @@ var JobService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "StopJob",
 			Handler:    _JobService_StopJob_Handler,
 		},
+		{
+			MethodName: "ListJobs",
+			Handler:    _JobService_ListJobs_Handler,
+		},
+		{
+			MethodName: "UpdateJobLabels",
+			Handler:    _JobService_UpdateJobLabels_Handler,
+		},
+		{
+			MethodName: "DeleteJob",
+			Handler:    _JobService_DeleteJob_Handler,
+		},
+		{
+			MethodName: "BatchSubmitJobs",
+			Handler:    _JobService_BatchSubmitJobs_Handler,
+		},
+		{
+			MethodName: "StopJobs",
+			Handler:    _JobService_StopJobs_Handler,
+		},
+		{
+			MethodName: "CreateSchedule",
+			Handler:    _JobService_CreateSchedule_Handler,
+		},
+		{
+			MethodName: "ListSchedules",
+			Handler:    _JobService_ListSchedules_Handler,
+		},
+		{
+			MethodName: "DeleteSchedule",
+			Handler:    _JobService_DeleteSchedule_Handler,
+		},
+		{
+			MethodName: "PauseSchedule",
+			Handler:    _JobService_PauseSchedule_Handler,
+		},
+		{
+			MethodName: "SubmitWorkflow",
+			Handler:    _JobService_SubmitWorkflow_Handler,
+		},
+		{
+			MethodName: "GetWorkflow",
+			Handler:    _JobService_GetWorkflow_Handler,
+		},
+		{
+			MethodName: "CreateTemplate",
+			Handler:    _JobService_CreateTemplate_Handler,
+		},
+		{
+			MethodName: "ListTemplates",
+			Handler:    _JobService_ListTemplates_Handler,
+		},
+		{
+			MethodName: "SubmitFromTemplate",
+			Handler:    _JobService_SubmitFromTemplate_Handler,
+		},
+		{
+			MethodName: "ListWebhookDeliveries",
+			Handler:    _JobService_ListWebhookDeliveries_Handler,
+		},
+		{
+			MethodName: "QueryAudit",
+			Handler:    _JobService_QueryAudit_Handler,
+		},
+		{
+			MethodName: "ListNamespaces",
+			Handler:    _JobService_ListNamespaces_Handler,
+		},
+		{
+			MethodName: "GetQuota",
+			Handler:    _JobService_GetQuota_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -248,6 +1072,11 @@ var JobService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _JobService_StreamJobOutput_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "WatchJobs",
+			Handler:       _JobService_WatchJobs_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "workergrpc/worker.proto",
 }
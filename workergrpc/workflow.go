@@ -0,0 +1,97 @@
+package workergrpc
+
+import (
+	"context"
+
+	"github.com/cretz/teleworker/worker"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (j *jobService) SubmitWorkflow(ctx context.Context, req *SubmitWorkflowRequest) (*SubmitWorkflowResponse, error) {
+	if err := validateSubmitWorkflowRequest(req); err != nil {
+		return nil, err
+	}
+	ns, err := j.namespace(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	} else if err := j.allowSubmit(ctx, ns, len(req.Workflow.Jobs)); err != nil {
+		return nil, err
+	}
+	jobs := make([]worker.WorkflowJob, len(req.Workflow.Jobs))
+	for i, job := range req.Workflow.Jobs {
+		jobs[i] = worker.WorkflowJob{
+			ID:           job.Id,
+			JobSpec:      fromProtoJobSpec(job),
+			Dependencies: fromProtoDependencies(job.Dependencies),
+			Labels:       job.Labels,
+			Annotations:  job.Annotations,
+		}
+	}
+	workflow, err := j.worker.SubmitWorkflow(ns, req.Workflow.Id, jobs)
+	if err == worker.ErrIDAlreadyExists {
+		return nil, status.Error(codes.AlreadyExists, "workflow or job with ID already exists")
+	} else if err == worker.ErrUserNotAllowed {
+		return nil, status.Error(codes.PermissionDenied, "user not allowed")
+	} else if err != nil {
+		return nil, jobSpecError(err, nil)
+	}
+	pbWorkflow, err := j.toProtoWorkflow(workflow)
+	if err != nil {
+		return nil, err
+	}
+	return &SubmitWorkflowResponse{Workflow: pbWorkflow}, nil
+}
+
+func validateSubmitWorkflowRequest(req *SubmitWorkflowRequest) error {
+	switch {
+	case req.Workflow == nil:
+		return status.Error(codes.InvalidArgument, "workflow required")
+	case len(req.Workflow.Jobs) == 0:
+		return status.Error(codes.InvalidArgument, "at least one job required")
+	case req.Workflow.CreatedAt != nil:
+		return status.Error(codes.InvalidArgument, "created at cannot be present on create")
+	}
+	for _, job := range req.Workflow.Jobs {
+		if err := validateJobSpec(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *jobService) GetWorkflow(ctx context.Context, req *GetWorkflowRequest) (*GetWorkflowResponse, error) {
+	if req.WorkflowId == "" {
+		return nil, status.Error(codes.InvalidArgument, "workflow ID required")
+	}
+	ns, err := j.namespace(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	workflow, err := j.worker.GetWorkflow(ns, req.WorkflowId)
+	if err == worker.ErrShutdown {
+		return nil, status.Error(codes.FailedPrecondition, "worker shutdown")
+	} else if err != nil {
+		return nil, err
+	} else if workflow == nil {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	pbWorkflow, err := j.toProtoWorkflow(workflow)
+	if err != nil {
+		return nil, err
+	}
+	return &GetWorkflowResponse{Workflow: pbWorkflow}, nil
+}
+
+func (j *jobService) toProtoWorkflow(workflow *worker.Workflow) (*Workflow, error) {
+	pbWorkflow := &Workflow{Id: workflow.ID, CreatedAt: timestamppb.New(workflow.CreatedAt)}
+	for _, job := range workflow.Jobs {
+		pbJob, err := j.toProtoJob(job, false /* includeStdout */, false /* includeStderr */)
+		if err != nil {
+			return nil, err
+		}
+		pbWorkflow.Jobs = append(pbWorkflow.Jobs, pbJob)
+	}
+	return pbWorkflow, nil
+}
@@ -0,0 +1,130 @@
+// Package workerprom implements worker and gRPC metrics with Prometheus.
+package workerprom
+
+import (
+	"time"
+
+	"github.com/cretz/teleworker/worker"
+	"github.com/cretz/teleworker/workergrpc"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+)
+
+// Metrics is a Prometheus implementation of worker.Metrics and
+// workergrpc.Metrics.
+type Metrics struct {
+	jobsSubmitted      *prometheus.CounterVec
+	jobsCompleted      *prometheus.CounterVec
+	jobsRunning        *prometheus.GaugeVec
+	jobsQueued         *prometheus.GaugeVec
+	outputBytes        *prometheus.CounterVec
+	jobDuration        *prometheus.HistogramVec
+	jobStopLatency     *prometheus.HistogramVec
+	cgroupSetupFailed  *prometheus.CounterVec
+	grpcMethodDuration *prometheus.HistogramVec
+}
+
+var (
+	_ worker.Metrics     = (*Metrics)(nil)
+	_ workergrpc.Metrics = (*Metrics)(nil)
+)
+
+// New creates metrics and registers them with the registerer.
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		jobsSubmitted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "teleworker_jobs_submitted_total",
+			Help: "Jobs accepted by the worker.",
+		}, []string{"namespace"}),
+		jobsCompleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "teleworker_jobs_completed_total",
+			Help: "Jobs completed by how they completed.",
+		}, []string{"namespace", "exit_class"}),
+		jobsRunning: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "teleworker_jobs_running",
+			Help: "Jobs currently running.",
+		}, []string{"namespace"}),
+		jobsQueued: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "teleworker_jobs_queued",
+			Help: "Jobs currently waiting for capacity to start.",
+		}, []string{"namespace"}),
+		outputBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "teleworker_job_output_bytes_total",
+			Help: "Bytes of job output captured.",
+		}, []string{"namespace", "stream"}),
+		jobDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "teleworker_job_duration_seconds",
+			Help:    "Time from the start of the first attempt of a job to its completion.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+		}, []string{"namespace", "exit_class"}),
+		jobStopLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "teleworker_job_stop_latency_seconds",
+			Help: "Time from the first stop request of a job to its completion.",
+		}, []string{"namespace"}),
+		cgroupSetupFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "teleworker_cgroup_setup_failures_total",
+			Help: "Job attempts whose cgroup limits could not be applied.",
+		}, []string{"namespace"}),
+		grpcMethodDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "teleworker_grpc_method_duration_seconds",
+			Help: "Time taken by gRPC calls, or the life of streams.",
+		}, []string{"method", "code"}),
+	}
+	for _, c := range []prometheus.Collector{
+		m.jobsSubmitted, m.jobsCompleted, m.jobsRunning, m.jobsQueued, m.outputBytes,
+		m.jobDuration, m.jobStopLatency, m.cgroupSetupFailed, m.grpcMethodDuration,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// JobSubmitted implements worker.Metrics.JobSubmitted.
+func (m *Metrics) JobSubmitted(namespace string) {
+	m.jobsSubmitted.WithLabelValues(namespace).Inc()
+}
+
+// JobCompleted implements worker.Metrics.JobCompleted. Jobs that never started
+// are not observed in the duration histogram.
+func (m *Metrics) JobCompleted(namespace string, class worker.JobExitClass, runDuration time.Duration) {
+	m.jobsCompleted.WithLabelValues(namespace, class.String()).Inc()
+	if runDuration > 0 {
+		m.jobDuration.WithLabelValues(namespace, class.String()).Observe(runDuration.Seconds())
+	}
+}
+
+// JobsRunning implements worker.Metrics.JobsRunning.
+func (m *Metrics) JobsRunning(namespace string, count int) {
+	m.jobsRunning.WithLabelValues(namespace).Set(float64(count))
+}
+
+// JobsQueued implements worker.Metrics.JobsQueued.
+func (m *Metrics) JobsQueued(namespace string, count int) {
+	m.jobsQueued.WithLabelValues(namespace).Set(float64(count))
+}
+
+// OutputCaptured implements worker.Metrics.OutputCaptured.
+func (m *Metrics) OutputCaptured(namespace string, stderr bool, bytes int) {
+	stream := "stdout"
+	if stderr {
+		stream = "stderr"
+	}
+	m.outputBytes.WithLabelValues(namespace, stream).Add(float64(bytes))
+}
+
+// JobStopped implements worker.Metrics.JobStopped.
+func (m *Metrics) JobStopped(namespace string, latency time.Duration) {
+	m.jobStopLatency.WithLabelValues(namespace).Observe(latency.Seconds())
+}
+
+// CGroupSetupFailed implements worker.Metrics.CGroupSetupFailed.
+func (m *Metrics) CGroupSetupFailed(namespace string) {
+	m.cgroupSetupFailed.WithLabelValues(namespace).Inc()
+}
+
+// MethodHandled implements workergrpc.Metrics.MethodHandled.
+func (m *Metrics) MethodHandled(method string, code codes.Code, duration time.Duration) {
+	m.grpcMethodDuration.WithLabelValues(method, code.String()).Observe(duration.Seconds())
+}
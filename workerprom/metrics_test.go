@@ -0,0 +1,98 @@
+package workerprom
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cretz/teleworker/worker"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/codes"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cannot register twice
+	if _, err := New(reg); err == nil {
+		t.Fatal("expected duplicate registration error")
+	}
+
+	m.JobSubmitted("ns1")
+	m.JobSubmitted("ns1")
+	m.JobSubmitted("ns2")
+	m.JobsRunning("ns1", 3)
+	m.JobsRunning("ns1", 1)
+	m.JobsQueued("ns1", 2)
+	m.OutputCaptured("ns1", false, 10)
+	m.OutputCaptured("ns1", true, 5)
+	m.OutputCaptured("ns1", false, 7)
+	m.CGroupSetupFailed("ns1")
+	m.JobStopped("ns1", 2*time.Second)
+	m.JobCompleted("ns1", worker.JobExitSuccess, time.Second)
+	// Never started, so counted but not observed
+	m.JobCompleted("ns1", worker.JobExitStartError, 0)
+	m.MethodHandled("/teleworker.worker.JobService/GetJob", codes.OK, time.Millisecond)
+	m.MethodHandled("/teleworker.worker.JobService/GetJob", codes.PermissionDenied, time.Millisecond)
+
+	checks := []struct {
+		name     string
+		c        prometheus.Collector
+		expected float64
+	}{
+		{"submitted ns1", m.jobsSubmitted.WithLabelValues("ns1"), 2},
+		{"submitted ns2", m.jobsSubmitted.WithLabelValues("ns2"), 1},
+		{"running", m.jobsRunning.WithLabelValues("ns1"), 1},
+		{"queued", m.jobsQueued.WithLabelValues("ns1"), 2},
+		{"stdout", m.outputBytes.WithLabelValues("ns1", "stdout"), 17},
+		{"stderr", m.outputBytes.WithLabelValues("ns1", "stderr"), 5},
+		{"cgroup", m.cgroupSetupFailed.WithLabelValues("ns1"), 1},
+		{"success", m.jobsCompleted.WithLabelValues("ns1", "success"), 1},
+		{"start failure", m.jobsCompleted.WithLabelValues("ns1", "start_error"), 1},
+	}
+	for _, check := range checks {
+		if actual := testutil.ToFloat64(check.c); actual != check.expected {
+			t.Fatalf("%v: expected %v, got %v", check.name, check.expected, actual)
+		}
+	}
+	// One series per observed label set of the histograms
+	histograms := []struct {
+		c        prometheus.Collector
+		expected int
+	}{
+		{m.jobDuration, 1},
+		{m.jobStopLatency, 1},
+		{m.grpcMethodDuration, 2},
+	}
+	for _, h := range histograms {
+		if actual := testutil.CollectAndCount(h.c); actual != h.expected {
+			t.Fatalf("expected %v series, got %v", h.expected, actual)
+		}
+	}
+	// Check the exposed names and labels
+	expected := `
+# HELP teleworker_job_stop_latency_seconds Time from the first stop request of a job to its completion.
+# TYPE teleworker_job_stop_latency_seconds histogram
+teleworker_job_stop_latency_seconds_bucket{namespace="ns1",le="0.005"} 0
+teleworker_job_stop_latency_seconds_bucket{namespace="ns1",le="0.01"} 0
+teleworker_job_stop_latency_seconds_bucket{namespace="ns1",le="0.025"} 0
+teleworker_job_stop_latency_seconds_bucket{namespace="ns1",le="0.05"} 0
+teleworker_job_stop_latency_seconds_bucket{namespace="ns1",le="0.1"} 0
+teleworker_job_stop_latency_seconds_bucket{namespace="ns1",le="0.25"} 0
+teleworker_job_stop_latency_seconds_bucket{namespace="ns1",le="0.5"} 0
+teleworker_job_stop_latency_seconds_bucket{namespace="ns1",le="1"} 0
+teleworker_job_stop_latency_seconds_bucket{namespace="ns1",le="2.5"} 1
+teleworker_job_stop_latency_seconds_bucket{namespace="ns1",le="5"} 1
+teleworker_job_stop_latency_seconds_bucket{namespace="ns1",le="10"} 1
+teleworker_job_stop_latency_seconds_bucket{namespace="ns1",le="+Inf"} 1
+teleworker_job_stop_latency_seconds_sum{namespace="ns1"} 2
+teleworker_job_stop_latency_seconds_count{namespace="ns1"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "teleworker_job_stop_latency_seconds"); err != nil {
+		t.Fatal(err)
+	}
+}